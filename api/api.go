@@ -1,21 +1,28 @@
 package api
 
 import (
-	"net/http"
+	"compress/gzip"
+	"net"
 	"os"
+	"sync/atomic"
 
+	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/h0rzn/monitoring_agent/api/hub"
+	"github.com/h0rzn/monitoring_agent/config"
 	"github.com/h0rzn/monitoring_agent/dock/controller"
+	"github.com/h0rzn/monitoring_agent/federation"
+	"github.com/h0rzn/monitoring_agent/systemd"
 	"github.com/sirupsen/logrus"
 )
 
 var upgrade = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	// frames are only compressed for clients asking for it in their hello
+	EnableCompression: true,
 }
 
 type API struct {
@@ -23,6 +30,14 @@ type API struct {
 	Addr       string
 	Controller *controller.Controller
 	Hub        *hub.Hub
+	// peers aggregated by this agent, see PEERS
+	Federation *federation.Federation
+	// mdns advertisement and browsing, nil unless MDNS is set
+	Discovery *federation.Discovery
+	jwt       *jwt.GinJWTMiddleware
+	conns     *connLimits
+	// set on shutdown, new websockets are refused
+	draining atomic.Bool
 }
 
 func NewAPI() (*API, error) {
@@ -32,54 +47,186 @@ func NewAPI() (*API, error) {
 	}
 
 	addr := os.Getenv("ADDR")
-	if addr == "" {
+	if addr == "" && config.String("LISTEN_SOCKET", "") == "" {
 
 		addr = "localhost:8080"
 		logrus.Infoln("ADDR not specified: running on default localhost:8080")
 	}
 
-	return &API{
-		Router:     gin.Default(),
+	pathPrefix = basePath()
+	router := gin.New()
+	router.Use(RequestLog(), Trace(), gin.Recovery())
+
+	peers := federation.New()
+	api := &API{
+		Router:     router,
 		Addr:       addr,
 		Controller: ctrl,
-		Hub:        hub.NewHub(ctrl),
-	}, nil
+		Hub:        hub.NewHub(ctrl, peers),
+		Federation: peers,
+		conns:      newConnLimits(),
+	}
+	if config.Bool("MDNS", false) {
+		api.Discovery = api.discovery()
+	}
+	return api, nil
 }
 
 func (api *API) RegRoutes() error {
-	jwt, err := JWT(api.Controller.DB.PasswordCorrect, api.Controller.DB.UserExists)
+	jwt, err := JWT(api.Controller.DB.PasswordCorrect, api.Controller.DB.UserExists, api.Controller.DB.UserScope, api.Controller.DB.UserRole)
 	if err != nil {
 		return err
 	}
+	api.jwt = jwt
 
-	api.Router.Use(cors.New(cors.Config{
-		AllowAllOrigins:  true,
+	origins := config.List("ALLOWED_ORIGINS", nil)
+	upgrade.CheckOrigin = checkOrigin(origins)
+	corsConfig := cors.Config{
+		AllowAllOrigins:  len(origins) == 0,
 		AllowHeaders:     []string{"Authorization", "content-type"},
 		AllowMethods:     []string{"PUT", "PATCH", "DELETE", "GET", "POST"},
 		AllowCredentials: true,
-	}))
+	}
+	if len(origins) > 0 {
+		corsConfig.AllowOriginFunc = func(origin string) bool {
+			return originAllowed(origins, origin)
+		}
+	}
+	api.Router.Use(cors.New(corsConfig))
+	api.Router.Use(Gzip(gzip.DefaultCompression))
+
+	api.Router.GET("/versions", api.Versions)
+	api.Router.GET("/readyz", api.Readyz)
+
+	v1 := api.Router.Group("/v1", Version("v1"))
+	api.regV1(v1, v1.Group(""), jwt)
+
+	// compatibility shims for frontends predating /v1
+	legacy := api.Router.Group("", Legacy(LatestVersion()))
+	api.regV1(legacy, legacy.Group("/api"), jwt)
+
+	if config.Bool("CADVISOR_API", false) {
+		api.regCadvisor(jwt)
+	}
+	if config.Bool("FLAT_METRICS", false) {
+		api.regFlat(jwt)
+	}
+
+	return api.regFrontend()
+}
+
+// regCadvisor registers the cadvisor compatible endpoints at the path
+// cadvisor clients expect, CADVISOR_AUTH=false serves them without token
+func (api *API) regCadvisor(jwt *jwt.GinJWTMiddleware) {
+	cadvisor := api.Router.Group("/api/v1.3")
+	if config.Bool("CADVISOR_AUTH", true) {
+		cadvisor.Use(jwt.MiddlewareFunc(), api.Scoped())
+	}
+	cadvisor.GET("/machine", api.CadvisorMachine)
+	cadvisor.GET("/containers", api.CadvisorRoot)
+	cadvisor.GET("/containers/docker/:id", api.CadvisorContainer)
+	cadvisor.GET("/docker", api.CadvisorDocker)
+	cadvisor.GET("/docker/:id", api.CadvisorDocker)
+}
+
+// regFlat registers the flat json output for telegraf and netdata,
+// FLAT_METRICS_AUTH=false serves it without token
+func (api *API) regFlat(jwt *jwt.GinJWTMiddleware) {
+	flat := api.Router.Group("/metrics")
+	if config.Bool("FLAT_METRICS_AUTH", true) {
+		flat.Use(jwt.MiddlewareFunc(), api.Scoped())
+	}
+	flat.GET("/flat", api.FlatMetrics)
+}
 
-	api.Router.POST("/login", jwt.LoginHandler)
-	authed := api.Router.Group("/api")
+// regV1 registers the v1 routes, authed holds the jwt protected endpoints
+func (api *API) regV1(public *gin.RouterGroup, authed *gin.RouterGroup, jwt *jwt.GinJWTMiddleware) {
+	public.POST("/login", jwt.LoginHandler)
 	authed.Use(jwt.MiddlewareFunc())
+	authed.Use(api.Audit())
+	authed.Use(api.Scoped())
+	authed.Use(ReadOnly())
 	authed.GET("refresh_token", jwt.RefreshHandler)
 
 	authed.GET("/containers/:id", api.Container)
 	authed.GET("/containers/all", api.Containers)
+	authed.GET("/containers/top", api.Top)
 	authed.GET("/containers/:id/metrics", api.Metrics)
+	authed.GET("/containers/:id/metrics/recent", api.RecentMetrics)
+	authed.GET("/containers/:id/archive", api.CopyFrom)
+	authed.GET("/host/containers/summary", api.HostSummary)
+	authed.GET("/host/sensors", api.HostSensors)
+	authed.GET("/projects", api.Projects)
+	authed.GET("/projects/:name/metrics", api.ProjectMetrics)
 	authed.GET("/images", api.Images)
 	authed.GET("/images/:id", api.Image)
+	authed.GET("/images/:id/remote-tags", api.RemoteTags)
+	authed.GET("/registries/credentials", api.Credentials)
+	authed.PUT("/registries/credentials/:host", api.SetCredential)
+	authed.DELETE("/registries/credentials/:host", api.RemoveCredential)
 	authed.GET("/about", api.About)
 	authed.GET("/volumes", api.Volumes)
+	authed.GET("/system/df", api.DiskUsage)
+	authed.GET("/topology", api.Topology)
+	authed.GET("/swarm/nodes", api.SwarmNodes)
+	authed.GET("/swarm/secrets", api.SwarmSecrets)
+	authed.GET("/swarm/configs", api.SwarmConfigs)
+	authed.GET("/events/queue", api.EventQueue)
+	authed.GET("/db/queue", api.WriteQueue)
+	authed.GET("/autoheal", api.AutoHeal)
+	authed.GET("/tasks", api.Tasks)
+	authed.GET("/collectors", api.Collectors)
+	authed.GET("/collectors/:name/metrics", api.CollectorMetrics)
+	authed.GET("/agent/metrics", api.AgentMetrics)
+	if api.Discovery != nil {
+		authed.GET("/discovery", api.Discovered)
+	}
+	if api.Federation.Enabled() {
+		authed.GET("/peers", api.Peers)
+		authed.GET("/federation/containers", api.FederatedContainers)
+		authed.Any("/peers/:name/api/*path", api.PeerProxy)
+	}
+	authed.GET("/hub/stats", api.HubStats)
+	authed.GET("/audit", api.AuditLog)
+	authed.GET("/views", api.Views)
+	authed.PUT("/views/:name", api.SaveView)
+	authed.DELETE("/views/:name", api.RemoveView)
 
-	authed.POST("/users", api.RegisterUser)
-	authed.DELETE("/users/:id", api.RemoveUser)
+	if config.Enabled("control") {
+		api.regControl(authed)
+	}
+
+	authed.POST("/users", Operator(), api.RegisterUser)
+	authed.DELETE("/users/:id", Operator(), api.RemoveUser)
 	authed.GET("/users", api.GetUsers)
-	authed.PATCH("/users/:id", api.PatchUser)
+	authed.PATCH("/users/:id", Operator(), api.PatchUser)
+	authed.POST("/admin/backup", Operator(), api.Backup)
+	authed.POST("/admin/restore", Operator(), api.Restore)
 
-	api.Router.GET("/stream", api.Stream)
+	public.GET("/ws", StreamToken(), jwt.MiddlewareFunc(), api.Stream)
+	public.GET("/link", StreamToken(), jwt.MiddlewareFunc(), api.Link)
+	if api.Federation.Accepting() {
+		public.GET("/push", StreamToken(), jwt.MiddlewareFunc(), Operator(), api.Push)
+	}
+	public.GET("/stream", Renamed("/ws"), StreamToken(), jwt.MiddlewareFunc(), api.Stream)
+}
 
-	return nil
+// regControl registers the endpoints creating or changing containers and
+// images, left out if the control feature is disabled. All of them
+// require the operator role
+func (api *API) regControl(authed *gin.RouterGroup) {
+	control := authed.Group("", Operator())
+	control.POST("/containers", api.CreateContainer)
+	control.POST("/containers/:id/update", api.UpdateContainer)
+	control.POST("/containers/:id/commit", api.CommitContainer)
+	control.PUT("/containers/:id/archive", api.CopyTo)
+	if config.Bool("EXPERIMENTAL_CHECKPOINTS", false) {
+		control.GET("/containers/:id/checkpoints", api.Checkpoints)
+		control.POST("/containers/:id/checkpoints", api.CreateCheckpoint)
+		control.POST("/containers/:id/checkpoints/:name/restore", api.RestoreCheckpoint)
+	}
+	control.POST("/images/build", api.BuildImage)
+	control.POST("/swarm/services/:id/scale", api.ScaleService)
 }
 
 func (api *API) Run() {
@@ -89,7 +236,38 @@ func (api *API) Run() {
 		return
 	}
 	go api.Hub.Run()
+	api.Federation.Run()
+	if pusher := federation.NewPusher(); pusher != nil {
+		go pusher.Run(api.servePush)
+	}
+	if api.Discovery != nil {
+		api.Discovery.Run()
+	}
+	go api.Watchdog()
 	// api.Controller.Storage.Events.SetInformer(api.Hub.BroadcastEvent)
 	logrus.Infoln("- API - starting gin router")
-	api.Router.Run(api.Addr)
+	listeners, err := listen(api.Addr, config.String("LISTEN_SOCKET", ""))
+	if err != nil {
+		logrus.Errorf("- API - failed to listen: %s\n", err)
+		return
+	}
+	server := newServer(api.Addr, withPrefix(pathPrefix, api.Router))
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- server.Serve(l)
+		}(l)
+	}
+	// ready once the ports are bound, systemd may retire the agent handing
+	// its sockets over as soon as it is told
+	if _, err := systemd.Notify("READY=1"); err != nil {
+		logrus.Warnf("- API - systemd notify failed: %s\n", err)
+	}
+	select {
+	case err := <-errs:
+		logrus.Errorf("- API - server stopped: %s\n", err)
+	case sig := <-shutdownSignals():
+		logrus.Infof("- API - received %s, shutting down\n", sig)
+		api.shutdown(server)
+	}
 }
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/requestid"
+	"github.com/sirupsen/logrus"
+)
+
+const auditKey = "audit"
+
+// auditDetail marks the request for the audit log with a description,
+// used for reading requests that are still worth recording
+func auditDetail(ctx *gin.Context, detail string) {
+	ctx.Set(auditKey, detail)
+}
+
+// Audit records every mutating request (and those marked by auditDetail)
+// with its user, target and result to the db
+func (api *API) Audit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		detail := ctx.GetString(auditKey)
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if detail == "" {
+				return
+			}
+		}
+
+		target := ctx.Param("id")
+		if target == "" {
+			target = ctx.Param("host")
+		}
+		entry := db.AuditEntry{
+			When:      time.Now(),
+			User:      identity(ctx),
+			Method:    ctx.Request.Method,
+			Route:     ctx.FullPath(),
+			Target:    target,
+			Detail:    detail,
+			Status:    ctx.Writer.Status(),
+			RequestID: requestid.From(ctx.Request.Context()),
+		}
+		if err := ctx.Errors.Last(); err != nil {
+			entry.Error = err.Error()
+		}
+		requestid.Logger(ctx.Request.Context()).Infof("- AUDIT - %s %s %s (%s) -> %d %s\n", entry.User, entry.Method, entry.Route, entry.Target, entry.Status, entry.Error)
+
+		go func() {
+			if err := api.Controller.DB.InsertAudit(entry); err != nil {
+				logrus.Warnf("- AUDIT - failed to store entry: %s\n", err)
+			}
+		}()
+	}
+}
+
+// /audit?from=X&to=Y&user=U&target=T&limit=N endpoint for fetching the
+// recorded control actions, newest first
+func (api *API) AuditLog(ctx *gin.Context) {
+	q := db.AuditQuery{
+		User:   ctx.Query("user"),
+		Target: ctx.Query("target"),
+	}
+	var err error
+	if from := ctx.Query("from"); from != "" {
+		q.From, err = time.Parse(time.RFC3339Nano, from)
+		if err != nil {
+			HttpErr(ctx, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if to := ctx.Query("to"); to != "" {
+		q.To, err = time.Parse(time.RFC3339Nano, to)
+		if err != nil {
+			HttpErr(ctx, http.StatusBadRequest, err)
+			return
+		}
+	}
+	q.Limit, err = strconv.ParseInt(ctx.DefaultQuery("limit", "100"), 10, 64)
+	if err != nil || q.Limit < 1 {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("limit has to be a positive number"))
+		return
+	}
+
+	entries, err := api.Controller.DB.Audit(q)
+	if err != nil {
+		HttpErr(ctx, http.StatusServiceUnavailable, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, entries)
+}
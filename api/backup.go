@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller"
+)
+
+// header carrying the passphrase a bundle is sealed with
+const passphraseHeader = "X-Backup-Passphrase"
+
+// largest bundle accepted by a restore
+const maxBundleBytes = 64 << 20
+
+// /admin/backup [POST] endpoint for an encrypted bundle of the agent's
+// configuration, users, saved views and registry credentials
+func (api *API) Backup(ctx *gin.Context) {
+	bundle, err := api.Controller.Backup(ctx.GetHeader(passphraseHeader))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, controller.ErrPassphrase) {
+			code = http.StatusBadRequest
+		}
+		HttpErr(ctx, code, err)
+		return
+	}
+	name := fmt.Sprintf("metawatch-backup-%s.mwb", time.Now().Format("20060102-150405"))
+	ctx.Header("Content-Disposition", "attachment; filename="+name)
+	ctx.Data(http.StatusOK, "application/octet-stream", bundle)
+}
+
+// /admin/restore [POST] endpoint for restoring a bundle of /admin/backup,
+// the configuration is returned as env file
+func (api *API) Restore(ctx *gin.Context) {
+	sealed, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxBundleBytes))
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	report, err := api.Controller.Restore(sealed, ctx.GetHeader(passphraseHeader))
+	if err != nil {
+		code := http.StatusBadRequest
+		if !errors.Is(err, controller.ErrBundle) {
+			code = dbStatus(err, http.StatusBadRequest)
+		}
+		HttpErr(ctx, code, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
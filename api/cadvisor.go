@@ -0,0 +1,324 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+)
+
+// cAdvisor compatible endpoints under /api/v1.3, see regCadvisor. Only the
+// fields the agent can fill are served
+
+// default number of stats per container, like cadvisor
+const defaultNumStats = 60
+
+// unlimited memory is reported as max uint64 like cadvisor does
+const cadvisorUnlimited = math.MaxUint64
+
+type CadvisorMachine struct {
+	Timestamp      time.Time         `json:"timestamp"`
+	NumCores       int               `json:"num_cores"`
+	MemoryCapacity int64             `json:"memory_capacity"`
+	MachineID      string            `json:"machine_id"`
+	SystemUUID     string            `json:"system_uuid"`
+	BootID         string            `json:"boot_id"`
+	Filesystems    []interface{}     `json:"filesystems"`
+	DiskMap        map[string]string `json:"disk_map"`
+	NetworkDevices []interface{}     `json:"network_devices"`
+	Topology       []interface{}     `json:"topology"`
+	CloudProvider  string            `json:"cloud_provider"`
+	InstanceType   string            `json:"instance_type"`
+	InstanceID     string            `json:"instance_id"`
+}
+
+type CadvisorContainer struct {
+	Name          string              `json:"name"`
+	Aliases       []string            `json:"aliases,omitempty"`
+	Namespace     string              `json:"namespace,omitempty"`
+	Subcontainers []CadvisorReference `json:"subcontainers,omitempty"`
+	Spec          CadvisorSpec        `json:"spec"`
+	Stats         []CadvisorStats     `json:"stats"`
+}
+
+type CadvisorReference struct {
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+}
+
+type CadvisorSpec struct {
+	CreationTime time.Time         `json:"creation_time"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	HasCPU       bool              `json:"has_cpu"`
+	CPU          struct {
+		Limit    uint64 `json:"limit"`
+		MaxLimit uint64 `json:"max_limit"`
+	} `json:"cpu"`
+	HasMemory bool `json:"has_memory"`
+	Memory    struct {
+		Limit     uint64 `json:"limit"`
+		SwapLimit uint64 `json:"swap_limit"`
+	} `json:"memory"`
+	HasNetwork    bool   `json:"has_network"`
+	HasFilesystem bool   `json:"has_filesystem"`
+	HasDiskIo     bool   `json:"has_diskio"`
+	Image         string `json:"image,omitempty"`
+}
+
+type CadvisorStats struct {
+	Timestamp time.Time `json:"timestamp"`
+	CPU       struct {
+		Usage struct {
+			// cumulative cpu time in nanoseconds
+			Total  uint64 `json:"total"`
+			User   uint64 `json:"user"`
+			System uint64 `json:"system"`
+		} `json:"usage"`
+		LoadAverage int32 `json:"load_average"`
+	} `json:"cpu"`
+	DiskIo struct {
+		IoServiceBytes []CadvisorDiskStats `json:"io_service_bytes,omitempty"`
+	} `json:"diskio"`
+	Memory struct {
+		Usage      uint64 `json:"usage"`
+		Cache      uint64 `json:"cache"`
+		RSS        uint64 `json:"rss"`
+		WorkingSet uint64 `json:"working_set"`
+	} `json:"memory"`
+	Network struct {
+		Name    string `json:"name"`
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"network"`
+}
+
+type CadvisorDiskStats struct {
+	Device string            `json:"device"`
+	Major  uint64            `json:"major"`
+	Minor  uint64            `json:"minor"`
+	Stats  map[string]uint64 `json:"stats"`
+}
+
+// cadvisorName is the cgroup style name cadvisor gives docker containers
+func cadvisorName(id string) string {
+	return "/docker/" + id
+}
+
+// cadvisorStats converts the sets of a container, oldest first. The sets
+// carry cpu percentages, the cumulative cpu time cadvisor reports is
+// integrated over them and starts at 0 with the oldest set
+func cadvisorStats(sets []metrics.Set, n int) []CadvisorStats {
+	stats := make([]CadvisorStats, 0, len(sets))
+	var cpuTotal float64
+	for i, set := range sets {
+		var s CadvisorStats
+		s.Timestamp = set.When.Time()
+		if i > 0 {
+			elapsed := set.When.Time().Sub(sets[i-1].When.Time())
+			cpuTotal += set.CPU.UsagePerc / 100 * float64(elapsed)
+		}
+		s.CPU.Usage.Total = uint64(cpuTotal)
+
+		s.Memory.Usage = uint64(set.Mem.Usage + set.Mem.Cache)
+		s.Memory.Cache = uint64(set.Mem.Cache)
+		s.Memory.RSS = uint64(set.Mem.Usage)
+		s.Memory.WorkingSet = uint64(set.Mem.Usage)
+
+		s.Network.Name = "eth0"
+		s.Network.RxBytes = uint64(set.Net.In)
+		s.Network.TxBytes = uint64(set.Net.Out)
+
+		for _, dev := range set.Disk.Devices {
+			s.DiskIo.IoServiceBytes = append(s.DiskIo.IoServiceBytes, CadvisorDiskStats{
+				Device: dev.Device,
+				Major:  dev.Major,
+				Minor:  dev.Minor,
+				Stats:  diskStats(dev.Read, dev.Write),
+			})
+		}
+		if len(set.Disk.Devices) == 0 {
+			s.DiskIo.IoServiceBytes = []CadvisorDiskStats{{Stats: diskStats(set.Disk.Read, set.Disk.Write)}}
+		}
+		stats = append(stats, s)
+	}
+	if len(stats) > n {
+		stats = stats[len(stats)-n:]
+	}
+	return stats
+}
+
+func diskStats(read, write float64) map[string]uint64 {
+	return map[string]uint64{
+		"Read":  uint64(read),
+		"Write": uint64(write),
+		"Total": uint64(read + write),
+	}
+}
+
+func cadvisorContainer(c *container.Container, n int) CadvisorContainer {
+	info := CadvisorContainer{
+		Name:      cadvisorName(c.ID),
+		Aliases:   []string{strings.TrimPrefix(c.Name, "/"), c.ID},
+		Namespace: "docker",
+		Stats:     make([]CadvisorStats, 0),
+	}
+	info.Spec.CreationTime, _ = time.Parse(time.RFC3339Nano, c.State.Started)
+	info.Spec.Labels = c.Labels
+	info.Spec.Image = c.Image.Tag
+	info.Spec.HasCPU = true
+	info.Spec.CPU.Limit = 1024
+	if c.Limits.CPUShares > 0 {
+		info.Spec.CPU.Limit = uint64(c.Limits.CPUShares)
+	}
+	info.Spec.CPU.MaxLimit = uint64(c.Limits.CPUs * 1000)
+	info.Spec.HasMemory = true
+	info.Spec.Memory.Limit = cadvisorUnlimited
+	if c.Limits.Memory > 0 {
+		info.Spec.Memory.Limit = uint64(c.Limits.Memory)
+	}
+	info.Spec.Memory.SwapLimit = cadvisorUnlimited
+	if c.Limits.MemorySwap > 0 {
+		info.Spec.Memory.SwapLimit = uint64(c.Limits.MemorySwap)
+	}
+	info.Spec.HasNetwork = true
+	info.Spec.HasDiskIo = true
+	if c.State.Status == "running" {
+		info.Stats = cadvisorStats(c.Streams.Metrics.History.Since(time.Time{}), n)
+	}
+	return info
+}
+
+// numStats reads the num_stats query of the request
+func numStats(ctx *gin.Context) (int, error) {
+	n, err := strconv.Atoi(ctx.DefaultQuery("num_stats", strconv.Itoa(defaultNumStats)))
+	if err != nil || n < 1 {
+		return 0, errors.New("num_stats has to be a positive number")
+	}
+	return n, nil
+}
+
+// cadvisorLookup finds a container by id or name
+func (api *API) cadvisorLookup(ref string) (*container.Container, bool) {
+	if c, exists := api.Controller.Containers.Container(ref); exists {
+		return c, true
+	}
+	for _, c := range api.Controller.Containers.Items() {
+		if strings.TrimPrefix(c.Name, "/") == ref || strings.HasPrefix(c.ID, ref) && len(ref) >= 12 {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// /api/v1.3/machine endpoint for the cadvisor machine info of the host
+func (api *API) CadvisorMachine(ctx *gin.Context) {
+	about := api.Controller.About.Snapshot()
+	ctx.JSON(http.StatusOK, CadvisorMachine{
+		Timestamp:      time.Now(),
+		NumCores:       about.CPUs,
+		MemoryCapacity: about.MaxMem,
+		MachineID:      about.Name,
+		Filesystems:    make([]interface{}, 0),
+		DiskMap:        make(map[string]string),
+		NetworkDevices: make([]interface{}, 0),
+		Topology:       make([]interface{}, 0),
+		CloudProvider:  "Unknown",
+		InstanceType:   "Unknown",
+		InstanceID:     "None",
+	})
+}
+
+// /api/v1.3/containers endpoint for the root container, stats are the sums
+// of the running containers and the containers are its subcontainers
+func (api *API) CadvisorRoot(ctx *gin.Context) {
+	n, err := numStats(ctx)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	about := api.Controller.About.Snapshot()
+	root := CadvisorContainer{
+		Name:          "/",
+		Subcontainers: make([]CadvisorReference, 0),
+	}
+	root.Spec.HasCPU = true
+	root.Spec.CPU.Limit = 1024
+	root.Spec.CPU.MaxLimit = uint64(about.CPUs * 1000)
+	root.Spec.HasMemory = true
+	root.Spec.Memory.Limit = uint64(about.MaxMem)
+	root.Spec.Memory.SwapLimit = cadvisorUnlimited
+	root.Spec.HasNetwork = true
+	root.Spec.HasDiskIo = true
+
+	series := make([][]metrics.Set, 0)
+	from := time.Now()
+	for _, c := range api.Controller.Containers.Items() {
+		root.Subcontainers = append(root.Subcontainers, CadvisorReference{
+			Name:      cadvisorName(c.ID),
+			Aliases:   []string{strings.TrimPrefix(c.Name, "/"), c.ID},
+			Namespace: "docker",
+		})
+		if c.State.Status != "running" {
+			continue
+		}
+		sets := c.Streams.Metrics.History.Since(time.Time{})
+		if len(sets) > 0 && sets[0].When.Time().Before(from) {
+			from = sets[0].When.Time()
+		}
+		series = append(series, sets)
+	}
+	sort.Slice(root.Subcontainers, func(i, j int) bool {
+		return root.Subcontainers[i].Name < root.Subcontainers[j].Name
+	})
+	root.Stats = cadvisorStats(metrics.SumSeries(series, from, time.Now(), n), n)
+	ctx.JSON(http.StatusOK, root)
+}
+
+// /api/v1.3/containers/docker/:id endpoint for the cadvisor info of a
+// container by id or name
+func (api *API) CadvisorContainer(ctx *gin.Context) {
+	n, err := numStats(ctx)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	c, exists := api.cadvisorLookup(ctx.Param("id"))
+	if !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return
+	}
+	ctx.JSON(http.StatusOK, cadvisorContainer(c, n))
+}
+
+// /api/v1.3/docker endpoint for the cadvisor info of the docker containers
+// by name, /api/v1.3/docker/:id for a single one
+func (api *API) CadvisorDocker(ctx *gin.Context) {
+	n, err := numStats(ctx)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	infos := make(map[string]CadvisorContainer)
+	if ref := ctx.Param("id"); ref != "" {
+		c, exists := api.cadvisorLookup(ref)
+		if !exists {
+			HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+			return
+		}
+		infos[cadvisorName(c.ID)] = cadvisorContainer(c, n)
+		ctx.JSON(http.StatusOK, infos)
+		return
+	}
+	for _, c := range api.Controller.Containers.Items() {
+		infos[cadvisorName(c.ID)] = cadvisorContainer(c, n)
+	}
+	ctx.JSON(http.StatusOK, infos)
+}
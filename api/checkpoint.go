@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type checkpointReq struct {
+	Name string `json:"name" binding:"required"`
+	Exit bool   `json:"exit"`
+}
+
+// experimental aborts with 501 if the daemon does not support checkpoints
+func (api *API) experimental(ctx *gin.Context) bool {
+	if !api.Controller.About.Snapshot().Experimental {
+		HttpErr(ctx, http.StatusNotImplemented, errors.New("docker daemon is not running in experimental mode"))
+		return false
+	}
+	if _, exists := api.Controller.Containers.Container(ctx.Param("id")); !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return false
+	}
+	return true
+}
+
+// /containers/:id/checkpoints endpoint for listing checkpoints of a container
+func (api *API) Checkpoints(ctx *gin.Context) {
+	if !api.experimental(ctx) {
+		return
+	}
+	checkpoints, err := api.Controller.Containers.Checkpoints(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, checkpoints)
+}
+
+// /containers/:id/checkpoints [POST] endpoint for checkpointing a running container
+func (api *API) CreateCheckpoint(ctx *gin.Context) {
+	var req checkpointReq
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if !api.experimental(ctx) {
+		return
+	}
+	err = api.Controller.Containers.Checkpoint(ctx.Request.Context(), ctx.Param("id"), req.Name, req.Exit)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"name": req.Name})
+}
+
+// /containers/:id/checkpoints/:name/restore [POST] endpoint for starting
+// a stopped container from a checkpoint
+func (api *API) RestoreCheckpoint(ctx *gin.Context) {
+	if !api.experimental(ctx) {
+		return
+	}
+	err := api.Controller.Containers.Restore(ctx.Request.Context(), ctx.Param("id"), ctx.Param("name"))
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"name": ctx.Param("name")})
+}
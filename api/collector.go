@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// /collectors endpoint for the running collectors with their latest set
+func (api *API) Collectors(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.Controller.Collectors.Status())
+}
+
+// /collectors/:name/metrics endpoint for the stored sets of a collector
+// between from and to, requires the db
+func (api *API) CollectorMetrics(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if !api.Controller.Collectors.Has(name) {
+		HttpErr(ctx, http.StatusNotFound, errors.New("collector not found"))
+		return
+	}
+	tmin, err := time.Parse(time.RFC3339Nano, ctx.Query("from"))
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("from has to be a RFC3339 time"))
+		return
+	}
+	tmax, err := time.Parse(time.RFC3339Nano, ctx.Query("to"))
+	if err != nil || !tmax.After(tmin) {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("to has to be a RFC3339 time after from"))
+		return
+	}
+	sets, err := api.Controller.DB.CollectorSets(name, tmin, tmax)
+	if err != nil {
+		HttpErr(ctx, dbStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	ctx.JSON(http.StatusOK, sets)
+}
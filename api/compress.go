@@ -0,0 +1,89 @@
+package api
+
+import (
+	"compress/gzip"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressible content types, binary or streamed content is passed through
+var compressible = []string{"application/json", "text/"}
+
+type gzipWriter struct {
+	gin.ResponseWriter
+	pool *sync.Pool
+	gz   *gzip.Writer
+	skip bool
+}
+
+// start decides on the first write whether the body gets compressed
+func (w *gzipWriter) start() {
+	contentType := w.Header().Get("Content-Type")
+	w.skip = true
+	for _, prefix := range compressible {
+		if strings.HasPrefix(contentType, prefix) {
+			w.skip = false
+		}
+	}
+	if w.skip || w.Header().Get("Content-Encoding") != "" {
+		w.skip = true
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Del("Content-Length")
+	w.gz = w.pool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.gz == nil && !w.skip {
+		w.start()
+	}
+	if w.skip {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// Gzip compresses json and text responses for clients that accept it.
+// Websocket upgrades are left untouched
+func Gzip(level int) gin.HandlerFunc {
+	pool := &sync.Pool{
+		New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		},
+	}
+
+	return func(ctx *gin.Context) {
+		if !strings.Contains(ctx.GetHeader("Accept-Encoding"), "gzip") ||
+			strings.EqualFold(ctx.GetHeader("Upgrade"), "websocket") {
+			ctx.Next()
+			return
+		}
+
+		w := &gzipWriter{
+			ResponseWriter: ctx.Writer,
+			pool:           pool,
+		}
+		ctx.Writer = w
+		defer w.close()
+		ctx.Next()
+	}
+}
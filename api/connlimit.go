@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
+)
+
+// closeTryAgainLater is sent to websockets rejected by a connection limit
+const closeTryAgainLater = 1013
+
+// connLimits counts the open websockets in total, per remote ip and per
+// user. A limit of 0 disables the check
+type connLimits struct {
+	mutex   *sync.Mutex
+	total   int
+	ips     map[string]int
+	users   map[string]int
+	max     int
+	maxIP   int
+	maxUser int
+}
+
+func newConnLimits() *connLimits {
+	l := &connLimits{
+		mutex:   &sync.Mutex{},
+		ips:     make(map[string]int),
+		users:   make(map[string]int),
+		max:     config.Int("MAX_CONNECTIONS", 0),
+		maxIP:   config.Int("MAX_CONNECTIONS_PER_IP", 0),
+		maxUser: config.Int("MAX_CONNECTIONS_PER_USER", 0),
+	}
+	selfmetrics.Register("ws_connections", func() float64 {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		return float64(l.total)
+	})
+	return l
+}
+
+// acquire reserves a connection for ip and user, the returned func
+// releases it again
+func (l *connLimits) acquire(ip, user string) (func(), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return nil, fmt.Errorf("connection limit of %d reached", l.max)
+	}
+	if l.maxIP > 0 && l.ips[ip] >= l.maxIP {
+		return nil, fmt.Errorf("connection limit of %d per ip reached", l.maxIP)
+	}
+	if l.maxUser > 0 && l.users[user] >= l.maxUser {
+		return nil, fmt.Errorf("connection limit of %d per user reached", l.maxUser)
+	}
+	l.total++
+	l.ips[ip]++
+	l.users[user]++
+
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() { l.release(ip, user) })
+	}, nil
+}
+
+func (l *connLimits) release(ip, user string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total--
+	if l.ips[ip]--; l.ips[ip] <= 0 {
+		delete(l.ips, ip)
+	}
+	if l.users[user]--; l.users[user] <= 0 {
+		delete(l.users, user)
+	}
+}
@@ -2,12 +2,20 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/api/hub"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+	"github.com/h0rzn/monitoring_agent/dock/controller"
 	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -23,11 +31,93 @@ func (api *API) Container(ctx *gin.Context) {
 
 // /containers/all endpoint for fetching all containers
 func (api *API) Containers(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, api.Controller.Containers)
+	if sel := scope(ctx); sel != nil {
+		JSONWithETag(ctx, http.StatusOK, api.Controller.Containers.Select(sel))
+		return
+	}
+	JSONWithETag(ctx, http.StatusOK, api.Controller.Containers)
+}
+
+// /containers [POST] endpoint for creating (and starting) a container
+func (api *API) CreateContainer(ctx *gin.Context) {
+	var opts container.CreateOpts
+	err := ctx.ShouldBindJSON(&opts)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	created, err := api.Controller.Containers.Create(ctx.Request.Context(), opts)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, created)
+}
+
+// /containers/:id/update [POST] endpoint for adjusting cpu and memory
+// limits of a running container
+func (api *API) UpdateContainer(ctx *gin.Context) {
+	var limits container.Limits
+	err := ctx.ShouldBindJSON(&limits)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, exists := api.Controller.Containers.Container(ctx.Param("id")); !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return
+	}
+	updated, err := api.Controller.Containers.Update(ctx.Request.Context(), ctx.Param("id"), limits)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, updated)
+}
+
+// /containers/:id/commit [POST] endpoint for snapshotting a container into an image
+func (api *API) CommitContainer(ctx *gin.Context) {
+	var opts controller.CommitOpts
+	err := ctx.ShouldBindJSON(&opts)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, exists := api.Controller.Containers.Container(ctx.Param("id")); !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return
+	}
+	img, err := api.Controller.Commit(ctx.Request.Context(), ctx.Param("id"), opts)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, img)
+}
+
+// /containers/top?metric=cpu|memory|net&n=10 endpoint for the heaviest
+// running containers based on their latest metrics
+func (api *API) Top(ctx *gin.Context) {
+	metric := ctx.DefaultQuery("metric", "cpu")
+	n, err := strconv.Atoi(ctx.DefaultQuery("n", "10"))
+	if err != nil || n < 1 {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("n has to be a positive number"))
+		return
+	}
+
+	top, err := api.Controller.Containers.Top(metric, n, scope(ctx))
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, top)
 }
 
 // /container/:id/metrics?from=X&to=Y endpoint for fetching container metrics
-// between X and Y
+// between X and Y, from the in memory history if no db is connected
 func (api *API) Metrics(ctx *gin.Context) {
 	id := ctx.Param("id")
 	query := ctx.Request.URL.Query()
@@ -53,21 +143,37 @@ func (api *API) Metrics(ctx *gin.Context) {
 
 	amount := 10
 	if amountParam := query.Get("amount"); amountParam != "" {
-		if n, err := strconv.Atoi(amountParam); err == nil {
+		if n, err := strconv.Atoi(amountParam); err == nil && n > 0 {
 			amount = n
 		}
 	}
 
-	tminP := primitive.NewDateTimeFromTime(tmin)
-	tmaxP := primitive.NewDateTimeFromTime(tmax)
-
-	dbResult := api.Controller.DB.Metrics(id, tminP, tmaxP)
+	var sets []metrics.Set
+	if api.Controller.DB.Connected() {
+		tminP := primitive.NewDateTimeFromTime(tmin)
+		tmaxP := primitive.NewDateTimeFromTime(tmax)
+		sets = api.Controller.DB.Metrics(id, tminP, tmaxP)[id]
+	} else {
+		cont, exists := api.Controller.Containers.Container(id)
+		if !exists {
+			HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+			return
+		}
+		for _, set := range cont.Streams.Metrics.History.Since(tmin) {
+			if !set.When.Time().After(tmax) {
+				sets = append(sets, set)
+			}
+		}
+	}
 	var result []metrics.Set
 
 	// delim := resultCount % amount
-	chunkSize := len(dbResult[id]) / amount
+	chunkSize := len(sets) / amount
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
 
-	chunks := metrics.Chunk(dbResult[id], chunkSize)
+	chunks := metrics.Chunk(sets, chunkSize)
 	for _, chunk := range chunks {
 		result = append(result, metrics.Average(chunk))
 	}
@@ -75,15 +181,79 @@ func (api *API) Metrics(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, result)
 }
 
-// /stream endpoint for accessing the websocket that supplies
-// live metrics, logs and events
+// /containers/:id/metrics/recent?since=5m endpoint for the metric sets kept
+// in memory, available without a db
+func (api *API) RecentMetrics(ctx *gin.Context) {
+	cont, exists := api.Controller.Containers.Container(ctx.Param("id"))
+	if !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return
+	}
+
+	since := time.Time{}
+	if param := ctx.Query("since"); param != "" {
+		d, err := time.ParseDuration(param)
+		if err != nil || d <= 0 {
+			HttpErr(ctx, http.StatusBadRequest, errors.New("since has to be a positive duration"))
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+
+	ctx.JSON(http.StatusOK, cont.Streams.Metrics.History.Since(since))
+}
+
+// /ws endpoint for accessing the websocket that supplies live metrics,
+// logs and events as subscriptions, the token is checked before the upgrade.
+// /stream is kept as deprecated alias
 func (api *API) Stream(ctx *gin.Context) {
-	con, err := upgrade.Upgrade(ctx.Writer, ctx.Request, nil)
+	if api.draining.Load() {
+		ctx.Header("Retry-After", strconv.Itoa(int(config.Duration("SHUTDOWN_RECONNECT_AFTER", defaultReconnectAfter).Seconds())))
+		HttpErr(ctx, http.StatusServiceUnavailable, errors.New("agent is shutting down"))
+		return
+	}
+	sel, err := streamScope(ctx)
 	if err != nil {
+		HttpErr(ctx, http.StatusForbidden, err)
+		return
+	}
+
+	header, err := streamHeader(ctx.Request)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	encoding := streamEncoding(ctx, header)
+	if encoding != hub.EncodingJSON && encoding != hub.EncodingProto {
+		HttpErr(ctx, http.StatusBadRequest, fmt.Errorf("unknown encoding %s", encoding))
+		return
+	}
+
+	release, limitErr := api.conns.acquire(ctx.ClientIP(), identity(ctx))
+	con, err := upgrade.Upgrade(ctx.Writer, ctx.Request, header)
+	if err != nil {
+		if release != nil {
+			release()
+		}
 		errBytes, _ := HttpErrBytes(500, err)
 		ctx.Writer.Write(errBytes)
 		return
 	}
-	client := api.Hub.CreateClient(con)
+	// rejected after the upgrade, browsers only see the close frame
+	if limitErr != nil {
+		logrus.Warnf("- API - rejecting websocket of %s: %s\n", ctx.ClientIP(), limitErr)
+		con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeTryAgainLater, limitErr.Error()), time.Now().Add(time.Second))
+		con.Close()
+		return
+	}
+	client := api.Hub.CreateClient(con, sel)
+	client.User = identity(ctx)
+	client.SetEncoding(encoding)
+	client.SetCompressible(strings.Contains(ctx.GetHeader("Sec-WebSocket-Extensions"), "permessage-deflate"))
+	api.Hub.Attach(client, ctx.Query("session"))
 	client.Run()
+	go func() {
+		<-client.Done()
+		release()
+	}()
 }
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// default size limit of archives copied in or out of containers
+const defaultCopyMaxBytes = 512 << 20
+
+// /containers/:id/archive?path=X [GET] endpoint for downloading a path
+// of a container as tar stream
+func (api *API) CopyFrom(ctx *gin.Context) {
+	id := ctx.Param("id")
+	src := ctx.Query("path")
+	if src == "" {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("path=x required"))
+		return
+	}
+	if _, exists := api.Controller.Containers.Container(id); !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return
+	}
+
+	limit := int64(config.Int("COPY_MAX_BYTES", defaultCopyMaxBytes))
+	r, stat, err := api.Controller.Containers.CopyFrom(ctx.Request.Context(), id, src)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	defer r.Close()
+	if stat.Mode.IsRegular() && stat.Size > limit {
+		HttpErr(ctx, http.StatusRequestEntityTooLarge, fmt.Errorf("%s exceeds the copy limit of %d bytes", src, limit))
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-tar")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(stat.Name)+".tar"))
+	ctx.Status(http.StatusOK)
+	// directories have no size up front, a stream growing past the limit
+	// is broken off
+	n, err := io.Copy(ctx.Writer, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		abortStream(ctx)
+		err = fmt.Errorf("exceeds the copy limit of %d bytes, aborted", limit)
+	}
+	detail := fmt.Sprintf("copied %s (%d bytes)", src, n)
+	if err != nil {
+		detail += ": " + err.Error()
+	}
+	auditDetail(ctx, detail)
+}
+
+// abortStream closes the connection of a response already under way without
+// ending it cleanly, so the client sees an error instead of a truncated body
+func abortStream(ctx *gin.Context) {
+	ctx.Writer.Flush()
+	conn, _, err := ctx.Writer.Hijack()
+	if err != nil {
+		logrus.Warnf("- API - failed to abort response: %s\n", err)
+		return
+	}
+	conn.Close()
+}
+
+// /containers/:id/archive?path=X [PUT] endpoint for extracting an uploaded
+// tar archive into the directory X of a container
+func (api *API) CopyTo(ctx *gin.Context) {
+	id := ctx.Param("id")
+	dst := ctx.Query("path")
+	if dst == "" {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("path=x required"))
+		return
+	}
+	if _, exists := api.Controller.Containers.Container(id); !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+		return
+	}
+
+	limit := int64(config.Int("COPY_MAX_BYTES", defaultCopyMaxBytes))
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	err := api.Controller.Containers.CopyTo(ctx.Request.Context(), id, dst, body)
+	auditDetail(ctx, "extracted archive to "+dst)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, struct{}{})
+}
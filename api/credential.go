@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller"
+)
+
+type credentialReq struct {
+	User     string `json:"user" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// /registries/credentials endpoint for listing stored registry
+// credentials, secrets are never returned
+func (api *API) Credentials(ctx *gin.Context) {
+	creds, err := api.Controller.Keychain.List()
+	if err != nil {
+		HttpErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, creds)
+}
+
+// /registries/credentials/:host [PUT] endpoint for storing the credentials
+// of a registry host (e.g. ghcr.io, registry-1.docker.io)
+func (api *API) SetCredential(ctx *gin.Context) {
+	var req credentialReq
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	host := ctx.Param("host")
+	err = api.Controller.Keychain.Set(host, req.User, req.Password)
+	if errors.Is(err, controller.ErrNoKeychainKey) {
+		HttpErr(ctx, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		HttpErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"host": host, "user": req.User})
+}
+
+// /registries/credentials/:host [DELETE] endpoint for removing the credentials of a registry
+func (api *API) RemoveCredential(ctx *gin.Context) {
+	host := ctx.Param("host")
+	err := api.Controller.Keychain.Remove(host)
+	if err != nil {
+		HttpErr(ctx, http.StatusNotFound, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, struct{}{})
+}
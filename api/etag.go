@@ -0,0 +1,57 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tagger is implemented by values caching their json along with its etag
+type tagger interface {
+	TaggedJSON() ([]byte, string, error)
+}
+
+// JSONWithETag writes obj as json tagged with a content hash and answers
+// 304 if the client already holds that content (If-None-Match)
+func JSONWithETag(ctx *gin.Context, code int, obj interface{}) {
+	var body []byte
+	var etag string
+	var err error
+	if t, ok := obj.(tagger); ok {
+		body, etag, err = t.TaggedJSON()
+	} else {
+		body, err = json.Marshal(obj)
+		sum := sha256.Sum256(body)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	if err != nil {
+		HttpErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.Header("ETag", etag)
+
+	if etagMatch(ctx.GetHeader("If-None-Match"), etag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(code, "application/json; charset=utf-8", body)
+}
+
+// etagMatch checks a If-None-Match header value against etag,
+// weak comparison as defined by RFC 7232
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
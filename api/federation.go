@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/federation"
+	"github.com/sirupsen/logrus"
+)
+
+// peerTransport sends the proxied requests with the token of the peer
+type peerTransport struct {
+	peer *federation.Peer
+}
+
+func (t peerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.peer.Do(req)
+}
+
+// /peers endpoint for the connection state of the aggregated peers
+func (api *API) Peers(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.Federation.Status())
+}
+
+// /federation/containers endpoint for the containers of this host and of
+// the peers, containers of peers have host-prefixed ids
+func (api *API) FederatedContainers(ctx *gin.Context) {
+	data, err := json.Marshal(api.Controller.Containers)
+	if err != nil {
+		HttpErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	containers := make([]federation.Container, 0)
+	if err = json.Unmarshal(data, &containers); err != nil {
+		HttpErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	host := api.Controller.About.Snapshot().Name
+	for _, c := range containers {
+		c["host"] = host
+	}
+	ctx.JSON(http.StatusOK, append(containers, api.Federation.Containers()...))
+}
+
+// /peers/:name/api/*path endpoint passing requests to the v1 api of a peer,
+// e.g. /peers/edge-1/api/containers/:id/metrics. Requests changing the
+// peer require the operator role
+func (api *API) PeerProxy(ctx *gin.Context) {
+	peer, exists := api.Federation.Peer(ctx.Param("name"))
+	if !exists {
+		HttpErr(ctx, http.StatusNotFound, errors.New("peer not found"))
+		return
+	}
+	if peer.URL == nil {
+		HttpErr(ctx, http.StatusBadGateway, errors.New("peer pushes its link, its api is not reachable"))
+		return
+	}
+	if ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+		v, _ := ctx.Get(jwtIDKey)
+		if user, ok := v.(*JWTUser); !ok || user.Role != db.RoleOperator {
+			HttpErr(ctx, http.StatusForbidden, errors.New("operator role required"))
+			return
+		}
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			target := *peer.URL
+			target.Path = strings.TrimSuffix(target.Path, "/") + "/v1/" + strings.TrimPrefix(ctx.Param("path"), "/")
+			target.RawPath = ""
+			target.RawQuery = req.URL.RawQuery
+			req.URL = &target
+			req.Host = target.Host
+			req.Header.Del("Authorization")
+			req.Header.Del("Cookie")
+		},
+		Transport: peerTransport{peer: peer},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			HttpErr(ctx, http.StatusBadGateway, err)
+		},
+	}
+	proxy.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+// /link endpoint for the websocket of aggregating agents, speaking the
+// link protocol (federation/proto/link.proto). A link resumes its session
+// with ?session=<token>&since=<last host sequence number>
+func (api *API) Link(ctx *gin.Context) {
+	if api.draining.Load() {
+		ctx.Header("Retry-After", strconv.Itoa(int(config.Duration("SHUTDOWN_RECONNECT_AFTER", defaultReconnectAfter).Seconds())))
+		HttpErr(ctx, http.StatusServiceUnavailable, errors.New("agent is shutting down"))
+		return
+	}
+	offered := false
+	for _, protocol := range websocketProtocols(ctx.Request) {
+		offered = offered || protocol == federation.LinkProtocol
+	}
+	if !offered {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("the "+federation.LinkProtocol+" subprotocol is required"))
+		return
+	}
+	var since uint64
+	if param := ctx.Query("since"); param != "" {
+		var err error
+		if since, err = strconv.ParseUint(param, 10, 64); err != nil {
+			HttpErr(ctx, http.StatusBadRequest, errors.New("since has to be a sequence number"))
+			return
+		}
+	}
+	sel, err := streamScope(ctx)
+	if err != nil {
+		HttpErr(ctx, http.StatusForbidden, err)
+		return
+	}
+
+	release, limitErr := api.conns.acquire(ctx.ClientIP(), identity(ctx))
+	header := http.Header{"Sec-Websocket-Protocol": {federation.LinkProtocol}}
+	con, err := upgrade.Upgrade(ctx.Writer, ctx.Request, header)
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		errBytes, _ := HttpErrBytes(500, err)
+		ctx.Writer.Write(errBytes)
+		return
+	}
+	if limitErr != nil {
+		logrus.Warnf("- API - rejecting link of %s: %s\n", ctx.ClientIP(), limitErr)
+		con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeTryAgainLater, limitErr.Error()), time.Now().Add(time.Second))
+		con.Close()
+		return
+	}
+	client := api.Hub.CreateLink(con, sel)
+	client.User = identity(ctx)
+	api.Hub.AttachLink(client, ctx.Query("session"), since)
+	client.Run()
+	go func() {
+		<-client.Done()
+		release()
+	}()
+}
+
+// /push endpoint for the links of agents that cannot be dialed, the agent
+// opens the link itself and is aggregated as peer ?name=<name>
+func (api *API) Push(ctx *gin.Context) {
+	offered := false
+	for _, protocol := range websocketProtocols(ctx.Request) {
+		offered = offered || protocol == federation.LinkProtocol
+	}
+	if !offered {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("the "+federation.LinkProtocol+" subprotocol is required"))
+		return
+	}
+	if sel, _ := streamScope(ctx); sel != nil {
+		HttpErr(ctx, http.StatusForbidden, errors.New("scoped users cannot push"))
+		return
+	}
+	name := ctx.Query("name")
+	owner := identity(ctx)
+	if peer, exists := api.Federation.Peer(name); exists && peer.URL != nil {
+		HttpErr(ctx, http.StatusConflict, errors.New("peer "+name+" is dialed by this agent"))
+		return
+	} else if exists && peer.Owner() != owner {
+		HttpErr(ctx, http.StatusForbidden, federation.ErrPushOwner)
+		return
+	}
+
+	header := http.Header{"Sec-Websocket-Protocol": {federation.LinkProtocol}}
+	con, err := upgrade.Upgrade(ctx.Writer, ctx.Request, header)
+	if err != nil {
+		errBytes, _ := HttpErrBytes(500, err)
+		ctx.Writer.Write(errBytes)
+		return
+	}
+	if err = api.Federation.Accept(name, owner, con); err != nil {
+		logrus.Warnf("- API - rejecting push link of %s: %s\n", ctx.ClientIP(), err)
+		con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()), time.Now().Add(time.Second))
+		con.Close()
+	}
+}
+
+// servePush serves the link this agent pushes to its aggregator
+func (api *API) servePush(con *websocket.Conn, session string, since uint64) <-chan struct{} {
+	client := api.Hub.CreateLink(con, nil)
+	api.Hub.AttachLink(client, session, since)
+	client.Run()
+	return client.Done()
+}
+
+// discovery advertises the agent on the local network, nil if it cannot.
+// The agents found at a host or address of MDNS_PEERS are aggregated as
+// peers, the shared peer credentials are not sent to others
+func (api *API) discovery() *federation.Discovery {
+	port := config.Int("MDNS_PORT", 0)
+	if port == 0 {
+		var err error
+		if port, err = federation.ListenPort(api.Addr); err != nil {
+			logrus.Errorf("- API - mdns disabled: %s, set MDNS_PORT\n", err)
+			return nil
+		}
+	}
+	txt := []string{"path=" + pathPrefix, "api=" + strings.Join(Versions, ",")}
+	d, err := federation.NewDiscovery(port, txt)
+	if err != nil {
+		logrus.Errorf("- API - mdns disabled: %s\n", err)
+		return nil
+	}
+	if allowed := config.List("MDNS_PEERS", nil); len(allowed) > 0 {
+		d.OnFound(func(agent federation.Agent) {
+			root, listed := agent.Listed(allowed)
+			if !listed {
+				logrus.Infof("- API - not aggregating %s at %s, its host is not in MDNS_PEERS\n", agent.Name, agent.URL)
+				return
+			}
+			if err := api.Federation.Add(agent.Name, root); err != nil {
+				logrus.Warnf("- API - not aggregating %s: %s\n", agent.Name, err)
+			}
+		})
+	}
+	return d
+}
+
+// DiscoveredAgent is an agent found on the network, Peer is set if a peer
+// of its name is aggregated
+type DiscoveredAgent struct {
+	federation.Agent
+	Peer bool `json:"peer"`
+}
+
+// /discovery endpoint for the agents advertising themselves on the local
+// network with mdns
+func (api *API) Discovered(ctx *gin.Context) {
+	agents := api.Discovery.Agents()
+	discovered := make([]DiscoveredAgent, 0, len(agents))
+	for _, agent := range agents {
+		_, peer := api.Federation.Peer(agent.Name)
+		discovered = append(discovered, DiscoveredAgent{Agent: agent, Peer: peer})
+	}
+	ctx.JSON(http.StatusOK, discovered)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+)
+
+// FlatRow is a measurement as flat json object, name and the string
+// values are tags, the numbers fields. Telegraf's json parser and custom
+// netdata collectors read them without further mapping
+type FlatRow map[string]interface{}
+
+// flatFields adds the values of set to row
+func flatFields(row FlatRow, set metrics.Set) {
+	row["timestamp"] = int64(set.When)
+	row["cpu_perc"] = set.CPU.UsagePerc
+	row["cpu_host_perc"] = set.CPU.HostPerc
+	row["cpu_limit_perc"] = set.CPU.LimitPerc
+	row["cpu_throttled_periods"] = set.CPU.ThrottledPeriods
+	row["mem_perc"] = set.Mem.UsagePerc
+	row["mem_usage_bytes"] = set.Mem.Usage
+	row["mem_cache_bytes"] = set.Mem.Cache
+	row["mem_limit_bytes"] = set.Mem.Limit
+	row["disk_read_bytes"] = set.Disk.Read
+	row["disk_write_bytes"] = set.Disk.Write
+	row["disk_read_rate"] = set.Disk.ReadRate
+	row["disk_write_rate"] = set.Disk.WriteRate
+	row["net_in_bytes"] = set.Net.In
+	row["net_out_bytes"] = set.Net.Out
+	row["net_in_rate"] = set.Net.InRate
+	row["net_out_rate"] = set.Net.OutRate
+}
+
+// flatRows returns a row per running container with metrics, a host row of
+// their totals and a row per sample of the collectors
+func (api *API) flatRows() []FlatRow {
+	host := api.Controller.About.Snapshot().Name
+	rows := make([]FlatRow, 0)
+	latest := make([]metrics.Set, 0)
+	for _, c := range api.Controller.Containers.Items() {
+		if c.State.Status != "running" {
+			continue
+		}
+		set := c.Streams.Metrics.Latest()
+		if set.When == 0 {
+			continue
+		}
+		latest = append(latest, set)
+		row := FlatRow{
+			"name":           "container",
+			"host":           host,
+			"container_id":   c.ID,
+			"container_name": strings.TrimPrefix(c.Name, "/"),
+			"image":          c.Image.Tag,
+			"project":        c.Labels[container.ProjectLabel],
+		}
+		flatFields(row, set)
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i]["container_name"].(string) < rows[j]["container_name"].(string)
+	})
+
+	summary := metrics.NewSummary(latest)
+	total := FlatRow{
+		"name":    "host",
+		"host":    host,
+		"running": summary.Running,
+	}
+	flatFields(total, summary.Total)
+	rows = append(rows, total)
+
+	for _, status := range api.Controller.Collectors.Status() {
+		if status.Latest == nil {
+			continue
+		}
+		for _, sample := range status.Latest.Samples {
+			row := FlatRow{
+				"name":      status.Name,
+				"host":      host,
+				"sample":    sample.Name,
+				"value":     sample.Value,
+				"timestamp": int64(status.Latest.When),
+			}
+			if sample.Unit != "" {
+				row["unit"] = sample.Unit
+			}
+			for k, v := range sample.Labels {
+				if _, taken := row[k]; !taken {
+					row[k] = v
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// /metrics/flat endpoint for the latest metrics as flat json objects
+func (api *API) FlatMetrics(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.flatRows())
+}
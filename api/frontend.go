@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// set by builds with the embedfrontend tag, see frontend_embed.go
+var embeddedFrontend fs.FS
+
+// api paths never answered by the frontend, unknown routes below them stay 404
+var apiPrefixes = []string{"/v1/", "/api/", "/ws", "/stream", "/login", "/versions", "/readyz"}
+
+// frontendFS returns the web ui to serve, FRONTEND_DIR takes precedence over
+// an embedded build. nil if neither is available
+func frontendFS() (fs.FS, error) {
+	if dir := config.String("FRONTEND_DIR", ""); dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, errors.New("FRONTEND_DIR is not a directory")
+		}
+		return os.DirFS(dir), nil
+	}
+	return embeddedFrontend, nil
+}
+
+// Frontend serves the files of the web ui, unknown paths fall back to
+// index.html so the client side router of the single page app takes over
+func Frontend(files fs.FS) gin.HandlerFunc {
+	server := http.FileServer(http.FS(files))
+	return func(ctx *gin.Context) {
+		method := ctx.Request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			HttpErr(ctx, http.StatusNotFound, errors.New("route not found"))
+			return
+		}
+		for _, prefix := range apiPrefixes {
+			if strings.HasPrefix(ctx.Request.URL.Path, prefix) {
+				HttpErr(ctx, http.StatusNotFound, errors.New("route not found"))
+				return
+			}
+		}
+
+		name := strings.TrimPrefix(path.Clean(ctx.Request.URL.Path), "/")
+		if name != "" {
+			if info, err := fs.Stat(files, name); err == nil && !info.IsDir() {
+				server.ServeHTTP(ctx.Writer, ctx.Request)
+				return
+			}
+		}
+
+		index, err := fs.ReadFile(files, "index.html")
+		if err != nil {
+			HttpErr(ctx, http.StatusNotFound, errors.New("frontend has no index.html"))
+			return
+		}
+		// the index references the hashed assets of the current build
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	}
+}
+
+// regFrontend serves the web ui at / if one is configured
+func (api *API) regFrontend() error {
+	files, err := frontendFS()
+	if err != nil {
+		return err
+	}
+	if files == nil {
+		return nil
+	}
+	logrus.Infoln("- API - serving frontend at /")
+	api.Router.NoRoute(Frontend(files))
+	return nil
+}
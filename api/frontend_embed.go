@@ -0,0 +1,22 @@
+//go:build embedfrontend
+
+package api
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// build output of the web ui, copied to api/frontend before building with
+// -tags embedfrontend
+//
+//go:embed all:frontend
+var frontendFiles embed.FS
+
+func init() {
+	files, err := fs.Sub(frontendFiles, "frontend")
+	if err != nil {
+		panic(err)
+	}
+	embeddedFrontend = files
+}
@@ -6,12 +6,28 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// /about endpoint for general data like docker (api) verion, ...
+// /about endpoint for general data like docker (api) verion, kernel, storage
+// driver and cgroup version, ?refresh=true skips the cached value
 func (a *API) About(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, a.Controller.About)
+	if ctx.Query("refresh") == "true" {
+		err := a.Controller.UpdateAbout()
+		if err != nil {
+			HttpErr(ctx, http.StatusBadGateway, err)
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, a.Controller.About.Snapshot())
 }
 
-// /volumes endpoint for list of volumes
+// /volumes endpoint for list of volumes, ?refresh=true reads them
+// from the daemon instead of the last disk usage refresh
 func (a *API) Volumes(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, a.Controller.Volumes)
+	if ctx.Query("refresh") == "true" {
+		err := a.Controller.UpdateVolumes()
+		if err != nil {
+			HttpErr(ctx, http.StatusBadGateway, err)
+			return
+		}
+	}
+	JSONWithETag(ctx, http.StatusOK, a.Controller.Volumes)
 }
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/collector/sensors"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+)
+
+// /host/containers/summary endpoint for totals and averages of the
+// latest metrics over all running containers
+func (api *API) HostSummary(ctx *gin.Context) {
+	latest := api.Controller.Containers.CollectLatest()
+	ctx.JSON(http.StatusOK, metrics.NewSummary(latest))
+}
+
+// /host/sensors endpoint for the latest temperatures, fan speeds and SMART
+// health of the host, requires the sensors collector
+func (api *API) HostSensors(ctx *gin.Context) {
+	if !api.Controller.Collectors.Has(sensors.Name) {
+		HttpErr(ctx, http.StatusNotFound, errors.New("sensors collector is not enabled"))
+		return
+	}
+	set, ok := api.Controller.Collectors.Latest(sensors.Name)
+	if !ok {
+		HttpErr(ctx, http.StatusServiceUnavailable, errors.New("sensors not collected yet"))
+		return
+	}
+	ctx.JSON(http.StatusOK, set)
+}
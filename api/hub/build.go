@@ -0,0 +1,161 @@
+package hub
+
+import (
+	"io"
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// output lines kept for subscribers joining a running build
+const maxBuildLines = 5000
+
+// BuildOutput reads a build's output, calling line for every output line
+type BuildOutput func(output io.ReadCloser, line func(string)) (string, error)
+
+// BuildState is sent to joining subscribers and once the build ended
+type BuildState struct {
+	ID      string   `json:"id"`
+	Done    bool     `json:"done"`
+	Error   string   `json:"error,omitempty"`
+	ImageID string   `json:"image_id,omitempty"`
+	Lines   []string `json:"lines"`
+}
+
+// BuildR relays the output of an image build started through the api,
+// the build runs to completion regardless of subscribers
+type BuildR struct {
+	mutex   *sync.Mutex
+	Typ     string
+	ID      string
+	Subs    map[*Client]bool
+	LveSig  chan Resource
+	Timeout *Timeout
+	output  io.ReadCloser
+	read    BuildOutput
+	lines   []string
+	done    bool
+	err     string
+	imageID string
+}
+
+func NewBuildR(id string, output io.ReadCloser, read BuildOutput, lveSig chan Resource) *BuildR {
+	r := &BuildR{
+		mutex:  &sync.Mutex{},
+		Typ:    "image_build",
+		ID:     id,
+		Subs:   make(map[*Client]bool),
+		LveSig: lveSig,
+		output: output,
+		read:   read,
+		lines:  make([]string, 0),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *BuildR) CID() string {
+	return r.ID
+}
+
+func (r *BuildR) Type() string {
+	return r.Typ
+}
+
+func (r *BuildR) Run() error {
+	go func() {
+		imageID, err := r.read(r.output, r.line)
+
+		r.mutex.Lock()
+		r.done = true
+		r.imageID = imageID
+		if err != nil {
+			r.err = err.Error()
+			logrus.Errorf("- HUB - build %s failed: %s\n", r.ID, err)
+		}
+		state := r.state()
+		idle := len(r.Subs) == 0
+		r.mutex.Unlock()
+
+		r.Broadcast(*stream.NewSet("image_build", state))
+		// keep the result around for late subscribers
+		if idle {
+			r.Timeout.Start()
+		}
+	}()
+	return nil
+}
+
+func (r *BuildR) line(l string) {
+	r.mutex.Lock()
+	r.lines = append(r.lines, l)
+	if len(r.lines) > maxBuildLines {
+		r.lines = r.lines[len(r.lines)-maxBuildLines:]
+	}
+	r.mutex.Unlock()
+	r.Broadcast(*stream.NewSet("image_build", l))
+}
+
+// state is the build output so far, callers hold the mutex
+func (r *BuildR) state() BuildState {
+	lines := make([]string, len(r.lines))
+	copy(lines, r.lines)
+	return BuildState{
+		ID:      r.ID,
+		Done:    r.done,
+		Error:   r.err,
+		ImageID: r.imageID,
+		Lines:   lines,
+	}
+}
+
+func (r *BuildR) Add(c *Client) {
+	r.mutex.Lock()
+	r.Subs[c] = true
+	state := r.state()
+	r.mutex.Unlock()
+	c.Send(&Response{
+		CID:     r.ID,
+		Type:    r.Typ,
+		Message: state,
+	})
+}
+
+func (r *BuildR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	// a running build outlives its subscribers
+	idle := len(r.Subs) == 0 && r.done
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *BuildR) Broadcast(set stream.Set) {
+	frame := &Response{
+		CID:     r.ID,
+		Type:    r.Typ,
+		Message: set.Data,
+	}
+	r.mutex.Lock()
+	for c := range r.Subs {
+		c.Send(frame)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *BuildR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *BuildR) Quit() {
+	logrus.Debugln("- HUB - image build resource quit")
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
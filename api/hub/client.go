@@ -7,31 +7,96 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/container"
 	"github.com/sirupsen/logrus"
 )
 
 type Request struct {
-	CID   string `json:"container_id"`
-	Event string `json:"event"` // eg subscribe
-	Type  string `json:"type"`  // eg metrics
+	// correlation id, echoed by the ack or error answering the demand
+	ID      string              `json:"id,omitempty"`
+	CID     string              `json:"container_id"`
+	Event   string              `json:"event"` // eg subscribe
+	Type    string              `json:"type"`  // eg metrics
+	Filters map[string][]string `json:"filters,omitempty"`
+	// only forward frames matching the expression, see Expr
+	Where string `json:"where,omitempty"`
+	// deliver at most one frame per interval (e.g. "10s"), the last one
+	// or with aggregate "average" the average of the interval
+	Interval  string `json:"interval,omitempty"`
+	Aggregate string `json:"aggregate,omitempty"`
+	// saved view to activate
+	View string `json:"view,omitempty"`
+	// replay the frames after this sequence number on subscribe
+	Since uint64 `json:"since,omitempty"`
+	// protocol version and capabilities offered by a hello
+	Version      int      `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 type Response struct {
 	CID     string      `json:"container_id,omitempty"`
 	Type    string      `json:"type"`
 	Message interface{} `json:"message"`
+	// sequence number within the resource, for replays
+	Seq uint64 `json:"seq,omitempty"`
+	// cached value sent on subscribe, before the live frames
+	Snapshot bool `json:"snapshot,omitempty"`
 }
 
 type CloseMessage struct {
 	Type string `json:"type"`
+	// close code, normal closure if 0
+	code int
 }
 
 type Demand struct {
+	ID string
+	// set for commands, subscribe and unsubscribe have their own channels
+	Event     string
 	Client    *Client
 	CID       string
 	Ressource string
+	Filters   map[string][]string
+	Shape     Shape
+	Since     uint64
+	// subscriptions of an activated view, set by the hub
+	batch []*Demand
 }
 
+// newDemand reads the demand of req, subscribe and unsubscribe demands are
+// validated. The demand is returned with the error to address it
+func newDemand(c *Client, req *Request) (*Demand, error) {
+	demand := &Demand{
+		ID:        req.ID,
+		CID:       req.CID,
+		Client:    c,
+		Ressource: req.Type,
+		Filters:   req.Filters,
+		Since:     req.Since,
+	}
+	shape, err := parseShape(req)
+	if err != nil {
+		return demand, err
+	}
+	demand.Shape = shape
+	if req.Event == "subscribe" || req.Event == "unsubscribe" {
+		return demand, validate(demand)
+	}
+	if req.Event == "view" {
+		// the view name is addressed like a container id
+		demand.CID = req.View
+	}
+	return demand, nil
+}
+
+const (
+	// default time allowed to write a single frame to the peer
+	defaultWriteWait = 10 * time.Second
+	// default frames queued per client before new frames are dropped
+	defaultSendQueue = 64
+)
+
 type Client struct {
 	wg       *sync.WaitGroup
 	ctx      context.Context
@@ -40,22 +105,58 @@ type Client struct {
 	In       chan *Response
 	Sub      chan *Demand
 	USub     chan *Demand
+	Cmd      chan *Demand
 	Lve      chan *Client
 	sndClose chan CloseMessage
+	// containers visible to the client, empty if unrestricted
+	Scope container.Selector
+	// authenticated user, owner of the saved views
+	User    string
+	session *Session
+	// numbering of the session if the client is a link
+	link *linkLog
+	// send counters of the client and the hub wide ones
+	sMutex   *sync.Mutex
+	sent     SendStats
+	dropping bool
+	stats    *sendStats
+	// deadline of a single write, a stuck peer is dropped after it
+	writeWait time.Duration
+	codec     codec
+	// negotiated by hello, owned by the writer
+	batching bool
+	maxBatch int
+	// permessage-deflate was negotiated on upgrade
+	compressible bool
 }
 
 func NewClient(con *websocket.Conn, sub chan *Demand, usub chan *Demand, lve chan *Client) *Client {
 	return &Client{
-		wg:       &sync.WaitGroup{},
-		con:      con,
-		In:       make(chan *Response),
-		Sub:      sub,
-		USub:     usub,
-		Lve:      lve,
-		sndClose: make(chan CloseMessage, 1),
+		wg:        &sync.WaitGroup{},
+		con:       con,
+		In:        make(chan *Response, config.Int("HUB_CLIENT_BUFFER", defaultSendQueue)),
+		Sub:       sub,
+		USub:      usub,
+		Lve:       lve,
+		sndClose:  make(chan CloseMessage, 1),
+		sMutex:    &sync.Mutex{},
+		writeWait: config.Duration("WS_WRITE_TIMEOUT", defaultWriteWait),
+		codec:     jsonCodec{},
+		maxBatch:  config.Int("HUB_BATCH_SIZE", defaultMaxBatch),
 	}
 }
 
+// SetEncoding selects the encoding of demands and frames, EncodingJSON
+// or EncodingProto, before the client runs
+func (c *Client) SetEncoding(encoding string) error {
+	cd, err := newCodec(encoding)
+	if err != nil {
+		return err
+	}
+	c.codec = cd
+	return nil
+}
+
 func (c *Client) parse() {
 	defer func() {
 		c.wg.Done()
@@ -68,9 +169,7 @@ func (c *Client) parse() {
 			fmt.Println("parse done <-c.done")
 			return
 		default:
-			var frame *Request
-			err := c.con.ReadJSON(&frame)
-			fmt.Println(err, frame)
+			typ, data, err := c.con.ReadMessage()
 			if err != nil {
 				go c.CloseByRemote()
 				select {
@@ -79,21 +178,33 @@ func (c *Client) parse() {
 				case <-time.After(3 * time.Second):
 					fmt.Println("CLIENT parse failed to finish")
 				}
+				return
 			}
-
-			demand := &Demand{
-				CID:       frame.CID,
-				Client:    c,
-				Ressource: frame.Type,
+			frame, err := c.decoder(typ).decode(typ, data)
+			if err != nil {
+				c.Error(nil, demandErr(CodeInvalidDemand, "malformed demand: %s", err))
+				continue
+			}
+			if frame.Event == "hello" {
+				c.hello(frame)
+				continue
 			}
 
+			demand, err := newDemand(c, frame)
+			if err != nil {
+				c.Error(demand, err)
+				continue
+			}
 			switch frame.Event {
 			case "subscribe":
 				c.Sub <- demand
 			case "unsubscribe":
 				c.USub <- demand
+			case "unsubscribe_all", "list", "resources", "view":
+				demand.Event = frame.Event
+				c.Cmd <- demand
 			default:
-				fmt.Println("client unkown event:", frame.Event)
+				c.Error(demand, demandErr(CodeInvalidDemand, "unknown event %q", frame.Event))
 				continue
 			}
 
@@ -102,6 +213,58 @@ func (c *Client) parse() {
 	}
 }
 
+// Send queues a frame for the writer goroutine without blocking the
+// broadcasting resource, frames for a full queue are dropped
+func (c *Client) Send(frame *Response) bool {
+	if c.link != nil {
+		return c.link.send(c, frame)
+	}
+	return c.enqueue(frame)
+}
+
+func (c *Client) enqueue(frame *Response) bool {
+	select {
+	case c.In <- frame:
+		return true
+	default:
+		c.sMutex.Lock()
+		c.sent.Dropped++
+		if !c.dropping {
+			c.dropping = true
+			logrus.Warnf("- CLIENT - slow consumer %s: send queue full, dropping frames\n", c.remote())
+		}
+		c.sMutex.Unlock()
+		if c.stats != nil {
+			c.stats.record(frame, 0, true)
+		}
+		return false
+	}
+}
+
+// Stats returns the send counters of the client
+func (c *Client) Stats() ClientStats {
+	stats := ClientStats{
+		Remote: c.remote(),
+		Queued: len(c.In),
+	}
+	if c.session != nil {
+		stats.Session = c.session.Token
+	}
+	c.sMutex.Lock()
+	stats.SendStats = c.sent
+	c.sMutex.Unlock()
+	return stats
+}
+
+func (c *Client) remote() string {
+	if c.con == nil {
+		return ""
+	}
+	return c.con.RemoteAddr().String()
+}
+
+// HandleSend is the only goroutine writing to the websocket,
+// every write is bound by the write deadline
 func (c *Client) HandleSend() {
 	defer func() {
 		c.wg.Done()
@@ -109,22 +272,94 @@ func (c *Client) HandleSend() {
 	for {
 		select {
 		case <-c.ctx.Done():
+			// a close requested together with the cancel still goes out
+			select {
+			case closeMsg := <-c.sndClose:
+				c.writeClose(closeMsg)
+			default:
+			}
 			return
 		case closeMsg := <-c.sndClose:
-			_ = c.con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, closeMsg.Type), time.Now().Add(3*time.Second))
-			c.con.Close()
+			c.writeClose(closeMsg)
 		case response := <-c.In:
-			err := c.con.WriteJSON(response)
-			if err != nil {
+			if err := c.write(c.batch(response)); err != nil {
+				logrus.Errorf("- CLIENT - write failed: %s\n", err)
+				go c.CloseByRemote()
 				return
 			}
 		}
 	}
 }
 
+func (c *Client) writeClose(msg CloseMessage) {
+	code := msg.code
+	if code == 0 {
+		code = websocket.CloseNormalClosure
+	}
+	_ = c.con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, msg.Type), time.Now().Add(c.writeWait))
+	c.con.Close()
+}
+
+// batch adds the frames already queued behind first if the client
+// negotiated batching
+func (c *Client) batch(first *Response) []*Response {
+	frames := []*Response{first}
+	if !c.batching {
+		return frames
+	}
+	for len(frames) < c.maxBatch {
+		select {
+		case response := <-c.In:
+			frames = append(frames, response)
+		default:
+			return frames
+		}
+	}
+	return frames
+}
+
+// write sends frames as one websocket message, several of them as batch
+func (c *Client) write(frames []*Response) error {
+	frame := frames[0]
+	if len(frames) > 1 {
+		frame = &Response{Type: "batch", Message: frames}
+	}
+	typ, data, err := c.codec.encode(frame)
+	if err != nil {
+		logrus.Errorf("- CLIENT - failed to encode %s frame: %s\n", frame.Type, err)
+		return nil
+	}
+	start := time.Now()
+	c.con.SetWriteDeadline(start.Add(c.writeWait))
+	err = c.con.WriteMessage(typ, data)
+	write := time.Since(start)
+	c.sMutex.Lock()
+	for range frames {
+		c.sent.sent(write)
+	}
+	c.dropping = false
+	c.sMutex.Unlock()
+	if c.stats != nil {
+		for _, f := range frames {
+			c.stats.record(f, write, false)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// switch only after the welcome went out in the old encoding
+	if welcome, ok := frame.Message.(Welcome); ok {
+		c.apply(welcome)
+	}
+	return nil
+}
+
 func (c *Client) CloseByRemote() {
 	logrus.Infoln("- CLIENT - closing by remote")
 	c.cancel()
+	// a failed write leaves the reader blocked in ReadMessage, closing the
+	// connection lets it return
+	c.con.Close()
 	c.wg.Wait()
 	c.Lve <- c
 	logrus.Debugln("- CLIENT - closed now")
@@ -143,14 +378,9 @@ func (c *Client) Close() {
 	logrus.Debugln("- CLIENT - closed now")
 }
 
-func (c *Client) Error(msg string) {
-	response := &Response{
-		CID:     "",
-		Type:    "error",
-		Message: msg,
-	}
-
-	_ = c.con.WriteJSON(response)
+// Done is closed once the client stopped, valid after Run
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
 }
 
 func (c *Client) Run() {
@@ -0,0 +1,54 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair returns the server side of a websocket connection and the peer
+// dialing it
+func wsPair(t *testing.T) (server *websocket.Conn, peer *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		con, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- con
+	}))
+	t.Cleanup(srv.Close)
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return <-conns, peer
+}
+
+// a client closed after a failed write leaves although its reader is
+// blocked on a peer that sends nothing
+func TestCloseByRemoteUnblocksReader(t *testing.T) {
+	server, _ := wsPair(t)
+	lve := make(chan *Client, 1)
+	c := NewClient(server, make(chan *Demand), make(chan *Demand), lve)
+	c.Run()
+	// let the reader block in ReadMessage
+	time.Sleep(100 * time.Millisecond)
+
+	go c.CloseByRemote()
+	select {
+	case left := <-lve:
+		if left != c {
+			t.Fatal("another client left")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("client did not leave, reader still blocked")
+	}
+}
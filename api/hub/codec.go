@@ -0,0 +1,312 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// encodings of the hub frames
+const (
+	EncodingJSON  = "json"
+	EncodingProto = "proto"
+)
+
+// Ack confirms a demand of the client
+type Ack struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event"`
+	CID   string `json:"container_id,omitempty"`
+	Type  string `json:"type"`
+}
+
+// codec translates between websocket messages and demands and frames
+type codec interface {
+	encode(frame *Response) (int, []byte, error)
+	decode(typ int, data []byte) (*Request, error)
+}
+
+// decoder returns the codec reading a message of typ, clients may send
+// json demands before switching to binary ones
+func decoder(typ int) codec {
+	if typ == websocket.BinaryMessage {
+		return protoCodec{}
+	}
+	return jsonCodec{}
+}
+
+// decoder returns the codec reading a message of typ from c, links only
+// speak their own protocol
+func (c *Client) decoder(typ int) codec {
+	if c.link != nil {
+		return linkCodec{}
+	}
+	return decoder(typ)
+}
+
+func newCodec(encoding string) (codec, error) {
+	switch encoding {
+	case "", EncodingJSON:
+		return jsonCodec{}, nil
+	case EncodingProto:
+		return protoCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %s", encoding)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) encode(frame *Response) (int, []byte, error) {
+	data, err := json.Marshal(frame)
+	return websocket.TextMessage, data, err
+}
+
+func (jsonCodec) decode(typ int, data []byte) (*Request, error) {
+	var req *Request
+	err := json.Unmarshal(data, &req)
+	if err == nil && req == nil {
+		err = fmt.Errorf("empty demand")
+	}
+	return req, err
+}
+
+// protoCodec writes the messages of proto/hub.proto, field numbers have
+// to match the schema
+type protoCodec struct{}
+
+// Frame fields
+const (
+	frameCID     protowire.Number = 1
+	frameType    protowire.Number = 2
+	frameSeq     protowire.Number = 3
+	frameMessage protowire.Number = 4
+	frameError   protowire.Number = 5
+	frameAck     protowire.Number = 6
+	frameBatch   protowire.Number = 7
+	frameSnap    protowire.Number = 8
+)
+
+// Demand fields
+const (
+	demandCID     protowire.Number = 1
+	demandEvent   protowire.Number = 2
+	demandType    protowire.Number = 3
+	demandFilters protowire.Number = 4
+	demandSince   protowire.Number = 5
+	demandVersion protowire.Number = 6
+	demandCaps    protowire.Number = 7
+	demandID      protowire.Number = 8
+	demandWhere   protowire.Number = 9
+	demandEvery   protowire.Number = 10
+	demandAggr    protowire.Number = 11
+	demandView    protowire.Number = 12
+)
+
+func (protoCodec) encode(frame *Response) (int, []byte, error) {
+	b, err := encodeFrame(frame)
+	return websocket.BinaryMessage, b, err
+}
+
+func encodeFrame(frame *Response) ([]byte, error) {
+	b := make([]byte, 0, 128)
+	if frame.CID != "" {
+		b = appendString(b, frameCID, frame.CID)
+	}
+	b = appendString(b, frameType, frame.Type)
+	if frame.Seq > 0 {
+		b = protowire.AppendTag(b, frameSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, frame.Seq)
+	}
+	if frame.Snapshot {
+		b = protowire.AppendTag(b, frameSnap, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+
+	switch msg := frame.Message.(type) {
+	case []*Response:
+		batch := make([]byte, 0)
+		for _, f := range msg {
+			encoded, err := encodeFrame(f)
+			if err != nil {
+				return nil, err
+			}
+			batch = appendMessage(batch, 1, encoded)
+		}
+		b = appendMessage(b, frameBatch, batch)
+	case Ack:
+		ack := appendString(nil, 1, msg.Event)
+		ack = appendString(ack, 2, msg.CID)
+		ack = appendString(ack, 3, msg.Type)
+		ack = appendString(ack, 4, msg.ID)
+		b = appendMessage(b, frameAck, ack)
+	case DemandError:
+		e := appendString(nil, 1, msg.Message)
+		e = appendString(e, 2, msg.Code)
+		e = appendString(e, 3, msg.Type)
+		e = appendString(e, 4, msg.ID)
+		b = appendMessage(b, frameError, e)
+	default:
+		value, err := protoValue(frame.Message)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, frameMessage, value)
+	}
+	return b, nil
+}
+
+// protoValue converts a payload into a google.protobuf.Value following its
+// json form, so both encodings carry the same fields
+func protoValue(payload interface{}) ([]byte, error) {
+	generic, err := genericJSON(payload)
+	if err != nil {
+		return nil, err
+	}
+	value, err := structpb.NewValue(generic)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(value)
+}
+
+// genericJSON returns the json form of payload as maps, slices and scalars
+func genericJSON(payload interface{}) (interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+	return generic, err
+}
+
+func (protoCodec) decode(typ int, data []byte) (*Request, error) {
+	if typ != websocket.BinaryMessage {
+		return nil, fmt.Errorf("expected binary demand")
+	}
+	req := &Request{}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == demandWhere && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.Where = v
+			return n, nil
+		case num == demandEvery && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.Interval = v
+			return n, nil
+		case num == demandAggr && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.Aggregate = v
+			return n, nil
+		case num == demandView && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.View = v
+			return n, nil
+		case num == demandID && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.ID = v
+			return n, nil
+		case num == demandCID && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.CID = v
+			return n, nil
+		case num == demandEvent && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.Event = v
+			return n, nil
+		case num == demandType && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.Type = v
+			return n, nil
+		case num == demandSince && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			req.Since = v
+			return n, nil
+		case num == demandVersion && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			req.Version = int(v)
+			return n, nil
+		case num == demandCaps && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.Capabilities = append(req.Capabilities, v)
+			return n, nil
+		case num == demandFilters && typ == protowire.BytesType:
+			entry, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			if req.Filters == nil {
+				req.Filters = make(map[string][]string)
+			}
+			return n, decodeFilter(entry, req.Filters)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	return req, err
+}
+
+// decodeFilter reads a map entry of Demand.filters (key 1, Values 2)
+func decodeFilter(entry []byte, filters map[string][]string) error {
+	var key string
+	values := make([]string, 0)
+	err := consumeFields(entry, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			key = v
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			list, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			return n, consumeFields(list, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num == 1 && typ == protowire.BytesType {
+					v, n := protowire.ConsumeString(b)
+					values = append(values, v)
+					return n, nil
+				}
+				return protowire.ConsumeFieldValue(num, typ, b), nil
+			})
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	filters[key] = append(filters[key], values...)
+	return err
+}
+
+// consumeFields calls field for every field of the message in b, field
+// returns the length of the value consumed (negative on malformed input)
+func consumeFields(b []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
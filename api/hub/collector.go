@@ -0,0 +1,123 @@
+package hub
+
+import (
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/dock/collector"
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorR forwards the sets of a single collector, the latest one is
+// sent to each new subscriber
+type CollectorR struct {
+	mutex   *sync.Mutex
+	Name    string
+	Typ     string
+	Runner  *collector.Runner
+	Subs    map[*Client]bool
+	LveSig  chan Resource
+	Timeout *Timeout
+	sets    chan collector.Set
+	ring    *Ring
+}
+
+func NewCollectorR(name string, runner *collector.Runner, lveSig chan Resource) *CollectorR {
+	r := &CollectorR{
+		mutex:  &sync.Mutex{},
+		Name:   name,
+		Typ:    "collector",
+		Runner: runner,
+		Subs:   make(map[*Client]bool),
+		LveSig: lveSig,
+		ring:   NewRing(),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *CollectorR) CID() string {
+	return r.Name
+}
+
+func (r *CollectorR) Type() string {
+	return r.Typ
+}
+
+func (r *CollectorR) Run() error {
+	r.sets = r.Runner.Listen()
+	go func() {
+		for set := range r.sets {
+			if set.Collector != r.Name {
+				continue
+			}
+			r.Broadcast(*stream.NewSet("collector", set))
+		}
+	}()
+	return nil
+}
+
+func (r *CollectorR) Add(c *Client) {
+	r.mutex.Lock()
+	if set, ok := r.Runner.Latest(r.Name); ok {
+		c.Send(&Response{
+			CID:      r.Name,
+			Type:     r.Typ,
+			Message:  set,
+			Snapshot: true,
+		})
+	}
+	r.Subs[c] = true
+	r.mutex.Unlock()
+}
+
+func (r *CollectorR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	idle := len(r.Subs) == 0
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *CollectorR) Broadcast(set stream.Set) {
+	frame := &Response{
+		CID:     r.Name,
+		Type:    r.Typ,
+		Message: set.Data,
+	}
+	r.mutex.Lock()
+	r.ring.Push(frame)
+	for c := range r.Subs {
+		c.Send(frame)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *CollectorR) Replay(c *Client, since uint64) {
+	r.mutex.Lock()
+	r.ring.Replay(c, since, r.Name, r.Typ, nil)
+	r.mutex.Unlock()
+}
+
+func (r *CollectorR) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *CollectorR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *CollectorR) Quit() {
+	logrus.Debugln("- HUB - collector resource quit")
+	r.Runner.Unlisten(r.sets)
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
@@ -1,34 +1,75 @@
 package hub
 
 import (
-	"fmt"
 	"sync"
 	"time"
 
 	"github.com/h0rzn/monitoring_agent/dock/container"
 	"github.com/h0rzn/monitoring_agent/dock/metrics"
 	"github.com/h0rzn/monitoring_agent/dock/stream"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/sirupsen/logrus"
 )
 
+// Aggregate computes the frame of a combined resource from the latest
+// sets of all running containers
+type Aggregate func([]metrics.Set) interface{}
+
 type CombindedMetrics struct {
 	ContainerStore *container.Storage
 	mutex          *sync.Mutex
 	Typ            string
-	Subs           map[*Client]bool
-	Timeout        *Timeout
-	LveSig         chan Resource
-	done           chan struct{}
+	// "_all" or the compose project
+	cid string
+	// latest sets of the containers aggregated
+	collect   func() []metrics.Set
+	Aggregate Aggregate
+	Subs      map[*Client]bool
+	Timeout   *Timeout
+	LveSig    chan Resource
+	done      chan struct{}
+	ring      *Ring
+	// delivery of the subscribers with a shape
+	shapes map[*Client]*delivery
 }
 
 func NewCombinedR(store *container.Storage, lveSig chan Resource) *CombindedMetrics {
+	return NewAggregateR("combined_metrics", func(latest []metrics.Set) interface{} {
+		return metrics.Sum(latest)
+	}, store, lveSig)
+}
+
+// NewHostR streams the host summary (totals and averages) of all running containers
+func NewHostR(store *container.Storage, lveSig chan Resource) *CombindedMetrics {
+	return NewAggregateR("host", func(latest []metrics.Set) interface{} {
+		return metrics.NewSummary(latest)
+	}, store, lveSig)
+}
+
+// NewProjectR streams the sum of the containers of a compose project
+func NewProjectR(project string, store *container.Storage, lveSig chan Resource) *CombindedMetrics {
+	r := NewAggregateR("project", func(latest []metrics.Set) interface{} {
+		return metrics.Sum(latest)
+	}, store, lveSig)
+	r.cid = project
+	r.collect = func() []metrics.Set {
+		return store.CollectLatestOf(container.ProjectSelector(project))
+	}
+	return r
+}
+
+func NewAggregateR(typ string, aggregate Aggregate, store *container.Storage, lveSig chan Resource) *CombindedMetrics {
 	r := &CombindedMetrics{
 		ContainerStore: store,
 		mutex:          &sync.Mutex{},
-		Typ:            "combined_metrics",
+		Typ:            typ,
+		cid:            "_all",
+		collect:        store.CollectLatest,
+		Aggregate:      aggregate,
 		Subs:           make(map[*Client]bool),
 		LveSig:         lveSig,
 		done:           make(chan struct{}),
+		ring:           NewRing(),
+		shapes:         make(map[*Client]*delivery),
 	}
 	r.Timeout = NewTimeout(r.Quit)
 	return r
@@ -39,70 +80,68 @@ func (cm *CombindedMetrics) Type() string {
 }
 
 func (cm *CombindedMetrics) CID() string {
-	return "_all"
+	return cm.cid
 }
 
 func (cm *CombindedMetrics) Add(c *Client) {
-	if len(c.Sub) == 0 {
-		cm.Timeout.Stop()
-	}
+	cm.AddShaped(c, Shape{})
+}
+
+// AddShaped adds c, receiving the frames as shape asks for
+func (cm *CombindedMetrics) AddShaped(c *Client, shape Shape) {
 	cm.mutex.Lock()
 	cm.Subs[c] = true
+	if shape != (Shape{}) {
+		cm.shapes[c] = newDelivery(shape)
+	} else {
+		delete(cm.shapes, c)
+	}
 	cm.mutex.Unlock()
 }
 
 func (cm *CombindedMetrics) Rm(c *Client) {
 	cm.mutex.Lock()
 	delete(cm.Subs, c)
+	delete(cm.shapes, c)
+	idle := len(cm.Subs) == 0
 	cm.mutex.Unlock()
-	if len(cm.Subs) == 0 {
-		go cm.Timeout.Start()
+	if idle {
+		cm.Timeout.Start()
 	}
 }
 
 func (r *CombindedMetrics) Broadcast(set stream.Set) {
 	frame := &Response{
-		CID:     "_all",
-		Type:    "combined_metrics",
+		CID:     r.cid,
+		Type:    r.Typ,
 		Message: set.Data,
 	}
 	r.mutex.Lock()
-	for client := range r.Subs {
-		client.In <- frame
-	}
+	r.ring.Push(frame)
+	deliver(r.Subs, r.shapes, frame)
 	r.mutex.Unlock()
 }
 
-func (cm *CombindedMetrics) procLatest(latest []metrics.Set) metrics.Set {
-	var result metrics.Set
-	result.When = primitive.NewDateTimeFromTime(time.Now())
-
-	for _, set := range latest {
-		// cpu
-		result.CPU.UsagePerc += set.CPU.UsagePerc
-		result.CPU.Online += set.CPU.Online
-		// mem
-		result.Mem.Usage += set.Mem.Usage
-		result.Mem.UsagePerc += set.Mem.UsagePerc
-		result.Mem.Available += set.Mem.Available
-		// disk
-		result.Disk.Read += set.Disk.Read
-		result.Disk.Write += set.Disk.Write
-		// net
-		result.Net.In += set.Net.In
-		result.Net.Out += set.Net.Out
-	}
-	return result
+func (cm *CombindedMetrics) Replay(c *Client, since uint64) {
+	cm.mutex.Lock()
+	cm.ring.Replay(c, since, cm.cid, cm.Typ, cm.shapes[c].replayMatch())
+	cm.mutex.Unlock()
+}
+
+func (cm *CombindedMetrics) Health() Health {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	return cm.ring.Health()
 }
 
-func (cm *CombindedMetrics) Latest() chan metrics.Set {
-	out := make(chan metrics.Set)
+func (cm *CombindedMetrics) Latest() chan interface{} {
+	out := make(chan interface{})
 
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
 		defer close(out)
 
-		comb := cm.procLatest(cm.ContainerStore.CollectLatest())
+		comb := cm.Aggregate(cm.collect())
 		out <- comb
 
 		for {
@@ -110,7 +149,7 @@ func (cm *CombindedMetrics) Latest() chan metrics.Set {
 			case <-cm.done:
 				return
 			case <-ticker.C:
-				comb := cm.procLatest(cm.ContainerStore.CollectLatest())
+				comb := cm.Aggregate(cm.collect())
 				out <- comb
 			}
 		}
@@ -119,24 +158,28 @@ func (cm *CombindedMetrics) Latest() chan metrics.Set {
 }
 
 func (cm *CombindedMetrics) Run() error {
-	fmt.Println("combined running")
+	logrus.Debugf("- HUB - %s resource running\n", cm.Typ)
 	combined := cm.Latest()
 	go func() {
-		for set := range combined {
-			set := *stream.NewSet("combined_metrics", set)
+		for data := range combined {
+			set := *stream.NewSet(cm.Typ, data)
 			cm.Broadcast(set)
 		}
 	}()
 	return nil
 }
 
+func (cm *CombindedMetrics) Idle() *Timeout {
+	return cm.Timeout
+}
+
 func (cm *CombindedMetrics) Quit() {
-	fmt.Println("comined metrics: quit")
-	cm.mutex.Lock()
+	logrus.Debugf("- HUB - %s resource quit\n", cm.Typ)
 	cm.done <- struct{}{}
+	cm.mutex.Lock()
 	for c := range cm.Subs {
-		cm.Rm(c)
+		delete(cm.Subs, c)
 	}
-	cm.LveSig <- cm
 	cm.mutex.Unlock()
+	cm.LveSig <- cm
 }
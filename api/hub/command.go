@@ -0,0 +1,158 @@
+package hub
+
+import (
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SubscriptionList answers the list command
+type SubscriptionList struct {
+	ID            string         `json:"id,omitempty"`
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// ResourceType describes a resource type, Target is what its container_id
+// names: container, _all, reference or none
+type ResourceType struct {
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+// ContainerResources lists the resources a container offers right now,
+// metrics only while it is running
+type ContainerResources struct {
+	ID        string   `json:"container_id"`
+	Name      string   `json:"name"`
+	Resources []string `json:"resources"`
+}
+
+// ResourceList answers the resources command
+type ResourceList struct {
+	ID         string               `json:"id,omitempty"`
+	Types      []ResourceType       `json:"types"`
+	Containers []ContainerResources `json:"containers"`
+	// compose projects, targets of the project resource
+	Projects []string `json:"projects,omitempty"`
+	// running collectors, targets of the collector resource
+	Collectors []string `json:"collectors,omitempty"`
+}
+
+// Command handles the demands not bound to a single resource:
+// unsubscribe_all drops all subscriptions of the client, list reports them,
+// resources lists what the client can subscribe to and view replaces the
+// subscriptions with the ones of a saved view
+func (h *Hub) Command(dem *Demand) {
+	switch dem.Event {
+	case "unsubscribe_all":
+		h.UnsubscribeAll(dem.Client)
+		dem.Client.ack(dem, dem.Event)
+	case "list":
+		dem.Client.Send(&Response{
+			Type: "subscriptions",
+			Message: SubscriptionList{
+				ID:            dem.ID,
+				Subscriptions: h.subscriptions(dem.Client),
+			},
+		})
+	case "view":
+		go h.loadView(dem)
+	case "apply_view":
+		h.UnsubscribeAll(dem.Client)
+		for _, sub := range dem.batch {
+			h.Subscribe(sub)
+		}
+		dem.Client.ack(dem, "view")
+	case "resources":
+		list := h.resources(dem.Client)
+		list.ID = dem.ID
+		dem.Client.Send(&Response{
+			Type:    "resources",
+			Message: list,
+		})
+	}
+}
+
+// resources lists the resource types available to c and the containers
+// within its scope
+func (h *Hub) resources(c *Client) ResourceList {
+	list := ResourceList{
+		Types:      make([]ResourceType, 0),
+		Containers: make([]ContainerResources, 0),
+	}
+	perContainer := make([]string, 0)
+	for typ, t := range resourceTypes {
+		if available(c, typ) != nil {
+			continue
+		}
+		list.Types = append(list.Types, ResourceType{Type: typ, Target: t.String()})
+		if t == targetContainer {
+			perContainer = append(perContainer, typ)
+		}
+	}
+	sort.Slice(list.Types, func(i, j int) bool {
+		return list.Types[i].Type < list.Types[j].Type
+	})
+	sort.Strings(perContainer)
+
+	if available(c, "project") == nil {
+		for name := range h.Ctr.Containers.Projects() {
+			list.Projects = append(list.Projects, name)
+		}
+		sort.Strings(list.Projects)
+	}
+	if available(c, "collector") == nil {
+		for _, status := range h.Ctr.Collectors.Status() {
+			list.Collectors = append(list.Collectors, status.Name)
+		}
+	}
+
+	containers := h.Ctr.Containers.Items()
+	if len(c.Scope) > 0 {
+		containers = h.Ctr.Containers.Select(c.Scope)
+	}
+	for _, cont := range containers {
+		res := make([]string, 0, len(perContainer))
+		for _, typ := range perContainer {
+			if typ == "metrics" && cont.State.Status != "running" {
+				continue
+			}
+			res = append(res, typ)
+		}
+		list.Containers = append(list.Containers, ContainerResources{
+			ID:        cont.ID,
+			Name:      cont.Name,
+			Resources: res,
+		})
+	}
+	return list
+}
+
+// UnsubscribeAll removes the client from every resource and clears its
+// session, the connection stays open
+func (h *Hub) UnsubscribeAll(c *Client) {
+	logrus.Infoln("- HUB - Unsubscribe all")
+	for r := range h.Resources {
+		r.Rm(c)
+	}
+	if s := c.session; s != nil {
+		h.sessions.mutex.Lock()
+		s.demands = make(map[Subscription]subscriptionOpts)
+		h.sessions.mutex.Unlock()
+	}
+}
+
+// subscriptions lists the subscriptions recorded in the client's session
+func (h *Hub) subscriptions(c *Client) []Subscription {
+	subs := make([]Subscription, 0)
+	s := c.session
+	if s == nil {
+		return subs
+	}
+	h.sessions.mutex.Lock()
+	for sub := range s.demands {
+		subs = append(subs, sub)
+	}
+	h.sessions.mutex.Unlock()
+	return subs
+}
@@ -0,0 +1,130 @@
+package hub
+
+import (
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/dock/container"
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// ContainersR sends a snapshot of the container list to each new
+// subscriber followed by add/update/remove deltas
+type ContainersR struct {
+	mutex   *sync.Mutex
+	Typ     string
+	Store   *container.Storage
+	Subs    map[*Client]bool
+	LveSig  chan Resource
+	Timeout *Timeout
+	changes chan container.Change
+	ring    *Ring
+}
+
+func NewContainersR(store *container.Storage, lveSig chan Resource) *ContainersR {
+	r := &ContainersR{
+		mutex:  &sync.Mutex{},
+		Typ:    "containers",
+		Store:  store,
+		Subs:   make(map[*Client]bool),
+		LveSig: lveSig,
+		ring:   NewRing(),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *ContainersR) CID() string {
+	return ""
+}
+
+func (r *ContainersR) Type() string {
+	return r.Typ
+}
+
+func (r *ContainersR) Run() error {
+	r.changes = r.Store.Listen()
+	go func() {
+		for change := range r.changes {
+			r.Broadcast(*stream.NewSet("containers", change))
+		}
+	}()
+	return nil
+}
+
+// Add sends the snapshot before the client is registered for deltas,
+// holding the mutex so no delta can overtake it
+func (r *ContainersR) Add(c *Client) {
+	var containers interface{} = r.Store
+	if len(c.Scope) > 0 {
+		containers = r.Store.Select(c.Scope)
+	}
+	snapshot := &Response{
+		Type: r.Typ,
+		Message: map[string]interface{}{
+			"action":     "snapshot",
+			"containers": containers,
+		},
+	}
+	r.mutex.Lock()
+	c.Send(snapshot)
+	r.Subs[c] = true
+	r.mutex.Unlock()
+}
+
+func (r *ContainersR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	idle := len(r.Subs) == 0
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *ContainersR) Broadcast(set stream.Set) {
+	frame := &Response{
+		Type:    r.Typ,
+		Message: set.Data,
+	}
+	change, _ := set.Data.(container.Change)
+	r.mutex.Lock()
+	r.ring.Push(frame)
+	for c := range r.Subs {
+		if len(c.Scope) > 0 && !c.Scope.MatchLabels(change.Labels) {
+			continue
+		}
+		c.Send(frame)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *ContainersR) Replay(c *Client, since uint64) {
+	r.mutex.Lock()
+	r.ring.Replay(c, since, "", r.Typ, func(frame *Response) bool {
+		change, _ := frame.Message.(container.Change)
+		return len(c.Scope) == 0 || c.Scope.MatchLabels(change.Labels)
+	})
+	r.mutex.Unlock()
+}
+
+func (r *ContainersR) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *ContainersR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *ContainersR) Quit() {
+	logrus.Debugln("- HUB - containers resource quit")
+	r.Store.Unlisten(r.changes)
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
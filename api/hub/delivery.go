@@ -0,0 +1,131 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+)
+
+// Shape is how a subscriber wants the frames of a resource delivered
+type Shape struct {
+	// only frames matching it are sent
+	Where *Expr
+	// at most one frame per Every, 0 for every frame
+	Every time.Duration
+	// average the metric sets of the interval instead of sending the last one
+	Average bool
+}
+
+// Shaped is implemented by resources delivering frames per subscriber shape
+type Shaped interface {
+	AddShaped(*Client, Shape)
+}
+
+// parseShape reads the where, interval and aggregate options of a demand
+func parseShape(req *Request) (Shape, error) {
+	shape := Shape{}
+	if req.Where != "" {
+		where, err := ParseExpr(req.Where)
+		if err != nil {
+			return shape, demandErr(CodeInvalidDemand, "invalid where expression: %s", err)
+		}
+		shape.Where = where
+	}
+	if req.Interval != "" {
+		every, err := time.ParseDuration(req.Interval)
+		if err != nil || every <= 0 {
+			return shape, demandErr(CodeInvalidDemand, "interval has to be a positive duration")
+		}
+		shape.Every = every
+	}
+	switch req.Aggregate {
+	case "", "sample":
+	case "average":
+		if shape.Every == 0 {
+			return shape, demandErr(CodeInvalidDemand, "aggregate average requires an interval")
+		}
+		shape.Average = true
+	default:
+		return shape, demandErr(CodeInvalidDemand, "unknown aggregate %q, expected sample or average", req.Aggregate)
+	}
+	return shape, nil
+}
+
+// delivery is the state of a shaped subscription, guarded by the mutex
+// of its resource
+type delivery struct {
+	Shape
+	last    time.Time
+	pending []metrics.Set
+}
+
+func newDelivery(shape Shape) *delivery {
+	return &delivery{
+		Shape:   shape,
+		pending: make([]metrics.Set, 0),
+	}
+}
+
+// next returns the frame due for the subscriber once frame arrived, nil
+// while the interval runs. The first frame is sent right away
+func (d *delivery) next(frame *Response, now time.Time) *Response {
+	if d.Every <= 0 {
+		return frame
+	}
+	if set, ok := frame.Message.(metrics.Set); ok && d.Average {
+		d.pending = append(d.pending, set)
+	}
+	if now.Sub(d.last) < d.Every {
+		return nil
+	}
+	d.last = now
+	pending := d.pending
+	d.pending = make([]metrics.Set, 0)
+	if len(pending) > 1 {
+		return &Response{
+			CID:     frame.CID,
+			Type:    frame.Type,
+			Message: metrics.Average(pending),
+			Seq:     frame.Seq,
+		}
+	}
+	return frame
+}
+
+// replayMatch returns the match func of a replay, nil for all frames.
+// Replays are not downsampled
+func (d *delivery) replayMatch() func(*Response) bool {
+	if d == nil || d.Where == nil {
+		return nil
+	}
+	return func(frame *Response) bool {
+		return whereMatcher(frame)(d.Where)
+	}
+}
+
+// deliver sends frame to the subscribers of a resource according to their
+// shapes, callers hold the mutex of the resource
+func deliver(subs map[*Client]bool, shapes map[*Client]*delivery, frame *Response) {
+	matches := whereMatcher(frame)
+	now := time.Now()
+	for client := range subs {
+		d, shaped := shapes[client]
+		if !shaped {
+			client.Send(frame)
+			continue
+		}
+		// sampled frames are filtered before they are due, so only a
+		// matching frame starts the interval. Averages are filtered as sent
+		if d.Where != nil && !d.Average && !matches(d.Where) {
+			continue
+		}
+		out := d.next(frame, now)
+		if out == nil {
+			continue
+		}
+		if d.Where != nil && d.Average && !whereMatcher(out)(d.Where) {
+			continue
+		}
+		client.Send(out)
+	}
+}
@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Closing is the message of the server_closing frame sent to every client
+// when the agent shuts down
+type Closing struct {
+	// seconds to wait before reconnecting
+	ReconnectAfter int `json:"reconnect_after"`
+	// seconds until the agent closes the connection
+	Drain int `json:"drain"`
+}
+
+// closeRequest hands the clients to drain from the Run loop to Shutdown
+type closeRequest struct {
+	closing Closing
+	clients chan []*Client
+}
+
+// Shutdown announces the shutdown to every client with a server_closing
+// frame and refuses new subscriptions. Clients still connected after drain
+// are closed with "going away"
+func (h *Hub) Shutdown(drain time.Duration, reconnectAfter time.Duration) {
+	req := closeRequest{
+		closing: Closing{
+			ReconnectAfter: int(reconnectAfter.Seconds()),
+			Drain:          int(drain.Seconds()),
+		},
+		clients: make(chan []*Client, 1),
+	}
+	h.drain <- req
+	clients := <-req.clients
+	logrus.Infof("- HUB - draining %d clients for %s\n", len(clients), drain)
+
+	deadline := time.Now().Add(drain)
+	for time.Now().Before(deadline) && len(h.clients()) > 0 {
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	remaining := h.clients()
+	done := make(chan struct{}, len(remaining))
+	for _, c := range remaining {
+		go func(c *Client) {
+			c.GoAway()
+			done <- struct{}{}
+		}(c)
+	}
+	for range remaining {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			logrus.Warnln("- HUB - clients did not close in time")
+			return
+		}
+	}
+	logrus.Infoln("- HUB - drained")
+}
+
+// closing runs in the Run loop: it stops new subscriptions and sends the
+// server_closing frame to the attached clients
+func (h *Hub) closing(req closeRequest) {
+	h.closed = true
+	clients := h.clients()
+	for _, c := range clients {
+		c.Send(&Response{
+			Type:    "server_closing",
+			Message: req.closing,
+		})
+	}
+	req.clients <- clients
+}
+
+// GoAway closes the connection like Close, telling the peer the server is
+// going away
+func (c *Client) GoAway() {
+	logrus.Infoln("- CLIENT - closing, server going away")
+	c.sndClose <- CloseMessage{
+		Type: "server_closing",
+		code: websocket.CloseGoingAway,
+	}
+	c.cancel()
+	c.wg.Wait()
+
+	c.Lve <- c
+}
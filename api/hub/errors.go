@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+)
+
+// codes of error frames
+const (
+	CodeInvalidDemand     = "invalid_demand"
+	CodeUnknownResource   = "unknown_resource"
+	CodeContainerNotFound = "container_not_found"
+	CodeUnauthorized      = "unauthorized"
+	CodeNotSubscribed     = "not_subscribed"
+	CodeViewNotFound      = "view_not_found"
+	CodeProjectNotFound   = "project_not_found"
+	CodeCollectorNotFound = "collector_not_found"
+	// the agent is shutting down, reconnect later
+	CodeServerClosing = "server_closing"
+	// the resource exists but failed to start
+	CodeResourceFailed = "resource_failed"
+)
+
+// DemandError is the message of an error frame, Code is machine readable
+type DemandError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// resource and correlation id of the failed demand
+	Type string `json:"type,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+func (e *DemandError) Error() string {
+	return e.Message
+}
+
+func demandErr(code string, format string, args ...interface{}) error {
+	return &DemandError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error sends an error frame for the failed demand dem (nil if the demand
+// could not be read), errors without code are reported as resource_failed
+func (c *Client) Error(dem *Demand, err error) {
+	frame := DemandError{
+		Code:    CodeResourceFailed,
+		Message: err.Error(),
+	}
+	var dErr *DemandError
+	if errors.As(err, &dErr) {
+		frame.Code = dErr.Code
+	}
+	response := &Response{Type: "error"}
+	if dem != nil {
+		response.CID = dem.CID
+		frame.Type = dem.Ressource
+		frame.ID = dem.ID
+	}
+	response.Message = frame
+	c.Send(response)
+}
@@ -0,0 +1,340 @@
+package hub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is the where expression of a subscription, e.g.
+// "cpu.perc > 80 || memory.perc > 90". Paths address the json fields of a
+// frame's message, comparisons (>, >=, <, <=, ==, !=) take numbers, quoted
+// strings, true and false and can be combined with &&, ||, ! and
+// parentheses. A bare path is true if the field is true or a non-zero number.
+// Missing fields never match
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ParseExpr compiles src
+func ParseExpr(src string) (*Expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match evaluates the expression against the json form of a message
+// (map[string]interface{}, float64, string, bool)
+func (e *Expr) Match(message interface{}) bool {
+	return e.root.eval(message)
+}
+
+// whereMatcher evaluates where expressions against frame, its message is
+// converted to the json form once, when the first expression needs it
+func whereMatcher(frame *Response) func(*Expr) bool {
+	var generic interface{}
+	converted := false
+	return func(where *Expr) bool {
+		if !converted {
+			generic, _ = genericJSON(frame.Message)
+			converted = true
+		}
+		return where.Match(generic)
+	}
+}
+
+type exprNode interface {
+	eval(message interface{}) bool
+}
+
+type orNode struct{ left, right exprNode }
+
+func (n orNode) eval(m interface{}) bool { return n.left.eval(m) || n.right.eval(m) }
+
+type andNode struct{ left, right exprNode }
+
+func (n andNode) eval(m interface{}) bool { return n.left.eval(m) && n.right.eval(m) }
+
+type notNode struct{ inner exprNode }
+
+func (n notNode) eval(m interface{}) bool { return !n.inner.eval(m) }
+
+// cmpNode compares the field at path to value, op is empty for a bare path
+type cmpNode struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+func (n cmpNode) eval(m interface{}) bool {
+	field, ok := lookupPath(m, n.path)
+	if !ok {
+		return false
+	}
+	if n.op == "" {
+		switch v := field.(type) {
+		case bool:
+			return v
+		case float64:
+			return v != 0
+		}
+		return false
+	}
+	switch want := n.value.(type) {
+	case float64:
+		got, ok := field.(float64)
+		return ok && compareOrdered(n.op, cmpFloat(got, want))
+	case string:
+		got, ok := field.(string)
+		return ok && compareOrdered(n.op, strings.Compare(got, want))
+	case bool:
+		got, ok := field.(bool)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case "==":
+			return got == want
+		case "!=":
+			return got != want
+		}
+	}
+	return false
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareOrdered(op string, c int) bool {
+	switch op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	}
+	return false
+}
+
+func lookupPath(m interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		obj, ok := m.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if m, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+type tokenKind int
+
+const (
+	tokPath tokenKind = iota
+	tokNumber
+	tokString
+	tokBool
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case strings.HasPrefix(src[i:], "&&") || strings.HasPrefix(src[i:], "||") ||
+			strings.HasPrefix(src[i:], ">=") || strings.HasPrefix(src[i:], "<=") ||
+			strings.HasPrefix(src[i:], "==") || strings.HasPrefix(src[i:], "!="):
+			tokens = append(tokens, exprToken{tokOp, src[i : i+2]})
+			i += 2
+		case strings.ContainsRune("()<>!", rune(ch)):
+			tokens = append(tokens, exprToken{tokOp, string(ch)})
+			i++
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(src[i+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, exprToken{tokString, src[i+1 : i+1+end]})
+			i += end + 2
+		case ch == '-' || ch == '.' || ch >= '0' && ch <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] == '.' || src[j] >= '0' && src[j] <= '9' || src[j] == 'e' || src[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, exprToken{tokNumber, src[i:j]})
+			i = j
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			word := src[i:j]
+			kind := tokPath
+			if word == "true" || word == "false" {
+				kind = tokBool
+			}
+			tokens = append(tokens, exprToken{kind, word})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", ch, i)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peekOp(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("||"); !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("&&"); !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if _, ok := p.peekOp("!"); ok {
+		p.pos++
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	if _, ok := p.peekOp("("); ok {
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.peekOp(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	if tok.kind != tokPath {
+		return nil, fmt.Errorf("expected field, got %q", tok.text)
+	}
+	p.pos++
+	node := cmpNode{path: strings.Split(tok.text, ".")}
+	op, ok := p.peekOp(">", ">=", "<", "<=", "==", "!=")
+	if !ok {
+		return node, nil
+	}
+	p.pos++
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing value after %s", op)
+	}
+	val := p.tokens[p.pos]
+	p.pos++
+	node.op = op
+	switch val.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(val.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", val.text)
+		}
+		node.value = f
+	case tokString:
+		node.value = val.text
+	case tokBool:
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("booleans only support == and !=")
+		}
+		node.value = val.text == "true"
+	default:
+		return nil, fmt.Errorf("expected value after %s, got %q", op, val.text)
+	}
+	return node, nil
+}
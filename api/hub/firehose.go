@@ -0,0 +1,161 @@
+package hub
+
+import (
+	"sync"
+
+	devents "github.com/docker/docker/api/types/events"
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// Filtered is implemented by resources that filter frames per subscriber
+type Filtered interface {
+	AddFiltered(*Client, map[string][]string)
+}
+
+// EventFilter matches docker events by "type", "action", "scope" and "id"
+// (actor id), a key matches if any of its values equals the event field
+type EventFilter map[string][]string
+
+func (f EventFilter) Match(event devents.Message) bool {
+	fields := map[string]string{
+		"type":   event.Type,
+		"action": event.Action,
+		"scope":  event.Scope,
+		"id":     event.Actor.ID,
+	}
+	for key, values := range f {
+		if len(values) == 0 {
+			continue
+		}
+		matched := false
+		for _, v := range values {
+			if fields[key] == v {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// FirehoseR relays the raw, host wide docker event stream
+type FirehoseR struct {
+	mutex   *sync.Mutex
+	Typ     string
+	Subs    map[*Client]EventFilter
+	LveSig  chan Resource
+	Events  GetEvents
+	Input   *stream.Receiver
+	Timeout *Timeout
+	ring    *Ring
+}
+
+func NewFirehoseR(getEvents GetEvents, lveSig chan Resource) *FirehoseR {
+	r := &FirehoseR{
+		mutex:  &sync.Mutex{},
+		Typ:    "host_events",
+		Subs:   make(map[*Client]EventFilter),
+		LveSig: lveSig,
+		Events: getEvents,
+		ring:   NewRing(),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *FirehoseR) CID() string {
+	return ""
+}
+
+func (r *FirehoseR) Type() string {
+	return r.Typ
+}
+
+func (r *FirehoseR) Run() error {
+	rcv, err := r.Events()
+	if err != nil {
+		return err
+	}
+	r.Input = rcv
+	go func() {
+		for set := range rcv.In {
+			r.Broadcast(set)
+		}
+	}()
+	return nil
+}
+
+func (r *FirehoseR) Add(c *Client) {
+	r.AddFiltered(c, nil)
+}
+
+func (r *FirehoseR) AddFiltered(c *Client, filters map[string][]string) {
+	r.mutex.Lock()
+	r.Subs[c] = EventFilter(filters)
+	r.mutex.Unlock()
+}
+
+func (r *FirehoseR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	idle := len(r.Subs) == 0
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *FirehoseR) Broadcast(set stream.Set) {
+	event, ok := set.Data.(devents.Message)
+	if !ok {
+		return
+	}
+	frame := &Response{
+		Type:    r.Typ,
+		Message: event,
+	}
+	r.mutex.Lock()
+	r.ring.Push(frame)
+	for c, filter := range r.Subs {
+		if filter.Match(event) {
+			c.Send(frame)
+		}
+	}
+	r.mutex.Unlock()
+}
+
+func (r *FirehoseR) Replay(c *Client, since uint64) {
+	r.mutex.Lock()
+	filter := r.Subs[c]
+	r.ring.Replay(c, since, "", r.Typ, func(frame *Response) bool {
+		return filter.Match(frame.Message.(devents.Message))
+	})
+	r.mutex.Unlock()
+}
+
+func (r *FirehoseR) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *FirehoseR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *FirehoseR) Quit() {
+	logrus.Debugln("- HUB - host events resource quit")
+	if r.Input != nil {
+		r.Input.Quit()
+	}
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
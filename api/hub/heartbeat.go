@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+)
+
+// default interval of heartbeat frames per subscription
+const defaultHeartbeat = 15 * time.Second
+
+// Health describes the stream of a resource: a heartbeat with an old
+// last sample means the stream stalled while the connection is alive
+type Health struct {
+	// time of the last frame, nil if nothing was sent yet
+	LastSample *time.Time `json:"last_sample"`
+	Seq        uint64     `json:"seq"`
+}
+
+// HealthReporter is implemented by resources reporting their last sample
+type HealthReporter interface {
+	Health() Health
+}
+
+type heartbeatFrame struct {
+	Type string `json:"type"`
+	Health
+}
+
+// heartbeatTicker returns the channel driving the heartbeats, nil (never
+// ready) if HUB_HEARTBEAT is 0
+func (h *Hub) heartbeatTicker() <-chan time.Time {
+	interval := config.Duration("HUB_HEARTBEAT", defaultHeartbeat)
+	if interval <= 0 {
+		return nil
+	}
+	return time.NewTicker(interval).C
+}
+
+// heartbeat sends a heartbeat frame for every subscription of the attached
+// clients, it runs in the Run loop as it reads the resources
+func (h *Hub) heartbeat() {
+	h.sessions.mutex.Lock()
+	beats := make(map[*Client][]Subscription)
+	for _, s := range h.sessions.byTok {
+		if s.client == nil {
+			continue
+		}
+		for sub := range s.demands {
+			beats[s.client] = append(beats[s.client], sub)
+		}
+	}
+	h.sessions.mutex.Unlock()
+
+	for c, subs := range beats {
+		for _, sub := range subs {
+			res, exists := h.Resource(sub.CID, sub.Type)
+			if !exists {
+				continue
+			}
+			frame := heartbeatFrame{Type: sub.Type}
+			if hr, ok := res.(HealthReporter); ok {
+				frame.Health = hr.Health()
+			}
+			c.Send(&Response{
+				CID:     sub.CID,
+				Type:    "heartbeat",
+				Message: frame,
+			})
+		}
+	}
+}
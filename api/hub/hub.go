@@ -1,38 +1,153 @@
 package hub
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"sync"
+	"io"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/container"
 	"github.com/h0rzn/monitoring_agent/dock/controller"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/federation"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
+	"github.com/h0rzn/monitoring_agent/tracing"
 	"github.com/sirupsen/logrus"
 )
 
+// Hub routes demands of clients to resources. Resources is owned by the
+// Run loop, everything touching it has to be sent there over a channel
 type Hub struct {
-	mutex     *sync.RWMutex
-	Sub       chan *Demand
-	USub      chan *Demand
-	Lve       chan *Client
-	Ctr       *controller.Controller
+	Sub  chan *Demand
+	USub chan *Demand
+	Cmd  chan *Demand
+	Lve  chan *Client
+	Ctr  *controller.Controller
+	// peers of an aggregating agent
+	Peers     *federation.Federation
 	Resources map[Resource]bool
 	LveSig    chan Resource
+	reg       chan Resource
+	probe     chan chan struct{}
+	drain     chan closeRequest
+	// set once the hub drains, owned by the Run loop
+	closed   bool
+	sessions *sessions
+	stats    *sendStats
 }
 
-func NewHub(ctr *controller.Controller) *Hub {
-	return &Hub{
-		mutex:     &sync.RWMutex{},
+func NewHub(ctr *controller.Controller, peers *federation.Federation) *Hub {
+	buffer := config.Int("HUB_BUFFER", 0)
+	h := &Hub{
 		Ctr:       ctr,
+		Peers:     peers,
 		Resources: make(map[Resource]bool),
-		Sub:       make(chan *Demand),
-		USub:      make(chan *Demand),
-		Lve:       make(chan *Client),
-		LveSig:    make(chan Resource),
+		Sub:       make(chan *Demand, buffer),
+		USub:      make(chan *Demand, buffer),
+		Cmd:       make(chan *Demand, buffer),
+		Lve:       make(chan *Client, buffer),
+		LveSig:    make(chan Resource, buffer),
+		reg:       make(chan Resource),
+		probe:     make(chan chan struct{}),
+		drain:     make(chan closeRequest),
+		sessions:  newSessions(),
+		stats:     newSendStats(),
 	}
+	h.registerGauges()
+	h.registerSendGauges()
+	return h
 }
 
-func (h *Hub) CreateClient(con *websocket.Conn) *Client {
-	return NewClient(con, h.Sub, h.USub, h.Lve)
+// registerGauges exposes the queue depths of the hub as self-metrics
+func (h *Hub) registerGauges() {
+	selfmetrics.Register("hub_sub_queue", func() float64 {
+		return float64(len(h.Sub))
+	})
+	selfmetrics.Register("hub_unsub_queue", func() float64 {
+		return float64(len(h.USub))
+	})
+	selfmetrics.Register("hub_leave_queue", func() float64 {
+		return float64(len(h.Lve) + len(h.LveSig))
+	})
+	selfmetrics.Register("hub_client_queue", func() float64 {
+		depth := 0
+		for _, c := range h.clients() {
+			depth += len(c.In)
+		}
+		return float64(depth)
+	})
+}
+
+// CreateClient creates a client restricted to the containers matched by scope
+func (h *Hub) CreateClient(con *websocket.Conn, scope container.Selector) *Client {
+	c := NewClient(con, h.Sub, h.USub, h.Lve)
+	c.Scope = scope
+	c.Cmd = h.Cmd
+	c.stats = h.stats
+	return c
+}
+
+// peerDemand reports whether dem addresses a container of a peer
+func peerDemand(dem *Demand) bool {
+	_, _, ok := federation.SplitID(dem.CID)
+	return ok && resourceTypes[dem.Ressource] == targetContainer
+}
+
+// permit checks that a scoped client only subscribes to its own containers,
+// that nothing is pulled in read-only mode and that the hub is not draining
+func (h *Hub) permit(dem *Demand) error {
+	if h.closed {
+		return demandErr(CodeServerClosing, "agent is shutting down")
+	}
+	if err := available(dem.Client, dem.Ressource); err != nil {
+		return err
+	}
+	if peerDemand(dem) && (dem.Shape.Where != nil || dem.Shape.Every > 0) {
+		return demandErr(CodeInvalidDemand, "containers of peers do not support where expressions or intervals")
+	}
+	scope := dem.Client.Scope
+	if len(scope) == 0 {
+		return nil
+	}
+	switch dem.Ressource {
+	case "metrics", "logs", "lifecycle":
+		c, exists := h.Ctr.Containers.Container(dem.CID)
+		if !exists || !scope.Match(c) {
+			return demandErr(CodeContainerNotFound, "cannot find container %s", dem.CID)
+		}
+	}
+	return nil
+}
+
+// resource types switched off with their feature
+var featureTypes = map[string]string{
+	"logs":        "logs",
+	"events":      "events",
+	"host_events": "events",
+	"lifecycle":   "events",
+	"image_pull":  "control",
+}
+
+// available checks that the resource type typ can be demanded by c at all
+func available(c *Client, typ string) error {
+	if typ == "image_pull" && config.Bool("READ_ONLY", false) {
+		return demandErr(CodeUnauthorized, "agent is running in read-only mode")
+	}
+	if feature, ok := featureTypes[typ]; ok && !config.Enabled(feature) {
+		return demandErr(CodeUnknownResource, "resource %s is disabled on this agent", typ)
+	}
+	if len(c.Scope) == 0 {
+		return nil
+	}
+	switch typ {
+	case "metrics", "logs", "lifecycle", "containers":
+		return nil
+	}
+	return demandErr(CodeUnauthorized, "resource %s is not available for clients scoped to %s", typ, c.Scope)
 }
 
 func (h *Hub) CreateGeneric(cid, typ string) (*GenericR, error) {
@@ -46,13 +161,13 @@ func (h *Hub) CreateGeneric(cid, typ string) (*GenericR, error) {
 		h.Resources[r] = true
 		return r, err
 	}
-	return &GenericR{}, fmt.Errorf("cannot find container %s", cid)
+	return &GenericR{}, demandErr(CodeContainerNotFound, "cannot find container %s", cid)
 }
 
 func (h *Hub) CreateCombined(cid, typ string) (*CombindedMetrics, error) {
 	logrus.Debugln("- HUB - creating combined resource")
 	if cid != "_all" {
-		return &CombindedMetrics{}, fmt.Errorf("failed to create combined resource: cid as to be '_all' got: %s", cid)
+		return &CombindedMetrics{}, demandErr(CodeInvalidDemand, "failed to create combined resource: cid as to be '_all' got: %s", cid)
 	}
 
 	r := NewCombinedR(h.Ctr.Containers, h.LveSig)
@@ -64,6 +179,70 @@ func (h *Hub) CreateCombined(cid, typ string) (*CombindedMetrics, error) {
 	return r, err
 }
 
+func (h *Hub) CreateHost() (*CombindedMetrics, error) {
+	logrus.Debugln("- HUB - creating host resource")
+	if r, exists := h.Resource("_all", "host"); exists {
+		return r.(*CombindedMetrics), nil
+	}
+
+	r := NewHostR(h.Ctr.Containers, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &CombindedMetrics{}, err
+	}
+	h.Resources[r] = true
+	return r, err
+}
+
+// CreateProject creates the aggregate of the compose project name
+func (h *Hub) CreateProject(name string) (*CombindedMetrics, error) {
+	logrus.Debugln("- HUB - creating project resource")
+	if len(h.Ctr.Containers.Select(container.ProjectSelector(name))) == 0 {
+		return &CombindedMetrics{}, demandErr(CodeProjectNotFound, "cannot find compose project %s", name)
+	}
+
+	r := NewProjectR(name, h.Ctr.Containers, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &CombindedMetrics{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
+// CreatePeer creates the resource forwarding a container resource of a peer
+func (h *Hub) CreatePeer(dem *Demand) (*PeerR, error) {
+	logrus.Debugln("- HUB - creating peer resource")
+	name, _, _ := federation.SplitID(dem.CID)
+	peer, exists := h.Peers.Peer(name)
+	if !exists {
+		return &PeerR{}, demandErr(CodeContainerNotFound, "cannot find container %s, no peer %s", dem.CID, name)
+	}
+	r := NewPeerR(dem.CID, dem.Ressource, peer, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &PeerR{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
+// CreateCollector creates the resource of the running collector name
+func (h *Hub) CreateCollector(name string) (*CollectorR, error) {
+	logrus.Debugln("- HUB - creating collector resource")
+	if !h.Ctr.Collectors.Has(name) {
+		return &CollectorR{}, demandErr(CodeCollectorNotFound, "no collector %s is running", name)
+	}
+
+	r := NewCollectorR(name, h.Ctr.Collectors, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &CollectorR{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
 func (h *Hub) CreateEvents() (*EventsR, error) {
 	logrus.Debugln("- HUB - creating events resource")
 	r, exists := h.hasEventR()
@@ -81,9 +260,97 @@ func (h *Hub) CreateEvents() (*EventsR, error) {
 	return r, nil
 }
 
+func (h *Hub) CreateContainers() (*ContainersR, error) {
+	logrus.Debugln("- HUB - creating containers resource")
+	if r, exists := h.Resource("", "containers"); exists {
+		return r.(*ContainersR), nil
+	}
+
+	r := NewContainersR(h.Ctr.Containers, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &ContainersR{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
+func (h *Hub) CreateLifecycle(cid string) (*LifecycleR, error) {
+	logrus.Debugln("- HUB - creating lifecycle resource")
+	if _, exists := h.Ctr.Containers.Container(cid); !exists {
+		return &LifecycleR{}, demandErr(CodeContainerNotFound, "cannot find container %s", cid)
+	}
+
+	r := NewLifecycleR(cid, h.Ctr.Events.Get, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &LifecycleR{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
+func (h *Hub) CreateFirehose() (*FirehoseR, error) {
+	logrus.Debugln("- HUB - creating host events resource")
+	r := NewFirehoseR(h.Ctr.Events.Get, h.LveSig)
+	err := r.Run()
+	if err != nil {
+		return &FirehoseR{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
+func (h *Hub) CreatePull(ref string, user string) (*PullR, error) {
+	logrus.Debugln("- HUB - creating image pull resource")
+	if ref == "" {
+		return &PullR{}, demandErr(CodeInvalidDemand, "image reference required")
+	}
+
+	r := NewPullR(ref, h.Ctr.Images.Pull, h.LveSig)
+	r.user = user
+	r.record = h.audit
+	err := r.Run()
+	if err != nil {
+		return &PullR{}, err
+	}
+	h.Resources[r] = true
+	return r, nil
+}
+
+// audit stores an entry of a control action started over the websocket
+func (h *Hub) audit(entry db.AuditEntry) {
+	logrus.Infof("- AUDIT - %s %s %s (%s) -> %d %s\n", entry.User, entry.Method, entry.Route, entry.Target, entry.Status, entry.Error)
+	go func() {
+		if err := h.Ctr.DB.InsertAudit(entry); err != nil {
+			logrus.Warnf("- AUDIT - failed to store entry: %s\n", err)
+		}
+	}()
+}
+
+// StartBuild registers a running build as resource, subscribers of
+// the returned id receive its output
+func (h *Hub) StartBuild(output io.ReadCloser) (string, error) {
+	logrus.Debugln("- HUB - creating image build resource")
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	r := NewBuildR(id, output, h.Ctr.Images.BuildOutput, h.LveSig)
+	err = r.Run()
+	if err != nil {
+		return "", err
+	}
+	h.reg <- r
+	return id, nil
+}
+
 func (h *Hub) hasEventR() (*EventsR, bool) {
 	for r := range h.Resources {
-		if r.Type() == "event" {
+		if r.Type() == "events" {
 			return (r).(*EventsR), true
 		}
 	}
@@ -92,61 +359,102 @@ func (h *Hub) hasEventR() (*EventsR, bool) {
 
 func (h *Hub) Subscribe(dem *Demand) {
 	logrus.Infoln("- HUB - Subscribe")
-	h.mutex.Lock()
-	if res, exists := h.Resource(dem.CID, dem.Ressource); exists {
+	_, span := tracing.Start(context.Background(), "hub subscribe", tracing.KindInternal)
+	defer span.End()
+	span.SetAttr("hub.resource", dem.Ressource)
+	span.SetAttr("hub.cid", dem.CID)
+	if err := h.permit(dem); err != nil {
+		span.SetError(err)
+		dem.Client.Error(dem, err)
+		return
+	}
+	res, exists := h.Resource(dem.CID, dem.Ressource)
+	// a subscriber back within the grace period keeps the running streams,
+	// a resource whose period ran out is quitting and replaced
+	if exists && res.Idle().Stop() {
+		delete(h.Resources, res)
+		exists = false
+	}
+	if exists {
 		fmt.Println("resource exists: adding client")
-		res.Add(dem.Client)
 	} else {
-		switch dem.Ressource {
-		case "metrics", "logs":
-			r, err := h.CreateGeneric(dem.CID, dem.Ressource)
-			if err != nil {
-				logrus.Errorf("resource creation err: %s\n", err)
-				dem.Client.Error(err.Error())
-				break
-			}
-			r.Add(dem.Client)
-		case "combined_metrics":
-			r, err := h.CreateCombined(dem.CID, dem.Ressource)
-			if err != nil {
-				logrus.Errorf("resource creation err: %s\n", err)
-				dem.Client.Error(err.Error())
-				break
-			}
-			r.Add(dem.Client)
-		case "events":
-			r, err := h.CreateEvents()
-			if err != nil {
-				logrus.Errorf("resource creation err: %s\n", err)
-				dem.Client.Error(err.Error())
-				break
-			}
-			r.Add(dem.Client)
-		default:
-			dem.Client.Error(fmt.Sprintf("cannot create resource, container %s or type %s does not exist", dem.CID, dem.Ressource))
+		var err error
+		res, err = h.create(dem)
+		if err != nil {
+			logrus.Errorf("resource creation err: %s\n", err)
+			span.SetError(err)
+			dem.Client.Error(dem, err)
+			return
 		}
 	}
-	h.mutex.Unlock()
+	h.add(res, dem)
+	dem.Client.ack(dem, "subscribe")
+	if rp, ok := res.(Replayer); ok && dem.Since > 0 {
+		rp.Replay(dem.Client, dem.Since)
+	} else {
+		h.snapshot(dem)
+	}
+	h.remember(dem)
+}
 
+// create creates and runs the resource demanded
+func (h *Hub) create(dem *Demand) (Resource, error) {
+	if peerDemand(dem) {
+		return h.CreatePeer(dem)
+	}
+	switch dem.Ressource {
+	case "metrics", "logs":
+		return h.CreateGeneric(dem.CID, dem.Ressource)
+	case "combined_metrics":
+		return h.CreateCombined(dem.CID, dem.Ressource)
+	case "lifecycle":
+		return h.CreateLifecycle(dem.CID)
+	case "host_events":
+		return h.CreateFirehose()
+	case "containers":
+		return h.CreateContainers()
+	case "host":
+		return h.CreateHost()
+	case "project":
+		return h.CreateProject(dem.CID)
+	case "image_pull":
+		return h.CreatePull(dem.CID, dem.Client.User)
+	case "events":
+		return h.CreateEvents()
+	case "collector":
+		return h.CreateCollector(dem.CID)
+	}
+	return nil, demandErr(CodeUnknownResource, "unknown resource type %s", dem.Ressource)
+}
+
+// add registers the demanding client, passing filters and the delivery
+// shape if supported
+func (h *Hub) add(r Resource, dem *Demand) {
+	if s, ok := r.(Shaped); ok {
+		s.AddShaped(dem.Client, dem.Shape)
+		return
+	}
+	if f, ok := r.(Filtered); ok {
+		f.AddFiltered(dem.Client, dem.Filters)
+		return
+	}
+	r.Add(dem.Client)
 }
 
 func (h *Hub) Unsubscribe(dem *Demand) {
 	logrus.Infoln("- HUB - Unsubscribe")
-	h.mutex.Lock()
+	_, span := tracing.Start(context.Background(), "hub unsubscribe", tracing.KindInternal)
+	defer span.End()
+	span.SetAttr("hub.resource", dem.Ressource)
+	span.SetAttr("hub.cid", dem.CID)
 	if r, exists := h.Resource(dem.CID, dem.Ressource); exists {
 		r.Rm(dem.Client)
+		h.forget(dem)
+		dem.Client.ack(dem, "unsubscribe")
 	} else {
 		logrus.Errorf("- HUB - failed to unsubscribe: resource not found")
-		dem.Client.Error("failed to unsubscribe, resource not found")
+		dem.Client.Error(dem, demandErr(CodeNotSubscribed, "failed to unsubscribe, resource not found"))
 	}
-	h.mutex.Unlock()
-}
-
-func (h *Hub) Remove(r Resource) {
-	r.Quit()
-	h.mutex.Lock()
-	delete(h.Resources, r)
-	h.mutex.Unlock()
 }
 
 func (h *Hub) Resource(cid string, event string) (r Resource, exists bool) {
@@ -162,17 +470,34 @@ func (h *Hub) ClientLeave(c *Client) {
 	for r := range h.Resources {
 		r.Rm(c)
 	}
+	h.detach(c)
 }
 
 func (h *Hub) RessourceLeave(res Resource) {
-	h.mutex.Lock()
 	delete(h.Resources, res)
-	h.mutex.Unlock()
+	h.stats.forget(res)
 	logrus.Infoln("- HUB - ressource removed")
 }
 
+// Alive reports whether the hub loop answers a probe within timeout
+func (h *Hub) Alive(timeout time.Duration) bool {
+	ack := make(chan struct{})
+	select {
+	case h.probe <- ack:
+	case <-time.After(timeout):
+		return false
+	}
+	select {
+	case <-ack:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func (h *Hub) Run() {
 	logrus.Infoln("- HUB - running")
+	heartbeat := h.heartbeatTicker()
 	for {
 		select {
 		case dem := <-h.Sub:
@@ -181,11 +506,21 @@ func (h *Hub) Run() {
 		case dem := <-h.USub:
 			fmt.Println("HUB: unsubscribing")
 			h.Unsubscribe(dem)
+		case dem := <-h.Cmd:
+			h.Command(dem)
 		case client := <-h.Lve:
 			fmt.Println("hub rcvd client leave")
 			h.ClientLeave(client)
 		case res := <-h.LveSig:
 			h.RessourceLeave(res)
+		case res := <-h.reg:
+			h.Resources[res] = true
+		case ack := <-h.probe:
+			close(ack)
+		case req := <-h.drain:
+			h.closing(req)
+		case <-heartbeat:
+			h.heartbeat()
 		}
 	}
 
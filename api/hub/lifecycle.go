@@ -0,0 +1,174 @@
+package hub
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	devents "github.com/docker/docker/api/types/events"
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// lifecycle actions relayed to subscribers, health transitions are
+// reported by docker as "health_status: <status>"
+var lifecycleActions = map[string]bool{
+	"create":  true,
+	"start":   true,
+	"restart": true,
+	"stop":    true,
+	"kill":    true,
+	"die":     true,
+	"oom":     true,
+	"pause":   true,
+	"unpause": true,
+	"destroy": true,
+}
+
+type Lifecycle struct {
+	// docker action or "state" for the snapshot sent on subscribe
+	Action string `json:"action"`
+	// container status of a state snapshot, e.g. running
+	State    string `json:"state,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Health   string `json:"health,omitempty"`
+	When     string `json:"when"`
+}
+
+// LifecycleR relays state changes of a single container
+type LifecycleR struct {
+	mutex   *sync.Mutex
+	Typ     string
+	ID      string
+	Subs    map[*Client]bool
+	LveSig  chan Resource
+	Events  GetEvents
+	Input   *stream.Receiver
+	Timeout *Timeout
+	ring    *Ring
+}
+
+func NewLifecycleR(cid string, getEvents GetEvents, lveSig chan Resource) *LifecycleR {
+	r := &LifecycleR{
+		mutex:  &sync.Mutex{},
+		Typ:    "lifecycle",
+		ID:     cid,
+		Subs:   make(map[*Client]bool),
+		LveSig: lveSig,
+		Events: getEvents,
+		ring:   NewRing(),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *LifecycleR) CID() string {
+	return r.ID
+}
+
+func (r *LifecycleR) Type() string {
+	return r.Typ
+}
+
+func (r *LifecycleR) Run() error {
+	rcv, err := r.Events()
+	if err != nil {
+		return err
+	}
+	r.Input = rcv
+	go func() {
+		for set := range rcv.In {
+			event, ok := set.Data.(devents.Message)
+			if !ok || event.Type != devents.ContainerEventType || event.Actor.ID != r.ID {
+				continue
+			}
+			if lc, ok := NewLifecycle(event); ok {
+				r.Broadcast(*stream.NewSet("lifecycle", lc))
+			}
+		}
+	}()
+	return nil
+}
+
+// NewLifecycle maps a container event to a lifecycle frame
+func NewLifecycle(event devents.Message) (*Lifecycle, bool) {
+	lc := &Lifecycle{
+		Action: event.Action,
+		When:   time.Unix(0, event.TimeNano).Format(time.RFC3339Nano),
+	}
+
+	if strings.HasPrefix(event.Action, "health_status: ") {
+		lc.Action = "health"
+		lc.Health = strings.TrimPrefix(event.Action, "health_status: ")
+		return lc, true
+	}
+	if !lifecycleActions[event.Action] {
+		return lc, false
+	}
+	if event.Action == "die" {
+		if code, err := strconv.Atoi(event.Actor.Attributes["exitCode"]); err == nil {
+			lc.ExitCode = &code
+		}
+	}
+	return lc, true
+}
+
+func (r *LifecycleR) Add(c *Client) {
+	r.mutex.Lock()
+	r.Subs[c] = true
+	r.mutex.Unlock()
+}
+
+func (r *LifecycleR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	idle := len(r.Subs) == 0
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *LifecycleR) Broadcast(set stream.Set) {
+	frame := &Response{
+		CID:     r.ID,
+		Type:    r.Typ,
+		Message: set.Data,
+	}
+	r.mutex.Lock()
+	r.ring.Push(frame)
+	for c := range r.Subs {
+		c.Send(frame)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *LifecycleR) Replay(c *Client, since uint64) {
+	r.mutex.Lock()
+	r.ring.Replay(c, since, r.ID, r.Typ, nil)
+	r.mutex.Unlock()
+}
+
+func (r *LifecycleR) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *LifecycleR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *LifecycleR) Quit() {
+	logrus.Debugln("- HUB - lifecycle resource quit")
+	if r.Input != nil {
+		r.Input.Quit()
+	}
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
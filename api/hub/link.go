@@ -0,0 +1,175 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+	"github.com/h0rzn/monitoring_agent/federation"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// default frames a link session keeps for resuming links
+	defaultLinkFrames = 1024
+	// default frames queued per link before new frames are dropped
+	defaultLinkQueue = 1024
+)
+
+// linkRaw is a frame already encoded for a link, passed through the codec
+type linkRaw []byte
+
+// linkLog numbers the resource frames sent to the links of a session
+// without gaps and keeps the last ones encoded. A resuming link gets the
+// frames after the last host sequence number it received resent, the
+// resources their frames after the last resource sequence number sent
+type linkLog struct {
+	mutex   *sync.Mutex
+	seq     uint64
+	entries [][]byte
+	// last resource sequence number stamped per subscription
+	last map[Subscription]uint64
+}
+
+func newLinkLog() *linkLog {
+	size := config.Int("LINK_REPLAY_FRAMES", defaultLinkFrames)
+	if size < 1 {
+		size = 1
+	}
+	return &linkLog{
+		mutex:   &sync.Mutex{},
+		entries: make([][]byte, size),
+		last:    make(map[Subscription]uint64),
+	}
+}
+
+// send stamps frames of resources with the next host sequence number and
+// queues them for c. Numbering and queueing happen under the lock, so
+// links receive the frames in the order of their numbers
+func (l *linkLog) send(c *Client, frame *Response) bool {
+	if frame.Seq == 0 && !frame.Snapshot {
+		return c.enqueue(frame)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	data, err := encodeLink(l.seq+1, frame)
+	if err != nil {
+		logrus.Errorf("- HUB - failed to encode %s frame for link: %s\n", frame.Type, err)
+		return false
+	}
+	l.seq++
+	l.entries[l.seq%uint64(len(l.entries))] = data
+	if frame.Seq > 0 {
+		l.last[Subscription{CID: frame.CID, Type: frame.Type}] = frame.Seq
+	}
+	return c.enqueue(&Response{CID: frame.CID, Type: frame.Type, Message: linkRaw(data)})
+}
+
+// resume queues the session frame and the kept frames after since for c,
+// the frame reports the last host sequence number and the frames after
+// since that are no longer kept
+func (l *linkLog) resume(c *Client, since uint64, frame sessionFrame) sessionFrame {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	oldest := uint64(1)
+	if size := uint64(len(l.entries)); l.seq > size {
+		oldest = l.seq - size + 1
+	}
+	if since > l.seq {
+		// numbers of another session, nothing to resend
+		since = l.seq
+	}
+	if since+1 < oldest {
+		frame.Lost = oldest - since - 1
+		since = oldest - 1
+	}
+	frame.Seq = l.seq
+	c.enqueue(&Response{Type: "session", Message: frame})
+	for seq := since + 1; seq <= l.seq; seq++ {
+		data := l.entries[seq%uint64(len(l.entries))]
+		c.enqueue(&Response{Type: "replay", Message: linkRaw(data)})
+	}
+	return frame
+}
+
+// since returns the last resource sequence number sent for sub
+func (l *linkLog) since(sub Subscription) uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.last[sub]
+}
+
+// encodeLink returns frame as link Frame with the host sequence number seq
+func encodeLink(seq uint64, frame *Response) ([]byte, error) {
+	message, err := json.Marshal(frame.Message)
+	if err != nil {
+		return nil, err
+	}
+	return federation.EncodeFrame(federation.LinkFrame{
+		HostSeq:  seq,
+		CID:      frame.CID,
+		Type:     frame.Type,
+		Seq:      frame.Seq,
+		Message:  message,
+		Snapshot: frame.Snapshot,
+	})
+}
+
+// linkCodec writes the messages of federation/proto/link.proto
+type linkCodec struct{}
+
+func (linkCodec) encode(frame *Response) (int, []byte, error) {
+	if raw, ok := frame.Message.(linkRaw); ok {
+		return websocket.BinaryMessage, raw, nil
+	}
+	data, err := encodeLink(0, frame)
+	return websocket.BinaryMessage, data, err
+}
+
+func (linkCodec) decode(typ int, data []byte) (*Request, error) {
+	if typ != websocket.BinaryMessage {
+		return nil, fmt.Errorf("expected binary demand")
+	}
+	dem, err := federation.DecodeDemand(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{ID: dem.ID, CID: dem.CID, Event: dem.Event, Type: dem.Type}, nil
+}
+
+// CreateLink creates the client of an agent aggregating this one, it
+// speaks the link protocol instead of the hub protocol
+func (h *Hub) CreateLink(con *websocket.Conn, scope container.Selector) *Client {
+	c := h.CreateClient(con, scope)
+	c.In = make(chan *Response, config.Int("LINK_QUEUE", defaultLinkQueue))
+	c.codec = linkCodec{}
+	return c
+}
+
+// AttachLink binds the link to the session of token like Attach. A resumed
+// link gets the frames after its last host sequence number since resent,
+// the restored subscriptions replay their resources from the last frame
+// the session was sent
+func (h *Hub) AttachLink(c *Client, token string, since uint64) {
+	s, restore := h.attach(c, token)
+	h.sessions.mutex.Lock()
+	if s.link == nil {
+		s.link = newLinkLog()
+	}
+	c.link = s.link
+	h.sessions.mutex.Unlock()
+
+	frame := sessionFrame{Token: s.Token, Restored: make([]Subscription, 0, len(restore))}
+	for _, dem := range restore {
+		sub := Subscription{CID: dem.CID, Type: dem.Ressource}
+		dem.Since = c.link.since(sub)
+		frame.Restored = append(frame.Restored, sub)
+	}
+	if frame = c.link.resume(c, since, frame); frame.Lost > 0 {
+		logrus.Warnf("- HUB - link %s resumed after %d frame(s) no longer kept\n", c.remote(), frame.Lost)
+	}
+	h.restore(restore)
+}
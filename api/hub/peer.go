@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/h0rzn/monitoring_agent/federation"
+	"github.com/sirupsen/logrus"
+)
+
+// PeerR subscribes to a container resource of a peer over the link to the
+// peer and forwards its frames under the host-prefixed id
+type PeerR struct {
+	mutex   *sync.Mutex
+	ID      string
+	Typ     string
+	Peer    *federation.Peer
+	remote  string
+	Input   chan federation.LinkFrame
+	Subs    map[*Client]bool
+	LveSig  chan Resource
+	Timeout *Timeout
+	ring    *Ring
+	done    chan struct{}
+}
+
+func NewPeerR(id, typ string, peer *federation.Peer, lveSig chan Resource) *PeerR {
+	_, remote, _ := federation.SplitID(id)
+	r := &PeerR{
+		mutex:  &sync.Mutex{},
+		ID:     id,
+		Typ:    typ,
+		Peer:   peer,
+		remote: remote,
+		Subs:   make(map[*Client]bool),
+		LveSig: lveSig,
+		ring:   NewRing(),
+		done:   make(chan struct{}),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *PeerR) CID() string {
+	return r.ID
+}
+
+func (r *PeerR) Type() string {
+	return r.Typ
+}
+
+func (r *PeerR) Run() error {
+	r.Input = r.Peer.Link().Subscribe(r.remote, r.Typ)
+	go func() {
+		for {
+			select {
+			case <-r.done:
+				return
+			case frame := <-r.Input:
+				r.Broadcast(*stream.NewSet(frame.Type, frame))
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *PeerR) Add(c *Client) {
+	r.mutex.Lock()
+	r.Subs[c] = true
+	r.mutex.Unlock()
+}
+
+func (r *PeerR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	idle := len(r.Subs) == 0
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *PeerR) Broadcast(set stream.Set) {
+	frame, _ := set.Data.(federation.LinkFrame)
+	out := &Response{
+		CID:      r.ID,
+		Type:     frame.Type,
+		Message:  frame.Message,
+		Snapshot: frame.Snapshot,
+	}
+	r.mutex.Lock()
+	if !out.Snapshot && out.Type != "error" {
+		r.ring.Push(out)
+	}
+	for c := range r.Subs {
+		c.Send(out)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *PeerR) Replay(c *Client, since uint64) {
+	r.mutex.Lock()
+	r.ring.Replay(c, since, r.ID, r.Typ, nil)
+	r.mutex.Unlock()
+}
+
+func (r *PeerR) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *PeerR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *PeerR) Quit() {
+	logrus.Debugln("- HUB - peer resource quit")
+	r.Peer.Link().Unsubscribe(r.remote, r.Typ, r.Input)
+	close(r.done)
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
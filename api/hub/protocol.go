@@ -0,0 +1,89 @@
+package hub
+
+// ProtocolVersion is the hub protocol spoken by the agent. Version 1 is the
+// protocol without hello/welcome, kept for clients not sending a hello
+const ProtocolVersion = 2
+
+// capabilities a client can request with its hello
+const (
+	// protobuf frames after the welcome
+	CapBinary = "binary"
+	// permessage-deflate compressed frames, if negotiated on upgrade
+	CapCompression = "compression"
+	// frames queued at once are sent as one batch frame
+	CapBatching = "batching"
+)
+
+// default number of frames in a batch frame
+const defaultMaxBatch = 32
+
+// Welcome answers a hello with the negotiated protocol version and the
+// capabilities enabled, the ones requested and supported by both sides
+type Welcome struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+	Encoding     string   `json:"encoding"`
+}
+
+// hello negotiates the protocol, the welcome is queued like every frame
+// and the writer switches to the capabilities once it is sent
+func (c *Client) hello(req *Request) {
+	if c.link != nil {
+		c.Error(nil, demandErr(CodeInvalidDemand, "links do not negotiate the protocol"))
+		return
+	}
+	if req.Version < 1 {
+		c.Error(nil, demandErr(CodeInvalidDemand, "hello requires a protocol version"))
+		return
+	}
+	version := req.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	welcome := Welcome{
+		Version:      version,
+		Capabilities: make([]string, 0),
+		Encoding:     EncodingJSON,
+	}
+	if _, ok := c.codec.(protoCodec); ok {
+		welcome.Encoding = EncodingProto
+	}
+	for _, capability := range req.Capabilities {
+		switch capability {
+		case CapBinary:
+			welcome.Encoding = EncodingProto
+		case CapCompression:
+			if !c.compressible {
+				continue
+			}
+		case CapBatching:
+		default:
+			continue
+		}
+		welcome.Capabilities = append(welcome.Capabilities, capability)
+	}
+	c.Send(&Response{
+		Type:    "welcome",
+		Message: welcome,
+	})
+}
+
+// apply enables the capabilities of a welcome, called by the writer
+func (c *Client) apply(welcome Welcome) {
+	c.codec, _ = newCodec(welcome.Encoding)
+	for _, capability := range welcome.Capabilities {
+		switch capability {
+		case CapCompression:
+			c.con.EnableWriteCompression(true)
+		case CapBatching:
+			c.batching = c.maxBatch > 1
+		}
+	}
+}
+
+// SetCompressible tells the client that permessage-deflate was negotiated
+// on upgrade, it is only used once requested with a hello
+func (c *Client) SetCompressible(compressible bool) {
+	c.compressible = compressible
+	c.con.EnableWriteCompression(false)
+}
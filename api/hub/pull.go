@@ -0,0 +1,189 @@
+package hub
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/dock/image"
+	"github.com/h0rzn/monitoring_agent/dock/stream"
+	"github.com/sirupsen/logrus"
+)
+
+// Pull starts pulling ref, reporting progress to the callback
+type Pull func(ref string, progress func(image.PullProgress)) error
+
+// PullState is sent to joining subscribers and once the pull ended
+type PullState struct {
+	Ref    string                `json:"ref"`
+	Done   bool                  `json:"done"`
+	Error  string                `json:"error,omitempty"`
+	Layers []*image.PullProgress `json:"layers"`
+}
+
+// PullR is an image pull shared by all subscribers of its ref, the pull
+// runs to completion regardless of subscribers leaving
+type PullR struct {
+	mutex   *sync.Mutex
+	Typ     string
+	Ref     string
+	Subs    map[*Client]bool
+	LveSig  chan Resource
+	Timeout *Timeout
+	pull    Pull
+	layers  map[string]*image.PullProgress
+	order   []string
+	done    bool
+	err     string
+	// user starting the pull, recorded in the audit log
+	user   string
+	record func(db.AuditEntry)
+}
+
+func NewPullR(ref string, pull Pull, lveSig chan Resource) *PullR {
+	r := &PullR{
+		mutex:  &sync.Mutex{},
+		Typ:    "image_pull",
+		Ref:    ref,
+		Subs:   make(map[*Client]bool),
+		LveSig: lveSig,
+		pull:   pull,
+		layers: make(map[string]*image.PullProgress),
+	}
+	r.Timeout = NewTimeout(r.Quit)
+	return r
+}
+
+func (r *PullR) CID() string {
+	return r.Ref
+}
+
+func (r *PullR) Type() string {
+	return r.Typ
+}
+
+func (r *PullR) Run() error {
+	go func() {
+		logrus.Infof("- HUB - pulling image %s\n", r.Ref)
+		r.audit(http.StatusAccepted, "pull started", nil)
+		err := r.pull(r.Ref, r.progress)
+		r.audit(http.StatusOK, "pulled", err)
+
+		r.mutex.Lock()
+		r.done = true
+		if err != nil {
+			r.err = err.Error()
+			logrus.Errorf("- HUB - pull of %s failed: %s\n", r.Ref, err)
+		}
+		state := r.state()
+		idle := len(r.Subs) == 0
+		r.mutex.Unlock()
+
+		r.Broadcast(*stream.NewSet("image_pull", state))
+		// keep the result around for late subscribers
+		if idle {
+			r.Timeout.Start()
+		}
+	}()
+	return nil
+}
+
+// audit records the pull like the control actions of the api
+func (r *PullR) audit(status int, detail string, err error) {
+	if r.record == nil {
+		return
+	}
+	entry := db.AuditEntry{
+		When:   time.Now(),
+		User:   r.user,
+		Method: "SUBSCRIBE",
+		Route:  r.Typ,
+		Target: r.Ref,
+		Detail: detail,
+		Status: status,
+	}
+	if err != nil {
+		entry.Status = http.StatusBadGateway
+		entry.Error = err.Error()
+	}
+	r.record(entry)
+}
+
+func (r *PullR) progress(p image.PullProgress) {
+	r.mutex.Lock()
+	if p.Layer != "" {
+		if _, exists := r.layers[p.Layer]; !exists {
+			r.order = append(r.order, p.Layer)
+		}
+		layer := p
+		r.layers[p.Layer] = &layer
+	}
+	r.mutex.Unlock()
+	r.Broadcast(*stream.NewSet("image_pull", p))
+}
+
+// state is the current progress of all layers, callers hold the mutex
+func (r *PullR) state() PullState {
+	state := PullState{
+		Ref:    r.Ref,
+		Done:   r.done,
+		Error:  r.err,
+		Layers: make([]*image.PullProgress, 0, len(r.order)),
+	}
+	for _, id := range r.order {
+		layer := *r.layers[id]
+		state.Layers = append(state.Layers, &layer)
+	}
+	return state
+}
+
+func (r *PullR) Add(c *Client) {
+	r.mutex.Lock()
+	r.Subs[c] = true
+	state := r.state()
+	r.mutex.Unlock()
+	c.Send(&Response{
+		CID:     r.Ref,
+		Type:    r.Typ,
+		Message: state,
+	})
+}
+
+func (r *PullR) Rm(c *Client) {
+	r.mutex.Lock()
+	delete(r.Subs, c)
+	// a running pull outlives its subscribers
+	idle := len(r.Subs) == 0 && r.done
+	r.mutex.Unlock()
+	if idle {
+		r.Timeout.Start()
+	}
+}
+
+func (r *PullR) Broadcast(set stream.Set) {
+	frame := &Response{
+		CID:     r.Ref,
+		Type:    r.Typ,
+		Message: set.Data,
+	}
+	r.mutex.Lock()
+	for c := range r.Subs {
+		c.Send(frame)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *PullR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *PullR) Quit() {
+	logrus.Debugln("- HUB - image pull resource quit")
+	r.mutex.Lock()
+	for c := range r.Subs {
+		delete(r.Subs, c)
+	}
+	r.mutex.Unlock()
+	r.LveSig <- r
+}
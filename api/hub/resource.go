@@ -17,6 +17,9 @@ type Resource interface {
 	Rm(*Client)
 	Broadcast(stream.Set)
 	Quit()
+	// Idle returns the grace period the resource quits after once its
+	// last subscriber left
+	Idle() *Timeout
 }
 
 type GenericR struct {
@@ -27,6 +30,9 @@ type GenericR struct {
 	Subs      map[*Client]bool
 	LveSig    chan Resource
 	Timeout   *Timeout
+	ring      *Ring
+	// delivery of the subscribers with a shape
+	shapes map[*Client]*delivery
 }
 
 func NewGenericR(typ string, cont *container.Container, lveSig chan Resource) *GenericR {
@@ -36,6 +42,8 @@ func NewGenericR(typ string, cont *container.Container, lveSig chan Resource) *G
 		Container: cont,
 		Subs:      make(map[*Client]bool),
 		LveSig:    lveSig,
+		ring:      NewRing(),
+		shapes:    make(map[*Client]*delivery),
 	}
 	r.Timeout = NewTimeout(r.Quit)
 	return r
@@ -72,7 +80,7 @@ func (r *GenericR) Run() error {
 		for {
 			select {
 			case <-r.Input.Closing:
-				r.Quit()
+				r.quit(false)
 				return
 			case set, ok := <-r.Input.In:
 				if !ok {
@@ -87,20 +95,29 @@ func (r *GenericR) Run() error {
 }
 
 func (r *GenericR) Add(c *Client) {
-	if len(c.Sub) == 0 {
-		r.Timeout.Stop()
-	}
+	r.AddShaped(c, Shape{})
+}
+
+// AddShaped adds c, receiving the frames as shape asks for
+func (r *GenericR) AddShaped(c *Client, shape Shape) {
 	r.mutex.Lock()
 	r.Subs[c] = true
+	if shape != (Shape{}) {
+		r.shapes[c] = newDelivery(shape)
+	} else {
+		delete(r.shapes, c)
+	}
 	r.mutex.Unlock()
 }
 
 func (r *GenericR) Rm(c *Client) {
 	r.mutex.Lock()
 	delete(r.Subs, c)
+	delete(r.shapes, c)
+	idle := len(r.Subs) == 0
 	r.mutex.Unlock()
-	if len(r.Subs) == 0 {
-		go r.Timeout.Start()
+	if idle {
+		r.Timeout.Start()
 	}
 }
 
@@ -111,20 +128,43 @@ func (r *GenericR) Broadcast(set stream.Set) {
 		Message: set.Data,
 	}
 	r.mutex.Lock()
-	for client := range r.Subs {
-		client.In <- frame
-	}
+	r.ring.Push(frame)
+	deliver(r.Subs, r.shapes, frame)
 	r.mutex.Unlock()
 }
 
-func (r *GenericR) Quit() {
+func (r *GenericR) Replay(c *Client, since uint64) {
 	r.mutex.Lock()
+	r.ring.Replay(c, since, r.CID(), r.Typ, r.shapes[c].replayMatch())
+	r.mutex.Unlock()
+}
 
+func (r *GenericR) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *GenericR) Idle() *Timeout {
+	return r.Timeout
+}
+
+func (r *GenericR) Quit() {
+	r.quit(true)
+}
+
+// quit tears the resource down, release leaves the container stream
+// which has to be skipped if the stream is closing by itself
+func (r *GenericR) quit(release bool) {
+	if release && r.Input != nil {
+		r.Input.Quit()
+	}
+	r.mutex.Lock()
 	for c := range r.Subs {
-		r.Rm(c)
+		delete(r.Subs, c)
 	}
-	r.LveSig <- r
 	r.mutex.Unlock()
+	r.LveSig <- r
 }
 
 type GetEvents func() (*stream.Receiver, error)
@@ -136,6 +176,7 @@ type EventsR struct {
 	LveSig  chan Resource
 	Events  GetEvents
 	Timeout *Timeout
+	ring    *Ring
 }
 
 func NewEventsR(getEvents GetEvents, lveSig chan Resource) *EventsR {
@@ -145,6 +186,7 @@ func NewEventsR(getEvents GetEvents, lveSig chan Resource) *EventsR {
 		Subs:   make(map[*Client]bool),
 		LveSig: lveSig,
 		Events: getEvents,
+		ring:   NewRing(),
 	}
 	r.Timeout = NewTimeout(r.Quit)
 	return r
@@ -175,9 +217,6 @@ func (r *EventsR) Run() error {
 }
 
 func (r *EventsR) Add(c *Client) {
-	if len(c.Sub) == 0 {
-		r.Timeout.Stop()
-	}
 	fmt.Println("eventsr adding client")
 	r.mutex.Lock()
 	r.Subs[c] = true
@@ -188,9 +227,10 @@ func (r *EventsR) Add(c *Client) {
 func (r *EventsR) Rm(c *Client) {
 	r.mutex.Lock()
 	delete(r.Subs, c)
+	idle := len(r.Subs) == 0
 	r.mutex.Unlock()
-	if len(r.Subs) == 0 {
-		go r.Timeout.Start()
+	if idle {
+		r.Timeout.Start()
 	}
 }
 
@@ -202,25 +242,41 @@ func (r *EventsR) Broadcast(set stream.Set) {
 	msg := &Response{
 		Type: "event",
 		Message: map[string]interface{}{
-			"type": fmt.Sprintf("%s_%s", event.Type, event.Status),
-			"id":   event.ID,
+			"type": fmt.Sprintf("%s_%s", event.Type, event.Action),
+			"id":   event.Actor.ID,
 		},
 	}
 	fmt.Println("sending to clients, len", len(r.Subs))
 	r.mutex.Lock()
+	r.ring.Push(msg)
 	for c := range r.Subs {
 		fmt.Println("event resource: sending to client")
-		c.In <- msg
+		c.Send(msg)
 	}
 	r.mutex.Unlock()
 }
 
-func (r *EventsR) Quit() {
+func (r *EventsR) Replay(c *Client, since uint64) {
+	r.mutex.Lock()
+	r.ring.Replay(c, since, "", r.Typ, nil)
+	r.mutex.Unlock()
+}
+
+func (r *EventsR) Health() Health {
 	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ring.Health()
+}
+
+func (r *EventsR) Idle() *Timeout {
+	return r.Timeout
+}
 
+func (r *EventsR) Quit() {
+	r.mutex.Lock()
 	for c := range r.Subs {
-		r.Rm(c)
+		delete(r.Subs, c)
 	}
-	r.LveSig <- r
 	r.mutex.Unlock()
+	r.LveSig <- r
 }
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+)
+
+// default number of frames a resource keeps for replay
+const defaultReplayFrames = 256
+
+// Replayer is implemented by resources that can resend missed frames
+type Replayer interface {
+	Replay(c *Client, since uint64)
+}
+
+// Replay reports the outcome of a replay, sent after the replayed frames
+type Replay struct {
+	Since  uint64 `json:"since"`
+	Frames int    `json:"frames"`
+	// frames after since were already dropped from the buffer
+	Gap bool `json:"gap"`
+}
+
+// Ring numbers the frames of a resource and keeps the last ones for
+// clients replaying from a sequence number after a reconnect. It is not
+// safe for concurrent use, resources guard it with their mutex
+type Ring struct {
+	frames []*Response
+	// index of the next write
+	next int
+	// sequence number and time of the last frame pushed
+	seq  uint64
+	last time.Time
+}
+
+func NewRing() *Ring {
+	return &Ring{
+		frames: make([]*Response, config.Int("HUB_REPLAY_FRAMES", defaultReplayFrames)),
+		// numbers of a recreated resource continue above the old ones
+		seq: uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+	}
+}
+
+// Push assigns the next sequence number to frame and stores it
+func (r *Ring) Push(frame *Response) {
+	r.seq++
+	r.last = time.Now()
+	frame.Seq = r.seq
+	if len(r.frames) == 0 {
+		return
+	}
+	r.frames[r.next] = frame
+	r.next = (r.next + 1) % len(r.frames)
+}
+
+// Health returns the sequence number and time of the last frame
+func (r *Ring) Health() Health {
+	h := Health{Seq: r.seq}
+	if !r.last.IsZero() {
+		last := r.last
+		h.LastSample = &last
+	}
+	return h
+}
+
+// Replay sends the stored frames after since matched by match (nil for
+// all) to c, followed by a replay frame reporting gaps. Frames dropped
+// for a full send queue are a gap as well
+func (r *Ring) Replay(c *Client, since uint64, cid, typ string, match func(*Response) bool) {
+	sent := 0
+	dropped := false
+	oldest := r.seq + 1
+	for i := 0; i < len(r.frames); i++ {
+		frame := r.frames[(r.next+i)%len(r.frames)]
+		if frame == nil {
+			continue
+		}
+		if frame.Seq < oldest {
+			oldest = frame.Seq
+		}
+		if frame.Seq <= since || match != nil && !match(frame) {
+			continue
+		}
+		if !c.Send(frame) {
+			dropped = true
+			continue
+		}
+		sent++
+	}
+	c.Send(&Response{
+		CID:  cid,
+		Type: "replay",
+		Message: map[string]interface{}{
+			"type":   typ,
+			"replay": Replay{Since: since, Frames: sent, Gap: dropped || since+1 < oldest},
+		},
+	})
+}
@@ -0,0 +1,174 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// default time a disconnected session can be resumed
+const defaultSessionTTL = 2 * time.Minute
+
+// Session is the subscription set of a client that survives reconnects,
+// a client presenting its token gets the subscriptions restored
+type Session struct {
+	Token   string
+	demands map[Subscription]subscriptionOpts
+	// attached client, expires is zero while one is attached
+	client  *Client
+	expires time.Time
+	// numbering of the frames sent to a link, nil for browser sessions
+	link *linkLog
+}
+
+// subscriptionOpts are the options of a demand restored with the session
+type subscriptionOpts struct {
+	filters map[string][]string
+	shape   Shape
+}
+
+// Subscription identifies a demand of a session
+type Subscription struct {
+	CID  string `json:"container_id"`
+	Type string `json:"type"`
+}
+
+type sessionFrame struct {
+	Token    string         `json:"token"`
+	Restored []Subscription `json:"restored"`
+	// links only: last host sequence number of the session and the frames
+	// a resuming link missed that are no longer kept
+	Seq  uint64 `json:"seq,omitempty"`
+	Lost uint64 `json:"lost,omitempty"`
+}
+
+type sessions struct {
+	mutex *sync.Mutex
+	ttl   time.Duration
+	byTok map[string]*Session
+}
+
+func newSessions() *sessions {
+	return &sessions{
+		mutex: &sync.Mutex{},
+		ttl:   config.Duration("SESSION_TTL", defaultSessionTTL),
+		byTok: make(map[string]*Session),
+	}
+}
+
+// Attach binds the client to the session of token if it is still
+// resumable, otherwise to a new session. The token is sent to the client
+// and the subscriptions of a resumed session are demanded again
+func (h *Hub) Attach(c *Client, token string) {
+	s, restore := h.attach(c, token)
+	restored := make([]Subscription, 0, len(restore))
+	for _, dem := range restore {
+		restored = append(restored, Subscription{CID: dem.CID, Type: dem.Ressource})
+	}
+	c.Send(&Response{
+		Type:    "session",
+		Message: sessionFrame{Token: s.Token, Restored: restored},
+	})
+	h.restore(restore)
+}
+
+// attach binds c to the session of token or a new one and returns the
+// demands restoring its subscriptions
+func (h *Hub) attach(c *Client, token string) (*Session, []*Demand) {
+	h.sessions.mutex.Lock()
+	defer h.sessions.mutex.Unlock()
+	s, exists := h.sessions.byTok[token]
+	if !exists || !s.expires.IsZero() && time.Now().After(s.expires) {
+		s = &Session{
+			Token:   newToken(),
+			demands: make(map[Subscription]subscriptionOpts),
+		}
+		h.sessions.byTok[s.Token] = s
+	}
+	s.expires = time.Time{}
+	s.client = c
+	c.session = s
+	restore := make([]*Demand, 0, len(s.demands))
+	for sub, opts := range s.demands {
+		restore = append(restore, &Demand{
+			Client:    c,
+			CID:       sub.CID,
+			Ressource: sub.Type,
+			Filters:   opts.filters,
+			Shape:     opts.shape,
+		})
+	}
+	return s, restore
+}
+
+// restore demands the subscriptions of a resumed session again
+func (h *Hub) restore(restore []*Demand) {
+	if len(restore) == 0 {
+		return
+	}
+	logrus.Infof("- HUB - resuming session with %d subscription(s)\n", len(restore))
+	go func() {
+		for _, dem := range restore {
+			h.Sub <- dem
+		}
+	}()
+}
+
+// remember records a successful subscription in the client's session
+func (h *Hub) remember(dem *Demand) {
+	s := dem.Client.session
+	if s == nil {
+		return
+	}
+	h.sessions.mutex.Lock()
+	s.demands[Subscription{CID: dem.CID, Type: dem.Ressource}] = subscriptionOpts{
+		filters: dem.Filters,
+		shape:   dem.Shape,
+	}
+	h.sessions.mutex.Unlock()
+}
+
+func (h *Hub) forget(dem *Demand) {
+	s := dem.Client.session
+	if s == nil {
+		return
+	}
+	h.sessions.mutex.Lock()
+	delete(s.demands, Subscription{CID: dem.CID, Type: dem.Ressource})
+	h.sessions.mutex.Unlock()
+}
+
+// detach keeps the session of a leaving client resumable for the ttl
+func (h *Hub) detach(c *Client) {
+	s := c.session
+	if s == nil {
+		return
+	}
+	h.sessions.mutex.Lock()
+	if s.client != c {
+		// the session was resumed before the old connection was noticed dead
+		h.sessions.mutex.Unlock()
+		return
+	}
+	s.client = nil
+	s.expires = time.Now().Add(h.sessions.ttl)
+	h.sessions.mutex.Unlock()
+
+	time.AfterFunc(h.sessions.ttl, func() {
+		h.sessions.mutex.Lock()
+		if !s.expires.IsZero() && time.Now().After(s.expires) {
+			delete(h.sessions.byTok, s.Token)
+		}
+		h.sessions.mutex.Unlock()
+	})
+}
+
+func newToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,44 @@
+package hub
+
+import (
+	"time"
+)
+
+// snapshot sends the latest cached value of a demanded metrics or lifecycle
+// resource right after subscribing, so clients can render before the next
+// live frame arrives
+func (h *Hub) snapshot(dem *Demand) {
+	c, exists := h.Ctr.Containers.Container(dem.CID)
+	if !exists {
+		return
+	}
+	var message interface{}
+	switch dem.Ressource {
+	case "metrics":
+		set := c.Streams.Metrics.Latest()
+		if set.When == 0 {
+			// nothing collected yet
+			return
+		}
+		message = set
+	case "lifecycle":
+		message = &Lifecycle{
+			Action: "state",
+			State:  c.State.Status,
+			When:   time.Now().Format(time.RFC3339Nano),
+		}
+	default:
+		return
+	}
+
+	frame := &Response{
+		CID:      dem.CID,
+		Type:     dem.Ressource,
+		Message:  message,
+		Snapshot: true,
+	}
+	if where := dem.Shape.Where; where != nil && !whereMatcher(frame)(where) {
+		return
+	}
+	dem.Client.Send(frame)
+}
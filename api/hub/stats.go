@@ -0,0 +1,166 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
+)
+
+// default write latency from which a client counts as slow consumer
+const defaultSlowWrite = 100 * time.Millisecond
+
+// SendStats counts the frames and websocket write latencies of a client
+// or of a resource over all its subscribers
+type SendStats struct {
+	Sent     uint64  `json:"sent"`
+	Dropped  uint64  `json:"dropped"`
+	AvgWrite float64 `json:"avg_write_ms"`
+	MaxWrite float64 `json:"max_write_ms"`
+	writeSum time.Duration
+	writeMax time.Duration
+}
+
+func (s *SendStats) sent(write time.Duration) {
+	s.Sent++
+	s.writeSum += write
+	if write > s.writeMax {
+		s.writeMax = write
+	}
+}
+
+// report fills the exported latency fields
+func (s SendStats) report() SendStats {
+	if s.Sent > 0 {
+		s.AvgWrite = float64(s.writeSum) / float64(s.Sent) / float64(time.Millisecond)
+	}
+	s.MaxWrite = float64(s.writeMax) / float64(time.Millisecond)
+	return s
+}
+
+// ClientStats identifies a client by its remote address and session
+type ClientStats struct {
+	Remote  string `json:"remote"`
+	Session string `json:"session"`
+	Queued  int    `json:"queued"`
+	// dropping frames or writes slower than HUB_SLOW_WRITE on average
+	Slow bool `json:"slow"`
+	SendStats
+}
+
+type ResourceStats struct {
+	Subscription
+	SendStats
+}
+
+// Stats is the answer of /hub/stats
+type Stats struct {
+	Clients   []ClientStats   `json:"clients"`
+	Resources []ResourceStats `json:"resources"`
+}
+
+// sendStats collects the counters of all resources, clients keep their own
+type sendStats struct {
+	mutex     *sync.Mutex
+	slow      time.Duration
+	resources map[Subscription]*SendStats
+	total     SendStats
+}
+
+func newSendStats() *sendStats {
+	return &sendStats{
+		mutex:     &sync.Mutex{},
+		slow:      config.Duration("HUB_SLOW_WRITE", defaultSlowWrite),
+		resources: make(map[Subscription]*SendStats),
+	}
+}
+
+func (st *sendStats) record(frame *Response, write time.Duration, dropped bool) {
+	key := Subscription{CID: frame.CID, Type: frame.Type}
+	st.mutex.Lock()
+	res, exists := st.resources[key]
+	if !exists {
+		res = &SendStats{}
+		st.resources[key] = res
+	}
+	if dropped {
+		res.Dropped++
+		st.total.Dropped++
+	} else {
+		res.sent(write)
+		st.total.sent(write)
+	}
+	st.mutex.Unlock()
+}
+
+// forget drops the counters of a resource that left
+func (st *sendStats) forget(r Resource) {
+	st.mutex.Lock()
+	delete(st.resources, Subscription{CID: r.CID(), Type: r.Type()})
+	st.mutex.Unlock()
+}
+
+// slowClient reports whether a client drops frames or writes slowly
+func (st *sendStats) slowClient(s SendStats) bool {
+	return s.Dropped > 0 || s.Sent > 0 && s.writeSum/time.Duration(s.Sent) >= st.slow
+}
+
+// Stats lists the send counters of the attached clients and the resources
+func (h *Hub) Stats() Stats {
+	stats := Stats{
+		Clients:   make([]ClientStats, 0),
+		Resources: make([]ResourceStats, 0),
+	}
+	for _, c := range h.clients() {
+		cs := c.Stats()
+		cs.Slow = h.stats.slowClient(cs.SendStats)
+		cs.SendStats = cs.SendStats.report()
+		stats.Clients = append(stats.Clients, cs)
+	}
+
+	h.stats.mutex.Lock()
+	for key, res := range h.stats.resources {
+		stats.Resources = append(stats.Resources, ResourceStats{
+			Subscription: key,
+			SendStats:    res.report(),
+		})
+	}
+	h.stats.mutex.Unlock()
+	return stats
+}
+
+// clients returns the clients attached to a session
+func (h *Hub) clients() []*Client {
+	h.sessions.mutex.Lock()
+	defer h.sessions.mutex.Unlock()
+	clients := make([]*Client, 0)
+	for _, s := range h.sessions.byTok {
+		if s.client != nil {
+			clients = append(clients, s.client)
+		}
+	}
+	return clients
+}
+
+func (h *Hub) registerSendGauges() {
+	selfmetrics.Register("hub_frames_sent", func() float64 {
+		h.stats.mutex.Lock()
+		defer h.stats.mutex.Unlock()
+		return float64(h.stats.total.Sent)
+	})
+	selfmetrics.Register("hub_frames_dropped", func() float64 {
+		h.stats.mutex.Lock()
+		defer h.stats.mutex.Unlock()
+		return float64(h.stats.total.Dropped)
+	})
+	selfmetrics.Register("hub_slow_clients", func() float64 {
+		slow := 0
+		for _, c := range h.clients() {
+			if h.stats.slowClient(c.Stats().SendStats) {
+				slow++
+			}
+		}
+		return float64(slow)
+	})
+}
@@ -4,52 +4,67 @@ import (
 	"sync"
 	"time"
 
+	"github.com/h0rzn/monitoring_agent/config"
 	"github.com/sirupsen/logrus"
 )
 
+// default grace period before an unused resource is torn down
+const defaultIdleTimeout = 10 * time.Minute
+
+// Timeout is the idle grace period of a resource: it is started when the
+// last subscriber leaves and stopped by the next subscriber, so a client
+// resubscribing within the period reuses the running streams
 type Timeout struct {
 	mutex    *sync.Mutex
 	Active   bool
-	stop     chan struct{}
+	Duration time.Duration
+	timer    *time.Timer
 	Callback func()
+	fired    bool
 }
 
 func NewTimeout(cb func()) *Timeout {
 	return &Timeout{
-		mutex: &sync.Mutex{},
-		Active: false,
-		stop: make(chan struct{}),
+		mutex:    &sync.Mutex{},
+		Active:   false,
+		Duration: config.Duration("HUB_IDLE_TIMEOUT", defaultIdleTimeout),
 		Callback: cb,
 	}
 }
 
 func (t *Timeout) Start() {
 	t.mutex.Lock()
-	if !t.Active {
+	defer t.mutex.Unlock()
+	if t.Active {
 		return
 	}
 	t.Active = true
-	t.mutex.Unlock()
+	t.timer = time.AfterFunc(t.Duration, t.fire)
+}
 
-	for {
-		timer := time.NewTimer(10 * time.Minute)
-		select {
-		case <-t.stop:
-			timer.Stop()
-			t.mutex.Lock()
-			t.Active = false
-			t.mutex.Unlock()
-			return
-		case <-timer.C:
-			logrus.Infoln("- RESSOURCE - timeout after 10min -> quit")
-			t.Callback()
-		}
+func (t *Timeout) fire() {
+	t.mutex.Lock()
+	if !t.Active {
+		// stopped while firing
+		t.mutex.Unlock()
+		return
 	}
+	t.Active = false
+	t.fired = true
+	t.mutex.Unlock()
 
+	logrus.Infof("- RESSOURCE - idle for %s -> quit\n", t.Duration)
+	t.Callback()
 }
 
-func (t *Timeout) Stop() {
+// Stop stops the grace period and reports whether it already fired, the
+// resource is quitting then and must not take new subscribers
+func (t *Timeout) Stop() (fired bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	if t.Active {
-		t.stop <- struct{}{}
+		t.timer.Stop()
+		t.Active = false
 	}
+	return t.fired
 }
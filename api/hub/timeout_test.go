@@ -0,0 +1,30 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeoutStop(t *testing.T) {
+	quit := make(chan struct{}, 1)
+	timeout := NewTimeout(func() { quit <- struct{}{} })
+	timeout.Duration = 10 * time.Millisecond
+
+	// back within the grace period
+	timeout.Start()
+	if fired := timeout.Stop(); fired {
+		t.Fatal("Stop() within the grace period reported fired")
+	}
+	select {
+	case <-quit:
+		t.Fatal("stopped timeout quit the resource")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// too late, the resource is quitting
+	timeout.Start()
+	<-quit
+	if fired := timeout.Stop(); !fired {
+		t.Fatal("Stop() after the grace period did not report fired")
+	}
+}
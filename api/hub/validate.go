@@ -0,0 +1,128 @@
+package hub
+
+import (
+	"regexp"
+)
+
+// target is what the container_id of a demand names
+type target int
+
+const (
+	// container_id is ignored
+	targetNone target = iota
+	targetContainer
+	// "_all"
+	targetAll
+	// image reference or build id
+	targetRef
+	// compose project name
+	targetProject
+	// collector name
+	targetCollector
+)
+
+func (t target) String() string {
+	switch t {
+	case targetContainer:
+		return "container"
+	case targetAll:
+		return "_all"
+	case targetRef:
+		return "reference"
+	case targetProject:
+		return "project"
+	case targetCollector:
+		return "collector"
+	}
+	return "none"
+}
+
+// resourceTypes lists the resources a client can demand
+var resourceTypes = map[string]target{
+	"metrics":          targetContainer,
+	"logs":             targetContainer,
+	"lifecycle":        targetContainer,
+	"combined_metrics": targetAll,
+	"project":          targetProject,
+	"host":             targetNone,
+	"events":           targetNone,
+	"host_events":      targetNone,
+	"containers":       targetNone,
+	"image_pull":       targetRef,
+	"image_build":      targetRef,
+	"collector":        targetCollector,
+}
+
+// resources evaluating where expressions
+var conditionalTypes = map[string]bool{
+	"metrics":          true,
+	"logs":             true,
+	"combined_metrics": true,
+	"project":          true,
+	"host":             true,
+}
+
+// resources that can be downsampled, logs would lose lines
+var sampledTypes = map[string]bool{
+	"metrics":          true,
+	"combined_metrics": true,
+	"project":          true,
+	"host":             true,
+}
+
+var containerID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// container id of a peer prefixed with the peer name, see federation
+var peerContainerID = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[0-9a-f]{64}$`)
+
+// validate rejects demands for unknown resources or with a container_id
+// not fitting the resource, before they reach the hub
+func validate(dem *Demand) error {
+	t, known := resourceTypes[dem.Ressource]
+	if !known {
+		return demandErr(CodeUnknownResource, "unknown resource type %q", dem.Ressource)
+	}
+	if dem.Shape.Where != nil && !conditionalTypes[dem.Ressource] {
+		return demandErr(CodeInvalidDemand, "resource %s does not support where expressions", dem.Ressource)
+	}
+	if dem.Shape.Every > 0 && !sampledTypes[dem.Ressource] {
+		return demandErr(CodeInvalidDemand, "resource %s does not support intervals", dem.Ressource)
+	}
+	switch t {
+	case targetContainer:
+		if !containerID.MatchString(dem.CID) && !peerContainerID.MatchString(dem.CID) {
+			return demandErr(CodeInvalidDemand, "malformed container id %q, expected 64 hex characters", dem.CID)
+		}
+	case targetAll:
+		if dem.CID != "_all" {
+			return demandErr(CodeInvalidDemand, "container_id of %s has to be _all", dem.Ressource)
+		}
+	case targetRef:
+		if dem.CID == "" {
+			return demandErr(CodeInvalidDemand, "%s requires a reference as container_id", dem.Ressource)
+		}
+	case targetProject:
+		if dem.CID == "" {
+			return demandErr(CodeInvalidDemand, "%s requires a compose project as container_id", dem.Ressource)
+		}
+	case targetCollector:
+		if dem.CID == "" {
+			return demandErr(CodeInvalidDemand, "%s requires a collector name as container_id", dem.Ressource)
+		}
+	}
+	return nil
+}
+
+// ack confirms a demand handled by the hub
+func (c *Client) ack(dem *Demand, event string) {
+	c.Send(&Response{
+		CID:  dem.CID,
+		Type: "ack",
+		Message: Ack{
+			ID:    dem.ID,
+			Event: event,
+			CID:   dem.CID,
+			Type:  dem.Ressource,
+		},
+	})
+}
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"fmt"
+
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+)
+
+// viewRequest turns a subscription of a saved view into a subscribe request
+func viewRequest(sub db.ViewSubscription) *Request {
+	return &Request{
+		CID:       sub.CID,
+		Event:     "subscribe",
+		Type:      sub.Type,
+		Filters:   sub.Filters,
+		Where:     sub.Where,
+		Interval:  sub.Interval,
+		Aggregate: sub.Aggregate,
+	}
+}
+
+// ValidateView checks the subscriptions of a view like subscribe demands
+func ValidateView(v db.View) error {
+	for i, sub := range v.Subscriptions {
+		if _, err := newDemand(nil, viewRequest(sub)); err != nil {
+			return fmt.Errorf("subscription %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// loadView reads the view demanded from the db outside the hub loop and
+// hands its subscriptions back to the loop
+func (h *Hub) loadView(dem *Demand) {
+	c := dem.Client
+	v, exists, err := h.Ctr.DB.View(c.User, dem.CID)
+	if err != nil {
+		c.Error(dem, err)
+		return
+	}
+	if !exists {
+		c.Error(dem, demandErr(CodeViewNotFound, "cannot find view %s", dem.CID))
+		return
+	}
+
+	batch := make([]*Demand, 0, len(v.Subscriptions))
+	for _, sub := range v.Subscriptions {
+		sd, err := newDemand(c, viewRequest(sub))
+		if err != nil {
+			c.Error(sd, err)
+			continue
+		}
+		batch = append(batch, sd)
+	}
+	h.Cmd <- &Demand{
+		ID:     dem.ID,
+		Event:  "apply_view",
+		Client: c,
+		CID:    dem.CID,
+		batch:  batch,
+	}
+}
@@ -2,22 +2,172 @@ package api
 
 import (
 	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/image"
+	"github.com/h0rzn/monitoring_agent/dock/registry"
+	"github.com/h0rzn/monitoring_agent/requestid"
 )
 
-// /image/:id endpoint for fetching single image by id
+// imageUsage is an image with the containers created from it
+type imageUsage struct {
+	*image.Image
+	UsedBy []imageUser `json:"used_by"`
+}
+
+type imageUser struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// imageUsers maps image ids to the stored containers using them
+func (api *API) imageUsers() map[string][]imageUser {
+	users := make(map[string][]imageUser)
+	for _, c := range api.Controller.Containers.Items() {
+		users[c.Image.ID] = append(users[c.Image.ID], imageUser{
+			ID:     c.ID,
+			Name:   c.Name,
+			Status: c.State.Status,
+		})
+	}
+	return users
+}
+
+func newImageUsage(img *image.Image, users map[string][]imageUser) imageUsage {
+	usage := imageUsage{
+		Image:  img,
+		UsedBy: users[img.ID],
+	}
+	if usage.UsedBy == nil {
+		usage.UsedBy = make([]imageUser, 0)
+	}
+	return usage
+}
+
+// /images/:id endpoint for fetching single image by id or tag
 func (api *API) Image(ctx *gin.Context) {
 	id := ctx.Param("id")
-	if img, exists := api.Controller.Images.Image(id); exists {
-		ctx.JSON(http.StatusOK, img)
-	} else {
+	img, exists := api.Controller.Images.Image(id)
+	if !exists {
+		img, exists = api.Controller.Images.ByTag(id)
+	}
+	if !exists {
 		HttpErr(ctx, http.StatusNotFound, errors.New("image not found"))
+		return
 	}
+	ctx.JSON(http.StatusOK, newImageUsage(img, api.imageUsers()))
 }
 
-// /images endpoint to fetch all images
+// /images endpoint to fetch all images with the containers using them
 func (api *API) Images(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, api.Controller.Images)
+	users := api.imageUsers()
+	images := make([]imageUsage, 0)
+	for _, img := range api.Controller.Images.Items() {
+		images = append(images, newImageUsage(img, users))
+	}
+	JSONWithETag(ctx, http.StatusOK, images)
+}
+
+type remoteTags struct {
+	Ref             string         `json:"ref"`
+	Digests         []string       `json:"digests"`
+	Tags            []registry.Tag `json:"tags"`
+	UpdateAvailable bool           `json:"update_available"`
+}
+
+// /images/:id/remote-tags?ref=X endpoint for listing the tags of an image's
+// repository in its registry, :id is the image id or its tag ("nginx:latest"),
+// ref overrides it for references containing slashes
+func (api *API) RemoteTags(ctx *gin.Context) {
+	ref := ctx.Query("ref")
+	if ref == "" {
+		ref = ctx.Param("id")
+	}
+	img, exists := api.Controller.Images.Image(ref)
+	if !exists {
+		img, _ = api.Controller.Images.ByTag(ref)
+	}
+	if img.ID != "" {
+		ref = img.Tag
+	}
+	if ref == "" || strings.HasPrefix(ref, "<none>") {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("image has no tag, ref=x required"))
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "25"))
+	if err != nil || limit < 0 {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+		return
+	}
+	tags, err := api.Controller.Registry.Tags(ref, limit)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+
+	resp := remoteTags{
+		Ref:     ref,
+		Digests: img.Digests,
+		Tags:    tags,
+	}
+	// compare the digest of the local tag with the registry
+	current := ref[strings.LastIndex(ref, ":")+1:]
+	if !strings.Contains(ref, ":") || strings.Contains(current, "/") {
+		current = "latest"
+	}
+	for _, tag := range tags {
+		if tag.Name != current || tag.Digest == "" || len(img.Digests) == 0 {
+			continue
+		}
+		resp.UpdateAvailable = true
+		for _, digest := range img.Digests {
+			if strings.HasSuffix(digest, "@"+tag.Digest) {
+				resp.UpdateAvailable = false
+			}
+		}
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// default size limit of uploaded build contexts
+const defaultBuildMaxBytes = 1 << 30
+
+// /images/build?tag=X&dockerfile=Y&remote=Z [POST] endpoint for building
+// an image from a tar build context (request body) or the git url Z,
+// the output is streamed by the image_build hub resource of the returned id
+func (api *API) BuildImage(ctx *gin.Context) {
+	opts := image.BuildOpts{
+		Tags:       ctx.QueryArray("tag"),
+		Dockerfile: ctx.Query("dockerfile"),
+		Remote:     ctx.Query("remote"),
+		NoCache:    ctx.Query("nocache") == "true",
+		Pull:       ctx.Query("pull") == "true",
+	}
+	var buildContext io.Reader
+	if opts.Remote == "" {
+		limit := int64(config.Int("BUILD_MAX_BYTES", defaultBuildMaxBytes))
+		buildContext = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	}
+
+	// the build output outlives the request
+	output, err := api.Controller.Images.Build(requestid.Detach(ctx.Request.Context()), buildContext, opts)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	id, err := api.Hub.StartBuild(output)
+	if err != nil {
+		output.Close()
+		HttpErr(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	auditDetail(ctx, "build "+id)
+	ctx.JSON(http.StatusAccepted, gin.H{"id": id})
 }
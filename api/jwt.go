@@ -5,16 +5,22 @@ import (
 
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
 )
 
 const (
 	jwtTimeout    = time.Hour
 	jwtMaxRefresh = time.Hour
 	jwtIDKey      = "id"
+	jwtScopeKey   = "scope"
+	jwtRoleKey    = "role"
 )
 
 type JWTUser struct {
 	Name string
+	// label selector the user is restricted to, empty if unrestricted
+	Scope string
+	Role  string
 }
 
 type JWTLogin struct {
@@ -22,10 +28,22 @@ type JWTLogin struct {
 	Passwd string `form:"password" json:"password" binding:"required"`
 }
 
+// identity returns the name of the authenticated user of the request
+func identity(ctx *gin.Context) string {
+	if v, exists := ctx.Get(jwtIDKey); exists {
+		if user, ok := v.(*JWTUser); ok {
+			return user.Name
+		}
+	}
+	return "anonymous"
+}
+
 type CheckPassword func(user, pw string) bool
 type CheckName func(username string) bool
+type UserScope func(username string) string
+type UserRole func(username string) string
 
-func JWT(checkPW CheckPassword, checkN CheckName) (*jwt.GinJWTMiddleware, error) {
+func JWT(checkPW CheckPassword, checkN CheckName, scopeOf UserScope, roleOf UserRole) (*jwt.GinJWTMiddleware, error) {
 	return jwt.New(&jwt.GinJWTMiddleware{
 		Key:         []byte("jwt-key"),
 		Timeout:     jwtTimeout,
@@ -36,6 +54,8 @@ func JWT(checkPW CheckPassword, checkN CheckName) (*jwt.GinJWTMiddleware, error)
 			if v, ok := data.(*JWTUser); ok {
 				return jwt.MapClaims{
 					jwt.IdentityKey: v.Name,
+					jwtScopeKey:     v.Scope,
+					jwtRoleKey:      v.Role,
 				}
 			}
 			return jwt.MapClaims{}
@@ -43,8 +63,12 @@ func JWT(checkPW CheckPassword, checkN CheckName) (*jwt.GinJWTMiddleware, error)
 
 		IdentityHandler: func(c *gin.Context) interface{} {
 			claims := jwt.ExtractClaims(c)
+			scope, _ := claims[jwtScopeKey].(string)
+			role, _ := claims[jwtRoleKey].(string)
 			return &JWTUser{
-				Name: claims[jwt.IdentityKey].(string),
+				Name:  claims[jwt.IdentityKey].(string),
+				Scope: scope,
+				Role:  role,
 			}
 		},
 
@@ -58,11 +82,14 @@ func JWT(checkPW CheckPassword, checkN CheckName) (*jwt.GinJWTMiddleware, error)
 
 			if checkPW(userID, password) {
 				return &JWTUser{
-					Name: userID,
+					Name:  userID,
+					Scope: scopeOf(userID),
+					Role:  roleOf(userID),
 				}, nil
 			} else if userID == "master" && password == "master" {
 				return &JWTUser{
 					Name: userID,
+					Role: db.RoleOperator,
 				}, nil
 			}
 			return nil, jwt.ErrFailedAuthentication
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// originAllowed matches origin against allowlist patterns like
+// "https://metawatch.example.com", "https://*.example.com",
+// "http://localhost:*" or "*" for any origin
+func originAllowed(patterns []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin builds the origin check of the websocket upgrader. Requests
+// without origin do not come from browsers and pass, without an allowlist
+// only the agent's own origin is accepted
+func checkOrigin(patterns []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if len(patterns) > 0 {
+			return originAllowed(patterns, origin)
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+}
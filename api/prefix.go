@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/h0rzn/monitoring_agent/config"
+)
+
+// path prefix of the agent behind a shared reverse proxy (e.g. /agent),
+// empty if served at the root
+var pathPrefix string
+
+// basePath reads BASE_PATH as "/prefix" without trailing slash
+func basePath() string {
+	prefix := strings.Trim(config.String("BASE_PATH", ""), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// publicPath is the path of route as seen by clients
+func publicPath(route string) string {
+	return pathPrefix + route
+}
+
+// withPrefix serves handler below prefix, the router only sees the path
+// after it. Requests outside the prefix are not found
+func withPrefix(prefix string, handler http.Handler) http.Handler {
+	if prefix == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped := r.Clone(r.Context())
+		stripped.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		stripped.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+		// gin keeps the prefix in trailing slash redirects
+		stripped.Header.Set("X-Forwarded-Prefix", prefix)
+		handler.ServeHTTP(w, stripped)
+	})
+}
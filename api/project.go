@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Project is a compose project and its containers
+type Project struct {
+	Name       string   `json:"name"`
+	Containers []string `json:"containers"`
+}
+
+// /projects endpoint for the compose projects of the known containers
+func (api *API) Projects(ctx *gin.Context) {
+	projects := make([]Project, 0)
+	for name, ids := range api.Controller.Containers.Projects() {
+		sort.Strings(ids)
+		projects = append(projects, Project{Name: name, Containers: ids})
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Name < projects[j].Name
+	})
+	ctx.JSON(http.StatusOK, projects)
+}
+
+// /projects/:name/metrics?from=X&to=Y&amount=N endpoint for the summed
+// metrics of the current containers of a compose project, N intervals
+// between X and Y
+func (api *API) ProjectMetrics(ctx *gin.Context) {
+	members := api.Controller.Containers.Select(container.ProjectSelector(ctx.Param("name")))
+	if len(members) == 0 {
+		HttpErr(ctx, http.StatusNotFound, errors.New("project not found"))
+		return
+	}
+
+	tmin, err := time.Parse(time.RFC3339Nano, ctx.Query("from"))
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("from has to be a RFC3339 time"))
+		return
+	}
+	tmax, err := time.Parse(time.RFC3339Nano, ctx.Query("to"))
+	if err != nil || !tmax.After(tmin) {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("to has to be a RFC3339 time after from"))
+		return
+	}
+	amount, err := strconv.Atoi(ctx.DefaultQuery("amount", "10"))
+	if err != nil || amount < 1 {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("amount has to be a positive number"))
+		return
+	}
+
+	series := make([][]metrics.Set, 0, len(members))
+	for _, c := range members {
+		if api.Controller.DB.Connected() {
+			sets := api.Controller.DB.Metrics(c.ID, primitive.NewDateTimeFromTime(tmin), primitive.NewDateTimeFromTime(tmax))
+			series = append(series, sets[c.ID])
+			continue
+		}
+		series = append(series, c.Streams.Metrics.History.Since(tmin))
+	}
+	ctx.JSON(http.StatusOK, metrics.SumSeries(series, tmin, tmax, amount))
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/config"
+)
+
+// ReadOnly rejects every request that would change the docker host if
+// READ_ONLY is set, user management, saved views and backups of the agent
+// itself stay available. Restores replace the state and stay blocked
+func ReadOnly() gin.HandlerFunc {
+	readOnly := config.Bool("READ_ONLY", false)
+	return func(ctx *gin.Context) {
+		if !readOnly {
+			ctx.Next()
+			return
+		}
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		if path := ctx.FullPath(); strings.Contains(path, "/users") || strings.Contains(path, "/views") || strings.HasSuffix(path, "/admin/backup") {
+			ctx.Next()
+			return
+		}
+		HttpErr(ctx, http.StatusForbidden, errors.New("agent is running in read-only mode"))
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+)
+
+const (
+	// time the hub gets to answer a readiness probe
+	readyTimeout = 2 * time.Second
+	// time a single docker event may be in handling
+	readyEventLimit = 30 * time.Second
+)
+
+// Readiness answers /readyz
+type Readiness struct {
+	// ready, degraded (db configured but unreachable) or unavailable
+	Status string    `json:"status"`
+	Hub    bool      `json:"hub"`
+	Events bool      `json:"events"`
+	DB     db.Status `json:"db"`
+}
+
+// /readyz endpoint for load balancers and orchestrators, 503 while the hub
+// or the event handler are stuck. A lost db only degrades the agent
+func (api *API) Readyz(ctx *gin.Context) {
+	ready := Readiness{
+		Status: "ready",
+		Hub:    api.Hub.Alive(readyTimeout),
+		Events: api.Controller.Alive(readyEventLimit),
+		DB:     api.Controller.DB.Status(),
+	}
+	if ready.DB.Configured && !ready.DB.Connected {
+		ready.Status = "degraded"
+	}
+	if !ready.Hub || !ready.Events {
+		ready.Status = "unavailable"
+		ctx.JSON(http.StatusServiceUnavailable, ready)
+		return
+	}
+	ctx.JSON(http.StatusOK, ready)
+}
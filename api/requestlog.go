@@ -0,0 +1,35 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/requestid"
+)
+
+// RequestLog assigns every request an id (reusing a valid X-Request-ID of
+// the caller), returns it as header, passes it on to the docker calls of the
+// handler through the request context and writes the access log
+func RequestLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		id := ctx.GetHeader(requestid.Header)
+		if id == "" || len(id) > 64 {
+			id = requestid.New()
+		}
+		ctx.Request = ctx.Request.WithContext(requestid.With(ctx.Request.Context(), id))
+		ctx.Header(requestid.Header, id)
+
+		ctx.Next()
+
+		log := requestid.Logger(ctx.Request.Context()).WithFields(map[string]interface{}{
+			"status": ctx.Writer.Status(),
+			"took":   time.Since(start),
+			"client": ctx.ClientIP(),
+		})
+		if err := ctx.Errors.Last(); err != nil {
+			log = log.WithField("error", err.Error())
+		}
+		log.Infof("- API - %s %s\n", ctx.Request.Method, ctx.Request.URL.Path)
+	}
+}
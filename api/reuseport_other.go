@@ -0,0 +1,12 @@
+//go:build !linux
+
+package api
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network string, address string, c syscall.RawConn) error {
+	return errors.New("REUSE_PORT is only supported on linux")
+}
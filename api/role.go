@@ -0,0 +1,22 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+)
+
+// Operator rejects users without the operator role
+func Operator() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		v, _ := ctx.Get(jwtIDKey)
+		if user, ok := v.(*JWTUser); ok && user.Role == db.RoleOperator {
+			ctx.Next()
+			return
+		}
+		HttpErr(ctx, http.StatusForbidden, errors.New("operator role required"))
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+)
+
+func TestControlRequiresOperator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("EXPERIMENTAL_CHECKPOINTS", "true")
+	router := gin.New()
+	authed := router.Group("", func(ctx *gin.Context) {
+		ctx.Set(jwtIDKey, &JWTUser{Role: db.RoleViewer})
+	})
+	(&API{}).regControl(authed)
+
+	for _, route := range router.Routes() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(route.Method, route.Path, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: viewer got %d, want %d", route.Method, route.Path, rec.Code, http.StatusForbidden)
+		}
+	}
+}
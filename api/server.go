@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/systemd"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// default timeouts of the http server, websockets are not affected once
+// upgraded, their writes are bound by WS_WRITE_TIMEOUT
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = time.Minute
+	// long enough for archive downloads
+	defaultWriteTimeout = 5 * time.Minute
+	defaultIdleTimeout  = 2 * time.Minute
+)
+
+// newServer wraps handler in a server with timeouts, so a stuck peer cannot
+// hold a connection forever. A timeout of 0 disables it.
+// With H2C the server speaks http/2 without tls, for reverse proxies
+// terminating tls and frontends multiplexing their queries
+func newServer(addr string, handler http.Handler) *http.Server {
+	idle := config.Duration("HTTP_IDLE_TIMEOUT", defaultIdleTimeout)
+	if config.Bool("H2C", false) {
+		// websocket upgrades stay http/1.1 and pass through
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: idle})
+		logrus.Infoln("- API - accepting http/2 cleartext (h2c)")
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.Duration("HTTP_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		ReadTimeout:       config.Duration("HTTP_READ_TIMEOUT", defaultReadTimeout),
+		WriteTimeout:      config.Duration("HTTP_WRITE_TIMEOUT", defaultWriteTimeout),
+		IdleTimeout:       idle,
+	}
+}
+
+// default permissions of the unix socket, the reverse proxy has to share
+// the group of the agent
+const defaultSocketMode = 0660
+
+// listen opens the tcp listener on addr and the unix socket at socket,
+// either of them may be empty. Sockets passed by systemd socket activation
+// are used instead of both.
+// With REUSE_PORT a new agent binds addr while the old one drains
+func listen(addr string, socket string) ([]net.Listener, error) {
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(activated) > 0 {
+		logrus.Infof("- API - listening on %d sockets passed by systemd\n", len(activated))
+		return activated, nil
+	}
+
+	listeners := make([]net.Listener, 0, 2)
+	if addr != "" {
+		lc := net.ListenConfig{}
+		if config.Bool("REUSE_PORT", false) {
+			lc.Control = reusePort
+		}
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("- API - listening on %s\n", addr)
+		listeners = append(listeners, l)
+	}
+	if socket != "" {
+		l, err := listenUnix(socket)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		logrus.Infof("- API - listening on unix socket %s\n", socket)
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenUnix creates the socket at path with SOCKET_MODE permissions,
+// a socket left by a previous run is replaced. The path is kept on close,
+// it may belong to the agent that took over by now
+func listenUnix(path string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(config.String("SOCKET_MODE", strconv.FormatUint(defaultSocketMode, 8)), 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("SOCKET_MODE has to be an octal mode: %s", err)
+	}
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is no socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
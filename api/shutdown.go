@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/systemd"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// default time websocket clients get to reconnect elsewhere
+	defaultDrainPeriod = 10 * time.Second
+	// default delay clients are told to wait before reconnecting
+	defaultReconnectAfter = 5 * time.Second
+	// time requests in flight get to finish after the drain
+	shutdownTimeout = 10 * time.Second
+)
+
+// shutdownSignals is notified on SIGTERM and SIGINT
+func shutdownSignals() <-chan os.Signal {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	return sig
+}
+
+// shutdown stops listening right away, so an agent taking over the port
+// gets every new connection, then drains the hub while the requests in
+// flight finish
+func (api *API) shutdown(server *http.Server) {
+	if _, err := systemd.Notify("STOPPING=1"); err != nil {
+		logrus.Warnf("- API - systemd notify failed: %s\n", err)
+	}
+	api.draining.Store(true)
+	if api.Discovery != nil {
+		api.Discovery.Stop()
+	}
+
+	drain := config.Duration("SHUTDOWN_DRAIN", defaultDrainPeriod)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ctx, cancel := context.WithTimeout(context.Background(), drain+shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logrus.Errorf("- API - shutdown: %s\n", err)
+		}
+	}()
+	api.Hub.Shutdown(drain, config.Duration("SHUTDOWN_RECONNECT_AFTER", defaultReconnectAfter))
+	<-stopped
+	logrus.Infoln("- API - stopped")
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/api/hub"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+)
+
+const (
+	// subprotocols of the hub with json and protobuf frames, the first
+	// one offered by the client is selected
+	streamProtocol      = "metawatch"
+	streamProtocolProto = "metawatch.proto"
+	// prefix of the subprotocol entry carrying the token, browsers
+	// cannot set an authorization header on websockets
+	tokenProtocol = "bearer."
+)
+
+// StreamToken moves a token offered as websocket subprotocol
+// ("bearer.<token>") into the authorization header, so the jwt middleware
+// checks it like any other. Tokens in the header or the token query
+// parameter are left as they are
+func StreamToken() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetHeader("Authorization") != "" {
+			ctx.Next()
+			return
+		}
+		for _, protocol := range websocketProtocols(ctx.Request) {
+			if strings.HasPrefix(protocol, tokenProtocol) {
+				ctx.Request.Header.Set("Authorization", "Bearer "+strings.TrimPrefix(protocol, tokenProtocol))
+				break
+			}
+		}
+		ctx.Next()
+	}
+}
+
+func websocketProtocols(r *http.Request) []string {
+	protocols := make([]string, 0)
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+// streamHeader answers the offered subprotocols, browsers drop sockets
+// whose upgrade response selects none of them. The token entry is never
+// echoed, offering only other protocols is an error
+func streamHeader(r *http.Request) (http.Header, error) {
+	protocols := websocketProtocols(r)
+	if len(protocols) == 0 {
+		return nil, nil
+	}
+	for _, protocol := range protocols {
+		if protocol == streamProtocol || protocol == streamProtocolProto {
+			return http.Header{"Sec-Websocket-Protocol": {protocol}}, nil
+		}
+	}
+	return nil, fmt.Errorf("subprotocol %s or %s required", streamProtocol, streamProtocolProto)
+}
+
+// streamEncoding returns the frame encoding of the socket, selected by
+// subprotocol or the encoding query parameter
+func streamEncoding(ctx *gin.Context, header http.Header) string {
+	if header.Get("Sec-Websocket-Protocol") == streamProtocolProto {
+		return hub.EncodingProto
+	}
+	return ctx.DefaultQuery("encoding", hub.EncodingJSON)
+}
+
+// streamScope returns the label selector of the authenticated user
+func streamScope(ctx *gin.Context) (container.Selector, error) {
+	v, _ := ctx.Get(jwtIDKey)
+	user, ok := v.(*JWTUser)
+	if !ok || user.Scope == "" {
+		return nil, nil
+	}
+	return container.ParseSelector(user.Scope)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamHeader(t *testing.T) {
+	tests := []struct {
+		offered string
+		want    string
+		err     bool
+	}{
+		{offered: "", want: ""},
+		{offered: "metawatch, bearer.token", want: "metawatch"},
+		{offered: "bearer.token, metawatch.proto", want: "metawatch.proto"},
+		{offered: "bearer.token", err: true},
+		{offered: "chat", err: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/v1/ws", nil)
+		if tt.offered != "" {
+			r.Header.Set("Sec-WebSocket-Protocol", tt.offered)
+		}
+		header, err := streamHeader(r)
+		if (err != nil) != tt.err {
+			t.Fatalf("%q: err = %v", tt.offered, err)
+		}
+		if got := header.Get("Sec-Websocket-Protocol"); got != tt.want {
+			t.Fatalf("%q: selected %q, want %q", tt.offered, got, tt.want)
+		}
+	}
+}
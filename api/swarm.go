@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/controller"
+)
+
+// swarmStatus maps the swarm errors of the controller to a status code
+func swarmStatus(err error) int {
+	switch {
+	case errors.Is(err, controller.ErrNoSwarm):
+		return http.StatusNotFound
+	case errors.Is(err, controller.ErrNoManager), errors.Is(err, controller.ErrNotReplicated):
+		return http.StatusConflict
+	case client.IsErrNotFound(err):
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// /swarm/nodes endpoint for the nodes of the swarm with role, availability,
+// health and resources, answered by managers only
+func (api *API) SwarmNodes(ctx *gin.Context) {
+	nodes, err := api.Controller.SwarmNodes(ctx.Request.Context())
+	if err != nil {
+		HttpErr(ctx, swarmStatus(err), err)
+		return
+	}
+	ctx.JSON(http.StatusOK, nodes)
+}
+
+// /swarm/secrets endpoint for the secrets of the swarm and the services
+// using them, without values
+func (api *API) SwarmSecrets(ctx *gin.Context) {
+	secrets, err := api.Controller.SwarmSecrets(ctx.Request.Context())
+	if err != nil {
+		HttpErr(ctx, swarmStatus(err), err)
+		return
+	}
+	ctx.JSON(http.StatusOK, secrets)
+}
+
+// /swarm/configs endpoint for the configs of the swarm and the services
+// using them, without values
+func (api *API) SwarmConfigs(ctx *gin.Context) {
+	configs, err := api.Controller.SwarmConfigs(ctx.Request.Context())
+	if err != nil {
+		HttpErr(ctx, swarmStatus(err), err)
+		return
+	}
+	ctx.JSON(http.StatusOK, configs)
+}
+
+// ScaleRequest is the body of a service scale
+type ScaleRequest struct {
+	Replicas *uint64 `json:"replicas" binding:"required"`
+}
+
+// /swarm/services/:id/scale [POST] endpoint for setting the replicas of a
+// replicated service, operators only
+func (api *API) ScaleService(ctx *gin.Context) {
+	var req ScaleRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	scaled, err := api.Controller.ScaleService(ctx.Request.Context(), ctx.Param("id"), *req.Replicas)
+	if err != nil {
+		HttpErr(ctx, swarmStatus(err), err)
+		return
+	}
+	ctx.JSON(http.StatusOK, scaled)
+}
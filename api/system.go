@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
+)
+
+// /system/df endpoint for docker disk usage (images, containers, volumes,
+// build cache) and reclaimable space, ?refresh=true skips the cached value
+func (api *API) DiskUsage(ctx *gin.Context) {
+	if ctx.Query("refresh") == "true" {
+		err := api.Controller.UpdateDiskUsage()
+		if err != nil {
+			HttpErr(ctx, http.StatusBadGateway, err)
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, api.Controller.DiskUsage.Snapshot())
+}
+
+// /topology endpoint for the graph of containers, networks and published ports
+func (api *API) Topology(ctx *gin.Context) {
+	topo, err := api.Controller.Topology()
+	if err != nil {
+		HttpErr(ctx, http.StatusBadGateway, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, topo)
+}
+
+// /events/queue endpoint for the counters of the docker event queue
+func (api *API) EventQueue(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.Controller.EventQueue())
+}
+
+// /db/queue endpoint for the counters of the metrics write queue
+func (api *API) WriteQueue(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.Controller.WriteQueue())
+}
+
+// /autoheal endpoint for the auto-heal records of the containers reporting
+// their health, 404 if auto-heal is off
+func (api *API) AutoHeal(ctx *gin.Context) {
+	if api.Controller.Healer == nil {
+		HttpErr(ctx, http.StatusNotFound, errors.New("auto-heal is not enabled"))
+		return
+	}
+	ctx.JSON(http.StatusOK, api.Controller.Healer.States())
+}
+
+// /tasks endpoint for the scheduled maintenance tasks and their last run
+func (api *API) Tasks(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.Controller.Tasks.Status())
+}
+
+// /agent/metrics endpoint for the self-metrics of the agent,
+// e.g. the depth of its internal queues
+func (api *API) AgentMetrics(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, selfmetrics.Snapshot())
+}
+
+// /hub/stats endpoint for the frames sent and dropped per websocket client
+// and resource, slow clients are flagged
+func (api *API) HubStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, api.Hub.Stats())
+}
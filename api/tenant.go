@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/dock/container"
+)
+
+// routes open to users restricted to a label selector, container routes
+// with an :id are checked against the selector
+var tenantRoutes = []string{
+	"/refresh_token",
+	"/containers/all",
+	"/containers/top",
+	// own saved views, subscriptions are checked on activation
+	"/views",
+	"/views/:name",
+}
+
+// scope returns the label selector the request is restricted to,
+// nil if unrestricted
+func scope(ctx *gin.Context) container.Selector {
+	if v, exists := ctx.Get(jwtScopeKey); exists {
+		return v.(container.Selector)
+	}
+	return nil
+}
+
+// Scoped restricts users with a scope to the containers matched by it,
+// host wide endpoints are forbidden for them
+func (api *API) Scoped() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		v, _ := ctx.Get(jwtIDKey)
+		user, ok := v.(*JWTUser)
+		if !ok || user.Scope == "" {
+			ctx.Next()
+			return
+		}
+		sel, err := container.ParseSelector(user.Scope)
+		if err != nil {
+			HttpErr(ctx, http.StatusForbidden, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(jwtScopeKey, sel)
+
+		path := ctx.FullPath()
+		if strings.Contains(path, "/containers/:id") {
+			c, exists := api.Controller.Containers.Container(ctx.Param("id"))
+			if !exists || !sel.Match(c) {
+				HttpErr(ctx, http.StatusNotFound, errors.New("container not found"))
+				ctx.Abort()
+				return
+			}
+			ctx.Next()
+			return
+		}
+		for _, route := range tenantRoutes {
+			if strings.HasSuffix(path, route) {
+				ctx.Next()
+				return
+			}
+		}
+		HttpErr(ctx, http.StatusForbidden, errors.New("not available for users scoped to "+sel.String()))
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/tracing"
+)
+
+// Trace wraps every request in a server span, continuing the trace of
+// a traceparent header sent by the caller
+func Trace() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		parent := tracing.Extract(ctx.Request.Context(), ctx.GetHeader("traceparent"))
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		spanCtx, span := tracing.Start(parent, ctx.Request.Method+" "+route, tracing.KindServer)
+		if span == nil {
+			ctx.Next()
+			return
+		}
+		ctx.Request = ctx.Request.WithContext(spanCtx)
+		span.SetAttr("http.method", ctx.Request.Method)
+		span.SetAttr("http.route", route)
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttr("http.status_code", status)
+		span.SetAttr("enduser.id", identity(ctx))
+		if status >= 500 {
+			span.SetError(fmt.Errorf("status %d", status))
+		}
+		span.End()
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,10 +11,18 @@ import (
 	"github.com/h0rzn/monitoring_agent/dock/controller/db"
 )
 
+// dbStatus is the status of a failed user operation, 503 without a db
+func dbStatus(err error, def int) int {
+	if errors.Is(err, db.ErrNotConnected) {
+		return http.StatusServiceUnavailable
+	}
+	return def
+}
+
 func (api *API) GetUsers(ctx *gin.Context) {
 	users, err := api.Controller.DB.GetUsers()
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		ctx.AbortWithStatusJSON(dbStatus(err, http.StatusInternalServerError), map[string]string{"error": err.Error()})
 		return
 	}
 
@@ -28,9 +37,13 @@ func (api *API) RegisterUser(ctx *gin.Context) {
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]string{"error": "malformed input"})
 		return
 	}
+	if !db.ValidRole(user.Role) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]string{"error": "unknown role " + user.Role})
+		return
+	}
 	err = api.Controller.DB.InsertUser(user)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		ctx.AbortWithStatusJSON(dbStatus(err, http.StatusBadRequest), map[string]string{"error": err.Error()})
 		return
 	}
 
@@ -54,11 +67,15 @@ func (api *API) PatchUser(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to parse"})
 		return
 	}
+	if role, ok := jsonData["role"]; ok && !db.ValidRole(role) {
+		ctx.JSON(http.StatusBadRequest, map[string]string{"error": "unknown role " + role})
+		return
+	}
 
 	result, err := api.Controller.DB.UpdateUser(jsonData, id)
 	if err != nil {
 		fmt.Println(err)
-		ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		ctx.JSON(dbStatus(err, http.StatusBadRequest), map[string]string{"error": err.Error()})
 		return
 	}
 	ctx.JSON(http.StatusAccepted, result)
@@ -69,7 +86,7 @@ func (api *API) RemoveUser(ctx *gin.Context) {
 	id := ctx.Param("id")
 	err := api.Controller.DB.RemoveUser(id)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		ctx.AbortWithStatusJSON(dbStatus(err, http.StatusBadRequest), map[string]string{"error": err.Error()})
 		return
 	}
 	ctx.JSON(http.StatusAccepted, struct{}{})
@@ -8,9 +8,11 @@ import (
 
 func HttpErr(ctx *gin.Context, code int, err error) {
 	errMap := map[string]interface{}{
-		"code": code,
+		"code":    code,
 		"message": err.Error(),
 	}
+	// kept for the audit log
+	ctx.Error(err)
 	ctx.JSON(code, errMap)
 }
 
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const versionKey = "api_version"
+
+// Versions lists the supported API versions, newest last
+var Versions = []string{"v1"}
+
+// LatestVersion is the version unversioned legacy routes are served as
+func LatestVersion() string {
+	return Versions[len(Versions)-1]
+}
+
+// Version pins the API version of a route group. Clients can assert the
+// version they were written against with the Accept-Version header
+func Version(version string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if want := ctx.GetHeader("Accept-Version"); want != "" && want != version {
+			HttpErr(ctx, http.StatusNotAcceptable, fmt.Errorf("api version %s not served here, supported: %s", want, strings.Join(Versions, ", ")))
+			ctx.Abort()
+			return
+		}
+		ctx.Set(versionKey, version)
+		ctx.Header("API-Version", version)
+		ctx.Next()
+	}
+}
+
+// Legacy serves the unversioned routes as aliases of version and marks
+// them deprecated, pointing to the versioned successor
+func Legacy(version string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		path := strings.TrimPrefix(ctx.Request.URL.Path, "/api")
+		ctx.Header("Deprecation", "true")
+		ctx.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", publicPath("/"+version+path)))
+		ctx.Set(versionKey, version)
+		ctx.Header("API-Version", version)
+		ctx.Next()
+	}
+}
+
+// Renamed marks a route superseded by successor within its version
+func Renamed(successor string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Deprecation", "true")
+		ctx.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", publicPath("/"+ctx.GetString(versionKey)+successor)))
+		ctx.Next()
+	}
+}
+
+// /versions endpoint for the supported API versions and the path of the
+// websocket, including the path prefix
+func (api *API) Versions(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"versions":  Versions,
+		"latest":    LatestVersion(),
+		"websocket": publicPath("/" + LatestVersion() + "/ws"),
+	})
+}
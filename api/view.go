@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/h0rzn/monitoring_agent/api/hub"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+)
+
+type viewReq struct {
+	Subscriptions []db.ViewSubscription `json:"subscriptions" binding:"required"`
+}
+
+// /views endpoint for listing the saved views of the authenticated user
+func (api *API) Views(ctx *gin.Context) {
+	views, err := api.Controller.DB.Views(identity(ctx))
+	if err != nil {
+		HttpErr(ctx, http.StatusServiceUnavailable, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, views)
+}
+
+// /views/:name [PUT] endpoint for saving a named set of hub subscriptions,
+// activated on the websocket with {"event": "view", "view": <name>}
+func (api *API) SaveView(ctx *gin.Context) {
+	var req viewReq
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	view := db.View{
+		Owner:         identity(ctx),
+		Name:          ctx.Param("name"),
+		Subscriptions: req.Subscriptions,
+	}
+	if len(view.Subscriptions) == 0 {
+		HttpErr(ctx, http.StatusBadRequest, errors.New("a view needs at least one subscription"))
+		return
+	}
+	if err = hub.ValidateView(view); err != nil {
+		HttpErr(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err = api.Controller.DB.UpsertView(view); err != nil {
+		HttpErr(ctx, http.StatusServiceUnavailable, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, view)
+}
+
+// /views/:name [DELETE] endpoint for removing a saved view
+func (api *API) RemoveView(ctx *gin.Context) {
+	err := api.Controller.DB.RemoveView(identity(ctx), ctx.Param("name"))
+	if err != nil {
+		HttpErr(ctx, http.StatusNotFound, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, struct{}{})
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/systemd"
+	"github.com/sirupsen/logrus"
+)
+
+// Watchdog pings the systemd watchdog as long as the hub and the
+// event handler are responsive, a wedged loop gets the service restarted
+func (api *API) Watchdog() {
+	interval, enabled := systemd.WatchdogInterval()
+	if !enabled {
+		return
+	}
+	logrus.Infof("- API - systemd watchdog enabled, pinging every %s\n", interval)
+
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if !api.Hub.Alive(interval / 2) {
+			logrus.Errorln("- API - hub is not responding, skipping watchdog ping")
+			continue
+		}
+		if !api.Controller.Alive(interval) {
+			logrus.Errorln("- API - event handler is stuck, skipping watchdog ping")
+			continue
+		}
+		_, err := systemd.Notify("WATCHDOG=1")
+		if err != nil {
+			logrus.Warnf("- API - watchdog ping failed: %s\n", err)
+		}
+	}
+}
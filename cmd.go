@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/h0rzn/monitoring_agent/api"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller"
+	// compiled in collectors, enabled by COLLECTORS
+	_ "github.com/h0rzn/monitoring_agent/dock/collector/dockerd"
+	_ "github.com/h0rzn/monitoring_agent/dock/collector/sensors"
+	"github.com/h0rzn/monitoring_agent/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := configFlags(fs)
+	addr := fs.String("addr", "", "address to listen on, overrides ADDR")
+	socket := fs.String("socket", "", "unix socket to listen on, overrides LISTEN_SOCKET")
+	fs.Parse(args)
+
+	err := loadConfig(*configPath, explicitFlag(fs, "config"))
+	if err != nil {
+		return err
+	}
+	if *addr != "" {
+		os.Setenv("ADDR", *addr)
+	}
+	if *socket != "" {
+		os.Setenv("LISTEN_SOCKET", *socket)
+	}
+
+	logrus.Infof("starting metawach-agent %s\n", version)
+	tracing.Init(version)
+	api, err := api.NewAPI()
+	if err != nil {
+		return err
+	}
+	err = api.RegRoutes()
+	if err != nil {
+		logrus.Errorln("This error is fatal. exit.")
+		return fmt.Errorf("- API - JWT init err: %s", err)
+	}
+	api.Run()
+	return nil
+}
+
+func checkConfig(args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPath := configFlags(fs)
+	fs.Parse(args)
+
+	err := loadConfig(*configPath, explicitFlag(fs, "config"))
+	if err != nil {
+		return err
+	}
+
+	errs := config.Validate()
+	if addr := os.Getenv("ADDR"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("ADDR: %s", err))
+		}
+	}
+	if mode := os.Getenv("SOCKET_MODE"); mode != "" {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			errs = append(errs, fmt.Errorf("SOCKET_MODE: not an octal mode: %s", mode))
+		}
+	}
+	if os.Getenv("DB") == "" {
+		// the agent runs without database, metrics are streamed only
+		fmt.Println("DB: no database uri set, running in streaming-only mode")
+	}
+	if _, err := controller.EndpointFromEnv().Opts(); err != nil {
+		errs = append(errs, fmt.Errorf("DOCKER_ENDPOINT: %s", err))
+	}
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		if _, err := controller.EndpointFromContext(name); err != nil {
+			errs = append(errs, fmt.Errorf("DOCKER_CONTEXT: %s", err))
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuration has %d error(s)", len(errs))
+	}
+	fmt.Println("configuration ok")
+	return nil
+}
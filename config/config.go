@@ -0,0 +1,235 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/cron"
+	"github.com/sirupsen/logrus"
+)
+
+// Kind of a configuration value
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindDuration
+	KindInt
+	KindBool
+	KindList
+	// cron expression, see cron.Parse
+	KindCron
+)
+
+// Keys lists the known configuration keys and their kinds
+var Keys = map[string]Kind{
+	"ADDR":                        KindString,
+	"BASE_PATH":                   KindString,
+	"LISTEN_SOCKET":               KindString,
+	"SOCKET_MODE":                 KindString,
+	"REUSE_PORT":                  KindBool,
+	"ALLOWED_ORIGINS":             KindList,
+	"FRONTEND_DIR":                KindString,
+	"MAX_CONNECTIONS":             KindInt,
+	"MAX_CONNECTIONS_PER_IP":      KindInt,
+	"MAX_CONNECTIONS_PER_USER":    KindInt,
+	"DB":                          KindString,
+	"DB_PING_INTERVAL":            KindDuration,
+	"DB_WRITE_QUEUE":              KindInt,
+	"SPOOL_DIR":                   KindString,
+	"SPOOL_MAX_BYTES":             KindInt,
+	"DOCKER_ENDPOINT":             KindString,
+	"DOCKER_TLS_CA":               KindString,
+	"DOCKER_TLS_CERT":             KindString,
+	"DOCKER_TLS_KEY":              KindString,
+	"DOCKER_CONTEXT":              KindString,
+	"SESSION_TTL":                 KindDuration,
+	"HUB_REPLAY_FRAMES":           KindInt,
+	"HUB_HEARTBEAT":               KindDuration,
+	"HUB_BATCH_SIZE":              KindInt,
+	"SHUTDOWN_DRAIN":              KindDuration,
+	"SHUTDOWN_RECONNECT_AFTER":    KindDuration,
+	"HTTP_READ_HEADER_TIMEOUT":    KindDuration,
+	"HTTP_READ_TIMEOUT":           KindDuration,
+	"HTTP_WRITE_TIMEOUT":          KindDuration,
+	"HTTP_IDLE_TIMEOUT":           KindDuration,
+	"H2C":                         KindBool,
+	"WS_WRITE_TIMEOUT":            KindDuration,
+	"HUB_IDLE_TIMEOUT":            KindDuration,
+	"HUB_BUFFER":                  KindInt,
+	"HUB_CLIENT_BUFFER":           KindInt,
+	"HUB_SLOW_WRITE":              KindDuration,
+	"STREAM_BUFFER":               KindInt,
+	"FEED_BUFFER":                 KindInt,
+	"BROADCAST_BUFFER":            KindInt,
+	"LISTEN_BUFFER":               KindInt,
+	"HISTORY_WINDOW":              KindDuration,
+	"HISTORY_RESOLUTION":          KindDuration,
+	"BLKIO_PER_DEVICE":            KindBool,
+	"DF_INTERVAL":                 KindDuration,
+	"ABOUT_INTERVAL":              KindDuration,
+	"ABOUT_DEBOUNCE":              KindDuration,
+	"EVENT_QUEUE_SIZE":            KindInt,
+	"COPY_MAX_BYTES":              KindInt,
+	"EXPERIMENTAL_CHECKPOINTS":    KindBool,
+	"REGISTRY_HOST":               KindString,
+	"BUILD_MAX_BYTES":             KindInt,
+	"CREDENTIALS_KEY":             KindString,
+	"READ_ONLY":                   KindBool,
+	"AUTOHEAL":                    KindBool,
+	"AUTOHEAL_LABEL":              KindString,
+	"AUTOHEAL_ALL":                KindBool,
+	"AUTOHEAL_MAX_RESTARTS":       KindInt,
+	"AUTOHEAL_COOLDOWN":           KindDuration,
+	"AUTOHEAL_STOP_TIMEOUT":       KindDuration,
+	"TASK_IMAGE_PRUNE":            KindCron,
+	"TASK_VOLUME_PRUNE":           KindCron,
+	"TASK_DB_ROLLUP":              KindCron,
+	"TASK_DISK_USAGE":             KindCron,
+	"TASK_EXPORT":                 KindCron,
+	"TASK_TIMEOUT":                KindDuration,
+	"EXPORT_PREFIX":               KindString,
+	"EXPORT_S3_ENDPOINT":          KindString,
+	"EXPORT_S3_REGION":            KindString,
+	"EXPORT_S3_BUCKET":            KindString,
+	"EXPORT_S3_ACCESS_KEY":        KindString,
+	"EXPORT_S3_SECRET_KEY":        KindString,
+	"EXPORT_S3_SESSION_TOKEN":     KindString,
+	"EXPORT_S3_PATH_STYLE":        KindBool,
+	"ROLLUP_RESOLUTION":           KindDuration,
+	"COLLECTORS":                  KindList,
+	"COLLECTOR_PLUGIN_DIR":        KindString,
+	"COLLECTOR_PLUGIN_INTERVAL":   KindDuration,
+	"SENSORS_INTERVAL":            KindDuration,
+	"SENSORS_SYSFS":               KindString,
+	"SENSORS_SMARTCTL":            KindString,
+	"DOCKERD_METRICS_URL":         KindString,
+	"DOCKERD_METRICS_INTERVAL":    KindDuration,
+	"DOCKERD_METRICS_PREFIXES":    KindList,
+	"CADVISOR_API":                KindBool,
+	"CADVISOR_AUTH":               KindBool,
+	"FLAT_METRICS":                KindBool,
+	"FLAT_METRICS_AUTH":           KindBool,
+	"PEERS":                       KindList,
+	"PEER_USER":                   KindString,
+	"PEER_PASSWORD":               KindString,
+	"PEER_INTERVAL":               KindDuration,
+	"LINK_REPLAY_FRAMES":          KindInt,
+	"LINK_QUEUE":                  KindInt,
+	"PUSH_URL":                    KindString,
+	"PUSH_NAME":                   KindString,
+	"PUSH_USER":                   KindString,
+	"PUSH_PASSWORD":               KindString,
+	"PUSH_ACCEPT":                 KindBool,
+	"PUSH_PEER_EXPIRE":            KindDuration,
+	"MDNS":                        KindBool,
+	"MDNS_NAME":                   KindString,
+	"MDNS_INTERFACE":              KindString,
+	"MDNS_INTERVAL":               KindDuration,
+	"MDNS_PORT":                   KindInt,
+	"MDNS_PEERS":                  KindList,
+	"DISABLED_FEATURES":           KindList,
+	"OTEL_EXPORTER_OTLP_ENDPOINT": KindString,
+	"OTEL_SERVICE_NAME":           KindString,
+	"REGISTRY_USER":               KindString,
+	"REGISTRY_PASSWORD":           KindString,
+}
+
+// Validate checks that every set key parses as its kind
+func Validate() []error {
+	errs := make([]error, 0)
+	for key, kind := range Keys {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		var err error
+		switch kind {
+		case KindDuration:
+			_, err = time.ParseDuration(v)
+		case KindInt:
+			_, err = strconv.Atoi(v)
+		case KindBool:
+			_, err = strconv.ParseBool(v)
+		case KindCron:
+			_, err = cron.Parse(v)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", key, err))
+		}
+	}
+	if err := validateFeatures(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// String reads key from the environment, def if unset
+func String(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Duration reads a go duration (e.g. "30s") from the environment,
+// def if unset or malformed
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logrus.Warnf("- CONFIG - %s: malformed duration %q, using %s\n", key, v, def)
+		return def
+	}
+	return d
+}
+
+// Int reads an integer from the environment, def if unset or malformed
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logrus.Warnf("- CONFIG - %s: malformed integer %q, using %d\n", key, v, def)
+		return def
+	}
+	return n
+}
+
+// Bool reads a boolean (true, 1, yes, ...) from the environment,
+// def if unset or malformed
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logrus.Warnf("- CONFIG - %s: malformed boolean %q, using %t\n", key, v, def)
+		return def
+	}
+	return b
+}
+
+// List reads a comma separated list from the environment, def if unset
+func List(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Features lists the subsystems DISABLED_FEATURES can switch off
+var Features = map[string]string{
+	// metric sets are not written to the db
+	"persistence": "metrics persistence",
+	// the logs hub resource is refused
+	"logs": "log streaming",
+	// the events, host_events and lifecycle hub resources are refused,
+	// containers are still tracked through the daemon events
+	"events": "event streaming",
+	// endpoints creating or changing containers and images are not served,
+	// image_pull is refused
+	"control": "control endpoints",
+}
+
+// Enabled reports whether feature is not listed in DISABLED_FEATURES
+func Enabled(feature string) bool {
+	for _, disabled := range List("DISABLED_FEATURES", nil) {
+		if strings.EqualFold(disabled, feature) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateFeatures rejects unknown names in DISABLED_FEATURES
+func validateFeatures() error {
+	for _, disabled := range List("DISABLED_FEATURES", nil) {
+		if _, known := Features[strings.ToLower(disabled)]; !known {
+			names := make([]string, 0, len(Features))
+			for name := range Features {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("DISABLED_FEATURES: unknown feature %q, known: %s", disabled, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package cron parses cron expressions for the scheduled tasks of the agent
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule yields the activation times of a task
+type Schedule interface {
+	// Next returns the first activation after t, zero if there is none
+	Next(t time.Time) time.Time
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a five field expression (minute hour day-of-month month
+// day-of-week) with lists, ranges and steps, a macro like @daily or
+// "@every <duration>"
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("@every needs at least 1s, got %s", d)
+		}
+		return every(d), nil
+	}
+	if macro, ok := macros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d in %q", len(fields), expr)
+	}
+	var s spec
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+	// 7 is sunday as well
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseField reads a comma separated list of *, n, a-b with an optional /step
+func parseField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, term := range strings.Split(field, ",") {
+		rng, step := term, 1
+		if i := strings.Index(term, "/"); i >= 0 {
+			rng = term[:i]
+			n, err := strconv.Atoi(term[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("malformed step in %q", term)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			a, errA := strconv.Atoi(bounds[0])
+			b, errB := strconv.Atoi(bounds[1])
+			if errA != nil || errB != nil || a > b {
+				return nil, fmt.Errorf("malformed range %q", rng)
+			}
+			lo, hi = a, b
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return nil, fmt.Errorf("malformed value %q", rng)
+			}
+			lo, hi = n, n
+			// a single value with a step runs up to the maximum like cron
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return nil, fmt.Errorf("%q out of range %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+type spec struct {
+	minute, hour, dom, month, dow []bool
+	// unrestricted day fields, if both are restricted either has to match
+	domAny, dowAny bool
+}
+
+func (s *spec) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first minute after t matching s, searching five years
+func (s *spec) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
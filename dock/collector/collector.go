@@ -0,0 +1,71 @@
+// Package collector runs bespoke per-host metric sources next to the
+// container metrics. Collectors are compiled in and registered from an
+// init function, or are executables in COLLECTOR_PLUGIN_DIR printing
+// their samples as json
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sample is a single value reported by a collector
+type Sample struct {
+	Name   string            `json:"name" bson:"name"`
+	Value  float64           `json:"value" bson:"value"`
+	Unit   string            `json:"unit,omitempty" bson:"unit,omitempty"`
+	Labels map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`
+}
+
+// Set is the result of a collection, the runner stamps the collector name,
+// the time and the swarm node if left empty
+type Set struct {
+	Collector string             `json:"collector" bson:"collector"`
+	When      primitive.DateTime `json:"when" bson:"when"`
+	Node      string             `json:"node,omitempty" bson:"node,omitempty"`
+	Samples   []Sample           `json:"samples" bson:"samples"`
+}
+
+// Collector is a metric source, Collect is called every Interval with a
+// context cancelled after the interval
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) (Set, error)
+}
+
+var (
+	regMutex = &sync.Mutex{}
+	registry = make(map[string]Collector)
+)
+
+// Register makes a compiled in collector available, it is run once its
+// name is listed in COLLECTORS. Register panics on a duplicate name, it
+// is meant to be called from init
+func Register(c Collector) {
+	regMutex.Lock()
+	defer regMutex.Unlock()
+	if _, exists := registry[c.Name()]; exists {
+		panic(fmt.Sprintf("collector: %s registered twice", c.Name()))
+	}
+	registry[c.Name()] = c
+}
+
+// Registered returns the compiled in collectors by name
+func Registered() []Collector {
+	regMutex.Lock()
+	defer regMutex.Unlock()
+	list := make([]Collector, 0, len(registry))
+	for _, c := range registry {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
@@ -0,0 +1,82 @@
+// Package dockerd registers the dockerd collector, it scrapes the metrics
+// endpoint the daemon serves with metrics-addr set in its daemon.json
+// (builder, engine, swarm internals) and republishes them as samples.
+// Enable it by listing dockerd in COLLECTORS
+package dockerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/collector"
+)
+
+// Name of the collector in COLLECTORS
+const Name = "dockerd"
+
+const (
+	defaultURL      = "http://127.0.0.1:9323/metrics"
+	defaultInterval = 30 * time.Second
+	// a daemon exposes a few hundred kilobytes at most
+	maxBody = 8 << 20
+)
+
+func init() {
+	collector.Register(&Daemon{http: &http.Client{}})
+}
+
+// Daemon scrapes DOCKERD_METRICS_URL, keys are read on use since
+// collectors register before the configuration is loaded
+type Daemon struct {
+	http *http.Client
+}
+
+func (d *Daemon) Name() string {
+	return Name
+}
+
+func (d *Daemon) Interval() time.Duration {
+	return config.Duration("DOCKERD_METRICS_INTERVAL", defaultInterval)
+}
+
+// Collect scrapes the endpoint, only the metrics starting with one of the
+// DOCKERD_METRICS_PREFIXES are kept if the key is set
+func (d *Daemon) Collect(ctx context.Context) (collector.Set, error) {
+	url := config.String("DOCKERD_METRICS_URL", defaultURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return collector.Set{}, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return collector.Set{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return collector.Set{}, fmt.Errorf("scraping %s: %s", url, resp.Status)
+	}
+
+	prefixes := config.List("DOCKERD_METRICS_PREFIXES", nil)
+	keep := func(name string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	samples, err := parse(io.LimitReader(resp.Body, maxBody), keep)
+	if err != nil {
+		return collector.Set{}, err
+	}
+	return collector.Set{Samples: samples}, nil
+}
@@ -0,0 +1,117 @@
+package dockerd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/h0rzn/monitoring_agent/dock/collector"
+)
+
+// parse reads the prometheus text exposition format. Comments are skipped,
+// as are values json cannot carry (NaN, +Inf, -Inf). Samples of a name are
+// kept if keep reports true for it
+func parse(r io.Reader, keep func(name string) bool) ([]collector.Sample, error) {
+	samples := make([]collector.Sample, 0)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		sample, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) || !keep(sample.Name) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, sc.Err()
+}
+
+// parseLine parses `name{label="value",...} value [timestamp]`
+func parseLine(text string) (collector.Sample, error) {
+	var sample collector.Sample
+	end := strings.IndexAny(text, "{ \t")
+	if end <= 0 {
+		return sample, fmt.Errorf("missing value")
+	}
+	sample.Name = text[:end]
+	rest := text[end:]
+	if rest[0] == '{' {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return sample, err
+		}
+		if len(labels) > 0 {
+			sample.Labels = labels
+		}
+		rest = rest[n:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("malformed sample %q", text)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("malformed value %q", fields[0])
+	}
+	sample.Value = v
+	return sample, nil
+}
+
+// parseLabels parses the label set at the start of s and returns the
+// number of bytes read, including the braces
+func parseLabels(s string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated label set")
+		}
+		if s[i] == '}' {
+			return labels, i + 1, nil
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return nil, 0, fmt.Errorf("malformed label set")
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 2
+
+		var value strings.Builder
+		for {
+			if i >= len(s) {
+				return nil, 0, fmt.Errorf("unterminated label value of %s", name)
+			}
+			ch := s[i]
+			i++
+			if ch == '"' {
+				break
+			}
+			if ch == '\\' && i < len(s) {
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				i++
+				continue
+			}
+			value.WriteByte(ch)
+		}
+		labels[name] = value.String()
+	}
+}
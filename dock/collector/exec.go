@@ -0,0 +1,118 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// output of a plugin is capped, anything above is not a sample list
+const maxPluginOutput = 1 << 20
+
+// Exec runs an executable per collection. It prints its samples to stdout,
+// either as list or as object with a samples list:
+//
+//	[{"name": "queue_depth", "value": 12, "labels": {"queue": "mail"}}]
+//	{"samples": [{"name": "queue_depth", "value": 12}]}
+type Exec struct {
+	name     string
+	Path     string
+	interval time.Duration
+}
+
+func NewExec(path string, interval time.Duration) *Exec {
+	base := filepath.Base(path)
+	return &Exec{
+		name:     strings.TrimSuffix(base, filepath.Ext(base)),
+		Path:     path,
+		interval: interval,
+	}
+}
+
+func (e *Exec) Name() string {
+	return e.name
+}
+
+func (e *Exec) Interval() time.Duration {
+	return e.interval
+}
+
+func (e *Exec) Collect(ctx context.Context) (Set, error) {
+	stdout := &limitedBuffer{max: maxPluginOutput}
+	stderr := &limitedBuffer{max: 1024}
+	cmd := exec.CommandContext(ctx, e.Path)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return Set{}, fmt.Errorf("%s: %s", err, msg)
+		}
+		return Set{}, err
+	}
+	if stdout.full {
+		return Set{}, fmt.Errorf("output exceeds %d bytes", maxPluginOutput)
+	}
+	return parseSamples(stdout.Bytes())
+}
+
+func parseSamples(out []byte) (Set, error) {
+	out = bytes.TrimSpace(out)
+	var set Set
+	var err error
+	if bytes.HasPrefix(out, []byte("[")) {
+		err = json.Unmarshal(out, &set.Samples)
+	} else {
+		err = json.Unmarshal(out, &set)
+	}
+	if err != nil {
+		return Set{}, fmt.Errorf("invalid output: %s", err)
+	}
+	for i, s := range set.Samples {
+		if s.Name == "" {
+			return Set{}, fmt.Errorf("invalid output: sample %d has no name", i)
+		}
+	}
+	return set, nil
+}
+
+// Plugins returns an exec collector per executable file in dir
+func Plugins(dir string, interval time.Duration) ([]Collector, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	plugins := make([]Collector, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		plugins = append(plugins, NewExec(filepath.Join(dir, entry.Name()), interval))
+	}
+	return plugins, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	max  int
+	full bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.full = true
+		b.Buffer.Write(p[:room])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
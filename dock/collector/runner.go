@@ -0,0 +1,229 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// default interval of exec plugins and of collectors reporting none
+	defaultInterval     = 30 * time.Second
+	defaultListenBuffer = 64
+)
+
+// Status is the schedule and the last result of a collector
+type Status struct {
+	Name string `json:"name"`
+	// builtin or exec
+	Source   string    `json:"source"`
+	Interval string    `json:"interval"`
+	LastRun  time.Time `json:"last_run"`
+	// duration of the last run
+	Took      string `json:"took,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Runs      int    `json:"runs"`
+	Failures  int    `json:"failures"`
+	// sets not passed to a listener that fell behind (hub, db writer)
+	Dropped int  `json:"dropped"`
+	Latest  *Set `json:"latest,omitempty"`
+}
+
+type entry struct {
+	collector Collector
+	interval  time.Duration
+	status    Status
+}
+
+// Runner collects every enabled collector on its interval and passes the
+// sets to its listeners, the hub and the db writer
+type Runner struct {
+	mutex     *sync.Mutex
+	entries   map[string]*entry
+	lMutex    *sync.Mutex
+	listeners map[chan Set]bool
+}
+
+// NewRunner enables the registered collectors listed in COLLECTORS and the
+// plugins found in COLLECTOR_PLUGIN_DIR
+func NewRunner() *Runner {
+	r := &Runner{
+		mutex:     &sync.Mutex{},
+		entries:   make(map[string]*entry),
+		lMutex:    &sync.Mutex{},
+		listeners: make(map[chan Set]bool),
+	}
+	enabled := make(map[string]bool)
+	for _, name := range config.List("COLLECTORS", nil) {
+		enabled[name] = true
+	}
+	for _, c := range Registered() {
+		if enabled[c.Name()] {
+			r.add(c, "builtin")
+			delete(enabled, c.Name())
+		}
+	}
+	for name := range enabled {
+		logrus.Warnf("- COLLECTOR - %s is not compiled in\n", name)
+	}
+
+	dir := config.String("COLLECTOR_PLUGIN_DIR", "")
+	if dir == "" {
+		return r
+	}
+	plugins, err := Plugins(dir, config.Duration("COLLECTOR_PLUGIN_INTERVAL", defaultInterval))
+	if err != nil {
+		logrus.Errorf("- COLLECTOR - plugins: %s\n", err)
+		return r
+	}
+	for _, c := range plugins {
+		r.add(c, "exec")
+	}
+	return r
+}
+
+func (r *Runner) add(c Collector, source string) {
+	if _, exists := r.entries[c.Name()]; exists {
+		logrus.Warnf("- COLLECTOR - %s (%s) ignored, name is taken\n", c.Name(), source)
+		return
+	}
+	interval := c.Interval()
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	r.entries[c.Name()] = &entry{
+		collector: c,
+		interval:  interval,
+		status: Status{
+			Name:     c.Name(),
+			Source:   source,
+			Interval: interval.String(),
+		},
+	}
+}
+
+// Run starts a loop per collector, collections of a collector never overlap
+func (r *Runner) Run() {
+	for _, e := range r.entries {
+		logrus.Infof("- COLLECTOR - running %s (%s) every %s\n", e.status.Name, e.status.Source, e.interval)
+		go r.loop(e)
+	}
+}
+
+func (r *Runner) loop(e *entry) {
+	r.collect(e)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.collect(e)
+	}
+}
+
+func (r *Runner) collect(e *entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	start := time.Now()
+	set, err := e.collector.Collect(ctx)
+	cancel()
+
+	r.mutex.Lock()
+	e.status.LastRun = start
+	e.status.Took = time.Since(start).Round(time.Millisecond).String()
+	e.status.Runs++
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+		r.mutex.Unlock()
+		logrus.Errorf("- COLLECTOR - %s failed: %s\n", e.status.Name, err)
+		return
+	}
+	e.status.LastError = ""
+	set.Collector = e.status.Name
+	if set.When == 0 {
+		set.When = primitive.NewDateTimeFromTime(start)
+	}
+	if set.Node == "" {
+		set.Node = metrics.Node()
+	}
+	if set.Samples == nil {
+		set.Samples = make([]Sample, 0)
+	}
+	e.status.Latest = &set
+	r.mutex.Unlock()
+
+	// a slow listener, e.g. the db writer while the db is down, must not
+	// stall the collectors and the other listeners
+	dropped := 0
+	r.lMutex.Lock()
+	for ch := range r.listeners {
+		select {
+		case ch <- set:
+		default:
+			dropped++
+		}
+	}
+	r.lMutex.Unlock()
+	if dropped > 0 {
+		r.mutex.Lock()
+		e.status.Dropped += dropped
+		r.mutex.Unlock()
+		logrus.Warnf("- COLLECTOR - %s: %d listener(s) behind, set dropped\n", e.status.Name, dropped)
+	}
+}
+
+// Listen returns a channel receiving every collected set
+func (r *Runner) Listen() chan Set {
+	ch := make(chan Set, config.Int("LISTEN_BUFFER", defaultListenBuffer))
+	r.lMutex.Lock()
+	r.listeners[ch] = true
+	r.lMutex.Unlock()
+	return ch
+}
+
+// Unlisten removes and closes a channel obtained by Listen
+func (r *Runner) Unlisten(ch chan Set) {
+	r.lMutex.Lock()
+	if _, exists := r.listeners[ch]; exists {
+		delete(r.listeners, ch)
+		close(ch)
+	}
+	r.lMutex.Unlock()
+}
+
+// Has reports whether a collector of name is running
+func (r *Runner) Has(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, exists := r.entries[name]
+	return exists
+}
+
+// Latest returns the last set collected by name
+func (r *Runner) Latest(name string) (Set, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e, exists := r.entries[name]
+	if !exists || e.status.Latest == nil {
+		return Set{}, false
+	}
+	return *e.status.Latest, true
+}
+
+// Status returns the status of the collectors by name
+func (r *Runner) Status() []Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := make([]Status, 0, len(r.entries))
+	for _, e := range r.entries {
+		status = append(status, e.status)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
@@ -0,0 +1,140 @@
+// Package sensors registers the sensors collector: temperatures and fan
+// speeds of the host's hwmon and thermal zones and the SMART health of its
+// disks. Enable it by listing sensors in COLLECTORS, inside a container the
+// host's /sys has to be mounted at SENSORS_SYSFS
+package sensors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/collector"
+)
+
+// Name of the collector in COLLECTORS
+const Name = "sensors"
+
+const defaultInterval = 30 * time.Second
+
+func init() {
+	collector.Register(&Sensors{})
+}
+
+// Sensors reads the hardware sensors, keys are read on use since
+// collectors register before the configuration is loaded
+type Sensors struct{}
+
+func (s *Sensors) Name() string {
+	return Name
+}
+
+func (s *Sensors) Interval() time.Duration {
+	return config.Duration("SENSORS_INTERVAL", defaultInterval)
+}
+
+// Collect reads the sensors, missing ones are left out. SMART is skipped
+// without smartctl
+func (s *Sensors) Collect(ctx context.Context) (collector.Set, error) {
+	sysfs := config.String("SENSORS_SYSFS", "/sys")
+	samples := hwmon(sysfs)
+	samples = append(samples, thermalZones(sysfs)...)
+	if smartctl := config.String("SENSORS_SMARTCTL", "smartctl"); smartctl != "" {
+		disks, err := smart(ctx, smartctl)
+		if err != nil {
+			return collector.Set{}, err
+		}
+		samples = append(samples, disks...)
+	}
+	return collector.Set{Samples: samples}, nil
+}
+
+// hwmon reads the temp*_input (millidegree celsius) and fan*_input (rpm)
+// files of the hwmon chips
+func hwmon(sysfs string) []collector.Sample {
+	samples := make([]collector.Sample, 0)
+	chips, _ := filepath.Glob(filepath.Join(sysfs, "class/hwmon/hwmon*"))
+	for _, dir := range chips {
+		chip := readString(filepath.Join(dir, "name"))
+		if chip == "" {
+			chip = filepath.Base(dir)
+		}
+		inputs, _ := filepath.Glob(filepath.Join(dir, "*_input"))
+		for _, input := range inputs {
+			sensor := strings.TrimSuffix(filepath.Base(input), "_input")
+			labels := map[string]string{"chip": chip, "sensor": sensor}
+			if label := readString(filepath.Join(dir, sensor+"_label")); label != "" {
+				labels["label"] = label
+			}
+			value, ok := readInt(input)
+			if !ok {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(sensor, "temp"):
+				samples = append(samples, collector.Sample{
+					Name:   "temperature",
+					Value:  float64(value) / 1000,
+					Unit:   "celsius",
+					Labels: labels,
+				})
+				if crit, ok := readInt(filepath.Join(dir, sensor+"_crit")); ok {
+					samples = append(samples, collector.Sample{
+						Name:   "temperature_critical",
+						Value:  float64(crit) / 1000,
+						Unit:   "celsius",
+						Labels: labels,
+					})
+				}
+			case strings.HasPrefix(sensor, "fan"):
+				samples = append(samples, collector.Sample{
+					Name:   "fan_speed",
+					Value:  float64(value),
+					Unit:   "rpm",
+					Labels: labels,
+				})
+			}
+		}
+	}
+	return samples
+}
+
+// thermalZones reads the temperatures of the thermal zones, the only
+// sensors of many arm boards
+func thermalZones(sysfs string) []collector.Sample {
+	samples := make([]collector.Sample, 0)
+	zones, _ := filepath.Glob(filepath.Join(sysfs, "class/thermal/thermal_zone*"))
+	for _, dir := range zones {
+		value, ok := readInt(filepath.Join(dir, "temp"))
+		if !ok {
+			continue
+		}
+		samples = append(samples, collector.Sample{
+			Name:  "temperature",
+			Value: float64(value) / 1000,
+			Unit:  "celsius",
+			Labels: map[string]string{
+				"zone": filepath.Base(dir),
+				"type": readString(filepath.Join(dir, "type")),
+			},
+		})
+	}
+	return samples
+}
+
+func readString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readInt(path string) (int64, bool) {
+	v, err := strconv.ParseInt(readString(path), 10, 64)
+	return v, err == nil
+}
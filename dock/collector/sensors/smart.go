@@ -0,0 +1,96 @@
+package sensors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/h0rzn/monitoring_agent/dock/collector"
+)
+
+// smartScan is the device list of smartctl --scan --json
+type smartScan struct {
+	Devices []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"devices"`
+}
+
+// smartInfo is the part of smartctl --json --health --attributes read
+type smartInfo struct {
+	Model  string `json:"model_name"`
+	Serial string `json:"serial_number"`
+	Status *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature struct {
+		Current *float64 `json:"current"`
+	} `json:"temperature"`
+	PowerOn struct {
+		Hours *float64 `json:"hours"`
+	} `json:"power_on_time"`
+}
+
+// smart reports the health, temperature and power on hours of the disks
+// smartctl finds, nothing if smartctl is not installed
+func smart(ctx context.Context, smartctl string) ([]collector.Sample, error) {
+	path, err := exec.LookPath(smartctl)
+	if err != nil {
+		return nil, nil
+	}
+	var scan smartScan
+	if err = runSmartctl(ctx, path, &scan, "--scan", "--json"); err != nil {
+		return nil, err
+	}
+
+	samples := make([]collector.Sample, 0)
+	for _, dev := range scan.Devices {
+		var info smartInfo
+		err := runSmartctl(ctx, path, &info, "--json", "--health", "--attributes", "--device", dev.Type, dev.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", dev.Name, err)
+		}
+		labels := map[string]string{"device": dev.Name, "model": info.Model, "serial": info.Serial}
+		if info.Status != nil {
+			passed := 0.0
+			if info.Status.Passed {
+				passed = 1
+			}
+			samples = append(samples, collector.Sample{Name: "smart_passed", Value: passed, Labels: labels})
+		}
+		if info.Temperature.Current != nil {
+			samples = append(samples, collector.Sample{
+				Name:   "disk_temperature",
+				Value:  *info.Temperature.Current,
+				Unit:   "celsius",
+				Labels: labels,
+			})
+		}
+		if info.PowerOn.Hours != nil {
+			samples = append(samples, collector.Sample{
+				Name:   "power_on_hours",
+				Value:  *info.PowerOn.Hours,
+				Unit:   "hours",
+				Labels: labels,
+			})
+		}
+	}
+	return samples, nil
+}
+
+// runSmartctl decodes the json output of smartctl into v. Its exit status
+// is a bit mask, only the lower two bits mean the command failed, the
+// others report findings about the disk
+func runSmartctl(ctx context.Context, path string, v interface{}, args ...string) error {
+	out, err := exec.CommandContext(ctx, path, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode()&3 == 0 {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
@@ -0,0 +1,47 @@
+package container
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Checkpoints lists the checkpoints of a container
+func (s *Storage) Checkpoints(ctx context.Context, id string) ([]types.Checkpoint, error) {
+	container, exists := s.Container(id)
+	if !exists {
+		return nil, fmt.Errorf("container %s not found", id)
+	}
+	return s.c.CheckpointList(ctx, container.ID, types.CheckpointListOptions{})
+}
+
+// Checkpoint snapshots the state of a running container as name,
+// exit stops the container afterwards
+func (s *Storage) Checkpoint(ctx context.Context, id, name string, exit bool) error {
+	container, exists := s.Container(id)
+	if !exists {
+		return fmt.Errorf("container %s not found", id)
+	}
+	if name == "" {
+		return fmt.Errorf("checkpoint name required")
+	}
+	return s.c.CheckpointCreate(ctx, container.ID, types.CheckpointCreateOptions{
+		CheckpointID: name,
+		Exit:         exit,
+	})
+}
+
+// Restore starts a stopped container from the checkpoint name
+func (s *Storage) Restore(ctx context.Context, id, name string) error {
+	container, exists := s.Container(id)
+	if !exists {
+		return fmt.Errorf("container %s not found", id)
+	}
+	if name == "" {
+		return fmt.Errorf("checkpoint name required")
+	}
+	return s.c.ContainerStart(ctx, container.ID, types.ContainerStartOptions{
+		CheckpointID: name,
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/h0rzn/monitoring_agent/dock/image"
 	"github.com/h0rzn/monitoring_agent/dock/logs"
@@ -21,14 +22,16 @@ type Container struct {
 	Name  string      `json:"name"`
 	Image image.Image `json:"image"`
 	// function from image store to get image data by id
-	ImageGet   ImageGet       `json:"-"`
-	State      State          `json:"state"`
-	Networks   []*Network     `json:"networks"`
-	MountPaths []string       `json:"-"`
-	Volumes    []*Volume      `json:"volumes"`
-	Ports      []*Port        `json:"ports"`
-	Streams    Streams        `json:"-"`
-	c          *client.Client `json:"-"`
+	ImageGet   ImageGet          `json:"-"`
+	State      State             `json:"state"`
+	Labels     map[string]string `json:"labels"`
+	Limits     Limits            `json:"limits"`
+	Networks   []*Network        `json:"networks"`
+	MountPaths []string          `json:"-"`
+	Volumes    []*Volume         `json:"volumes"`
+	Ports      []*Port           `json:"ports"`
+	Streams    Streams           `json:"-"`
+	c          *client.Client    `json:"-"`
 }
 
 type State struct {
@@ -148,6 +151,19 @@ func (s *Streams) Stop() error {
 	return nil
 }
 
+func newNetworks(networks map[string]*network.EndpointSettings) []*Network {
+	out := make([]*Network, 0, len(networks))
+	for net, eps := range networks {
+		out = append(out, &Network{
+			Name:    net,
+			ID:      eps.EndpointID,
+			Aliases: eps.Aliases,
+			IPAddr:  eps.IPAddress,
+		})
+	}
+	return out
+}
+
 func NewContainer(c *client.Client, cid string, feedIn chan FeedItem) *Container {
 	return &Container{
 		ID:       cid,
@@ -196,18 +212,19 @@ func (cont *Container) prepare() <-chan error {
 		RestartPolicy: base.HostConfig.RestartPolicy.Name,
 	}
 
-	// networks
-	networks := json.NetworkSettings.Networks
-	for net, eps := range networks {
-		n := &Network{
-			Name:    net,
-			ID:      eps.EndpointID,
-			Aliases: eps.Aliases,
-			IPAddr:  eps.IPAddress,
-		}
-		cont.Networks = append(cont.Networks, n)
+	if json.Config != nil {
+		cont.Labels = json.Config.Labels
+	}
+
+	// limits
+	if base.HostConfig != nil {
+		cont.Limits = NewLimits(base.HostConfig.Resources)
+		cont.Streams.Metrics.SetLimits(metricsLimits(base.HostConfig.Resources))
 	}
 
+	// networks
+	cont.Networks = newNetworks(json.NetworkSettings.Networks)
+
 	for _, mp := range json.Mounts {
 		cont.MountPaths = append(cont.MountPaths, mp.Source)
 	}
@@ -0,0 +1,27 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
+
+// CopyFrom returns a tar stream of path inside the container
+func (s *Storage) CopyFrom(ctx context.Context, id, path string) (io.ReadCloser, types.ContainerPathStat, error) {
+	container, exists := s.Container(id)
+	if !exists {
+		return nil, types.ContainerPathStat{}, fmt.Errorf("container %s not found", id)
+	}
+	return s.c.CopyFromContainer(ctx, container.ID, path)
+}
+
+// CopyTo extracts the tar stream content to the directory path inside the container
+func (s *Storage) CopyTo(ctx context.Context, id, path string, content io.Reader) error {
+	container, exists := s.Container(id)
+	if !exists {
+		return fmt.Errorf("container %s not found", id)
+	}
+	return s.c.CopyToContainer(ctx, container.ID, path, content, types.CopyToContainerOptions{})
+}
@@ -0,0 +1,115 @@
+package container
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	dcontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+)
+
+type PortBinding struct {
+	Port     string `json:"port" binding:"required"` // container port, eg 80/tcp
+	HostIP   string `json:"host_ip"`
+	HostPort string `json:"host_port"`
+}
+
+type Mount struct {
+	Type     string `json:"type"` // bind, volume, tmpfs
+	Source   string `json:"source"`
+	Target   string `json:"target" binding:"required"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// CreateOpts describes a container to create
+type CreateOpts struct {
+	Name   string            `json:"name"`
+	Image  string            `json:"image" binding:"required"`
+	Cmd    []string          `json:"cmd"`
+	Env    []string          `json:"env"`
+	Labels map[string]string `json:"labels"`
+	Ports  []PortBinding     `json:"ports"`
+	Mounts []Mount           `json:"mounts"`
+	Start  bool              `json:"start"`
+}
+
+func (opts CreateOpts) configs() (*dcontainer.Config, *dcontainer.HostConfig, error) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	for _, p := range opts.Ports {
+		port, err := nat.NewPort(nat.SplitProtoPort(p.Port))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid port %s: %s", p.Port, err)
+		}
+		exposed[port] = struct{}{}
+		if p.HostPort != "" || p.HostIP != "" {
+			bindings[port] = append(bindings[port], nat.PortBinding{
+				HostIP:   p.HostIP,
+				HostPort: p.HostPort,
+			})
+		}
+	}
+
+	mounts := make([]mount.Mount, 0)
+	for _, m := range opts.Mounts {
+		typ := mount.Type(m.Type)
+		if typ == "" {
+			typ = mount.TypeVolume
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     typ,
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	config := &dcontainer.Config{
+		Image:        opts.Image,
+		Cmd:          opts.Cmd,
+		Env:          opts.Env,
+		Labels:       opts.Labels,
+		ExposedPorts: exposed,
+	}
+	hostConfig := &dcontainer.HostConfig{
+		PortBindings: bindings,
+		Mounts:       mounts,
+	}
+	return config, hostConfig, nil
+}
+
+// Create creates a container and tracks it right away,
+// opts.Start starts it afterwards
+func (s *Storage) Create(ctx context.Context, opts CreateOpts) (*Container, error) {
+	config, hostConfig, err := opts.configs()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.c.ContainerCreate(ctx, config, hostConfig, nil, nil, opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range created.Warnings {
+		logrus.Warnf("- STORAGE - create %s: %s\n", created.ID, warning)
+	}
+
+	err = s.Add(created.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Start {
+		// the start event brings the stored container up to date
+		err = s.c.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	container, _ := s.Container(created.ID)
+	return container, nil
+}
@@ -0,0 +1,71 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Selector restricts containers to those carrying all of its labels,
+// an empty selector matches every container
+type Selector map[string]string
+
+// ProjectLabel names the compose project of a container
+const ProjectLabel = "com.docker.compose.project"
+
+// ProjectSelector matches the containers of the compose project name
+func ProjectSelector(name string) Selector {
+	return Selector{ProjectLabel: name}
+}
+
+// ParseSelector parses a comma separated selector like "team=payments,env=prod"
+func ParseSelector(s string) (Selector, error) {
+	sel := make(Selector)
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("malformed selector term %q, expected key=value", term)
+		}
+		sel[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return sel, nil
+}
+
+func (sel Selector) Match(c *Container) bool {
+	return sel.MatchLabels(c.Labels)
+}
+
+func (sel Selector) MatchLabels(labels map[string]string) bool {
+	for key, value := range sel {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel Selector) String() string {
+	terms := make([]string, 0, len(sel))
+	for key, value := range sel {
+		terms = append(terms, key+"="+value)
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, ",")
+}
+
+// Select returns the containers matched by sel
+func (s *Storage) Select(sel Selector) []*Container {
+	containers := make([]*Container, 0)
+	s.do(func() {
+		for c := range s.containers {
+			if sel.Match(c) {
+				containers = append(containers, c)
+			}
+		}
+	})
+	return containers
+}
@@ -2,36 +2,153 @@ package container
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/h0rzn/monitoring_agent/config"
 	"github.com/h0rzn/monitoring_agent/dock/controller/db"
 	"github.com/h0rzn/monitoring_agent/dock/image"
 	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
 	"github.com/sirupsen/logrus"
 )
 
 type ImageGet func(string) (*image.Image, bool)
 
 type Storage struct {
-	mutex      sync.Mutex
-	c          *client.Client
-	Containers map[*Container]bool
+	c *client.Client
+	// container index and running state, owned by the run goroutine
+	containers map[*Container]bool
+	ops        chan func()
 	Feed       chan FeedItem
 	ImageGet   ImageGet
+	lMutex     sync.Mutex
+	listeners  map[chan Change]bool
+	// marshaled container list and its etag, dropped on every change
+	cMutex sync.Mutex
+	cache  []byte
+	etag   string
+	gen    uint64
 }
 
+// Change describes a modification of the storage
+type Change struct {
+	Action    string     `json:"action"` // add, update, remove
+	ID        string     `json:"id"`
+	Container *Container `json:"container,omitempty"`
+	// labels of the container, also set for removals
+	Labels map[string]string `json:"-"`
+}
+
+// default buffer of each change listener
+const defaultListenBuffer = 16
+
 func NewStorage(c *client.Client) *Storage {
-	return &Storage{
-		mutex:      sync.Mutex{},
+	s := &Storage{
 		c:          c,
-		Feed:       make(chan FeedItem),
-		Containers: map[*Container]bool{},
+		Feed:       make(chan FeedItem, config.Int("FEED_BUFFER", 0)),
+		containers: map[*Container]bool{},
+		ops:        make(chan func()),
+		listeners:  make(map[chan Change]bool),
+	}
+	selfmetrics.Register("storage_feed_queue", func() float64 {
+		return float64(len(s.Feed))
+	})
+	selfmetrics.Register("storage_listener_queue", func() float64 {
+		s.lMutex.Lock()
+		defer s.lMutex.Unlock()
+		depth := 0
+		for ch := range s.listeners {
+			depth += len(ch)
+		}
+		return float64(depth)
+	})
+	go s.run()
+	return s
+}
+
+// run is the only goroutine touching the container index, every
+// read and mutation is sent to it as op
+func (s *Storage) run() {
+	for op := range s.ops {
+		op()
+	}
+}
+
+// do executes op on the owning goroutine and waits for it to finish,
+// ops must not call do themselves
+func (s *Storage) do(op func()) {
+	done := make(chan struct{})
+	s.ops <- func() {
+		op()
+		close(done)
+	}
+	<-done
+}
+
+// lookup finds a container by id, only to be used within ops
+func (s *Storage) lookup(id string) (*Container, bool) {
+	for container := range s.containers {
+		if container.ID == id {
+			return container, true
+		}
+	}
+	return nil, false
+}
+
+// state returns the container of id and whether it is running
+func (s *Storage) state(id string) (container *Container, running bool, exists bool) {
+	s.do(func() {
+		container, exists = s.lookup(id)
+		running = exists && s.containers[container]
+	})
+	return
+}
+
+// Listen returns a channel receiving every change of the storage
+func (s *Storage) Listen() chan Change {
+	ch := make(chan Change, config.Int("LISTEN_BUFFER", defaultListenBuffer))
+	s.lMutex.Lock()
+	s.listeners[ch] = true
+	s.lMutex.Unlock()
+	return ch
+}
+
+// Unlisten removes and closes a channel obtained by Listen
+func (s *Storage) Unlisten(ch chan Change) {
+	s.lMutex.Lock()
+	if _, exists := s.listeners[ch]; exists {
+		delete(s.listeners, ch)
+		close(ch)
 	}
+	s.lMutex.Unlock()
+}
+
+// notify passes a change to the listeners, it is called outside of ops
+// so slow listeners do not stall the storage
+func (s *Storage) notify(action string, id string, container *Container) {
+	change := Change{
+		Action:    action,
+		ID:        id,
+		Container: container,
+	}
+	if container != nil {
+		change.Labels = container.Labels
+	}
+	if action == "remove" {
+		change.Container = nil
+	}
+	s.invalidate()
+	s.lMutex.Lock()
+	for ch := range s.listeners {
+		ch <- change
+	}
+	s.lMutex.Unlock()
 }
 
 func (s *Storage) Init(imgFunc ImageGet) error {
@@ -60,24 +177,26 @@ func (s *Storage) Init(imgFunc ImageGet) error {
 	return nil
 }
 
+// Add indexes the container of id or restarts the streams of a stopped
+// one, docker is queried outside of the owning goroutine
 func (s *Storage) Add(id string) (err error) {
-	if container, exists := s.Container(id); exists {
-		// stopped
-		if !s.Containers[container] {
-			err = container.Start()
-			if err != nil {
-				return
-			}
-			s.Containers[container] = true
+	if container, running, exists := s.state(id); exists {
+		// dont do anything if container is already running
+		if running {
 			return
 		}
-		// dont do anything if container is already running
+		err = container.Start()
+		if err != nil {
+			return
+		}
+		s.do(func() {
+			s.containers[container] = true
+		})
+		s.notify("update", id, container)
 		return
 	}
 
 	// add unindexed container
-	s.mutex.Lock()
-
 	container := NewContainer(s.c, id, s.Feed)
 	container.ImageGet = s.ImageGet
 	err = container.Start()
@@ -85,14 +204,24 @@ func (s *Storage) Add(id string) (err error) {
 		return
 	}
 
-	if container.State.Status == "running" {
-		s.Containers[container] = true
+	running := container.State.Status == "running"
+	var added bool
+	s.do(func() {
+		// added concurrently while docker was queried
+		if _, exists := s.lookup(id); exists {
+			return
+		}
+		s.containers[container] = running
+		added = true
+	})
+	if !added {
+		return container.Stop()
+	}
+	if running {
 		go container.RunFeed()
 		go container.Streams.Metrics.HandleLatest()
-	} else {
-		s.Containers[container] = false
 	}
-	s.mutex.Unlock()
+	s.notify("add", id, container)
 
 	logrus.Infof("- STORAGE - added %s container\n", container.State.Status)
 
@@ -100,77 +229,168 @@ func (s *Storage) Add(id string) (err error) {
 }
 
 func (s *Storage) Stop(id string) error {
-	s.mutex.Lock()
-	if container, exists := s.Container(id); exists {
-		running := s.Containers[container]
-		if running {
-			err := container.Stop()
-			if err != nil {
-				return err
-			}
-			s.Containers[container] = false
-		}
+	container, running, exists := s.state(id)
+	if !exists || !running {
+		return nil
+	}
+	err := container.Stop()
+	if err != nil {
+		return err
 	}
-	s.mutex.Unlock()
+	s.do(func() {
+		s.containers[container] = false
+		container.State.Status = "exited"
+	})
+	s.notify("update", id, container)
 	return nil
 }
 
 func (s *Storage) Remove(id string) error {
-	s.mutex.Lock()
-	if container, exists := s.Container(id); exists {
-		fmt.Println("attempt stop")
-		err := container.Stop()
-		fmt.Println("container stopped")
-		if err != nil {
-			return err
-		}
-		delete(s.Containers, container)
-		logrus.Infof("- STORAGE - container removed: %d left\n", len(s.Containers))
-	} else {
+	container, _, exists := s.state(id)
+	if !exists {
 		logrus.Warningln("- STORAGE - tried to remove unkown container")
+		return nil
 	}
-	s.mutex.Unlock()
-
+	err := container.Stop()
+	if err != nil {
+		return err
+	}
+	var left int
+	s.do(func() {
+		delete(s.containers, container)
+		left = len(s.containers)
+	})
+	s.notify("remove", id, container)
+	logrus.Infof("- STORAGE - container removed: %d left\n", left)
 	return nil
 }
 
 func (s *Storage) Container(id string) (*Container, bool) {
-	for container := range s.Containers {
-		if container.ID == id {
-			return container, true
-		}
+	container, _, exists := s.state(id)
+	if !exists {
+		return &Container{}, false
 	}
-	return &Container{}, false
+	return container, true
+}
+
+// Items returns all stored containers
+func (s *Storage) Items() (containers []*Container) {
+	s.do(func() {
+		for c := range s.containers {
+			containers = append(containers, c)
+		}
+	})
+	return
+}
+
+// Each runs fn for every stored container on the owning goroutine,
+// so fn may modify the container but must not call the storage
+func (s *Storage) Each(fn func(*Container)) {
+	s.do(func() {
+		for c := range s.containers {
+			fn(c)
+		}
+	})
+	s.invalidate()
 }
 
+// invalidate drops the cached container list
+func (s *Storage) invalidate() {
+	s.cMutex.Lock()
+	s.cache = nil
+	s.etag = ""
+	s.gen++
+	s.cMutex.Unlock()
+}
+
+// MarshalJSON serves the container list from cache
 func (s *Storage) MarshalJSON() ([]byte, error) {
-	containers := make([]*Container, 0)
-	s.mutex.Lock()
-	for c := range s.Containers {
-		containers = append(containers, c)
+	data, _, err := s.TaggedJSON()
+	return data, err
+}
+
+// TaggedJSON returns the marshaled container list with a hash of it as
+// etag, both cached until the storage changes. A list marshaled while the
+// storage changed is not cached
+func (s *Storage) TaggedJSON() ([]byte, string, error) {
+	s.cMutex.Lock()
+	cache, etag, gen := s.cache, s.etag, s.gen
+	s.cMutex.Unlock()
+	if cache != nil {
+		return cache, etag, nil
+	}
+
+	var data []byte
+	var err error
+	s.do(func() {
+		containers := make([]*Container, 0, len(s.containers))
+		for c := range s.containers {
+			containers = append(containers, c)
+		}
+		data, err = json.Marshal(containers)
+	})
+	if err != nil {
+		return nil, "", err
 	}
-	s.mutex.Unlock()
+	sum := sha256.Sum256(data)
+	etag = `"` + hex.EncodeToString(sum[:16]) + `"`
 
-	return json.Marshal(containers)
+	s.cMutex.Lock()
+	if s.gen == gen {
+		s.cache = data
+		s.etag = etag
+	}
+	s.cMutex.Unlock()
+	return data, etag, nil
 }
 
 func (s *Storage) CollectLatest() (colLatest []metrics.Set) {
-	s.mutex.Lock()
-	for container, active := range s.Containers {
-		if active {
-			latest := container.Streams.Metrics.Latest()
-			colLatest = append(colLatest, latest)
+	s.do(func() {
+		for container, active := range s.containers {
+			if active {
+				latest := container.Streams.Metrics.Latest()
+				colLatest = append(colLatest, latest)
+			}
 		}
-	}
-	s.mutex.Unlock()
+	})
+	return
+}
+
+// CollectLatestOf returns the latest sets of the running containers
+// matched by sel
+func (s *Storage) CollectLatestOf(sel Selector) (colLatest []metrics.Set) {
+	s.do(func() {
+		for container, active := range s.containers {
+			if active && sel.Match(container) {
+				colLatest = append(colLatest, container.Streams.Metrics.Latest())
+			}
+		}
+	})
 	return
 }
 
+// Projects returns the ids of the containers per compose project
+func (s *Storage) Projects() map[string][]string {
+	projects := make(map[string][]string)
+	s.do(func() {
+		for c := range s.containers {
+			if name := c.Labels[ProjectLabel]; name != "" {
+				projects[name] = append(projects[name], c.ID)
+			}
+		}
+	})
+	return projects
+}
+
 func (s *Storage) Broadcast() chan []interface{} {
-	out := make(chan []interface{})
+	out := make(chan []interface{}, config.Int("BROADCAST_BUFFER", 0))
+	selfmetrics.Register("storage_broadcast_queue", func() float64 {
+		return float64(len(out))
+	})
 	go func() {
 		data := make([]interface{}, 0)
 		ticker := time.NewTicker(5 * time.Second)
+		seqs := make(map[string]uint64)
 		for item := range s.Feed {
 			select {
 			case <-ticker.C:
@@ -179,6 +399,8 @@ func (s *Storage) Broadcast() chan []interface{} {
 			default:
 			}
 			mod := db.NewMetricsMod(item.Origin.ID, item.Body.When, item.Body)
+			seqs[item.Origin.ID]++
+			mod.Seq = seqs[item.Origin.ID]
 			data = append(data, mod)
 		}
 		close(out)
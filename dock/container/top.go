@@ -0,0 +1,62 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+)
+
+// Ranked is a container ranked by one metric of its latest set
+type Ranked struct {
+	ID      string      `json:"id"`
+	Name    string      `json:"name"`
+	Value   float64     `json:"value"`
+	Metrics metrics.Set `json:"metrics"`
+}
+
+// rankBy maps metric names to the value containers are ranked by
+var rankBy = map[string]func(metrics.Set) float64{
+	"cpu": func(set metrics.Set) float64 {
+		return set.CPU.UsagePerc
+	},
+	"memory": func(set metrics.Set) float64 {
+		return set.Mem.Usage
+	},
+	"net": func(set metrics.Set) float64 {
+		return set.Net.In + set.Net.Out
+	},
+}
+
+// Top returns the n running containers matched by sel with the highest
+// value of metric (cpu, memory, net) based on their latest cached set
+func (s *Storage) Top(metric string, n int, sel Selector) ([]Ranked, error) {
+	value, exists := rankBy[metric]
+	if !exists {
+		return nil, fmt.Errorf("unkown metric %s, expected cpu, memory or net", metric)
+	}
+
+	ranked := make([]Ranked, 0)
+	s.do(func() {
+		for container, active := range s.containers {
+			if !active || !sel.Match(container) {
+				continue
+			}
+			latest := container.Streams.Metrics.Latest()
+			ranked = append(ranked, Ranked{
+				ID:      container.ID,
+				Name:    container.Name,
+				Value:   value(latest),
+				Metrics: latest,
+			})
+		}
+	})
+
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].Value > ranked[j].Value
+	})
+	if n >= 0 && n < len(ranked) {
+		ranked = ranked[:n]
+	}
+	return ranked, nil
+}
@@ -0,0 +1,124 @@
+package container
+
+import (
+	"context"
+	"fmt"
+
+	dcontainer "github.com/docker/docker/api/types/container"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits are the live adjustable resource limits of a container,
+// zero values are unlimited (model) or unchanged (update)
+type Limits struct {
+	CPUs       float64 `json:"cpus"`
+	CPUShares  int64   `json:"cpu_shares"`
+	Memory     int64   `json:"memory"`
+	MemorySwap int64   `json:"memory_swap"`
+}
+
+func NewLimits(res dcontainer.Resources) Limits {
+	return Limits{
+		CPUs:       float64(res.NanoCPUs) / 1e9,
+		CPUShares:  res.CPUShares,
+		Memory:     res.Memory,
+		MemorySwap: res.MemorySwap,
+	}
+}
+
+func (l Limits) resources() dcontainer.Resources {
+	return dcontainer.Resources{
+		NanoCPUs:   int64(l.CPUs * 1e9),
+		CPUShares:  l.CPUShares,
+		Memory:     l.Memory,
+		MemorySwap: l.MemorySwap,
+	}
+}
+
+// default cfs period of the daemon if only a quota is set
+const defaultCPUPeriod = 100000
+
+// metricsLimits converts the limits the metric percentages relate to,
+// a cpu limit set by quota (--cpu-quota) counts like --cpus
+func metricsLimits(res dcontainer.Resources) metrics.Limits {
+	cpus := float64(res.NanoCPUs) / 1e9
+	if cpus == 0 && res.CPUQuota > 0 {
+		period := res.CPUPeriod
+		if period == 0 {
+			period = defaultCPUPeriod
+		}
+		cpus = float64(res.CPUQuota) / float64(period)
+	}
+	return metrics.Limits{CPUs: cpus, Memory: res.Memory}
+}
+
+// merge applies the set fields of update
+func (l *Limits) merge(update Limits) {
+	if update.CPUs != 0 {
+		l.CPUs = update.CPUs
+	}
+	if update.CPUShares != 0 {
+		l.CPUShares = update.CPUShares
+	}
+	if update.Memory != 0 {
+		l.Memory = update.Memory
+	}
+	if update.MemorySwap != 0 {
+		l.MemorySwap = update.MemorySwap
+	}
+}
+
+// Update adjusts the resource limits of a container while it runs
+func (s *Storage) Update(ctx context.Context, id string, limits Limits) (*Container, error) {
+	container, exists := s.Container(id)
+	if !exists {
+		return nil, fmt.Errorf("container %s not found", id)
+	}
+	if limits.CPUs < 0 || limits.Memory < 0 || limits.CPUShares < 0 {
+		return nil, fmt.Errorf("limits must not be negative")
+	}
+
+	resp, err := s.c.ContainerUpdate(ctx, container.ID, dcontainer.UpdateConfig{
+		Resources: limits.resources(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range resp.Warnings {
+		logrus.Warnf("- STORAGE - update %s: %s\n", id, warning)
+	}
+
+	s.do(func() {
+		container.Limits.merge(limits)
+	})
+	s.notify("update", container.ID, container)
+	return container, nil
+}
+
+// Refresh re-reads name, limits, restart policy and networks of a container
+// after docker reported it renamed, updated or (dis)connected
+func (s *Storage) Refresh(id string) error {
+	container, exists := s.Container(id)
+	if !exists {
+		return fmt.Errorf("container %s not found", id)
+	}
+	json, err := s.c.ContainerInspect(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	s.do(func() {
+		container.Name = json.Name
+		if json.HostConfig != nil {
+			container.Limits = NewLimits(json.HostConfig.Resources)
+			container.Streams.Metrics.SetLimits(metricsLimits(json.HostConfig.Resources))
+			container.State.RestartPolicy = json.HostConfig.RestartPolicy.Name
+		}
+		if json.NetworkSettings != nil {
+			container.Networks = newNetworks(json.NetworkSettings.Networks)
+		}
+	})
+	s.notify("update", id, container)
+	return nil
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// default time events are collected before About is refreshed
+	defaultAboutDebounce = 2 * time.Second
+	// default interval About is refreshed without events
+	defaultAboutInterval = time.Minute
+)
+
+type About struct {
+	mutex      *sync.RWMutex
+	Version    string `json:"version"`
+	APIVersion string `json:"api_version"`
+	OS         string `json:"os"`
+	OSType     string `json:"os_type"`
+	CPUs       int    `json:"cups"`
+	MaxMem     int64  `json:"max_mem"`
+	ImageN     int    `json:"image_n"`
+	ContainerN int    `json:"container_n"`
+	VolumeN    int    `json:"volume_n"`
+	Rootless   bool   `json:"rootless"`
+	// daemon supports experimental features like checkpoints
+	Experimental bool   `json:"experimental"`
+	Name         string `json:"name"`
+	Kernel       string `json:"kernel"`
+	System       string `json:"operating_system"`
+	Arch         string `json:"arch"`
+	Driver       string `json:"storage_driver"`
+	CgroupDriver string `json:"cgroup_driver"`
+	// "1" or "2", empty for daemons not reporting it
+	CgroupVersion string `json:"cgroup_version"`
+	RunningN      int    `json:"running_n"`
+	// nil outside of a swarm
+	Swarm   *SwarmInfo `json:"swarm,omitempty"`
+	Updated time.Time  `json:"updated"`
+}
+
+func NewAbout() *About {
+	return &About{
+		mutex: &sync.RWMutex{},
+	}
+}
+
+// Snapshot returns a copy safe to serialize while refreshing
+func (a *About) Snapshot() About {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return *a
+}
+
+// count sets a counter of a, or adjusts it by n if relative
+func (a *About) count(counter *int, n int, relative bool) {
+	a.mutex.Lock()
+	if relative {
+		*counter += n
+	} else {
+		*counter = n
+	}
+	a.mutex.Unlock()
+}
+
+func (ctr *Controller) UpdateAbout() (err error) {
+	ctx := context.Background()
+	version, err := ctr.c.ServerVersion(ctx)
+	if err != nil {
+		return
+	}
+
+	ctx = context.Background()
+	info, err := ctr.c.Info(ctx)
+	if err != nil {
+		return
+	}
+
+	a := ctr.About
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.Version = version.Version
+	a.APIVersion = version.APIVersion
+	a.OS = version.Os
+	a.CPUs = info.NCPU
+	a.MaxMem = info.MemTotal
+	a.OSType = info.OSType
+	a.ImageN = info.Images
+	a.ContainerN = info.Containers
+	a.Experimental = info.ExperimentalBuild
+	a.Name = info.Name
+	a.Kernel = info.KernelVersion
+	a.System = info.OperatingSystem
+	a.Arch = info.Architecture
+	a.Driver = info.Driver
+	a.CgroupDriver = info.CgroupDriver
+	a.CgroupVersion = info.CgroupVersion
+	a.RunningN = info.ContainersRunning
+	a.Swarm = newSwarmInfo(info.Swarm)
+	if a.Swarm != nil {
+		metrics.SetNode(a.Swarm.NodeID)
+	} else {
+		metrics.SetNode("")
+	}
+	a.Updated = time.Now()
+	a.Rootless = false
+	for _, opt := range info.SecurityOptions {
+		if opt == "name=rootless" {
+			a.Rootless = true
+		}
+	}
+	return
+}
+
+// requestAbout schedules a refresh of About without waiting for it
+func (ctr *Controller) requestAbout() {
+	select {
+	case ctr.aboutReq <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshAbout updates About every ABOUT_INTERVAL and after events, events
+// within ABOUT_DEBOUNCE are merged into a single refresh. Counters are
+// adjusted by the events themselves in between
+func (ctr *Controller) RefreshAbout() {
+	debounce := config.Duration("ABOUT_DEBOUNCE", defaultAboutDebounce)
+	ticker := time.NewTicker(config.Duration("ABOUT_INTERVAL", defaultAboutInterval))
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctr.aboutReq:
+			time.Sleep(debounce)
+			// drop the requests made while waiting
+			select {
+			case <-ctr.aboutReq:
+			default:
+			}
+		}
+		err := ctr.UpdateAbout()
+		if err != nil {
+			logrus.Warnf("- CONTROLLER - about refresh failed: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	bundleVersion = 1
+	// leads every sealed bundle, followed by salt, nonce and ciphertext
+	bundleMagic = "MWB1"
+	saltSize    = 16
+	// minimum length of a backup passphrase
+	minPassphrase = 12
+)
+
+var (
+	ErrPassphrase = fmt.Errorf("passphrase needs at least %d characters", minPassphrase)
+	ErrBundle     = errors.New("not a backup bundle or wrong passphrase")
+)
+
+// Bundle is the state of the agent needed to replace its host: the set
+// configuration keys, users with their password hashes, saved views and
+// registry credentials in plain text. It only leaves the agent sealed
+type Bundle struct {
+	Version     int                `json:"version"`
+	Created     time.Time          `json:"created"`
+	Host        string             `json:"host"`
+	Config      map[string]string  `json:"config"`
+	Users       []db.User          `json:"users"`
+	Views       []BundleView       `json:"views"`
+	Credentials []BundleCredential `json:"credentials"`
+}
+
+// BundleView is a saved view with its owner
+type BundleView struct {
+	Owner string `json:"owner"`
+	db.View
+}
+
+// BundleCredential is a registry credential with its password
+type BundleCredential struct {
+	Host     string `json:"host"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// RestoreReport counts what a restore applied, Env holds the configuration
+// as env file, it takes effect once the agent is restarted with it
+type RestoreReport struct {
+	Created     time.Time `json:"created"`
+	Host        string    `json:"host"`
+	Users       int       `json:"users"`
+	Views       int       `json:"views"`
+	Credentials int       `json:"credentials"`
+	Skipped     []string  `json:"skipped,omitempty"`
+	Env         string    `json:"env"`
+}
+
+// Backup collects the bundle and seals it with passphrase. Without a db
+// the bundle holds the configuration only
+func (ctr *Controller) Backup(passphrase string) ([]byte, error) {
+	if len(passphrase) < minPassphrase {
+		return nil, ErrPassphrase
+	}
+	bundle := Bundle{
+		Version:     bundleVersion,
+		Created:     time.Now(),
+		Host:        ctr.About.Snapshot().Name,
+		Config:      make(map[string]string),
+		Views:       make([]BundleView, 0),
+		Credentials: make([]BundleCredential, 0),
+	}
+	for key := range config.Keys {
+		if v, ok := os.LookupEnv(key); ok {
+			bundle.Config[key] = v
+		}
+	}
+
+	var err error
+	if bundle.Users, err = ctr.DB.UsersWithHash(); err != nil && !errors.Is(err, db.ErrNotConnected) {
+		return nil, err
+	}
+	views, err := ctr.DB.AllViews()
+	if err != nil && !errors.Is(err, db.ErrNotConnected) {
+		return nil, err
+	}
+	for _, v := range views {
+		bundle.Views = append(bundle.Views, BundleView{Owner: v.Owner, View: v})
+	}
+	if bundle.Credentials, err = ctr.Keychain.Export(); err != nil && !errors.Is(err, db.ErrNotConnected) {
+		return nil, err
+	}
+
+	plain := &bytes.Buffer{}
+	zw := gzip.NewWriter(plain)
+	if err = json.NewEncoder(zw).Encode(bundle); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	logrus.Infof("- CONTROLLER - backup of %d users, %d views, %d credentials\n", len(bundle.Users), len(bundle.Views), len(bundle.Credentials))
+	return sealBundle(plain.Bytes(), passphrase)
+}
+
+// Restore opens a sealed bundle and stores its users, views and
+// credentials, existing ones of the same name are replaced
+func (ctr *Controller) Restore(sealed []byte, passphrase string) (RestoreReport, error) {
+	var report RestoreReport
+	plain, err := openBundle(sealed, passphrase)
+	if err != nil {
+		return report, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return report, ErrBundle
+	}
+	var bundle Bundle
+	if err = json.NewDecoder(zr).Decode(&bundle); err != nil {
+		return report, ErrBundle
+	}
+	if bundle.Version != bundleVersion {
+		return report, fmt.Errorf("bundle version %d is not supported", bundle.Version)
+	}
+	report.Created = bundle.Created
+	report.Host = bundle.Host
+
+	if len(bundle.Users)+len(bundle.Views)+len(bundle.Credentials) > 0 && !ctr.DB.Connected() {
+		return report, db.ErrNotConnected
+	}
+	for _, u := range bundle.Users {
+		if err := ctr.DB.RestoreUser(u); err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("user %s: %s", u.Name, err))
+			continue
+		}
+		report.Users++
+	}
+	for _, v := range bundle.Views {
+		v.View.Owner = v.Owner
+		if err := ctr.DB.UpsertView(v.View); err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("view %s of %s: %s", v.Name, v.Owner, err))
+			continue
+		}
+		report.Views++
+	}
+	for _, c := range bundle.Credentials {
+		if err := ctr.Keychain.Set(c.Host, c.User, c.Password); err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("credential of %s: %s", c.Host, err))
+			continue
+		}
+		report.Credentials++
+	}
+
+	keys := make([]string, 0, len(bundle.Config))
+	for key := range bundle.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var env strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&env, "%s=%q\n", key, bundle.Config[key])
+	}
+	report.Env = env.String()
+	logrus.Infof("- CONTROLLER - restored %d users, %d views, %d credentials from backup of %s\n", report.Users, report.Views, report.Credentials, bundle.Host)
+	return report, nil
+}
+
+// bundleKey derives the AES-256 key of passphrase with scrypt
+func bundleKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func sealBundle(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := bundleGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(bundleMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(bundleMagic)), nil
+}
+
+func openBundle(sealed []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, []byte(bundleMagic)) || len(sealed) < len(bundleMagic)+saltSize {
+		return nil, ErrBundle
+	}
+	sealed = sealed[len(bundleMagic):]
+	gcm, err := bundleGCM(passphrase, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[saltSize:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrBundle
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(bundleMagic))
+	if err != nil {
+		return nil, ErrBundle
+	}
+	return plain, nil
+}
+
+func bundleGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := bundleKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
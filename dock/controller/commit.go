@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/h0rzn/monitoring_agent/dock/image"
+	"github.com/sirupsen/logrus"
+)
+
+// CommitOpts describes the image a container is committed to
+type CommitOpts struct {
+	Repo    string `json:"repo"`
+	Tag     string `json:"tag"`
+	Author  string `json:"author"`
+	Message string `json:"message"`
+	// pause the container during the commit, defaults to true
+	Pause *bool `json:"pause"`
+}
+
+// Commit snapshots a container into an image and registers it in the image store
+func (ctr *Controller) Commit(ctx context.Context, id string, opts CommitOpts) (*image.Image, error) {
+	container, exists := ctr.Containers.Container(id)
+	if !exists {
+		return nil, fmt.Errorf("container %s not found", id)
+	}
+
+	ref := opts.Repo
+	if ref != "" && opts.Tag != "" {
+		ref = ref + ":" + opts.Tag
+	}
+	pause := true
+	if opts.Pause != nil {
+		pause = *opts.Pause
+	}
+
+	resp, err := ctr.c.ContainerCommit(ctx, container.ID, types.ContainerCommitOptions{
+		Reference: ref,
+		Comment:   opts.Message,
+		Author:    opts.Author,
+		Pause:     pause,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("- CONTROLLER - committed %s to %s\n", container.ID, resp.ID)
+
+	err = ctr.Images.Add(resp.ID)
+	if err != nil {
+		return nil, err
+	}
+	img, _ := ctr.Images.Image(resp.ID)
+	return img, nil
+}
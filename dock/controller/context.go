@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// contextMeta is the subset of a docker context store meta.json we need
+type contextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// contextStore returns the context store dir of the docker cli,
+// respecting DOCKER_CONFIG
+func contextStore() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "contexts"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "contexts"), nil
+}
+
+// EndpointFromContext resolves the docker endpoint and tls material of a
+// named context as created by `docker context create`
+func EndpointFromContext(name string) (Endpoint, error) {
+	if name == "" || name == "default" {
+		return Endpoint{}, nil
+	}
+
+	store, err := contextStore()
+	if err != nil {
+		return Endpoint{}, err
+	}
+	// contexts are stored by the sha256 digest of their name
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+
+	raw, err := os.ReadFile(filepath.Join(store, "meta", id, "meta.json"))
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("docker context %s not found: %s", name, err)
+	}
+	var meta contextMeta
+	err = json.Unmarshal(raw, &meta)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("docker context %s malformed: %s", name, err)
+	}
+	docker, exists := meta.Endpoints["docker"]
+	if !exists {
+		return Endpoint{}, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+	if docker.SkipTLSVerify {
+		logrus.Warnf("- CONTROLLER - context %s skips tls verification, which is not supported\n", name)
+	}
+
+	ep := Endpoint{Host: docker.Host}
+	tlsDir := filepath.Join(store, "tls", id, "docker")
+	for file, field := range map[string]*string{
+		"ca.pem":   &ep.CACert,
+		"cert.pem": &ep.Cert,
+		"key.pem":  &ep.Key,
+	} {
+		path := filepath.Join(tlsDir, file)
+		if _, err := os.Stat(path); err == nil {
+			*field = path
+		}
+	}
+
+	logrus.Infof("- CONTROLLER - using docker context %s (%s)\n", name, ep.Host)
+	return ep, nil
+}
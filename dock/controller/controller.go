@@ -2,39 +2,56 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	dock_events "github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/client"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/collector"
 	"github.com/h0rzn/monitoring_agent/dock/container"
 	"github.com/h0rzn/monitoring_agent/dock/controller/db"
 	"github.com/h0rzn/monitoring_agent/dock/events"
 	"github.com/h0rzn/monitoring_agent/dock/image"
+	"github.com/h0rzn/monitoring_agent/dock/registry"
+	"github.com/h0rzn/monitoring_agent/requestid"
+	"github.com/h0rzn/monitoring_agent/tracing"
 	"github.com/sirupsen/logrus"
 )
 
+// Controller is the single owner of the docker client and everything built
+// on it: the container and image stores, the event stream and the db. Init
+// wires them in dependency order, other packages only reach them through
+// the controller
 type Controller struct {
-	c *client.Client
-	// Storage    *Storage
+	c          *client.Client
 	DB         *db.DB
 	About      *About
+	DiskUsage  *DiskUsage
 	Volumes    []*Volume
 	Events     *events.Events
 	Containers *container.Storage
 	Images     *image.Storage
+	Registry   *registry.Client
+	Keychain   *Keychain
+	// nil unless AUTOHEAL is set
+	Healer     *Healer
+	Tasks      *Scheduler
+	Collectors *collector.Runner
+	queue      *eventQueue
+	writes     *writeQueue
+	spool      *db.Spool
+	aboutReq   chan struct{}
+	// unix nano since the event in handling started, 0 if idle
+	busySince int64
 }
 
-type About struct {
-	Version    string `json:"version"`
-	APIVersion string `json:"api_version"`
-	OS         string `json:"os"`
-	OSType     string `json:"os_type"`
-	CPUs       int    `json:"cups"`
-	MaxMem     int64  `json:"max_mem"`
-	ImageN     int    `json:"image_n"`
-	ContainerN int    `json:"container_n"`
-	VolumeN    int    `json:"volume_n"`
-}
 type Volume struct {
 	Name       string `json:"name"`
 	Mountpoint string `json:"mountpoint"`
@@ -45,38 +62,117 @@ type Volume struct {
 }
 
 func NewController() (ctr *Controller, err error) {
-	c, err := client.NewClientWithOpts(client.FromEnv)
+	ep := EndpointFromEnv()
+	// an explicit endpoint takes precedence over a docker context
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" && ep.Host == "" {
+		ep, err = EndpointFromContext(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewControllerWithEndpoint(ep)
+}
+
+// NewControllerWithEndpoint creates a controller for the daemon at ep,
+// e.g. a remote tcp:// daemon secured with tls client certs
+func NewControllerWithEndpoint(ep Endpoint) (ctr *Controller, err error) {
+	opts, err := ep.Opts()
+	if err != nil {
+		return nil, err
+	}
+	// the options configure the transport of httpClient, which is wrapped
+	// afterwards to log and trace the docker calls of api requests
+	transport := &http.Transport{}
+	httpClient := &http.Client{
+		Transport:     transport,
+		CheckRedirect: client.CheckRedirect,
+	}
+	opts = append([]client.Opt{
+		client.WithHTTPClient(httpClient),
+		client.WithHost(client.DefaultDockerHost),
+	}, opts...)
+	c, err := client.NewClientWithOpts(opts...)
 	if err != nil {
 		return nil, err
 	}
+	httpClient.Transport = &requestid.Transport{
+		Base: &tracing.Transport{Base: transport},
+	}
+
+	database := db.NewDB()
+	keychain := NewKeychain(database)
+	images := image.NewStorage(c)
+	images.Keychain = keychain
 
 	return &Controller{
 		c:          c,
-		DB:         &db.DB{},
-		About:      &About{},
+		DB:         database,
+		About:      NewAbout(),
+		DiskUsage:  NewDiskUsage(),
 		Volumes:    make([]*Volume, 0),
 		Events:     events.NewEvents(c),
 		Containers: container.NewStorage(c),
-		Images:     image.NewStorage(c),
+		Images:     images,
+		Registry:   registry.NewClient(keychain.Auth),
+		Keychain:   keychain,
+		queue:      newEventQueue(),
+		writes:     newWriteQueue(),
+		aboutReq:   make(chan struct{}, 1),
 	}, err
 }
 
+// initHealer creates the healer if auto-heal is configured and the agent
+// may change containers
+func (ctr *Controller) initHealer() {
+	if !config.Bool("AUTOHEAL", false) {
+		return
+	}
+	if config.Bool("READ_ONLY", false) || !config.Enabled("control") {
+		logrus.Warnln("- CONTROLLER - auto-heal ignored, the agent must not change containers")
+		return
+	}
+	ctr.Healer = newHealer(ctr.c, ctr.DB)
+	logrus.Infoln("- CONTROLLER - auto-heal enabled")
+}
+
+// initCollectors runs the enabled collectors, their sets are written to
+// the db unless persistence is disabled
+func (ctr *Controller) initCollectors(persist bool) {
+	ctr.Collectors = collector.NewRunner()
+	ctr.Collectors.Run()
+	if !persist {
+		return
+	}
+	sets := ctr.Collectors.Listen()
+	go func() {
+		for set := range sets {
+			err := ctr.DB.InsertCollectorSet(set)
+			if err != nil && !errors.Is(err, db.ErrNotConnected) {
+				logrus.Errorf("- CONTROLLER - writing %s set: %s\n", set.Collector, err)
+			}
+		}
+	}()
+}
+
 func (ctr *Controller) Init() (err error) {
 	logrus.Infoln("- CONTROLLER - starting")
 	err = ctr.UpdateAbout()
 	if err != nil {
 		logrus.Warnf("- CONTROLLER - about might not be complete, err: %s\n", err)
 	}
+	go ctr.RefreshAbout()
 
-	err = ctr.UpdateVolumes()
+	err = ctr.UpdateDiskUsage()
 	if err != nil {
 		logrus.Warnf("- CONTROLLER - volumes might not be complete, err: %s\n", err)
 	}
+	go ctr.RefreshDiskUsage()
 
 	err = ctr.Events.Init()
 	if err != nil {
 		return err
 	}
+	ctr.initHealer()
 	go ctr.HandleEvents()
 
 	err = ctr.Images.Init()
@@ -92,51 +188,48 @@ func (ctr *Controller) Init() (err error) {
 	}
 	ctr.SetVolumes()
 
+	// the agent streams without a db, history is kept in memory then
+	err = ctr.DB.Init()
+	switch {
+	case errors.Is(err, db.ErrNotConfigured):
+		logrus.Infoln("- CONTROLLER - no db configured, running streaming-only")
+	case err != nil:
+		logrus.Errorf("- STORAGE - (db) not reachable, streaming-only until it is: %s\n", err)
+	}
+
+	persist := config.Enabled("persistence")
+	if !persist {
+		logrus.Infoln("- CONTROLLER - metrics persistence disabled")
+	}
+	ctr.initTasks()
+	ctr.initCollectors(persist)
+	ctr.spool = openSpool()
+	go ctr.WriteMetrics()
 	go func() {
 		fmt.Println("started storage broadcast")
 		for items := range ctr.Containers.Broadcast() {
-			go ctr.DB.InsertManyMetrics(items)
+			// while the db is down batches are spooled if configured
+			if !persist || !ctr.DB.Status().Configured || (ctr.spool == nil && !ctr.DB.Connected()) {
+				continue
+			}
+			ctr.writes.Push(items)
 		}
 		logrus.Warningln("- CONTROLLER - feed writer left")
 	}()
-
-	err = ctr.DB.Init()
-	if err != nil {
-		logrus.Errorf("- STORAGE - (db) failed to init: %s\n", err)
-	}
-	return err
+	return nil
 }
 
-func (ctr *Controller) UpdateAbout() (err error) {
+func (ctr *Controller) UpdateVolumes() (err error) {
 	ctx := context.Background()
-	version, err := ctr.c.ServerVersion(ctx)
-	if err != nil {
-		return
-	}
-	ctr.About.Version = version.Version
-	ctr.About.APIVersion = version.APIVersion
-	ctr.About.OS = version.Os
-
-	ctx = context.Background()
-	info, err := ctr.c.Info(ctx)
+	du, err := ctr.c.DiskUsage(ctx)
 	if err != nil {
 		return
 	}
-	ctr.About.CPUs = info.NCPU
-	ctr.About.MaxMem = info.MemTotal
-	ctr.About.OSType = info.OSType
-	ctr.About.ImageN = info.Images
-	ctr.About.ContainerN = info.Containers
-	fmt.Println(info.OSType, info.Architecture, info.OperatingSystem)
+	ctr.setVolumes(du)
 	return
 }
 
-func (ctr *Controller) UpdateVolumes() (err error) {
-	ctx := context.Background()
-	du, err := ctr.c.DiskUsage(ctx)
-	if err != nil {
-		return
-	}
+func (ctr *Controller) setVolumes(du types.DiskUsage) {
 	updated := make([]*Volume, 0)
 	for _, v := range du.Volumes {
 		new := &Volume{
@@ -150,22 +243,68 @@ func (ctr *Controller) UpdateVolumes() (err error) {
 		updated = append(updated, new)
 	}
 	ctr.Volumes = updated
-	ctr.About.VolumeN = len(ctr.Volumes)
-	return
+	ctr.About.count(&ctr.About.VolumeN, len(ctr.Volumes), false)
 }
 
-func (ctr *Controller) SetVolumes() {
+// addVolume appends a created volume, it is not in use yet
+func (ctr *Controller) addVolume(name string) error {
+	v, err := ctr.c.VolumeInspect(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	updated := make([]*Volume, 0, len(ctr.Volumes)+1)
+	for _, vol := range ctr.Volumes {
+		if vol.Name != name {
+			updated = append(updated, vol)
+		}
+	}
+	updated = append(updated, &Volume{
+		Name:       v.Name,
+		Mountpoint: v.Mountpoint,
+		Driver:     v.Driver,
+		Created:    v.CreatedAt,
+	})
+	ctr.Volumes = updated
+	ctr.About.count(&ctr.About.VolumeN, len(ctr.Volumes), false)
+	return nil
+}
+
+func (ctr *Controller) removeVolume(name string) {
+	updated := make([]*Volume, 0, len(ctr.Volumes))
 	for _, vol := range ctr.Volumes {
-		for c := range ctr.Containers.Containers {
+		if vol.Name != name {
+			updated = append(updated, vol)
+		}
+	}
+	ctr.Volumes = updated
+	ctr.About.count(&ctr.About.VolumeN, len(ctr.Volumes), false)
+}
+
+// SetVolumes attaches the known volumes to the containers mounting them
+func (ctr *Controller) SetVolumes() {
+	ctr.Containers.Each(func(c *container.Container) {
+		c.Volumes = make([]*container.Volume, 0)
+		for _, vol := range ctr.Volumes {
 			for _, mp := range c.MountPaths {
-				if mp == vol.Mountpoint {
+				if ctr.samePath(mp, vol.Mountpoint) {
 					contVol := container.NewVolume(vol.Name, "", vol.Mountpoint, vol.Size, vol.UsedBy)
 					c.Volumes = append(c.Volumes, contVol)
 				}
 			}
 		}
-	}
+	})
+
+}
 
+// samePath compares host paths, case insensitive and separator agnostic
+// for windows daemons
+func (ctr *Controller) samePath(a, b string) bool {
+	if ctr.About.Snapshot().OSType != "windows" {
+		return a == b
+	}
+	a = strings.TrimRight(strings.ReplaceAll(a, "/", `\`), `\`)
+	b = strings.TrimRight(strings.ReplaceAll(b, "/", `\`), `\`)
+	return strings.EqualFold(a, b)
 }
 
 func (ctr *Controller) HandleEvents() {
@@ -176,27 +315,118 @@ func (ctr *Controller) HandleEvents() {
 	}
 
 	logrus.Infoln("- CONTROLLER - running event handler...")
-	for set := range eventRcv.In {
-		fmt.Println("handling event")
-		event := set.Data.(dock_events.Message)
-		// add queue
-		if event.Type != dock_events.ContainerEventType {
-			continue
+	go func() {
+		for set := range eventRcv.In {
+			event := set.Data.(dock_events.Message)
+			switch event.Type {
+			case dock_events.ContainerEventType, dock_events.ImageEventType,
+				dock_events.NetworkEventType, dock_events.VolumeEventType:
+				ctr.queue.Push(event)
+			}
 		}
-		switch event.Status {
-		case "start":
-			ctr.ContainerStart(event)
-		case "stop":
-			ctr.ContainerStop(event)
-		case "destroy":
-			ctr.ContainerDestroy(event)
-		default:
-			logrus.Warnf("- CONTROLLER - event %s is unkown or not implemented\n", event.Status)
+		logrus.Warningln("- CONTROLLER - event stream left")
+	}()
+
+	for {
+		event := ctr.queue.Pop()
+		atomic.StoreInt64(&ctr.busySince, time.Now().UnixNano())
+		switch event.Type {
+		case dock_events.ContainerEventType:
+			ctr.handleContainerEvent(event)
+		case dock_events.ImageEventType:
+			ctr.handleImageEvent(event)
+		case dock_events.NetworkEventType:
+			ctr.handleNetworkEvent(event)
+		case dock_events.VolumeEventType:
+			ctr.handleVolumeEvent(event)
 		}
-		ctr.UpdateAbout()
+		ctr.requestAbout()
+		atomic.StoreInt64(&ctr.busySince, 0)
 	}
 }
 
+func (ctr *Controller) handleContainerEvent(event dock_events.Message) {
+	switch event.Status {
+	case "create":
+		ctr.About.count(&ctr.About.ContainerN, 1, true)
+	case "start":
+		ctr.ContainerStart(event)
+	case "stop":
+		ctr.ContainerStop(event)
+	case "destroy":
+		ctr.About.count(&ctr.About.ContainerN, -1, true)
+		ctr.ContainerDestroy(event)
+		if ctr.Healer != nil {
+			ctr.Healer.Handle(event)
+		}
+	case "rename", "update":
+		ctr.ContainerRefresh(event)
+	case "health_status: healthy", "health_status: unhealthy":
+		if ctr.Healer != nil {
+			ctr.Healer.Handle(event)
+		}
+	default:
+		logrus.Warnf("- CONTROLLER - event %s is unkown or not implemented\n", event.Status)
+	}
+}
+
+// handleImageEvent keeps the image store current, pull events carry
+// the pulled reference instead of the image id
+func (ctr *Controller) handleImageEvent(event dock_events.Message) {
+	var err error
+	switch event.Action {
+	case "pull", "tag", "untag":
+		err = ctr.Images.Refresh(event.Actor.ID)
+	case "delete":
+		ctr.About.count(&ctr.About.ImageN, -1, true)
+		err = ctr.Images.Remove(event.Actor.ID)
+	default:
+		return
+	}
+	logEventExec(err, event)
+}
+
+// handleNetworkEvent refreshes the networks of (dis)connected containers,
+// networks themselves are not stored but queried with the topology
+func (ctr *Controller) handleNetworkEvent(event dock_events.Message) {
+	switch event.Action {
+	case "connect", "disconnect":
+		cid := event.Actor.Attributes["container"]
+		if _, exists := ctr.Containers.Container(cid); !exists {
+			// not indexed yet, networks are read when it is added
+			return
+		}
+		logEventExec(ctr.Containers.Refresh(cid), event)
+	}
+}
+
+// handleVolumeEvent adds created and drops destroyed volumes, usage
+// changes by (un)mounts are picked up by the disk usage refresh
+func (ctr *Controller) handleVolumeEvent(event dock_events.Message) {
+	var err error
+	switch event.Action {
+	case "create":
+		err = ctr.addVolume(event.Actor.ID)
+	case "destroy":
+		ctr.removeVolume(event.Actor.ID)
+	default:
+		return
+	}
+	logEventExec(err, event)
+}
+
+// EventQueue returns the counters of the event queue
+func (ctr *Controller) EventQueue() QueueStats {
+	return ctr.queue.Stats()
+}
+
+// Alive reports whether the event handler is idle or has been handling
+// the current event for less than limit
+func (ctr *Controller) Alive(limit time.Duration) bool {
+	since := atomic.LoadInt64(&ctr.busySince)
+	return since == 0 || time.Since(time.Unix(0, since)) < limit
+}
+
 func (ctr *Controller) ContainerStart(e dock_events.Message) {
 	err := ctr.Containers.Add(e.ID)
 	logEventExec(err, e)
@@ -212,6 +442,12 @@ func (ctr *Controller) ContainerDestroy(e dock_events.Message) {
 	logEventExec(err, e)
 }
 
+// ContainerRefresh picks up the new name or limits of a container
+func (ctr *Controller) ContainerRefresh(e dock_events.Message) {
+	err := ctr.Containers.Refresh(e.ID)
+	logEventExec(err, e)
+}
+
 func (ctr *Controller) Quit() {
 	// complete this
 	ctr.c.Close()
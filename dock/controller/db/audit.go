@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry records a control action taken through the api
+type AuditEntry struct {
+	When   time.Time `json:"when" bson:"when"`
+	User   string    `json:"user" bson:"user"`
+	Method string    `json:"method" bson:"method"`
+	Route  string    `json:"route" bson:"route"`
+	Target string    `json:"target" bson:"target"`
+	Detail string    `json:"detail,omitempty" bson:"detail,omitempty"`
+	Status int       `json:"status" bson:"status"`
+	Error  string    `json:"error,omitempty" bson:"error,omitempty"`
+	// id of the request in the agent's logs
+	RequestID string `json:"request_id" bson:"request_id"`
+}
+
+// AuditQuery filters audit entries, zero fields are ignored
+type AuditQuery struct {
+	From   time.Time
+	To     time.Time
+	User   string
+	Target string
+	Limit  int64
+}
+
+func (db *DB) InsertAudit(e AuditEntry) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	ctx, span := tracing.Start(context.Background(), "db insert audit", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("db.system", "mongodb")
+	span.SetAttr("db.mongodb.collection", "audit")
+
+	col := db.conn().Database("metawatch").Collection("audit")
+	_, err := col.InsertOne(ctx, e)
+	span.SetError(err)
+	return err
+}
+
+// Audit returns the matching entries, newest first
+func (db *DB) Audit(q AuditQuery) (result []AuditEntry, err error) {
+	result = make([]AuditEntry, 0)
+	if !db.Connected() {
+		return result, ErrNotConnected
+	}
+
+	filter := bson.D{}
+	when := bson.D{}
+	if !q.From.IsZero() {
+		when = append(when, bson.E{Key: "$gte", Value: q.From})
+	}
+	if !q.To.IsZero() {
+		when = append(when, bson.E{Key: "$lte", Value: q.To})
+	}
+	if len(when) > 0 {
+		filter = append(filter, bson.E{Key: "when", Value: when})
+	}
+	if q.User != "" {
+		filter = append(filter, bson.E{Key: "user", Value: q.User})
+	}
+	if q.Target != "" {
+		filter = append(filter, bson.E{Key: "target", Value: q.Target})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "when", Value: -1}})
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	col := db.conn().Database("metawatch").Collection("audit")
+	ctx := context.Background()
+	cur, err := col.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	err = cur.All(ctx, &result)
+	return
+}
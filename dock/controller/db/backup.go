@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AllViews lists the views of every user, for backups
+func (db *DB) AllViews() (result []View, err error) {
+	result = make([]View, 0)
+	if !db.Connected() {
+		return result, ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("views")
+	opts := options.Find().SetSort(bson.D{{"owner", 1}, {"name", 1}})
+	cur, err := col.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return
+	}
+	err = cur.All(context.TODO(), &result)
+	return
+}
+
+// UsersWithHash lists the users including their password hashes, for backups
+func (db *DB) UsersWithHash() (result []User, err error) {
+	result = make([]User, 0)
+	if !db.Connected() {
+		return result, ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("users")
+	cur, err := col.Find(context.TODO(), bson.D{}, options.Find().SetSort(bson.D{{"name", 1}}))
+	if err != nil {
+		return
+	}
+	err = cur.All(context.TODO(), &result)
+	return
+}
+
+// RestoreUser stores u with its password hash as is, replacing the user
+// of the same name
+func (db *DB) RestoreUser(u User) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	u.ID = primitive.NilObjectID
+	col := db.conn().Database("metawatch").Collection("users")
+	filter := bson.D{{"name", u.Name}}
+	_, err := col.ReplaceOne(context.TODO(), filter, u, options.Replace().SetUpsert(true))
+	return err
+}
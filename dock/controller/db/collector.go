@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/dock/collector"
+	"github.com/h0rzn/monitoring_agent/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertCollectorSet stores a set of a collector in
+// metawatch.collector_metrics
+func (db *DB) InsertCollectorSet(set collector.Set) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	ctx, span := tracing.Start(context.Background(), "db insert collector set", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("db.system", "mongodb")
+	span.SetAttr("db.mongodb.collection", "collector_metrics")
+
+	col := db.conn().Database("metawatch").Collection("collector_metrics")
+	_, err := col.InsertOne(ctx, set)
+	span.SetError(err)
+	return err
+}
+
+// CollectorSets returns the sets of the collector name between from and
+// to, oldest first
+func (db *DB) CollectorSets(name string, from, to time.Time) ([]collector.Set, error) {
+	sets := make([]collector.Set, 0)
+	if !db.Connected() {
+		return sets, ErrNotConnected
+	}
+	filter := bson.D{
+		{Key: "collector", Value: name},
+		{Key: "when", Value: bson.D{
+			{Key: "$gte", Value: primitive.NewDateTimeFromTime(from)},
+			{Key: "$lte", Value: primitive.NewDateTimeFromTime(to)},
+		}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "when", Value: 1}})
+	ctx := context.Background()
+	curs, err := db.conn().Database("metawatch").Collection("collector_metrics").Find(ctx, filter, opts)
+	if err != nil {
+		return sets, err
+	}
+	err = curs.All(ctx, &sets)
+	return sets, err
+}
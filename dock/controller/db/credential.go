@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Credential of a registry, the secret is sealed by the controller's
+// keychain and never serialized to json
+type Credential struct {
+	Host   string `json:"host" bson:"host"`
+	User   string `json:"user" bson:"user"`
+	Secret []byte `json:"-" bson:"secret"`
+	// scrypt salt of the key Secret is sealed with
+	Salt    []byte    `json:"-" bson:"salt,omitempty"`
+	Updated time.Time `json:"updated" bson:"updated"`
+}
+
+// UpsertCredential stores the credential of its host, replacing an existing one
+func (db *DB) UpsertCredential(c Credential) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	c.Updated = time.Now()
+	col := db.conn().Database("metawatch").Collection("credentials")
+	filter := bson.D{{"host", c.Host}}
+	_, err := col.ReplaceOne(context.TODO(), filter, c, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (db *DB) RemoveCredential(host string) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("credentials")
+	res, err := col.DeleteOne(context.TODO(), bson.D{{"host", host}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount != 1 {
+		return errors.New("failed to delete nonexistent credential")
+	}
+	return nil
+}
+
+func (db *DB) Credential(host string) (c Credential, exists bool, err error) {
+	if !db.Connected() {
+		return c, false, nil
+	}
+	col := db.conn().Database("metawatch").Collection("credentials")
+	err = col.FindOne(context.TODO(), bson.D{{"host", host}}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return c, false, nil
+	}
+	return c, err == nil, err
+}
+
+func (db *DB) Credentials() (result []Credential, err error) {
+	result = make([]Credential, 0)
+	if !db.Connected() {
+		return result, nil
+	}
+	col := db.conn().Database("metawatch").Collection("credentials")
+	cur, err := col.Find(context.TODO(), bson.D{})
+	if err != nil {
+		return
+	}
+	err = cur.All(context.TODO(), &result)
+	return
+}
@@ -7,9 +7,11 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/h0rzn/monitoring_agent/tracing"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -25,53 +27,81 @@ const (
 
 type MetricsMod struct {
 	MongoID primitive.ObjectID `bson:"_id,omitempty"`
-	CID     string             `bson:"cid"`     // metadata field
-	When    primitive.DateTime `bson:"when"`    // time
+	CID     string             `bson:"cid"`  // metadata field
+	When    primitive.DateTime `bson:"when"` // time, read by docker
+	// time the agent wrote the document, later than when by the write latency
+	Written primitive.DateTime `bson:"written"`
+	// sequence per container within the epoch (agent start), gaps are
+	// lost sets, lower numbers after higher ones arrived late
+	Epoch   primitive.DateTime `bson:"epoch"`
+	Seq     uint64             `bson:"seq"`
 	Metrics metrics.Set        `bson:"metrics"` // actual data
 }
 
+// epoch of the sequence numbers of this agent run
+var epoch = primitive.NewDateTimeFromTime(time.Now())
+
 func NewMetricsMod(cid string, when primitive.DateTime, metrics metrics.Set) *MetricsMod {
 	return &MetricsMod{
 		CID:     cid,
 		When:    when,
+		Epoch:   epoch,
 		Metrics: metrics,
 	}
 }
 
+// ErrNotConnected is returned while no db is configured or reachable, the
+// agent keeps streaming without one
+var ErrNotConnected = errors.New("db not connected")
+
+// ErrNotConfigured is returned by Init if DB is unset
+var ErrNotConfigured = errors.New("no db configured")
+
 type DB struct {
-	Client *mongo.Client
+	mutex  *sync.RWMutex
+	client *mongo.Client
 	URI    string
+	status Status
+	// collections are created once the db was reached
+	schemeReady bool
 }
 
 func NewDB() *DB {
-	return &DB{}
+	return &DB{
+		mutex: &sync.RWMutex{},
+	}
 }
 
+// Init connects to the db at DB and keeps monitoring the connection, an
+// unreachable db is retried in the background. ErrNotConfigured if unset
 func (db *DB) Init() error {
 	logrus.Infoln("- DB - init...")
 
 	uri := os.Getenv("DB")
 	if uri == "" {
-		return errors.New("")
+		return ErrNotConfigured
 	}
 	db.URI = uri
+	db.mutex.Lock()
+	db.status.Configured = true
+	db.mutex.Unlock()
+	db.registerGauges()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(db.URI))
-	if err != nil {
-		return err
-	}
-	logrus.Info("- DB::Client - connection successful")
-	db.Client = client
-
-	err = db.InitScheme()
+	err := db.check()
+	go db.Monitor()
 	return err
 }
 
+// conn returns the client, check Connected before
+func (db *DB) conn() *mongo.Client {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.client
+}
+
 // InitScheme initiates collections
 func (db *DB) InitScheme() error {
-	dbc := db.Client.Database("metawatch")
+	dbc := db.conn().Database("metawatch")
 
 	// metawatch.metrics
 	tso := options.TimeSeries().SetTimeField("when").SetMetaField("cid")
@@ -85,6 +115,30 @@ func (db *DB) InitScheme() error {
 		logrus.Infoln("- DB - metawatch.metrics created")
 	}
 
+	// metawatch.metrics_rollup, averages written by the db_rollup task
+	tso = options.TimeSeries().SetTimeField("when").SetMetaField("cid").SetGranularity("hours")
+	opts = options.CreateCollection().SetTimeSeriesOptions(tso)
+	err = dbc.CreateCollection(context.TODO(), "metrics_rollup", opts)
+
+	e = &mongo.CommandError{}
+	if errors.As(err, e) && e.Code == 48 {
+		logrus.Infoln("- DB - metawatch.metrics_rollup found")
+	} else if err == nil {
+		logrus.Infoln("- DB - metawatch.metrics_rollup created")
+	}
+
+	// metawatch.collector_metrics, sets of the collectors
+	tso = options.TimeSeries().SetTimeField("when").SetMetaField("collector")
+	opts = options.CreateCollection().SetTimeSeriesOptions(tso)
+	err = dbc.CreateCollection(context.TODO(), "collector_metrics", opts)
+
+	e = &mongo.CommandError{}
+	if errors.As(err, e) && e.Code == 48 {
+		logrus.Infoln("- DB - metawatch.collector_metrics found")
+	} else if err == nil {
+		logrus.Infoln("- DB - metawatch.collector_metrics created")
+	}
+
 	// metawatch.users
 	opts = &options.CreateCollectionOptions{}
 	err = dbc.CreateCollection(context.TODO(), "users", opts)
@@ -96,14 +150,47 @@ func (db *DB) InitScheme() error {
 		logrus.Infoln("- DB - metawatch.users created")
 	}
 
+	// metawatch.credentials
+	err = dbc.CreateCollection(context.TODO(), "credentials", opts)
+
+	e = &mongo.CommandError{}
+	if errors.As(err, e) && e.Code == 48 {
+		logrus.Infoln("- DB - metawatch.credentials found")
+	} else if err == nil {
+		logrus.Infoln("- DB - metawatch.credentials created")
+	}
+
+	// metawatch.audit
+	err = dbc.CreateCollection(context.TODO(), "audit", opts)
+
+	e = &mongo.CommandError{}
+	if errors.As(err, e) && e.Code == 48 {
+		logrus.Infoln("- DB - metawatch.audit found")
+	} else if err == nil {
+		logrus.Infoln("- DB - metawatch.audit created")
+	}
+
+	// metawatch.views
+	err = dbc.CreateCollection(context.TODO(), "views", opts)
+
+	e = &mongo.CommandError{}
+	if errors.As(err, e) && e.Code == 48 {
+		logrus.Infoln("- DB - metawatch.views found")
+	} else if err == nil {
+		logrus.Infoln("- DB - metawatch.views created")
+	}
+
 	return nil
 }
 
 func (db *DB) InsertUser(u User) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
 	u.HashPassword()
 	u.SetCreated()
 
-	col := db.Client.Database("metawatch").Collection("users")
+	col := db.conn().Database("metawatch").Collection("users")
 
 	filter := bson.D{{"name", u.Name}}
 	err := col.FindOne(context.TODO(), filter).Decode(&User{})
@@ -122,7 +209,10 @@ func (db *DB) InsertUser(u User) error {
 }
 
 func (db *DB) RemoveUser(id string) error {
-	col := db.Client.Database("metawatch").Collection("users")
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("users")
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -150,13 +240,16 @@ func (db *DB) UpdateUser(update map[string]string, id string) (map[string]interf
 	status := make(map[string]bool)
 	result := make(map[string]interface{})
 
+	if !db.Connected() {
+		return nil, ErrNotConnected
+	}
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, errors.New("id cant be parsed")
 	}
 
 	filter := bson.D{{"_id", objID}}
-	col := db.Client.Database("metawatch").Collection("users")
+	col := db.conn().Database("metawatch").Collection("users")
 	err = col.FindOne(context.TODO(), filter).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -206,7 +299,10 @@ func (db *DB) UpdateUser(update map[string]string, id string) (map[string]interf
 }
 
 func (db *DB) GetUsers() (result []User, err error) {
-	col := db.Client.Database("metawatch").Collection("users")
+	if !db.Connected() {
+		return nil, ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("users")
 	cur, err := col.Find(context.TODO(), bson.D{})
 	if err != nil {
 		return
@@ -242,7 +338,12 @@ func (db *DB) Metrics(cid string, tmin primitive.DateTime, tmax primitive.DateTi
 
 	// add group stage to only get $when and $metrics
 
-	col := db.Client.Database("metawatch").Collection("metrics")
+	out := make(map[string][]metrics.Set)
+	out[cid] = make([]metrics.Set, 0)
+	if !db.Connected() {
+		return out
+	}
+	col := db.conn().Database("metawatch").Collection("metrics")
 	ctx := context.Background()
 	curs, err := col.Aggregate(ctx, mongo.Pipeline{match})
 
@@ -254,8 +355,6 @@ func (db *DB) Metrics(cid string, tmin primitive.DateTime, tmax primitive.DateTi
 		logrus.Errorf("- DB - metrics aggregation err (getting all from cursor): %s", err)
 	}
 
-	out := make(map[string][]metrics.Set)
-	out[cid] = make([]metrics.Set, 0)
 	logrus.Debugf("- DB - aggregated %d sets\n", len(result))
 
 	for _, prim := range result {
@@ -267,24 +366,71 @@ func (db *DB) Metrics(cid string, tmin primitive.DateTime, tmax primitive.DateTi
 	return out
 }
 
-func (db *DB) InsertManyMetrics(data []interface{}) {
+// EachMetric calls fn with the stored metrics between from and to, ordered
+// by container and time
+func (db *DB) EachMetric(ctx context.Context, from, to time.Time, fn func(MetricsMod) error) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	filter := bson.D{{Key: "when", Value: bson.D{
+		{Key: "$gte", Value: primitive.NewDateTimeFromTime(from)},
+		{Key: "$lt", Value: primitive.NewDateTimeFromTime(to)},
+	}}}
+	opts := options.Find().SetSort(bson.D{{Key: "cid", Value: 1}, {Key: "when", Value: 1}})
+	curs, err := db.conn().Database("metawatch").Collection("metrics").Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer curs.Close(ctx)
+	for curs.Next(ctx) {
+		var mod MetricsMod
+		if err = curs.Decode(&mod); err != nil {
+			return err
+		}
+		if err = fn(mod); err != nil {
+			return err
+		}
+	}
+	return curs.Err()
+}
+
+func (db *DB) InsertManyMetrics(data []interface{}) error {
 	if len(data) == 0 {
-		return
+		return nil
+	}
+	if !db.Connected() {
+		return ErrNotConnected
 	}
 
-	col := db.Client.Database("metawatch").Collection("metrics")
-	ctx := context.Background()
+	ctx, span := tracing.Start(context.Background(), "db insert metrics", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("db.system", "mongodb")
+	span.SetAttr("db.mongodb.collection", "metrics")
+	span.SetAttr("db.documents", len(data))
+
+	written := primitive.NewDateTimeFromTime(time.Now())
+	for _, item := range data {
+		if mod, ok := item.(*MetricsMod); ok {
+			mod.Written = written
+		}
+	}
+	col := db.conn().Database("metawatch").Collection("metrics")
 	res, err := col.InsertMany(ctx, data)
 	if err != nil {
-		logrus.Errorf("- DB - bulk write err:", err)
-		return
+		span.SetError(err)
+		logrus.Errorf("- DB - bulk write err: %s\n", err)
+		return err
 	}
 	logrus.Infof("- DB - sucessful insert of %d metric entries\n", len(res.InsertedIDs))
+	return nil
 }
 
 func (db *DB) HashByUser(username string) (bool, []byte) {
 	var user User
-	col := db.Client.Database("metawatch").Collection("users")
+	if !db.Connected() {
+		return false, []byte{}
+	}
+	col := db.conn().Database("metawatch").Collection("users")
 	filter := bson.D{{"name", username}}
 	err := col.FindOne(context.TODO(), filter).Decode(&user)
 	if err == mongo.ErrNoDocuments {
@@ -301,6 +447,36 @@ func (db *DB) PasswordCorrect(username, password string) bool {
 	return false
 }
 
+// UserScope returns the label selector the user is restricted to, empty if unrestricted
+func (db *DB) UserScope(username string) string {
+	var user User
+	if !db.Connected() {
+		return ""
+	}
+	col := db.conn().Database("metawatch").Collection("users")
+	filter := bson.D{{"name", username}}
+	err := col.FindOne(context.TODO(), filter).Decode(&user)
+	if err != nil {
+		return ""
+	}
+	return user.Scope
+}
+
+// UserRole returns the role of the user, RoleViewer if none is set
+func (db *DB) UserRole(username string) string {
+	var user User
+	if !db.Connected() {
+		return RoleViewer
+	}
+	col := db.conn().Database("metawatch").Collection("users")
+	filter := bson.D{{"name", username}}
+	err := col.FindOne(context.TODO(), filter).Decode(&user)
+	if err != nil || user.Role == "" {
+		return RoleViewer
+	}
+	return user.Role
+}
+
 func (db *DB) UserExists(username string) bool {
 	users, err := db.GetUsers()
 	if err != nil {
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// default interval of the connection check
+	defaultPingInterval = 15 * time.Second
+	// time a connect or ping may take
+	pingTimeout = 5 * time.Second
+)
+
+// Status describes the connection to the db
+type Status struct {
+	Configured bool       `json:"configured"`
+	Connected  bool       `json:"connected"`
+	LastPing   *time.Time `json:"last_ping,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	// times the connection came back after it was lost
+	Reconnects int `json:"reconnects"`
+}
+
+// Connected reports whether the last check reached the db
+func (db *DB) Connected() bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.status.Connected
+}
+
+// Status returns the state of the connection
+func (db *DB) Status() Status {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.status
+}
+
+// Monitor checks the connection every DB_PING_INTERVAL, a lost or never
+// established connection is retried
+func (db *DB) Monitor() {
+	ticker := time.NewTicker(config.Duration("DB_PING_INTERVAL", defaultPingInterval))
+	for range ticker.C {
+		db.check()
+	}
+}
+
+// check connects if there is no client yet and pings the db. The scheme
+// is created on the first successful ping
+func (db *DB) check() error {
+	client := db.conn()
+	if client == nil {
+		var err error
+		client, err = db.connect()
+		if err != nil {
+			db.failed(err)
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		db.failed(err)
+		return err
+	}
+
+	db.mutex.Lock()
+	now := time.Now()
+	restored := !db.status.Connected && db.status.LastPing != nil
+	db.status.Connected = true
+	db.status.LastPing = &now
+	db.status.LastError = ""
+	if restored {
+		db.status.Reconnects++
+	}
+	initScheme := !db.schemeReady
+	db.schemeReady = true
+	db.mutex.Unlock()
+
+	if restored {
+		logrus.Infoln("- DB - connection restored")
+	} else if initScheme {
+		logrus.Infoln("- DB - connection successful")
+	}
+	if initScheme {
+		return db.InitScheme()
+	}
+	return nil
+}
+
+// connect creates the client, the driver reconnects it on its own from
+// then on
+func (db *DB) connect() (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(db.URI))
+	if err != nil {
+		return nil, err
+	}
+	db.mutex.Lock()
+	db.client = client
+	db.mutex.Unlock()
+	return client, nil
+}
+
+// failed marks the db unreachable, the loss is logged once
+func (db *DB) failed(err error) {
+	db.mutex.Lock()
+	lost := db.status.Connected
+	db.status.Connected = false
+	db.status.LastError = err.Error()
+	db.mutex.Unlock()
+	if lost {
+		logrus.Errorf("- DB - connection lost: %s\n", err)
+	} else {
+		logrus.Debugf("- DB - not reachable: %s\n", err)
+	}
+}
+
+func (db *DB) registerGauges() {
+	selfmetrics.Register("db_connected", func() float64 {
+		if db.Connected() {
+			return 1
+		}
+		return 0
+	})
+	selfmetrics.Register("db_reconnects", func() float64 {
+		return float64(db.Status().Reconnects)
+	})
+}
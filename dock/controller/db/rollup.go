@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rollups cover at most this span per run, the first run starts with it
+const rollupSpan = 24 * time.Hour
+
+// Rollup averages the metrics of every container per resolution into
+// metawatch.metrics_rollup, continuing after the latest rollup. Only
+// completed intervals are rolled up, the number of documents is returned
+func (db *DB) Rollup(ctx context.Context, resolution time.Duration) (int, error) {
+	if !db.Connected() {
+		return 0, ErrNotConnected
+	}
+	dbc := db.conn().Database("metawatch")
+	rollups := dbc.Collection("metrics_rollup")
+
+	to := time.Now().Truncate(resolution)
+	from := to.Add(-rollupSpan)
+	var latest MetricsMod
+	err := rollups.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "when", Value: -1}})).Decode(&latest)
+	if err == nil && latest.When.Time().Add(resolution).After(from) {
+		from = latest.When.Time().Add(resolution)
+	}
+	if !from.Before(to) {
+		return 0, nil
+	}
+
+	window := bson.D{
+		{Key: "$gte", Value: primitive.NewDateTimeFromTime(from)},
+		{Key: "$lt", Value: primitive.NewDateTimeFromTime(to)},
+	}
+	raw := dbc.Collection("metrics")
+	cids, err := raw.Distinct(ctx, "cid", bson.D{{Key: "when", Value: window}})
+	if err != nil {
+		return 0, err
+	}
+
+	written := primitive.NewDateTimeFromTime(time.Now())
+	docs := make([]interface{}, 0)
+	for _, v := range cids {
+		cid, ok := v.(string)
+		if !ok {
+			continue
+		}
+		curs, err := raw.Find(ctx, bson.D{{Key: "cid", Value: cid}, {Key: "when", Value: window}},
+			options.Find().SetSort(bson.D{{Key: "when", Value: 1}}))
+		if err != nil {
+			return 0, err
+		}
+		var mods []MetricsMod
+		if err = curs.All(ctx, &mods); err != nil {
+			return 0, err
+		}
+
+		// sets are sorted, every bucket is a consecutive run
+		var bucket []metrics.Set
+		var start time.Time
+		flush := func() {
+			if len(bucket) == 0 {
+				return
+			}
+			avg := metrics.Average(bucket)
+			docs = append(docs, &MetricsMod{
+				CID:     cid,
+				When:    primitive.NewDateTimeFromTime(start),
+				Written: written,
+				Epoch:   epoch,
+				Metrics: avg,
+			})
+			bucket = nil
+		}
+		for _, mod := range mods {
+			when := mod.When.Time().Truncate(resolution)
+			if !when.Equal(start) {
+				flush()
+				start = when
+			}
+			bucket = append(bucket, mod.Metrics)
+		}
+		flush()
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+	_, err = rollups.InsertMany(ctx, docs)
+	if err != nil {
+		return 0, err
+	}
+	logrus.Infof("- DB - rolled up %s-%s into %d documents\n", from.Format(time.RFC3339), to.Format(time.RFC3339), len(docs))
+	return len(docs), nil
+}
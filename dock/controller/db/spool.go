@@ -0,0 +1,170 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const spoolExt = ".bson"
+
+// SpoolStats counts the metric batches kept on disk while the db is down
+type SpoolStats struct {
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Spooled  uint64 `json:"spooled"`
+	Replayed uint64 `json:"replayed"`
+	Evicted  uint64 `json:"evicted"`
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// spooled is the document of a spool file
+type spooled struct {
+	Metrics []MetricsMod `bson:"metrics"`
+}
+
+// Spool keeps metric batches the db could not take in files below dir,
+// bounded by max bytes. The oldest files are evicted first
+type Spool struct {
+	mutex *sync.Mutex
+	dir   string
+	max   int64
+	// oldest first
+	files []spoolFile
+	stats SpoolStats
+}
+
+// NewSpool opens the spool at dir, batches left by a previous run are kept
+// for replay
+func NewSpool(dir string, max int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		mutex: &sync.Mutex{},
+		dir:   dir,
+		max:   max,
+		files: make([]spoolFile, 0),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, spoolFile{name: entry.Name(), size: info.Size()})
+		s.stats.Bytes += info.Size()
+	}
+	// names are nano timestamps of equal length
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].name < s.files[j].name
+	})
+	s.stats.Files = len(s.files)
+	if len(s.files) > 0 {
+		logrus.Infof("- DB - spool holds %d batches from a previous run\n", len(s.files))
+	}
+	return s, nil
+}
+
+// Store writes batch to a new spool file, evicting the oldest files
+// exceeding the size limit
+func (s *Spool) Store(batch []interface{}) error {
+	data, err := bson.Marshal(bson.M{"metrics": batch})
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if size > s.max {
+		return fmt.Errorf("batch of %d bytes exceeds the spool size", size)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.files) > 0 && s.stats.Bytes+size > s.max {
+		s.remove(0)
+		s.stats.Evicted++
+	}
+	name := fmt.Sprintf("%019d%s", time.Now().UnixNano(), spoolExt)
+	if err := os.WriteFile(filepath.Join(s.dir, name), data, 0600); err != nil {
+		return err
+	}
+	s.files = append(s.files, spoolFile{name: name, size: size})
+	s.stats.Bytes += size
+	s.stats.Files = len(s.files)
+	s.stats.Spooled++
+	return nil
+}
+
+// Oldest returns the oldest spooled batch and its name, false if the spool
+// is empty. Unreadable files are dropped
+func (s *Spool) Oldest() (string, []interface{}, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.files) > 0 {
+		f := s.files[0]
+		data, err := os.ReadFile(filepath.Join(s.dir, f.name))
+		var doc spooled
+		if err == nil {
+			err = bson.Unmarshal(data, &doc)
+		}
+		if err != nil {
+			logrus.Errorf("- DB - dropping unreadable spool file %s: %s\n", f.name, err)
+			s.remove(0)
+			continue
+		}
+		batch := make([]interface{}, 0, len(doc.Metrics))
+		for i := range doc.Metrics {
+			batch = append(batch, &doc.Metrics[i])
+		}
+		return f.name, batch, true
+	}
+	return "", nil, false
+}
+
+// Replayed removes the spool file name after its batch was written
+func (s *Spool) Replayed(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, f := range s.files {
+		if f.name == name {
+			s.remove(i)
+			s.stats.Replayed++
+			return
+		}
+	}
+}
+
+// remove deletes the i-th file, the lock is held by the caller
+func (s *Spool) remove(i int) {
+	f := s.files[i]
+	if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("- DB - failed to remove spool file %s: %s\n", f.name, err)
+	}
+	s.files = append(s.files[:i], s.files[i+1:]...)
+	s.stats.Bytes -= f.size
+	s.stats.Files = len(s.files)
+}
+
+// Stats returns a copy of the spool counters
+func (s *Spool) Stats() SpoolStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
@@ -12,6 +12,24 @@ type User struct {
 	Name     string             `json:"name" binding:"required" bson:"name"`
 	Created  time.Time          `json:"created" bson:"created"`
 	Password string             `json:"password,omitempty" binding:"required" bson:"password"`
+	// label selector (e.g. "team=payments") restricting the visible containers
+	Scope string `json:"scope,omitempty" bson:"scope,omitempty"`
+	// viewer if empty, operators may change the swarm
+	Role string `json:"role,omitempty" bson:"role,omitempty"`
+}
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+)
+
+// ValidRole reports whether role is a known role, empty is a viewer
+func ValidRole(role string) bool {
+	switch role {
+	case "", RoleViewer, RoleOperator:
+		return true
+	}
+	return false
 }
 
 func (u *User) HashPassword() error {
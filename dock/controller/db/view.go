@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// View is a named set of hub subscriptions of a user, activated on the
+// websocket with a single message
+type View struct {
+	Owner         string             `json:"-" bson:"owner"`
+	Name          string             `json:"name" bson:"name"`
+	Subscriptions []ViewSubscription `json:"subscriptions" bson:"subscriptions"`
+	Updated       time.Time          `json:"updated" bson:"updated"`
+}
+
+// ViewSubscription mirrors the options of a hub subscribe demand
+type ViewSubscription struct {
+	CID       string              `json:"container_id" bson:"container_id"`
+	Type      string              `json:"type" bson:"type"`
+	Filters   map[string][]string `json:"filters,omitempty" bson:"filters,omitempty"`
+	Where     string              `json:"where,omitempty" bson:"where,omitempty"`
+	Interval  string              `json:"interval,omitempty" bson:"interval,omitempty"`
+	Aggregate string              `json:"aggregate,omitempty" bson:"aggregate,omitempty"`
+}
+
+// UpsertView stores the view of its owner, replacing one with the same name
+func (db *DB) UpsertView(v View) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	v.Updated = time.Now()
+	col := db.conn().Database("metawatch").Collection("views")
+	filter := bson.D{{"owner", v.Owner}, {"name", v.Name}}
+	_, err := col.ReplaceOne(context.TODO(), filter, v, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (db *DB) RemoveView(owner, name string) error {
+	if !db.Connected() {
+		return ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("views")
+	res, err := col.DeleteOne(context.TODO(), bson.D{{"owner", owner}, {"name", name}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount != 1 {
+		return errors.New("failed to delete nonexistent view")
+	}
+	return nil
+}
+
+func (db *DB) View(owner, name string) (v View, exists bool, err error) {
+	if !db.Connected() {
+		return v, false, ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("views")
+	err = col.FindOne(context.TODO(), bson.D{{"owner", owner}, {"name", name}}).Decode(&v)
+	if err == mongo.ErrNoDocuments {
+		return v, false, nil
+	}
+	return v, err == nil, err
+}
+
+// Views lists the views of owner
+func (db *DB) Views(owner string) (result []View, err error) {
+	result = make([]View, 0)
+	if !db.Connected() {
+		return result, ErrNotConnected
+	}
+	col := db.conn().Database("metawatch").Collection("views")
+	opts := options.Find().SetSort(bson.D{{"name", 1}})
+	cur, err := col.Find(context.TODO(), bson.D{{"owner", owner}}, opts)
+	if err != nil {
+		return
+	}
+	err = cur.All(context.TODO(), &result)
+	return
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// default interval of the system df refresh, the call is slow on large hosts
+const defaultDFInterval = 5 * time.Minute
+
+type Usage struct {
+	Count       int   `json:"count"`
+	Active      int   `json:"active"`
+	Size        int64 `json:"size"`
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// DiskUsage is the `docker system df` summary
+type DiskUsage struct {
+	mutex      *sync.RWMutex
+	Updated    time.Time `json:"updated"`
+	Images     Usage     `json:"images"`
+	Containers Usage     `json:"containers"`
+	Volumes    Usage     `json:"volumes"`
+	BuildCache Usage     `json:"build_cache"`
+}
+
+func NewDiskUsage() *DiskUsage {
+	return &DiskUsage{
+		mutex: &sync.RWMutex{},
+	}
+}
+
+// Snapshot returns a copy safe to serialize while refreshing
+func (du *DiskUsage) Snapshot() DiskUsage {
+	du.mutex.RLock()
+	defer du.mutex.RUnlock()
+	return *du
+}
+
+func (du *DiskUsage) set(raw types.DiskUsage) {
+	var images, containers, volumes, cache Usage
+
+	// shared layers are only counted once
+	images.Size = raw.LayersSize
+	for _, img := range raw.Images {
+		images.Count++
+		if img.Containers > 0 {
+			images.Active++
+		} else {
+			images.Reclaimable += img.Size - img.SharedSize
+		}
+	}
+
+	for _, c := range raw.Containers {
+		containers.Count++
+		containers.Size += c.SizeRw
+		if c.State == "running" {
+			containers.Active++
+		} else {
+			containers.Reclaimable += c.SizeRw
+		}
+	}
+
+	for _, v := range raw.Volumes {
+		volumes.Count++
+		if v.UsageData == nil || v.UsageData.Size < 0 {
+			continue
+		}
+		volumes.Size += v.UsageData.Size
+		if v.UsageData.RefCount > 0 {
+			volumes.Active++
+		} else {
+			volumes.Reclaimable += v.UsageData.Size
+		}
+	}
+
+	for _, bc := range raw.BuildCache {
+		cache.Count++
+		if !bc.Shared {
+			cache.Size += bc.Size
+		}
+		if bc.InUse {
+			cache.Active++
+		} else if !bc.Shared {
+			cache.Reclaimable += bc.Size
+		}
+	}
+
+	du.mutex.Lock()
+	du.Updated = time.Now()
+	du.Images = images
+	du.Containers = containers
+	du.Volumes = volumes
+	du.BuildCache = cache
+	du.mutex.Unlock()
+}
+
+// UpdateDiskUsage refreshes disk usage and volumes with a single df call
+func (ctr *Controller) UpdateDiskUsage() error {
+	ctx := context.Background()
+	du, err := ctr.c.DiskUsage(ctx)
+	if err != nil {
+		return err
+	}
+	ctr.DiskUsage.set(du)
+	ctr.setVolumes(du)
+	return nil
+}
+
+// RefreshDiskUsage updates disk usage every DF_INTERVAL
+func (ctr *Controller) RefreshDiskUsage() {
+	interval := config.Duration("DF_INTERVAL", defaultDFInterval)
+	logrus.Infof("- CONTROLLER - refreshing disk usage every %s\n", interval)
+
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		err := ctr.UpdateDiskUsage()
+		if err != nil {
+			logrus.Warnf("- CONTROLLER - disk usage refresh failed: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/dock/logs"
+	"github.com/h0rzn/monitoring_agent/dock/metrics"
+	"github.com/h0rzn/monitoring_agent/s3"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// default key prefix of the exported archives
+const defaultExportPrefix = "metawatch"
+
+// newExportClient creates the client of the EXPORT_S3_* bucket
+func newExportClient() (*s3.Client, error) {
+	client, err := s3.NewClient(
+		config.String("EXPORT_S3_ENDPOINT", ""),
+		config.String("EXPORT_S3_REGION", "us-east-1"),
+		config.String("EXPORT_S3_BUCKET", ""),
+	)
+	if err != nil {
+		return nil, err
+	}
+	client.AccessKey = config.String("EXPORT_S3_ACCESS_KEY", "")
+	client.SecretKey = config.String("EXPORT_S3_SECRET_KEY", "")
+	client.SessionToken = config.String("EXPORT_S3_SESSION_TOKEN", "")
+	client.PathStyle = config.Bool("EXPORT_S3_PATH_STYLE", true)
+	return client, nil
+}
+
+// ExportedMetric is a line of the metrics archive
+type ExportedMetric struct {
+	CID     string             `json:"cid"`
+	Written primitive.DateTime `json:"written"`
+	Epoch   primitive.DateTime `json:"epoch"`
+	Seq     uint64             `json:"seq"`
+	Metrics metrics.Set        `json:"metrics"`
+}
+
+// archive is a gzip compressed json lines object
+type archive struct {
+	buf   *bytes.Buffer
+	zw    *gzip.Writer
+	enc   *json.Encoder
+	lines int
+}
+
+func newArchive() *archive {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	return &archive{buf: buf, zw: zw, enc: json.NewEncoder(zw)}
+}
+
+func (a *archive) add(v interface{}) error {
+	a.lines++
+	return a.enc.Encode(v)
+}
+
+func (a *archive) bytes() ([]byte, error) {
+	if err := a.zw.Close(); err != nil {
+		return nil, err
+	}
+	return a.buf.Bytes(), nil
+}
+
+// export uploads the metrics and logs of the previous day as
+// <prefix>/<host>/<day>/metrics.ndjson.gz and logs/<container>.ndjson.gz
+func (ctr *Controller) export(ctx context.Context) (string, error) {
+	client, err := newExportClient()
+	if err != nil {
+		return "", err
+	}
+	to := time.Now()
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location())
+	from := to.AddDate(0, 0, -1)
+	host := ctr.About.Snapshot().Name
+	if host == "" {
+		host = "agent"
+	}
+	dir := path.Join(config.String("EXPORT_PREFIX", defaultExportPrefix), host, from.Format("2006-01-02"))
+
+	var errs []string
+	uploaded := 0
+	upload := func(key string, a *archive) {
+		body, err := a.bytes()
+		if err == nil {
+			err = client.Put(ctx, key, body, "application/gzip")
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+		uploaded++
+	}
+
+	// metrics need the db, the in memory history does not reach back a day
+	metricsArchive := newArchive()
+	err = ctr.DB.EachMetric(ctx, from, to, func(mod db.MetricsMod) error {
+		set := mod.Metrics
+		set.When = mod.When
+		return metricsArchive.add(ExportedMetric{
+			CID:     mod.CID,
+			Written: mod.Written,
+			Epoch:   mod.Epoch,
+			Seq:     mod.Seq,
+			Metrics: set,
+		})
+	})
+	switch {
+	case errors.Is(err, db.ErrNotConnected):
+		logrus.Warnln("- EXPORT - no db connected, skipping metrics")
+	case err != nil:
+		errs = append(errs, "metrics: "+err.Error())
+	default:
+		upload(path.Join(dir, "metrics.ndjson.gz"), metricsArchive)
+	}
+
+	// logs of the containers the daemon still knows
+	for _, c := range ctr.Containers.Items() {
+		logArchive := newArchive()
+		err := logs.Range(ctx, ctr.c, c.ID, from, to, func(e *logs.Entry) error {
+			return logArchive.add(e)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("logs of %s: %s", c.Name, err))
+			continue
+		}
+		if logArchive.lines == 0 {
+			continue
+		}
+		upload(path.Join(dir, "logs", strings.TrimPrefix(c.Name, "/")+".ndjson.gz"), logArchive)
+	}
+
+	result := fmt.Sprintf("%d objects uploaded to %s", uploaded, dir)
+	if len(errs) > 0 {
+		return result, errors.New(strings.Join(errs, "; "))
+	}
+	return result, nil
+}
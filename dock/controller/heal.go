@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dock_events "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// default label opting containers in (=true) or out (=false)
+	defaultHealLabel = "autoheal"
+	// default restarts of an unhealthy container before giving up,
+	// counted until it is healthy again
+	defaultHealMaxRestarts = 3
+	// default minimum time between two restarts of a container
+	defaultHealCooldown = 5 * time.Minute
+	// default time the container is given to stop on restart
+	defaultHealStopTimeout = 10 * time.Second
+)
+
+// HealState is the auto-heal record of a container
+type HealState struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Unhealthy   bool      `json:"unhealthy"`
+	Restarts    int       `json:"restarts"`
+	LastRestart time.Time `json:"last_restart"`
+	LastError   string    `json:"last_error,omitempty"`
+	// max restarts reached, waiting for the container to turn healthy
+	GaveUp bool `json:"gave_up"`
+	// a restart is due once the cooldown passed
+	pending bool
+}
+
+// Healer restarts containers reporting unhealthy. Containers are picked by
+// label (or all with AUTOHEAL_ALL), the labels <label>.max_restarts and
+// <label>.cooldown override the defaults per container
+type Healer struct {
+	mutex       *sync.Mutex
+	c           *client.Client
+	db          *db.DB
+	label       string
+	all         bool
+	maxRestarts int
+	cooldown    time.Duration
+	stopTimeout time.Duration
+	states      map[string]*HealState
+}
+
+func newHealer(c *client.Client, database *db.DB) *Healer {
+	return &Healer{
+		mutex:       &sync.Mutex{},
+		c:           c,
+		db:          database,
+		label:       config.String("AUTOHEAL_LABEL", defaultHealLabel),
+		all:         config.Bool("AUTOHEAL_ALL", false),
+		maxRestarts: config.Int("AUTOHEAL_MAX_RESTARTS", defaultHealMaxRestarts),
+		cooldown:    config.Duration("AUTOHEAL_COOLDOWN", defaultHealCooldown),
+		stopTimeout: config.Duration("AUTOHEAL_STOP_TIMEOUT", defaultHealStopTimeout),
+		states:      make(map[string]*HealState),
+	}
+}
+
+// policy returns whether the container with labels is healed and its
+// max restarts and cooldown
+func (h *Healer) policy(labels map[string]string) (bool, int, time.Duration) {
+	enabled := h.all
+	if v, ok := labels[h.label]; ok {
+		enabled, _ = strconv.ParseBool(v)
+	}
+	maxRestarts, cooldown := h.maxRestarts, h.cooldown
+	if v, err := strconv.Atoi(labels[h.label+".max_restarts"]); err == nil && v >= 0 {
+		maxRestarts = v
+	}
+	if v, err := time.ParseDuration(labels[h.label+".cooldown"]); err == nil && v >= 0 {
+		cooldown = v
+	}
+	return enabled, maxRestarts, cooldown
+}
+
+// Handle takes the health and destroy events of containers, it does not
+// block the event handler
+func (h *Healer) Handle(e dock_events.Message) {
+	if e.Status == "destroy" {
+		h.mutex.Lock()
+		delete(h.states, e.ID)
+		h.mutex.Unlock()
+		return
+	}
+	enabled, maxRestarts, cooldown := h.policy(e.Actor.Attributes)
+	if !enabled {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	state, exists := h.states[e.ID]
+	if !exists {
+		state = &HealState{ID: e.ID, Name: e.Actor.Attributes["name"]}
+		h.states[e.ID] = state
+	}
+	switch strings.TrimSpace(strings.TrimPrefix(e.Status, "health_status:")) {
+	case types.Healthy:
+		state.Unhealthy = false
+		state.Restarts = 0
+		state.GaveUp = false
+	case types.Unhealthy:
+		state.Unhealthy = true
+		h.schedule(state, maxRestarts, cooldown)
+	}
+}
+
+// schedule restarts the container of state now or after the cooldown,
+// docker reports unhealthy once, so a skipped restart is rechecked
+func (h *Healer) schedule(state *HealState, maxRestarts int, cooldown time.Duration) {
+	if state.pending || state.GaveUp {
+		return
+	}
+	if state.Restarts >= maxRestarts {
+		state.GaveUp = true
+		logrus.Warnf("- HEAL - %s still unhealthy after %d restarts, giving up\n", state.Name, state.Restarts)
+		h.audit(state, "GIVE_UP", fmt.Sprintf("still unhealthy after %d restarts", state.Restarts), nil)
+		return
+	}
+	state.pending = true
+	wait := time.Until(state.LastRestart.Add(cooldown))
+	if wait < 0 {
+		wait = 0
+	}
+	time.AfterFunc(wait, func() {
+		h.restart(state.ID, maxRestarts, cooldown)
+	})
+}
+
+func (h *Healer) restart(id string, maxRestarts int, cooldown time.Duration) {
+	h.mutex.Lock()
+	state, exists := h.states[id]
+	if !exists {
+		h.mutex.Unlock()
+		return
+	}
+	state.pending = false
+	if !state.Unhealthy {
+		h.mutex.Unlock()
+		return
+	}
+	state.Restarts++
+	state.LastRestart = time.Now()
+	detail := fmt.Sprintf("unhealthy, restart %d/%d", state.Restarts, maxRestarts)
+	name := state.Name
+	h.mutex.Unlock()
+
+	logrus.Infof("- HEAL - restarting %s: %s\n", name, detail)
+	timeout := h.stopTimeout
+	err := h.c.ContainerRestart(context.Background(), id, &timeout)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	state.LastError = ""
+	if err != nil {
+		state.LastError = err.Error()
+		logrus.Errorf("- HEAL - restart of %s failed: %s\n", name, err)
+		// no health event follows a failed restart
+		h.schedule(state, maxRestarts, cooldown)
+	}
+	h.audit(state, "RESTART", detail, err)
+}
+
+// audit records an action in the audit log next to the api's control actions
+func (h *Healer) audit(state *HealState, action string, detail string, err error) {
+	entry := db.AuditEntry{
+		When:   time.Now(),
+		User:   "autoheal",
+		Method: action,
+		Route:  "autoheal",
+		Target: state.ID,
+		Detail: detail,
+		Status: http.StatusOK,
+	}
+	if err != nil {
+		entry.Status = http.StatusBadGateway
+		entry.Error = err.Error()
+	}
+	go func() {
+		if err := h.db.InsertAudit(entry); err != nil {
+			logrus.Warnf("- HEAL - failed to write audit entry: %s\n", err)
+		}
+	}()
+}
+
+// States returns the records of the containers that reported their health
+func (h *Healer) States() []HealState {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	states := make([]HealState, 0, len(h.states))
+	for _, state := range h.states {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
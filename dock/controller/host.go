@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+)
+
+// Endpoint describes how to reach a docker daemon. An empty Host
+// falls back to DOCKER_HOST and socket discovery
+type Endpoint struct {
+	Host   string `json:"host"`
+	CACert string `json:"ca_cert"`
+	Cert   string `json:"cert"`
+	Key    string `json:"key"`
+}
+
+// EndpointFromEnv reads the endpoint from DOCKER_ENDPOINT and
+// DOCKER_TLS_CA, DOCKER_TLS_CERT, DOCKER_TLS_KEY (file paths)
+func EndpointFromEnv() Endpoint {
+	return Endpoint{
+		Host:   os.Getenv("DOCKER_ENDPOINT"),
+		CACert: os.Getenv("DOCKER_TLS_CA"),
+		Cert:   os.Getenv("DOCKER_TLS_CERT"),
+		Key:    os.Getenv("DOCKER_TLS_KEY"),
+	}
+}
+
+// TLS reports whether client certificate material is configured
+func (ep Endpoint) TLS() bool {
+	return ep.CACert != "" || ep.Cert != "" || ep.Key != ""
+}
+
+// Opts returns the docker client options for the endpoint
+func (ep Endpoint) Opts() ([]client.Opt, error) {
+	opts := []client.Opt{client.FromEnv}
+
+	if ep.Host != "" {
+		opts = append(opts, client.WithHost(ep.Host))
+	} else if host, found := DiscoverHost(); found {
+		opts = append(opts, client.WithHost(host))
+	}
+
+	if ep.TLS() {
+		if (ep.Cert == "") != (ep.Key == "") {
+			return opts, errors.New("tls cert and key have to be set together")
+		}
+		opts = append(opts, client.WithTLSClientConfig(ep.CACert, ep.Cert, ep.Key))
+	}
+	return opts, nil
+}
+
+// DiscoverHost looks for a daemon socket if DOCKER_HOST is not set.
+// Rootless daemons listen on $XDG_RUNTIME_DIR/docker.sock instead of
+// the system wide /var/run/docker.sock. Windows daemons are reached
+// through the named pipe of client.DefaultDockerHost
+func DiscoverHost() (string, bool) {
+	if os.Getenv("DOCKER_HOST") != "" || runtime.GOOS == "windows" {
+		return "", false
+	}
+
+	candidates := make([]string, 0)
+	if xdg := os.Getenv("XDG_RUNTIME_DIR"); xdg != "" {
+		candidates = append(candidates, filepath.Join(xdg, "docker.sock"))
+	}
+	candidates = append(candidates, fmt.Sprintf("/run/user/%d/docker.sock", os.Getuid()))
+
+	for _, sock := range candidates {
+		if _, err := os.Stat(sock); err == nil {
+			logrus.Infof("- CONTROLLER - using rootless docker socket %s\n", sock)
+			return "unix://" + sock, true
+		}
+	}
+
+	// fall back to client.DefaultDockerHost
+	return client.DefaultDockerHost, false
+}
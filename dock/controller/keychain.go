@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/dock/registry"
+	"github.com/sirupsen/logrus"
+)
+
+// legacy auth config key of docker hub
+const dockerHubIndex = "https://index.docker.io/v1/"
+
+var ErrNoKeychainKey = errors.New("CREDENTIALS_KEY not set, registry credentials are disabled")
+
+// Keychain stores registry credentials in the db, sealed with AES-GCM
+// under a key derived from CREDENTIALS_KEY with scrypt and a random salt
+// stored with each credential
+type Keychain struct {
+	db     *db.DB
+	secret string
+	mutex  *sync.Mutex
+	// keys derived per salt, scrypt is too slow to run per lookup
+	keys map[string][]byte
+}
+
+func NewKeychain(database *db.DB) *Keychain {
+	return &Keychain{
+		db:     database,
+		secret: config.String("CREDENTIALS_KEY", ""),
+		mutex:  &sync.Mutex{},
+		keys:   make(map[string][]byte),
+	}
+}
+
+// Set stores the credentials of host
+func (k *Keychain) Set(host, user, password string) error {
+	if k.secret == "" {
+		return ErrNoKeychainKey
+	}
+	salt, secret, err := k.seal(host, []byte(password))
+	if err != nil {
+		return err
+	}
+	k.evict(host)
+	return k.db.UpsertCredential(db.Credential{
+		Host:   host,
+		User:   user,
+		Secret: secret,
+		Salt:   salt,
+	})
+}
+
+func (k *Keychain) Remove(host string) error {
+	k.evict(host)
+	return k.db.RemoveCredential(host)
+}
+
+// evict drops the cached key of the credentials stored for host
+func (k *Keychain) evict(host string) {
+	c, exists, err := k.db.Credential(host)
+	if err != nil || !exists || len(c.Salt) == 0 {
+		return
+	}
+	k.mutex.Lock()
+	delete(k.keys, string(c.Salt))
+	k.mutex.Unlock()
+}
+
+// List returns the stored credentials without their secrets
+func (k *Keychain) List() ([]db.Credential, error) {
+	return k.db.Credentials()
+}
+
+// Export returns the stored credentials with their passwords, for backups.
+// Without CREDENTIALS_KEY nothing is stored
+func (k *Keychain) Export() ([]BundleCredential, error) {
+	creds := make([]BundleCredential, 0)
+	if k.secret == "" {
+		return creds, nil
+	}
+	stored, err := k.db.Credentials()
+	if err != nil {
+		return creds, err
+	}
+	for _, c := range stored {
+		plain, err := k.unseal(c)
+		if err != nil {
+			return creds, fmt.Errorf("cannot decrypt credentials of %s: %s", c.Host, err)
+		}
+		creds = append(creds, BundleCredential{Host: c.Host, User: c.User, Password: string(plain)})
+	}
+	return creds, nil
+}
+
+// Auth returns the credentials of host, stored credentials take
+// precedence over REGISTRY_USER/REGISTRY_PASSWORD
+func (k *Keychain) Auth(host string) (user, password string) {
+	if k.secret != "" {
+		c, exists, err := k.db.Credential(host)
+		if err != nil {
+			logrus.Warnf("- KEYCHAIN - lookup of %s failed: %s\n", host, err)
+		}
+		if exists {
+			plain, err := k.unseal(c)
+			if err == nil {
+				return c.User, string(plain)
+			}
+			logrus.Errorf("- KEYCHAIN - cannot decrypt credentials of %s: %s\n", host, err)
+		}
+	}
+	return registry.EnvAuth(host)
+}
+
+// AuthConfigs returns all known credentials in the form passed to builds
+func (k *Keychain) AuthConfigs() map[string]types.AuthConfig {
+	configs := make(map[string]types.AuthConfig)
+	hosts := []string{config.String("REGISTRY_HOST", "registry-1.docker.io")}
+	if k.secret != "" {
+		creds, err := k.db.Credentials()
+		if err != nil {
+			logrus.Warnf("- KEYCHAIN - listing credentials failed: %s\n", err)
+		}
+		for _, c := range creds {
+			hosts = append(hosts, c.Host)
+		}
+	}
+	for _, host := range hosts {
+		user, password := k.Auth(host)
+		if user == "" {
+			continue
+		}
+		auth := types.AuthConfig{
+			Username:      user,
+			Password:      password,
+			ServerAddress: host,
+		}
+		configs[host] = auth
+		if host == "registry-1.docker.io" {
+			configs[dockerHubIndex] = auth
+		}
+	}
+	return configs
+}
+
+// seal seals plain under the key of a new salt, bound to host
+func (k *Keychain) seal(host string, plain []byte) (salt []byte, sealed []byte, err error) {
+	salt = make([]byte, saltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, err
+	}
+	gcm, err := k.gcm(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return salt, gcm.Seal(nonce, nonce, plain, []byte(host)), nil
+}
+
+// unseal opens the secret of c, credentials sealed without salt are
+// sealed anew
+func (k *Keychain) unseal(c db.Credential) ([]byte, error) {
+	plain, err := k.open(c)
+	if err == nil && len(c.Salt) == 0 {
+		if err := k.Set(c.Host, c.User, string(plain)); err != nil {
+			logrus.Warnf("- KEYCHAIN - resealing credentials of %s failed: %s\n", c.Host, err)
+		}
+	}
+	return plain, err
+}
+
+func (k *Keychain) open(c db.Credential) ([]byte, error) {
+	gcm, err := k.gcm(c.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Secret) < gcm.NonceSize() {
+		return nil, errors.New("sealed secret too short")
+	}
+	nonce, data := c.Secret[:gcm.NonceSize()], c.Secret[gcm.NonceSize():]
+	// credentials without salt were sealed without the host
+	var host []byte
+	if len(c.Salt) > 0 {
+		host = []byte(c.Host)
+	}
+	return gcm.Open(nil, nonce, data, host)
+}
+
+func (k *Keychain) gcm(salt []byte) (cipher.AEAD, error) {
+	key, err := k.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// key derives the key of salt like the key of backup bundles. Credentials
+// stored without salt were sealed under the plain sha256 of the secret
+func (k *Keychain) key(salt []byte) ([]byte, error) {
+	if len(salt) == 0 {
+		sum := sha256.Sum256([]byte(k.secret))
+		return sum[:], nil
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if key, ok := k.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := bundleKey(k.secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[string(salt)] = key
+	return key, nil
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"sync"
+
+	dock_events "github.com/docker/docker/api/types/events"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// default number of pending events before new ones are dropped
+const defaultEventQueueSize = 1024
+
+// QueueStats counts the events passing the event queue
+type QueueStats struct {
+	Pending   int    `json:"pending"`
+	Received  uint64 `json:"received"`
+	Coalesced uint64 `json:"coalesced"`
+	Dropped   uint64 `json:"dropped"`
+	Handled   uint64 `json:"handled"`
+}
+
+// eventQueue decouples the docker event stream from the handler. Events
+// are kept in order per object, objects take turns. Bursts of lifecycle
+// events (e.g. restarts) are coalesced, events exceeding the size are dropped
+type eventQueue struct {
+	mutex   *sync.Mutex
+	size    int
+	order   []string
+	pending map[string][]dock_events.Message
+	ready   chan struct{}
+	stats   QueueStats
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		mutex:   &sync.Mutex{},
+		size:    config.Int("EVENT_QUEUE_SIZE", defaultEventQueueSize),
+		order:   make([]string, 0),
+		pending: make(map[string][]dock_events.Message),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// lifecycle events only depend on the latest state of a container
+var lifecycle = map[string]bool{
+	"start":   true,
+	"stop":    true,
+	"destroy": true,
+}
+
+func queueKey(e dock_events.Message) string {
+	return string(e.Type) + ":" + e.Actor.ID
+}
+
+// Push queues e without blocking
+func (q *eventQueue) Push(e dock_events.Message) {
+	key := queueKey(e)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.stats.Received++
+
+	list := q.pending[key]
+	if coalesced, ok := coalesce(list, e); ok {
+		q.stats.Coalesced += uint64(len(list) + 1 - len(coalesced))
+		q.stats.Pending -= len(list) - len(coalesced)
+		q.pending[key] = coalesced
+		return
+	}
+	if q.stats.Pending >= q.size {
+		q.stats.Dropped++
+		logrus.Warnf("- CONTROLLER - event queue full, dropping %s %s\n", e.Type, e.Action)
+		return
+	}
+	if len(list) == 0 {
+		q.order = append(q.order, key)
+	}
+	q.pending[key] = append(list, e)
+	q.stats.Pending++
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// coalesce merges e into the pending events of its object:
+// a repeated event is dropped, start/stop/start collapses to start and
+// destroy supersedes pending lifecycle events
+func coalesce(list []dock_events.Message, e dock_events.Message) ([]dock_events.Message, bool) {
+	n := len(list)
+	if n == 0 || e.Type != dock_events.ContainerEventType || !lifecycle[e.Status] {
+		return nil, false
+	}
+	last := list[n-1]
+	if !lifecycle[last.Status] {
+		return nil, false
+	}
+	switch {
+	case e.Status == "destroy":
+		cut := n
+		for cut > 0 && lifecycle[list[cut-1].Status] {
+			cut--
+		}
+		return append(list[:cut], e), true
+	case last.Status == e.Status:
+		return list, true
+	case n > 1 && list[n-2].Status == e.Status:
+		return list[:n-1], true
+	}
+	return nil, false
+}
+
+// Pop blocks until an event is pending and returns the next one
+func (q *eventQueue) Pop() dock_events.Message {
+	for {
+		q.mutex.Lock()
+		if len(q.order) == 0 {
+			q.mutex.Unlock()
+			<-q.ready
+			continue
+		}
+		key := q.order[0]
+		q.order = q.order[1:]
+		list := q.pending[key]
+		e := list[0]
+		if len(list) > 1 {
+			q.pending[key] = list[1:]
+			// let other objects go first
+			q.order = append(q.order, key)
+		} else {
+			delete(q.pending, key)
+		}
+		q.stats.Pending--
+		q.stats.Handled++
+		q.mutex.Unlock()
+		return e
+	}
+}
+
+// Stats returns a copy of the queue counters
+func (q *eventQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.stats
+}
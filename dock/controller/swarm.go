@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoSwarm is returned by swarm calls if the daemon is not part of a swarm
+	ErrNoSwarm = errors.New("daemon is not part of a swarm")
+	// ErrNoManager is returned by swarm calls only a manager can answer
+	ErrNoManager = errors.New("daemon is not a swarm manager")
+	// ErrNotReplicated is returned when scaling a global service
+	ErrNotReplicated = errors.New("service is not replicated")
+)
+
+// SwarmInfo is the swarm membership of the daemon
+type SwarmInfo struct {
+	NodeID    string `json:"node_id"`
+	NodeAddr  string `json:"node_addr"`
+	ClusterID string `json:"cluster_id,omitempty"`
+	Manager   bool   `json:"manager"`
+	Nodes     int    `json:"nodes,omitempty"`
+	Managers  int    `json:"managers,omitempty"`
+}
+
+func newSwarmInfo(info swarm.Info) *SwarmInfo {
+	if info.LocalNodeState != swarm.LocalNodeStateActive {
+		return nil
+	}
+	s := &SwarmInfo{
+		NodeID:   info.NodeID,
+		NodeAddr: info.NodeAddr,
+		Manager:  info.ControlAvailable,
+		Nodes:    info.Nodes,
+		Managers: info.Managers,
+	}
+	if info.Cluster != nil {
+		s.ClusterID = info.Cluster.ID
+	}
+	return s
+}
+
+// Node is a swarm node with its health and resources
+type Node struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	// manager or worker
+	Role string `json:"role"`
+	// active, pause or drain
+	Availability string `json:"availability"`
+	// ready, down, disconnected or unknown
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+	Addr    string `json:"addr"`
+	// manager only: leader and reachable, unreachable or unknown
+	Leader       bool              `json:"leader,omitempty"`
+	Reachability string            `json:"reachability,omitempty"`
+	CPUs         float64           `json:"cpus"`
+	Memory       int64             `json:"memory_bytes"`
+	OS           string            `json:"os"`
+	Arch         string            `json:"arch"`
+	Engine       string            `json:"engine_version"`
+	Labels       map[string]string `json:"labels"`
+	// the node the agent runs on
+	Self bool `json:"self"`
+}
+
+func newNode(n swarm.Node, self string) Node {
+	node := Node{
+		ID:           n.ID,
+		Hostname:     n.Description.Hostname,
+		Role:         string(n.Spec.Role),
+		Availability: string(n.Spec.Availability),
+		State:        string(n.Status.State),
+		Message:      n.Status.Message,
+		Addr:         n.Status.Addr,
+		CPUs:         float64(n.Description.Resources.NanoCPUs) / 1e9,
+		Memory:       n.Description.Resources.MemoryBytes,
+		OS:           n.Description.Platform.OS,
+		Arch:         n.Description.Platform.Architecture,
+		Engine:       n.Description.Engine.EngineVersion,
+		Labels:       n.Spec.Labels,
+		Self:         n.ID == self,
+	}
+	if n.ManagerStatus != nil {
+		node.Leader = n.ManagerStatus.Leader
+		node.Reachability = string(n.ManagerStatus.Reachability)
+	}
+	return node
+}
+
+// Swarm returns the swarm membership of the daemon, ErrNoSwarm or
+// ErrNoManager if manager is required and the daemon is no manager
+func (ctr *Controller) Swarm(ctx context.Context, manager bool) (*SwarmInfo, error) {
+	info, err := ctr.c.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := newSwarmInfo(info.Swarm)
+	if s == nil {
+		return nil, ErrNoSwarm
+	}
+	if manager && !s.Manager {
+		return nil, ErrNoManager
+	}
+	return s, nil
+}
+
+// SwarmNodes lists the nodes of the swarm by hostname, only managers can
+// list them
+func (ctr *Controller) SwarmNodes(ctx context.Context) ([]Node, error) {
+	s, err := ctr.Swarm(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ctr.c.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(raw))
+	for _, n := range raw {
+		nodes = append(nodes, newNode(n, s.NodeID))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Hostname < nodes[j].Hostname
+	})
+	return nodes, nil
+}
+
+// Scaled is the result of a service scale
+type Scaled struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Previous uint64   `json:"previous"`
+	Replicas uint64   `json:"replicas"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ScaleService sets the replicas of a replicated service, the update is
+// based on the inspected version so concurrent changes are rejected
+func (ctr *Controller) ScaleService(ctx context.Context, id string, replicas uint64) (Scaled, error) {
+	if _, err := ctr.Swarm(ctx, true); err != nil {
+		return Scaled{}, err
+	}
+	service, _, err := ctr.c.ServiceInspectWithRaw(ctx, id, types.ServiceInspectOptions{})
+	if err != nil {
+		return Scaled{}, err
+	}
+	mode := service.Spec.Mode.Replicated
+	if mode == nil || mode.Replicas == nil {
+		return Scaled{}, ErrNotReplicated
+	}
+	scaled := Scaled{
+		ID:       service.ID,
+		Name:     service.Spec.Name,
+		Previous: *mode.Replicas,
+		Replicas: replicas,
+	}
+	mode.Replicas = &replicas
+	resp, err := ctr.c.ServiceUpdate(ctx, service.ID, service.Version, service.Spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		return Scaled{}, err
+	}
+	scaled.Warnings = resp.Warnings
+	logrus.Infof("- CONTROLLER - scaled service %s from %d to %d replicas\n", scaled.Name, scaled.Previous, replicas)
+	return scaled, nil
+}
+
+// SwarmData is a swarm secret or config without its value
+type SwarmData struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Created time.Time         `json:"created"`
+	Updated time.Time         `json:"updated"`
+	Labels  map[string]string `json:"labels"`
+	// external secret driver, empty for secrets stored in the swarm
+	Driver string `json:"driver,omitempty"`
+	// services mounting it
+	Services []DataUser `json:"services"`
+}
+
+// DataUser is a service using a secret or config
+type DataUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// file the service mounts it as, empty for runtime configs
+	Target string `json:"target,omitempty"`
+}
+
+// dataUsers maps secret and config ids to the services using them
+func (ctr *Controller) dataUsers(ctx context.Context) (secrets, configs map[string][]DataUser, err error) {
+	services, err := ctr.c.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return
+	}
+	secrets = make(map[string][]DataUser)
+	configs = make(map[string][]DataUser)
+	for _, service := range services {
+		spec := service.Spec.TaskTemplate.ContainerSpec
+		if spec == nil {
+			continue
+		}
+		for _, ref := range spec.Secrets {
+			user := DataUser{ID: service.ID, Name: service.Spec.Name}
+			if ref.File != nil {
+				user.Target = ref.File.Name
+			}
+			secrets[ref.SecretID] = append(secrets[ref.SecretID], user)
+		}
+		for _, ref := range spec.Configs {
+			user := DataUser{ID: service.ID, Name: service.Spec.Name}
+			if ref.File != nil {
+				user.Target = ref.File.Name
+			}
+			configs[ref.ConfigID] = append(configs[ref.ConfigID], user)
+		}
+	}
+	return
+}
+
+func sortData(data []SwarmData) {
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Name < data[j].Name
+	})
+}
+
+// SwarmSecrets lists the secrets of the swarm and the services using them,
+// the values are never read
+func (ctr *Controller) SwarmSecrets(ctx context.Context) ([]SwarmData, error) {
+	if _, err := ctr.Swarm(ctx, true); err != nil {
+		return nil, err
+	}
+	raw, err := ctr.c.SecretList(ctx, types.SecretListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	users, _, err := ctr.dataUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]SwarmData, 0, len(raw))
+	for _, secret := range raw {
+		data := SwarmData{
+			ID:       secret.ID,
+			Name:     secret.Spec.Name,
+			Created:  secret.CreatedAt,
+			Updated:  secret.UpdatedAt,
+			Labels:   secret.Spec.Labels,
+			Services: users[secret.ID],
+		}
+		if secret.Spec.Driver != nil {
+			data.Driver = secret.Spec.Driver.Name
+		}
+		if data.Services == nil {
+			data.Services = []DataUser{}
+		}
+		secrets = append(secrets, data)
+	}
+	sortData(secrets)
+	return secrets, nil
+}
+
+// SwarmConfigs lists the configs of the swarm and the services using them,
+// the values are never read
+func (ctr *Controller) SwarmConfigs(ctx context.Context) ([]SwarmData, error) {
+	if _, err := ctr.Swarm(ctx, true); err != nil {
+		return nil, err
+	}
+	raw, err := ctr.c.ConfigList(ctx, types.ConfigListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	_, users, err := ctr.dataUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]SwarmData, 0, len(raw))
+	for _, config := range raw {
+		data := SwarmData{
+			ID:       config.ID,
+			Name:     config.Spec.Name,
+			Created:  config.CreatedAt,
+			Updated:  config.UpdatedAt,
+			Labels:   config.Spec.Labels,
+			Services: users[config.ID],
+		}
+		if data.Services == nil {
+			data.Services = []DataUser{}
+		}
+		configs = append(configs, data)
+	}
+	sortData(configs)
+	return configs, nil
+}
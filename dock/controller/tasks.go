@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/cron"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// default time a task may run before it is cancelled
+	defaultTaskTimeout = 30 * time.Minute
+	// default interval the db_rollup task averages metrics to
+	defaultRollupResolution = 5 * time.Minute
+)
+
+// TaskStatus is the schedule and the result of the last run of a task
+type TaskStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+	Running  bool      `json:"running"`
+	LastRun  time.Time `json:"last_run"`
+	// duration of the last run
+	Took       string `json:"took,omitempty"`
+	LastResult string `json:"last_result,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	Runs       int    `json:"runs"`
+	Failures   int    `json:"failures"`
+}
+
+type task struct {
+	status   TaskStatus
+	schedule cron.Schedule
+	// returns a short summary of what was done
+	run func(ctx context.Context) (string, error)
+}
+
+// Scheduler runs the maintenance tasks on the cron schedules of their
+// TASK_<NAME> keys, tasks without one are not scheduled
+type Scheduler struct {
+	mutex   *sync.Mutex
+	tasks   []*task
+	timeout time.Duration
+}
+
+func newScheduler() *Scheduler {
+	return &Scheduler{
+		mutex:   &sync.Mutex{},
+		tasks:   make([]*task, 0),
+		timeout: config.Duration("TASK_TIMEOUT", defaultTaskTimeout),
+	}
+}
+
+// add schedules run as name if key holds a valid cron expression, control
+// tasks change the docker host and are left out in read-only mode
+func (s *Scheduler) add(name, key string, control bool, run func(ctx context.Context) (string, error)) {
+	expr := config.String(key, "")
+	if expr == "" {
+		return
+	}
+	if control && (config.Bool("READ_ONLY", false) || !config.Enabled("control")) {
+		logrus.Warnf("- TASKS - %s ignored, the agent must not change the docker host\n", name)
+		return
+	}
+	schedule, err := cron.Parse(expr)
+	if err != nil {
+		logrus.Errorf("- TASKS - %s: %s\n", key, err)
+		return
+	}
+	s.tasks = append(s.tasks, &task{
+		status:   TaskStatus{Name: name, Schedule: expr},
+		schedule: schedule,
+		run:      run,
+	})
+}
+
+// Run starts a loop per task, runs of a task never overlap
+func (s *Scheduler) Run() {
+	for _, t := range s.tasks {
+		logrus.Infof("- TASKS - scheduled %s (%s)\n", t.status.Name, t.status.Schedule)
+		go s.loop(t)
+	}
+}
+
+func (s *Scheduler) loop(t *task) {
+	for {
+		next := t.schedule.Next(time.Now())
+		s.mutex.Lock()
+		t.status.Next = next
+		s.mutex.Unlock()
+		if next.IsZero() {
+			return
+		}
+		time.Sleep(time.Until(next))
+		s.exec(t)
+	}
+}
+
+func (s *Scheduler) exec(t *task) {
+	s.mutex.Lock()
+	t.status.Running = true
+	s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	start := time.Now()
+	result, err := t.run(ctx)
+	cancel()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t.status.Running = false
+	t.status.LastRun = start
+	t.status.Took = time.Since(start).Round(time.Millisecond).String()
+	t.status.LastResult = result
+	t.status.LastError = ""
+	t.status.Runs++
+	if err != nil {
+		t.status.Failures++
+		t.status.LastError = err.Error()
+		logrus.Errorf("- TASKS - %s failed: %s\n", t.status.Name, err)
+		return
+	}
+	logrus.Infof("- TASKS - %s done: %s\n", t.status.Name, result)
+}
+
+// Status returns the status of the scheduled tasks by name
+func (s *Scheduler) Status() []TaskStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status := make([]TaskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		status = append(status, t.status)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
+
+// initTasks schedules the maintenance tasks
+func (ctr *Controller) initTasks() {
+	s := newScheduler()
+	s.add("image_prune", "TASK_IMAGE_PRUNE", true, ctr.pruneImages)
+	s.add("volume_prune", "TASK_VOLUME_PRUNE", true, ctr.pruneVolumes)
+	s.add("db_rollup", "TASK_DB_ROLLUP", false, ctr.rollup)
+	s.add("export", "TASK_EXPORT", false, ctr.export)
+	s.add("disk_usage", "TASK_DISK_USAGE", false, func(ctx context.Context) (string, error) {
+		return "refreshed", ctr.UpdateDiskUsage()
+	})
+	ctr.Tasks = s
+	s.Run()
+}
+
+// pruneImages removes dangling images
+func (ctr *Controller) pruneImages(ctx context.Context) (string, error) {
+	report, err := ctr.c.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", "true")))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d images deleted, %d bytes reclaimed", len(report.ImagesDeleted), report.SpaceReclaimed), nil
+}
+
+// pruneVolumes removes volumes no container uses
+func (ctr *Controller) pruneVolumes(ctx context.Context) (string, error) {
+	report, err := ctr.c.VolumesPrune(ctx, filters.NewArgs())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d volumes deleted, %d bytes reclaimed", len(report.VolumesDeleted), report.SpaceReclaimed), nil
+}
+
+func (ctr *Controller) rollup(ctx context.Context) (string, error) {
+	resolution := config.Duration("ROLLUP_RESOLUTION", defaultRollupResolution)
+	if resolution <= 0 {
+		resolution = defaultRollupResolution
+	}
+	n, err := ctr.DB.Rollup(ctx, resolution)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d rollup documents written", n), nil
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Topology is a graph of containers, the networks connecting them and
+// their published host ports
+type Topology struct {
+	Nodes []*TopologyNode `json:"nodes"`
+	Edges []*TopologyEdge `json:"edges"`
+}
+
+type TopologyNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"` // container, network, port
+	Name string `json:"name"`
+	// network driver or container state
+	Detail string `json:"detail,omitempty"`
+}
+
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// container address in the network
+	IP string `json:"ip,omitempty"`
+}
+
+// Topology builds the graph from network inspect data
+func (ctr *Controller) Topology() (*Topology, error) {
+	ctx := context.Background()
+	networks, err := ctr.c.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	topo := &Topology{
+		Nodes: make([]*TopologyNode, 0),
+		Edges: make([]*TopologyEdge, 0),
+	}
+
+	for _, c := range ctr.Containers.Items() {
+		topo.Nodes = append(topo.Nodes, &TopologyNode{
+			ID:     c.ID,
+			Kind:   "container",
+			Name:   c.Name,
+			Detail: c.State.Status,
+		})
+		for _, p := range c.Ports {
+			portID := fmt.Sprintf("port:%s:%s/%s", p.HostIP, p.HostPort, p.Proto)
+			topo.Nodes = append(topo.Nodes, &TopologyNode{
+				ID:     portID,
+				Kind:   "port",
+				Name:   fmt.Sprintf("%s:%s", p.HostIP, p.HostPort),
+				Detail: fmt.Sprintf("%s/%s", p.Port, p.Proto),
+			})
+			topo.Edges = append(topo.Edges, &TopologyEdge{
+				From: portID,
+				To:   c.ID,
+			})
+		}
+	}
+
+	for _, listed := range networks {
+		// the list does not include the attached containers
+		network, err := ctr.c.NetworkInspect(ctx, listed.ID, types.NetworkInspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		topo.Nodes = append(topo.Nodes, &TopologyNode{
+			ID:     network.ID,
+			Kind:   "network",
+			Name:   network.Name,
+			Detail: network.Driver,
+		})
+		for cid, ep := range network.Containers {
+			topo.Edges = append(topo.Edges, &TopologyEdge{
+				From: network.ID,
+				To:   cid,
+				IP:   ep.IPv4Address,
+			})
+		}
+	}
+	return topo, nil
+}
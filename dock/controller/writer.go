@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/dock/controller/db"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
+	"github.com/sirupsen/logrus"
+)
+
+// default number of metric batches waiting for the db before new ones
+// are dropped
+const defaultWriteQueueSize = 64
+
+// WriteStats counts the metric batches passing the write queue
+type WriteStats struct {
+	Pending int    `json:"pending"`
+	Queued  uint64 `json:"queued"`
+	Dropped uint64 `json:"dropped"`
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"`
+	// batches kept on disk while the db is down, nil without SPOOL_DIR
+	Spool *db.SpoolStats `json:"spool,omitempty"`
+}
+
+// writeQueue decouples the container broadcast from the db writer. A
+// single writer works the batches off in order, during slow db periods
+// batches exceeding the size are dropped instead of piling up goroutines
+type writeQueue struct {
+	mutex    *sync.Mutex
+	size     int
+	batches  [][]interface{}
+	ready    chan struct{}
+	stats    WriteStats
+	dropping bool
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{
+		mutex:   &sync.Mutex{},
+		size:    config.Int("DB_WRITE_QUEUE", defaultWriteQueueSize),
+		batches: make([][]interface{}, 0),
+		ready:   make(chan struct{}, 1),
+	}
+	selfmetrics.Register("db_write_queue", func() float64 {
+		return float64(q.Stats().Pending)
+	})
+	selfmetrics.Register("db_write_dropped", func() float64 {
+		return float64(q.Stats().Dropped)
+	})
+	return q
+}
+
+// Push queues batch without blocking
+func (q *writeQueue) Push(batch []interface{}) {
+	if len(batch) == 0 {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.batches) >= q.size {
+		q.stats.Dropped++
+		if !q.dropping {
+			q.dropping = true
+			logrus.Warnln("- CONTROLLER - db write queue full, dropping metric batches")
+		}
+		return
+	}
+	q.dropping = false
+	q.batches = append(q.batches, batch)
+	q.stats.Queued++
+	q.stats.Pending = len(q.batches)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Pop blocks until a batch is pending and returns the oldest one
+func (q *writeQueue) Pop() []interface{} {
+	for {
+		q.mutex.Lock()
+		if len(q.batches) == 0 {
+			q.mutex.Unlock()
+			<-q.ready
+			continue
+		}
+		batch := q.batches[0]
+		q.batches[0] = nil
+		q.batches = q.batches[1:]
+		q.stats.Pending = len(q.batches)
+		q.mutex.Unlock()
+		return batch
+	}
+}
+
+// done records the outcome of writing a popped batch
+func (q *writeQueue) done(err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if err != nil {
+		q.stats.Failed++
+		return
+	}
+	q.stats.Written++
+}
+
+// Stats returns a copy of the queue counters
+func (q *writeQueue) Stats() WriteStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.stats
+}
+
+// default size of the spool on disk
+const defaultSpoolBytes = 64 << 20
+
+// openSpool opens the spool at SPOOL_DIR, nil if unset
+func openSpool() *db.Spool {
+	dir := config.String("SPOOL_DIR", "")
+	if dir == "" {
+		return nil
+	}
+	spool, err := db.NewSpool(dir, int64(config.Int("SPOOL_MAX_BYTES", defaultSpoolBytes)))
+	if err != nil {
+		logrus.Errorf("- CONTROLLER - failed to open spool, batches are dropped while the db is down: %s\n", err)
+		return nil
+	}
+	return spool
+}
+
+// WriteMetrics works off the write queue, one batch at a time. Batches
+// failing to be written are spooled to disk if configured and replayed,
+// oldest first, after the next successful write
+func (ctr *Controller) WriteMetrics() {
+	spool := ctr.spool
+	for {
+		batch := ctr.writes.Pop()
+		err := ctr.DB.InsertManyMetrics(batch)
+		ctr.writes.done(err)
+		if spool == nil {
+			continue
+		}
+		if err != nil {
+			if err := spool.Store(batch); err != nil {
+				logrus.Errorf("- CONTROLLER - failed to spool metric batch: %s\n", err)
+			}
+			continue
+		}
+		ctr.replay(spool)
+	}
+}
+
+// replay writes the spooled batches until the spool is empty, a write
+// fails or new batches are waiting
+func (ctr *Controller) replay(spool *db.Spool) {
+	for ctr.writes.Stats().Pending == 0 {
+		name, batch, ok := spool.Oldest()
+		if !ok {
+			return
+		}
+		if err := ctr.DB.InsertManyMetrics(batch); err != nil {
+			return
+		}
+		spool.Replayed(name)
+	}
+}
+
+// WriteQueue returns the counters of the db write queue
+func (ctr *Controller) WriteQueue() WriteStats {
+	stats := ctr.writes.Stats()
+	if ctr.spool != nil {
+		spool := ctr.spool.Stats()
+		stats.Spool = &spool
+	}
+	return stats
+}
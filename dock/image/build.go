@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
+
+// BuildOpts describes an image build, the context is either an uploaded
+// tar archive or Remote (a git url)
+type BuildOpts struct {
+	Tags       []string
+	Dockerfile string
+	Remote     string
+	NoCache    bool
+	Pull       bool
+}
+
+// buildMessage is the subset of docker's jsonmessage emitted by builds
+type buildMessage struct {
+	Stream string `json:"stream"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Aux    struct {
+		ID string `json:"ID"`
+	} `json:"aux"`
+}
+
+// Build sends the build context to the daemon and returns the build output
+func (s *Storage) Build(ctx context.Context, buildContext io.Reader, opts BuildOpts) (io.ReadCloser, error) {
+	var auths map[string]types.AuthConfig
+	if s.Keychain != nil {
+		auths = s.Keychain.AuthConfigs()
+	}
+	resp, err := s.c.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		AuthConfigs:   auths,
+		Tags:          opts.Tags,
+		Dockerfile:    opts.Dockerfile,
+		RemoteContext: opts.Remote,
+		NoCache:       opts.NoCache,
+		PullParent:    opts.Pull,
+		Remove:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// BuildOutput reads the output of a build line by line and registers the
+// resulting image, which is returned
+func (s *Storage) BuildOutput(output io.ReadCloser, line func(string)) (string, error) {
+	defer output.Close()
+	id := ""
+	dec := json.NewDecoder(output)
+	for {
+		var msg buildMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if msg.Error != "" {
+			return "", errors.New(msg.Error)
+		}
+		if msg.Aux.ID != "" {
+			id = msg.Aux.ID
+		}
+		if msg.Stream != "" {
+			line(msg.Stream)
+		} else if msg.Status != "" {
+			line(msg.Status + "\n")
+		}
+	}
+	if id == "" {
+		return "", errors.New("build finished without image id")
+	}
+	return id, s.Add(id)
+}
@@ -7,22 +7,51 @@ import (
 )
 
 type Image struct {
-	ID         string `json:"id"`
-	Tag        string `json:"tag"`
-	Size       int64  `json:"size"`
-	Created    string `json:"created"`
-	Containers int64  `json:"containers"`
+	ID  string `json:"id"`
+	Tag string `json:"tag"`
+	// all tags of the image, Tag is the first of them
+	Tags       []string `json:"tags"`
+	Size       int64    `json:"size"`
+	Created    string   `json:"created"`
+	Containers int64    `json:"containers"`
+	// repo digests (e.g. "nginx@sha256:...") of pulled images
+	Digests []string `json:"digests"`
 }
 
+// tag of dangling images
+const untagged = "<none>:<none>"
+
 func NewImage(raw types.ImageSummary) *Image {
 	unix := time.Unix(raw.Created, 0)
 	stamp := unix.Format(time.RFC3339Nano)
 
 	return &Image{
 		ID:         raw.ID,
-		Tag:        raw.RepoTags[0],
+		Tag:        firstTag(raw.RepoTags),
+		Tags:       raw.RepoTags,
 		Size:       raw.Size,
 		Created:    stamp,
 		Containers: raw.Containers,
+		Digests:    raw.RepoDigests,
+	}
+}
+
+// NewImageFromInspect creates an image from inspect data, which has
+// no container count
+func NewImageFromInspect(raw types.ImageInspect) *Image {
+	return &Image{
+		ID:      raw.ID,
+		Tag:     firstTag(raw.RepoTags),
+		Tags:    raw.RepoTags,
+		Size:    raw.Size,
+		Created: raw.Created,
+		Digests: raw.RepoDigests,
+	}
+}
+
+func firstTag(tags []string) string {
+	if len(tags) == 0 {
+		return untagged
 	}
+	return tags[0]
 }
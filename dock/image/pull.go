@@ -0,0 +1,85 @@
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/h0rzn/monitoring_agent/dock/registry"
+)
+
+// PullProgress is the state of a single layer of a pull, as reported
+// by the daemon's json message stream
+type PullProgress struct {
+	Layer   string `json:"layer"`
+	Status  string `json:"status"`
+	Current int64  `json:"current"`
+	Total   int64  `json:"total"`
+}
+
+// pullMessage is the subset of docker's jsonmessage used for progress
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+// Pull pulls ref, reporting layer progress to progress, and registers
+// the image once complete
+func (s *Storage) Pull(ref string, progress func(PullProgress)) error {
+	opts := types.ImagePullOptions{}
+	auth := registry.EnvAuth
+	if s.Keychain != nil {
+		auth = s.Keychain.Auth
+	}
+	if host, _, err := registry.Repo(ref); err == nil {
+		if user, password := auth(host); user != "" {
+			auth, err := json.Marshal(types.AuthConfig{
+				Username:      user,
+				Password:      password,
+				ServerAddress: host,
+			})
+			if err != nil {
+				return err
+			}
+			opts.RegistryAuth = base64.URLEncoding.EncodeToString(auth)
+		}
+	}
+
+	ctx := context.Background()
+	rc, err := s.c.ImagePull(ctx, ref, opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := json.NewDecoder(rc)
+	for {
+		var msg pullMessage
+		err = dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		progress(PullProgress{
+			Layer:   msg.ID,
+			Status:  msg.Status,
+			Current: msg.ProgressDetail.Current,
+			Total:   msg.ProgressDetail.Total,
+		})
+	}
+	// ref may point to an image already stored, Refresh matches the id
+	return s.Refresh(ref)
+}
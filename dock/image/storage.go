@@ -6,16 +6,22 @@ import (
 	"sync"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
 
+// Keychain provides registry credentials for pulls and builds
+type Keychain interface {
+	Auth(host string) (user, password string)
+	AuthConfigs() map[string]types.AuthConfig
+}
+
 type Storage struct {
-	mutex  sync.Mutex
-	c      *client.Client
-	Images map[*Image]bool
-	Feed   chan interface{}
+	mutex    sync.Mutex
+	c        *client.Client
+	Images   map[*Image]bool
+	Feed     chan interface{}
+	Keychain Keychain
 }
 
 func NewStorage(c *client.Client) *Storage {
@@ -45,7 +51,6 @@ func (s *Storage) Init() error {
 	return nil
 }
 
-
 func (s *Storage) AddRaw(raw types.ImageSummary) error {
 	s.mutex.Lock()
 	img := NewImage(raw)
@@ -55,29 +60,19 @@ func (s *Storage) AddRaw(raw types.ImageSummary) error {
 }
 
 func (s *Storage) Add(id string) error {
-	s.mutex.Lock()
 	if _, exists := s.Image(id); exists {
 		return nil
 	}
 	ctx := context.Background()
-	idFilter := filters.NewArgs()
-	idFilter.Add("id", id)
-	images, err := s.c.ImageList(
-		ctx,
-		types.ImageListOptions{
-			Filters: idFilter,
-		})
-	if err != nil {
-		return err
-	}
-	raw := images[0]
-	err = s.AddRaw(raw)
+	raw, _, err := s.c.ImageInspectWithRaw(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	s.mutex.Lock()
+	s.Images[NewImageFromInspect(raw)] = true
 	s.mutex.Unlock()
-	logrus.Infoln("- STORAGE - added container")
+	logrus.Infoln("- STORAGE - added image")
 	return nil
 }
 
@@ -94,7 +89,36 @@ func (s *Storage) Remove(id string) error {
 	return nil
 }
 
+// Refresh re-reads the image ref points to after docker reported it
+// pulled, tagged or untagged. An image that is gone is removed
+func (s *Storage) Refresh(ref string) error {
+	ctx := context.Background()
+	raw, _, err := s.c.ImageInspectWithRaw(ctx, ref)
+	if client.IsErrNotFound(err) {
+		return s.Remove(ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	fresh := NewImageFromInspect(raw)
+	s.mutex.Lock()
+	if img, exists := s.Image(raw.ID); exists {
+		img.Tag = fresh.Tag
+		img.Tags = fresh.Tags
+		img.Digests = fresh.Digests
+		img.Size = fresh.Size
+	} else {
+		s.Images[fresh] = true
+		logrus.Infoln("- STORAGE - added image")
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
 func (s *Storage) ByID(id string) (*Image, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for img := range s.Images {
 		if img.ID == id {
 			return img, true
@@ -112,6 +136,23 @@ func (s *Storage) Image(id string) (*Image, bool) {
 	return &Image{}, false
 }
 
+// ByTag returns the image tagged tag
+func (s *Storage) ByTag(tag string) (*Image, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for img := range s.Images {
+		if img.Tag == tag {
+			return img, true
+		}
+		for _, t := range img.Tags {
+			if t == tag {
+				return img, true
+			}
+		}
+	}
+	return &Image{}, false
+}
+
 func (s *Storage) Items() (images []*Image) {
 	s.mutex.Lock()
 	for img := range s.Images {
@@ -123,6 +164,8 @@ func (s *Storage) Items() (images []*Image) {
 
 func (s *Storage) MarshalJSON() ([]byte, error) {
 	var images []*Image
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for img := range s.Images {
 		images = append(images, img)
 	}
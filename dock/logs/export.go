@@ -0,0 +1,50 @@
+package logs
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Range calls fn with every log entry cid wrote between since and until
+func Range(ctx context.Context, c *client.Client, cid string, since, until time.Time, fn func(*Entry) error) error {
+	opts := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Since:      since.Format(time.RFC3339Nano),
+		Until:      until.Format(time.RFC3339Nano),
+	}
+	r, err := c.ContainerLogs(ctx, cid, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hdr := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, hdr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		content := make([]byte, binary.BigEndian.Uint32(hdr[4:]))
+		if _, err = io.ReadFull(r, content); err != nil {
+			return err
+		}
+		when, data, found := strings.Cut(string(content), " ")
+		if !found {
+			continue
+		}
+		if err = fn(NewEntry(when, data, hdr[0])); err != nil {
+			return err
+		}
+	}
+}
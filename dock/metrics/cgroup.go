@@ -0,0 +1,29 @@
+package metrics
+
+import "github.com/docker/docker/api/types"
+
+// cgroup versions as reported by the memory.stat keys of a stats frame
+const (
+	CgroupUnknown = 0
+	CgroupV1      = 1
+	CgroupV2      = 2
+)
+
+// CgroupVersion infers the cgroup version of the daemon from the keys
+// present in memory.stat: v1 exposes hierarchical total_* counters and
+// "cache", while the unified v2 hierarchy exposes "anon" and "file"
+func CgroupVersion(mem types.MemoryStats) int {
+	if _, v1 := mem.Stats["total_inactive_file"]; v1 {
+		return CgroupV1
+	}
+	if _, v1 := mem.Stats["cache"]; v1 {
+		return CgroupV1
+	}
+	if _, v2 := mem.Stats["anon"]; v2 {
+		return CgroupV2
+	}
+	if _, v2 := mem.Stats["file"]; v2 {
+		return CgroupV2
+	}
+	return CgroupUnknown
+}
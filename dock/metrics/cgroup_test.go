@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// loadStats reads a stats frame captured from the daemon into testdata
+func loadStats(t *testing.T, name string) types.StatsJSON {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats types.StatsJSON
+	if err = json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return stats
+}
+
+func TestCgroupVersion(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    int
+	}{
+		// hierarchical total_* counters
+		{"stats_v1.json", CgroupV1},
+		// cache without total_inactive_file
+		{"stats_v1_cache.json", CgroupV1},
+		// anon and file of the unified hierarchy
+		{"stats_v2.json", CgroupV2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			stats := loadStats(t, tt.fixture)
+			if got := CgroupVersion(stats.MemoryStats); got != tt.want {
+				t.Errorf("CgroupVersion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// windows containers report no memory.stat
+	if got := CgroupVersion(types.MemoryStats{}); got != CgroupUnknown {
+		t.Errorf("CgroupVersion() without stats = %d, want %d", got, CgroupUnknown)
+	}
+}
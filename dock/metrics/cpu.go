@@ -3,29 +3,61 @@ package metrics
 import "github.com/docker/docker/api/types"
 
 type CPU struct {
+	// percent of one cpu like docker stats, up to online * 100
 	UsagePerc float64 `json:"perc" bson:"cpu_perc"`
-	Online    float64 `json:"online" bson:"cpu_online"`
+	// percent of all online cpus of the host, 0-100
+	HostPerc float64 `json:"host_perc" bson:"cpu_host_perc"`
+	// percent of the container's cpu limit, HostPerc if unlimited
+	LimitPerc        float64 `json:"limit_perc" bson:"cpu_limit_perc"`
+	Online           float64 `json:"online" bson:"cpu_online"`
+	ThrottledPeriods float64 `json:"throttled_periods" bson:"cpu_throttled_periods"`
+	ThrottledTime    float64 `json:"throttled_time" bson:"cpu_throttled_time"`
 }
 
 func NewCPU(preCPU, sysCPU types.CPUStats) *CPU {
-	var cpuPerc = 0.0
+	var cpuPerc, hostPerc = 0.0, 0.0
 	prevCPUU := preCPU.CPUUsage.TotalUsage
 	prevSysU := preCPU.SystemUsage
 
 	cpuDelta := float64(sysCPU.CPUUsage.TotalUsage) - float64(prevCPUU)
 	systemDelta := float64(sysCPU.SystemUsage) - float64(prevSysU)
 
+	// cgroup v2 does not report percpu_usage, online_cpus is always set there
 	online := float64(sysCPU.OnlineCPUs)
 
 	if online == 0.0 {
 		online = float64(len(sysCPU.CPUUsage.PercpuUsage))
 	}
-	if systemDelta > 0.0 {
-		cpuPerc = (cpuDelta / systemDelta) * online * 100.0
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		hostPerc = (cpuDelta / systemDelta) * 100.0
+		cpuPerc = hostPerc * online
+	}
+
+	// throttling counters are cumulative, report the delta of this frame
+	throttling := sysCPU.ThrottlingData
+	prevThrottling := preCPU.ThrottlingData
+	var throttledPeriods, throttledTime float64
+	if throttling.ThrottledPeriods >= prevThrottling.ThrottledPeriods {
+		throttledPeriods = float64(throttling.ThrottledPeriods - prevThrottling.ThrottledPeriods)
+	}
+	if throttling.ThrottledTime >= prevThrottling.ThrottledTime {
+		throttledTime = float64(throttling.ThrottledTime - prevThrottling.ThrottledTime)
 	}
 
 	return &CPU{
-		UsagePerc: float64(cpuPerc),
-		Online:    float64(online),
+		UsagePerc:        float64(cpuPerc),
+		HostPerc:         hostPerc,
+		Online:           float64(online),
+		ThrottledPeriods: throttledPeriods,
+		ThrottledTime:    throttledTime,
+	}
+}
+
+// limit relates the usage to a limit of cpus, e.g. 1.5 for --cpus=1.5
+func (c *CPU) limit(cpus float64) {
+	if cpus <= 0 || cpus >= c.Online {
+		c.LimitPerc = c.HostPerc
+		return
 	}
+	c.LimitPerc = c.HostPerc * c.Online / cpus
 }
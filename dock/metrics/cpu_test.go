@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewCPU(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    CPU
+	}{
+		// online_cpus next to percpu_usage
+		{"stats_v1.json", CPU{UsagePerc: 8, HostPerc: 2, Online: 4}},
+		// no online_cpus, counted from percpu_usage
+		{"stats_v1_cache.json", CPU{UsagePerc: 4, HostPerc: 1, Online: 4}},
+		// no percpu_usage, throttling as delta to the previous frame
+		{"stats_v2.json", CPU{UsagePerc: 10, HostPerc: 5, Online: 2, ThrottledPeriods: 2, ThrottledTime: 5000000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			stats := loadStats(t, tt.fixture)
+			got := NewCPU(stats.PreCPUStats, stats.CPUStats)
+			if math.Abs(got.UsagePerc-tt.want.UsagePerc) > 1e-9 || math.Abs(got.HostPerc-tt.want.HostPerc) > 1e-9 {
+				t.Errorf("UsagePerc, HostPerc = %v, %v, want %v, %v", got.UsagePerc, got.HostPerc, tt.want.UsagePerc, tt.want.HostPerc)
+			}
+			got.UsagePerc, got.HostPerc = tt.want.UsagePerc, tt.want.HostPerc
+			if *got != tt.want {
+				t.Errorf("NewCPU() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
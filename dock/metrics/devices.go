@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+// DeviceIO are the bytes read and written on a single block device
+type DeviceIO struct {
+	Device string  `json:"device" bson:"device"`
+	Major  uint64  `json:"major" bson:"major"`
+	Minor  uint64  `json:"minor" bson:"minor"`
+	Read   float64 `json:"read" bson:"read"`
+	Write  float64 `json:"write" bson:"write"`
+}
+
+// NewDevices breaks the blkio bytes down per block device
+func NewDevices(disk types.BlkioStats) []DeviceIO {
+	devices := make([]DeviceIO, 0)
+	index := make(map[[2]uint64]int)
+	for _, io := range disk.IoServiceBytesRecursive {
+		if len(io.Op) == 0 {
+			continue
+		}
+		key := [2]uint64{io.Major, io.Minor}
+		idx, exists := index[key]
+		if !exists {
+			idx = len(devices)
+			index[key] = idx
+			devices = append(devices, DeviceIO{
+				Device: deviceName(io.Major, io.Minor),
+				Major:  io.Major,
+				Minor:  io.Minor,
+			})
+		}
+		switch io.Op[0] {
+		case 'r', 'R':
+			devices[idx].Read += float64(io.Value)
+		case 'w', 'W':
+			devices[idx].Write += float64(io.Value)
+		}
+	}
+	return devices
+}
+
+// resolved device names by major:minor, devices rarely change
+var deviceNames = struct {
+	mutex *sync.Mutex
+	names map[string]string
+}{
+	mutex: &sync.Mutex{},
+	names: make(map[string]string),
+}
+
+// deviceName resolves major:minor to the device name (e.g. "sda") via sysfs,
+// falling back to "major:minor" if /sys is not available
+func deviceName(major, minor uint64) string {
+	id := fmt.Sprintf("%d:%d", major, minor)
+	deviceNames.mutex.Lock()
+	defer deviceNames.mutex.Unlock()
+	if name, exists := deviceNames.names[id]; exists {
+		return name
+	}
+
+	name := id
+	f, err := os.Open("/sys/dev/block/" + id + "/uevent")
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "DEVNAME=") {
+				name = strings.TrimPrefix(scanner.Text(), "DEVNAME=")
+				break
+			}
+		}
+		f.Close()
+	}
+	deviceNames.names[id] = name
+	return name
+}
@@ -5,6 +5,11 @@ import "github.com/docker/docker/api/types"
 type Disk struct {
 	Read  float64 `json:"read" bson:"disk_read"`
 	Write float64 `json:"write" bson:"disk_write"`
+	// bytes per second since the previous set
+	ReadRate  float64 `json:"read_rate" bson:"disk_read_rate"`
+	WriteRate float64 `json:"write_rate" bson:"disk_write_rate"`
+	// per device breakdown, only with BLKIO_PER_DEVICE
+	Devices []DeviceIO `json:"devices,omitempty" bson:"disk_devices,omitempty"`
 }
 
 func NewDisk(disk types.BlkioStats) *Disk {
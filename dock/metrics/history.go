@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+)
+
+const (
+	// default time span of sets kept in memory per container
+	defaultHistoryWindow = 15 * time.Minute
+	// default minimal distance of kept sets
+	defaultHistoryResolution = 5 * time.Second
+)
+
+// History keeps the recent sets of a container in memory, independent of
+// a db, thinned out to one set per resolution
+type History struct {
+	mutex      *sync.RWMutex
+	sets       []Set
+	window     time.Duration
+	resolution time.Duration
+}
+
+func NewHistory() *History {
+	return &History{
+		mutex:      &sync.RWMutex{},
+		sets:       make([]Set, 0),
+		window:     config.Duration("HISTORY_WINDOW", defaultHistoryWindow),
+		resolution: config.Duration("HISTORY_RESOLUTION", defaultHistoryResolution),
+	}
+}
+
+// Add appends set unless it is closer than the resolution to the last
+// kept set, sets older than the window are dropped
+func (h *History) Add(set Set) {
+	when := set.When.Time()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if n := len(h.sets); n > 0 && when.Sub(h.sets[n-1].When.Time()) < h.resolution {
+		return
+	}
+	h.sets = append(h.sets, set)
+
+	cut := 0
+	for cut < len(h.sets) && when.Sub(h.sets[cut].When.Time()) > h.window {
+		cut++
+	}
+	if cut > 0 {
+		h.sets = append(h.sets[:0:0], h.sets[cut:]...)
+	}
+}
+
+// Since returns a copy of the kept sets newer than since
+func (h *History) Since(since time.Time) []Set {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	sets := make([]Set, 0, len(h.sets))
+	for _, set := range h.sets {
+		if set.When.Time().After(since) {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
@@ -0,0 +1,18 @@
+package metrics
+
+// Limits are the resource limits of a container the percentages of its
+// sets relate to, zero values are unlimited
+type Limits struct {
+	CPUs   float64
+	Memory int64
+}
+
+// SetLimits updates the limits used for the following sets
+func (m *Metrics) SetLimits(l Limits) {
+	m.limits.Store(l)
+}
+
+func (m *Metrics) Limits() Limits {
+	l, _ := m.limits.Load().(Limits)
+	return l
+}
@@ -3,20 +3,35 @@ package metrics
 import "github.com/docker/docker/api/types"
 
 type Memory struct {
+	// usage without page cache in percent of Limit
 	UsagePerc float64 `json:"perc" bson:"mem_perc"`
 	Usage     float64 `json:"usage_bytes" bson:"mem_usage_bytes"`
+	Cache     float64 `json:"cache_bytes" bson:"mem_cache_bytes"`
 	Available float64 `json:"available_bytes" bson:"mem_available_bytes"`
+	// memory limit of the container, host memory if it is unlimited
+	Limit   float64 `json:"limit_bytes" bson:"mem_limit_bytes"`
+	Limited bool    `json:"limited" bson:"mem_limited"`
 }
 
 func NewMem(mem types.MemoryStats) *Memory {
 	var memU = 0.0
 	var memP = 0.0
+	var cache uint64
 	var limit = float64(mem.Limit)
 
-	// add support for cgroup v1
-	// cgroup v2
-	if v := mem.Stats["inactive_file"]; v < mem.Usage {
-		memU = float64(mem.Usage - v)
+	// usage without inactive page cache like docker stats, see docker cli
+	// calculateMemUsageUnixNoCache: v1 reports the hierarchical
+	// total_inactive_file, v2 and v1 without hierarchy inactive_file
+	if v, v1 := mem.Stats["total_inactive_file"]; v1 {
+		cache = v
+	} else {
+		cache = mem.Stats["inactive_file"]
+	}
+
+	if cache < mem.Usage {
+		memU = float64(mem.Usage - cache)
+	} else {
+		memU = float64(mem.Usage)
 	}
 
 	// in percent
@@ -27,6 +42,22 @@ func NewMem(mem types.MemoryStats) *Memory {
 	return &Memory{
 		UsagePerc: memP,
 		Usage:     memU,
+		Cache:     float64(cache),
 		Available: limit,
+		Limit:     limit,
+	}
+}
+
+// limit relates the usage to the memory limit of the container, without
+// one the limit reported by the daemon (host memory) is kept
+func (m *Memory) limit(bytes int64) {
+	if bytes > 0 {
+		m.Limited = true
+		if m.Limit == 0 || float64(bytes) < m.Limit {
+			m.Limit = float64(bytes)
+		}
+	}
+	if m.Limit > 0 {
+		m.UsagePerc = m.Usage / m.Limit * 100
 	}
 }
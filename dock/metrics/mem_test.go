@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewMem(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    Memory
+	}{
+		// usage without total_inactive_file
+		{"stats_v1.json", Memory{
+			UsagePerc: 0.09808493585412273,
+			Usage:     8175616,
+			Cache:     2883584,
+			Available: 8335241216,
+			Limit:     8335241216,
+		}},
+		// inactive_file without total_inactive_file, cache is not subtracted
+		{"stats_v1_cache.json", Memory{
+			UsagePerc: 0.43125152587890625,
+			Usage:     9261056,
+			Cache:     1798144,
+			Available: 2147483648,
+			Limit:     2147483648,
+		}},
+		// usage without inactive_file
+		{"stats_v2.json", Memory{
+			UsagePerc: 3.212738037109375,
+			Usage:     17248256,
+			Cache:     6352896,
+			Available: 536870912,
+			Limit:     536870912,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			stats := loadStats(t, tt.fixture)
+			got := NewMem(stats.MemoryStats)
+			if math.Abs(got.UsagePerc-tt.want.UsagePerc) > 1e-9 {
+				t.Errorf("UsagePerc = %v, want %v", got.UsagePerc, tt.want.UsagePerc)
+			}
+			got.UsagePerc = tt.want.UsagePerc
+			if *got != tt.want {
+				t.Errorf("NewMem() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/client"
@@ -20,6 +21,11 @@ type Metrics struct {
 	CID       string
 	LatestSet Set
 	LatestRcv *stream.Receiver
+	// recent sets, served without a db
+	History *History
+	limits  atomic.Value
+	// kept across restarts of the container to detect counter resets
+	rates *rateCalc
 }
 
 func NewMetrics(c *client.Client, cid string) *Metrics {
@@ -28,6 +34,8 @@ func NewMetrics(c *client.Client, cid string) *Metrics {
 		Streamer: nil,
 		client:   c,
 		CID:      cid,
+		History:  NewHistory(),
+		rates:    newRateCalc(),
 	}
 }
 
@@ -54,7 +62,7 @@ func (m *Metrics) InitStr() (err error) {
 	if err != nil {
 		return
 	}
-	pipe := NewPipeline(r)
+	pipe := NewPipeline(r, m.Limits, m.rates)
 	m.Streamer = stream.NewStr(pipe)
 	go m.Streamer.Run()
 	return
@@ -83,6 +91,7 @@ func (m *Metrics) HandleLatest() {
 			m.mutex.Lock()
 			m.LatestSet = metrics
 			m.mutex.Unlock()
+			m.History.Add(metrics)
 		}
 	}
 }
@@ -5,6 +5,9 @@ import "github.com/docker/docker/api/types"
 type Net struct {
 	In  float64 `json:"in" bson:"net_in"`
 	Out float64 `json:"out" bson:"net_out"`
+	// bytes per second since the previous set
+	InRate  float64 `json:"in_rate" bson:"net_in_rate"`
+	OutRate float64 `json:"out_rate" bson:"net_out_rate"`
 }
 
 func NewNet(net map[string]types.NetworkStats) *Net {
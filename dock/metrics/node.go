@@ -0,0 +1,17 @@
+package metrics
+
+import "sync/atomic"
+
+// swarm node id of the agent, stamped on every set
+var node atomic.Value
+
+// SetNode sets the node id carried by new sets, empty outside of a swarm
+func SetNode(id string) {
+	node.Store(id)
+}
+
+// Node returns the node id carried by new sets
+func Node() string {
+	id, _ := node.Load().(string)
+	return id
+}
@@ -6,18 +6,26 @@ import (
 	"io"
 
 	"github.com/docker/docker/api/types"
+	"github.com/h0rzn/monitoring_agent/config"
 	"github.com/h0rzn/monitoring_agent/dock/stream"
 )
 
 type Pipeline struct {
-	R    io.ReadCloser
-	done chan struct{}
+	R      io.ReadCloser
+	done   chan struct{}
+	limits func() Limits
+	rates  *rateCalc
+	// break disk io down per block device
+	perDevice bool
 }
 
-func NewPipeline(r io.ReadCloser) *Pipeline {
+func NewPipeline(r io.ReadCloser, limits func() Limits, rates *rateCalc) *Pipeline {
 	return &Pipeline{
-		R:    r,
-		done: make(chan struct{}, 2),
+		R:         r,
+		done:      make(chan struct{}, 2),
+		limits:    limits,
+		rates:     rates,
+		perDevice: config.Bool("BLKIO_PER_DEVICE", false),
 	}
 }
 
@@ -52,7 +60,13 @@ func (p *Pipeline) toSet(parsed chan types.StatsJSON) chan stream.Set {
 	go func() {
 		defer close(out)
 		for in := range parsed {
-			set := stream.NewSet("metrics", NewSetWithJSON(in))
+			metrics := NewSetWithLimits(in, p.limits())
+			if p.perDevice && !IsWindows(in) {
+				metrics.Disk.Devices = NewDevices(in.BlkioStats)
+			}
+			p.rates.apply(&metrics)
+			metrics.Node = Node()
+			set := stream.NewSet("metrics", metrics)
 			select {
 			case out <- *set:
 			case <-p.done:
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"sync"
+)
+
+// rateCalc derives per second rates from the cumulative network and disk
+// counters of consecutive sets. The counters start at zero again when the
+// container restarts, a decreasing counter is taken as reset and its value
+// as the increase since then
+type rateCalc struct {
+	mutex *sync.Mutex
+	prev  *Set
+}
+
+func newRateCalc() *rateCalc {
+	return &rateCalc{
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (rc *rateCalc) apply(set *Set) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	prev := rc.prev
+	cur := *set
+	rc.prev = &cur
+	if prev == nil {
+		return
+	}
+	secs := set.When.Time().Sub(prev.When.Time()).Seconds()
+	if secs <= 0 {
+		return
+	}
+
+	var reset bool
+	if set.available("net") && prev.available("net") {
+		set.Net.InRate = rate(prev.Net.In, set.Net.In, secs, &reset)
+		set.Net.OutRate = rate(prev.Net.Out, set.Net.Out, secs, &reset)
+	}
+	if set.available("disk") && prev.available("disk") {
+		set.Disk.ReadRate = rate(prev.Disk.Read, set.Disk.Read, secs, &reset)
+		set.Disk.WriteRate = rate(prev.Disk.Write, set.Disk.Write, secs, &reset)
+	}
+	set.Reset = reset
+}
+
+func rate(prev, cur, secs float64, reset *bool) float64 {
+	if cur < prev {
+		*reset = true
+		return cur / secs
+	}
+	return (cur - prev) / secs
+}
+
+// available reports whether the daemon reported the stats group
+func (s Set) available(group string) bool {
+	for _, missing := range s.Unavailable {
+		if missing == group {
+			return false
+		}
+	}
+	return true
+}
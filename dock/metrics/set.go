@@ -14,6 +14,12 @@ type Set struct {
 	Mem  Memory             `json:"memory" bson:"mem,inline"`
 	Disk Disk               `json:"disk" bson:"disk,inline"`
 	Net  Net                `json:"net" bson:"net,inline"`
+	// stats groups the daemon did not report, e.g. rootless without cgroup delegation
+	Unavailable []string `json:"unavailable,omitempty" bson:"unavailable,omitempty"`
+	// network or disk counters restarted since the previous set
+	Reset bool `json:"counter_reset,omitempty" bson:"counter_reset,omitempty"`
+	// swarm node the set was read on
+	Node string `json:"node,omitempty" bson:"node,omitempty"`
 }
 
 func NewSet(r io.Reader) Set {
@@ -25,49 +31,112 @@ func NewSet(r io.Reader) Set {
 }
 
 func NewSetWithJSON(stats types.StatsJSON) Set {
+	return NewSetWithLimits(stats, Limits{})
+}
+
+// NewSetWithLimits creates a set whose limit percentages relate to limits
+func NewSetWithLimits(stats types.StatsJSON, limits Limits) Set {
+	var set Set
+	if IsWindows(stats) {
+		set = NewWindowsSet(stats)
+	} else {
+		set = newLinuxSet(stats)
+	}
+	set.CPU.limit(limits.CPUs)
+	set.Mem.limit(limits.Memory)
+	return set
+}
+
+func newLinuxSet(stats types.StatsJSON) Set {
 	return Set{
-		When: primitive.NewDateTimeFromTime(stats.Read), //stats.Read.Format(time.RFC3339Nano),
-		CPU:  *NewCPU(stats.PreCPUStats, stats.CPUStats),
-		Mem:  *NewMem(stats.MemoryStats),
-		Disk: *NewDisk(stats.BlkioStats),
-		Net:  *NewNet(stats.Networks),
+		When:        primitive.NewDateTimeFromTime(stats.Read), //stats.Read.Format(time.RFC3339Nano),
+		CPU:         *NewCPU(stats.PreCPUStats, stats.CPUStats),
+		Mem:         *NewMem(stats.MemoryStats),
+		Disk:        *NewDisk(stats.BlkioStats),
+		Net:         *NewNet(stats.Networks),
+		Unavailable: unavailable(stats),
 	}
 }
 
+// unavailable lists the stats groups missing from a frame so zeros
+// are not mistaken for real measurements. Rootless daemons without
+// cgroup delegation report empty cpu, memory and blkio stats
+func unavailable(stats types.StatsJSON) []string {
+	missing := make([]string, 0)
+	if stats.CPUStats.CPUUsage.TotalUsage == 0 && stats.CPUStats.SystemUsage == 0 {
+		missing = append(missing, "cpu")
+	}
+	if stats.MemoryStats.Usage == 0 && stats.MemoryStats.Limit == 0 {
+		missing = append(missing, "memory")
+	}
+	if stats.BlkioStats.IoServiceBytesRecursive == nil {
+		missing = append(missing, "disk")
+	}
+	if stats.Networks == nil {
+		missing = append(missing, "net")
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return missing
+}
+
 func Average(metrics []Set) Set {
 	var result Set
 	c := len(metrics)
 	cfloat := float64(c)
 
 	for _, set := range metrics {
+		result.Reset = result.Reset || set.Reset
 		result.CPU.UsagePerc += set.CPU.UsagePerc
+		result.CPU.HostPerc += set.CPU.HostPerc
+		result.CPU.LimitPerc += set.CPU.LimitPerc
 		result.CPU.Online += set.CPU.Online
+		result.CPU.ThrottledPeriods += set.CPU.ThrottledPeriods
+		result.CPU.ThrottledTime += set.CPU.ThrottledTime
 
 		result.Mem.Usage += set.Mem.Usage
 		result.Mem.UsagePerc += set.Mem.UsagePerc
+		result.Mem.Cache += set.Mem.Cache
 		result.Mem.Available += set.Mem.Available
+		result.Mem.Limit += set.Mem.Limit
 
 		result.Disk.Read += set.Disk.Read
 		result.Disk.Write += set.Disk.Write
+		result.Disk.ReadRate += set.Disk.ReadRate
+		result.Disk.WriteRate += set.Disk.WriteRate
 
 		result.Net.In += set.Net.In
 		result.Net.Out += set.Net.Out
+		result.Net.InRate += set.Net.InRate
+		result.Net.OutRate += set.Net.OutRate
 	}
 
 	result.When = metrics[len(metrics)-1].When
+	result.Node = metrics[len(metrics)-1].Node
 
 	result.CPU.UsagePerc = result.CPU.UsagePerc / cfloat
+	result.CPU.HostPerc = result.CPU.HostPerc / cfloat
+	result.CPU.LimitPerc = result.CPU.LimitPerc / cfloat
 	result.CPU.Online = result.CPU.Online / cfloat
+	result.CPU.ThrottledPeriods = result.CPU.ThrottledPeriods / cfloat
+	result.CPU.ThrottledTime = result.CPU.ThrottledTime / cfloat
 
 	result.Mem.Usage = result.Mem.Usage / cfloat
 	result.Mem.UsagePerc = result.Mem.UsagePerc / cfloat
+	result.Mem.Cache = result.Mem.Cache / cfloat
 	result.Mem.Available = result.Mem.Available / cfloat
+	result.Mem.Limit = result.Mem.Limit / cfloat
 
 	result.Disk.Read = result.Disk.Read / cfloat
-	result.Disk.Read = result.Disk.Read / cfloat
+	result.Disk.Write = result.Disk.Write / cfloat
+	result.Disk.ReadRate = result.Disk.ReadRate / cfloat
+	result.Disk.WriteRate = result.Disk.WriteRate / cfloat
 
 	result.Net.In = result.Net.In / cfloat
 	result.Net.Out = result.Net.Out / cfloat
+	result.Net.InRate = result.Net.InRate / cfloat
+	result.Net.OutRate = result.Net.OutRate / cfloat
 
 	return result
 }
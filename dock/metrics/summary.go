@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Summary aggregates the latest sets of all running containers
+type Summary struct {
+	When    primitive.DateTime `json:"when"`
+	Running int                `json:"running"`
+	Total   Set                `json:"total"`
+	Average Set                `json:"average"`
+}
+
+func NewSummary(latest []Set) Summary {
+	summary := Summary{
+		When:    primitive.NewDateTimeFromTime(time.Now()),
+		Running: len(latest),
+		Total:   Sum(latest),
+	}
+	if len(latest) > 0 {
+		summary.Average = Average(latest)
+	}
+	summary.Average.When = summary.When
+	return summary
+}
+
+// Sum adds up sets, e.g. the latest sets of all running containers
+func Sum(latest []Set) Set {
+	var result Set
+	result.When = primitive.NewDateTimeFromTime(time.Now())
+	result.Node = Node()
+
+	for _, set := range latest {
+		// cpu
+		result.CPU.UsagePerc += set.CPU.UsagePerc
+		result.CPU.HostPerc += set.CPU.HostPerc
+		result.CPU.LimitPerc += set.CPU.LimitPerc
+		result.CPU.Online += set.CPU.Online
+		result.CPU.ThrottledPeriods += set.CPU.ThrottledPeriods
+		result.CPU.ThrottledTime += set.CPU.ThrottledTime
+		// mem
+		result.Mem.Usage += set.Mem.Usage
+		result.Mem.Cache += set.Mem.Cache
+		result.Mem.UsagePerc += set.Mem.UsagePerc
+		result.Mem.Available += set.Mem.Available
+		result.Mem.Limit += set.Mem.Limit
+		// disk
+		result.Disk.Read += set.Disk.Read
+		result.Disk.Write += set.Disk.Write
+		result.Disk.ReadRate += set.Disk.ReadRate
+		result.Disk.WriteRate += set.Disk.WriteRate
+		// net
+		result.Net.In += set.Net.In
+		result.Net.Out += set.Net.Out
+		result.Net.InRate += set.Net.InRate
+		result.Net.OutRate += set.Net.OutRate
+	}
+	return result
+}
+
+// SumSeries splits from-to into n intervals, averages every series (the
+// sets of one container) per interval and sums the averages. Intervals
+// without sets are left out
+func SumSeries(series [][]Set, from time.Time, to time.Time, n int) []Set {
+	width := to.Sub(from) / time.Duration(n)
+	if width <= 0 {
+		return []Set{}
+	}
+	result := make([]Set, 0, n)
+	for i := 0; i < n; i++ {
+		start := from.Add(time.Duration(i) * width)
+		end := start.Add(width)
+		averages := make([]Set, 0, len(series))
+		for _, sets := range series {
+			in := make([]Set, 0)
+			for _, set := range sets {
+				when := set.When.Time()
+				if !when.Before(start) && when.Before(end) {
+					in = append(in, set)
+				}
+			}
+			if len(in) > 0 {
+				averages = append(averages, Average(in))
+			}
+		}
+		if len(averages) == 0 {
+			continue
+		}
+		sum := Sum(averages)
+		sum.When = primitive.NewDateTimeFromTime(start)
+		result = append(result, sum)
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/docker/docker/api/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IsWindows reports whether a stats frame stems from a windows daemon,
+// which reports a process count and no cgroup data
+func IsWindows(stats types.StatsJSON) bool {
+	return stats.NumProcs > 0
+}
+
+// NewWindowsSet maps the windows counters onto the metric model
+func NewWindowsSet(stats types.StatsJSON) Set {
+	set := Set{
+		When: primitive.NewDateTimeFromTime(stats.Read),
+		CPU:  *NewWindowsCPU(stats),
+		Mem: Memory{
+			// private working set is what task manager shows, there is no limit
+			Usage: float64(stats.MemoryStats.PrivateWorkingSet),
+		},
+		Disk: Disk{
+			Read:  float64(stats.StorageStats.ReadSizeBytes),
+			Write: float64(stats.StorageStats.WriteSizeBytes),
+		},
+		Net: *NewNet(stats.Networks),
+	}
+	if stats.Networks == nil {
+		set.Unavailable = []string{"net"}
+	}
+	return set
+}
+
+// NewWindowsCPU calculates the cpu percentage like the docker cli does for
+// windows: cpu time is given in 100ns intervals across all processors
+func NewWindowsCPU(stats types.StatsJSON) *CPU {
+	var cpuPerc = 0.0
+	possIntervals := uint64(stats.Read.Sub(stats.PreRead).Nanoseconds())
+	possIntervals /= 100
+	possIntervals *= uint64(stats.NumProcs)
+
+	prev := stats.PreCPUStats.CPUUsage.TotalUsage
+	cur := stats.CPUStats.CPUUsage.TotalUsage
+	if possIntervals > 0 && cur > prev {
+		cpuPerc = float64(cur-prev) / float64(possIntervals) * 100.0
+	}
+
+	// already relative to all processors
+	return &CPU{
+		UsagePerc: cpuPerc,
+		HostPerc:  cpuPerc,
+		Online:    float64(stats.NumProcs),
+	}
+}
@@ -0,0 +1,295 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/h0rzn/monitoring_agent/config"
+)
+
+// manifest media types accepted when resolving tag digests
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// lifetime of registry tokens without expires_in, see the token spec
+const tokenLifetime = 60 * time.Second
+
+// Auth returns the credentials for a registry host, empty if anonymous
+type Auth func(host string) (user, password string)
+
+// Client queries the tags of v2 registries (docker hub, ghcr, ...). The
+// auth challenge of a registry is remembered and its tokens are reused per
+// realm and scope until they expire
+type Client struct {
+	http       *http.Client
+	auth       Auth
+	mutex      *sync.Mutex
+	challenges map[string]authChallenge
+	tokens     map[string]cachedToken
+}
+
+// authChallenge is the WWW-Authenticate challenge of a registry host
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+type Tag struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest,omitempty"`
+}
+
+func NewClient(auth Auth) *Client {
+	return &Client{
+		http:       &http.Client{Timeout: 15 * time.Second},
+		auth:       auth,
+		mutex:      &sync.Mutex{},
+		challenges: make(map[string]authChallenge),
+		tokens:     make(map[string]cachedToken),
+	}
+}
+
+// Repo resolves an image reference (e.g. "nginx:1.23") to the registry
+// host and repository path
+func Repo(ref string) (host, path string, err error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", "", err
+	}
+	host = reference.Domain(named)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return host, reference.Path(named), nil
+}
+
+// Tags lists the tags of the repository of ref, the digests of the last
+// limit tags (and of the tag of ref) are resolved. Digests failing to
+// resolve are left empty
+func (c *Client) Tags(ref string, limit int) ([]Tag, error) {
+	host, path, err := Repo(ref)
+	if err != nil {
+		return nil, err
+	}
+	scope := fmt.Sprintf("repository:%s:pull", path)
+
+	resp, err := c.do(http.MethodGet, fmt.Sprintf("https://%s/v2/%s/tags/list", host, path), scope, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry %s: tag list: %s", host, resp.Status)
+	}
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+
+	current := ""
+	if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			current = tagged.Tag()
+		}
+	}
+	tags := make([]Tag, len(list.Tags))
+	for i, name := range list.Tags {
+		tags[i] = Tag{Name: name}
+		if i < len(list.Tags)-limit && name != current {
+			continue
+		}
+		if digest, err := c.digest(host, path, name, scope); err == nil {
+			tags[i].Digest = digest
+		}
+	}
+	return tags, nil
+}
+
+// digest resolves the manifest digest of tag
+func (c *Client) digest(host, path, tag, scope string) (string, error) {
+	header := http.Header{"Accept": {strings.Join(manifestTypes, ", ")}}
+	resp, err := c.do(http.MethodHead, fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, path, tag), scope, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry %s: manifest %s: %s", host, tag, resp.Status)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// do sends the request, authorized up front if the challenge of the host
+// is known, and answers an auth challenge once
+func (c *Client) do(method, target, scope string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	c.mutex.Lock()
+	known, ok := c.challenges[req.URL.Host]
+	c.mutex.Unlock()
+	if ok {
+		if err = c.authorize(req, known, scope); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.http.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	scheme, params := challenge(resp.Header.Get("WWW-Authenticate"))
+	ch := authChallenge{scheme: scheme, params: params}
+	c.mutex.Lock()
+	c.challenges[req.URL.Host] = ch
+	if ok {
+		// the cached token was refused
+		delete(c.tokens, tokenKey(params, scope))
+	}
+	c.mutex.Unlock()
+	if err = c.authorize(req, ch, scope); err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+// authorize sets the credentials answering ch on req
+func (c *Client) authorize(req *http.Request, ch authChallenge, scope string) error {
+	user, password := "", ""
+	if c.auth != nil {
+		user, password = c.auth(req.URL.Host)
+	}
+	switch ch.scheme {
+	case "bearer":
+		token, err := c.token(ch.params, scope, user, password)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		if user == "" {
+			return fmt.Errorf("registry %s requires credentials", req.URL.Host)
+		}
+		req.SetBasicAuth(user, password)
+	default:
+		return fmt.Errorf("registry %s: unsupported auth challenge %q", req.URL.Host, ch.scheme)
+	}
+	return nil
+}
+
+// tokenKey identifies the tokens of a realm for scope
+func tokenKey(params map[string]string, scope string) string {
+	return params["realm"] + " " + params["service"] + " " + scope
+}
+
+// token returns the cached bearer token of the realm of the challenge for
+// scope or fetches a new one
+func (c *Client) token(params map[string]string, scope, user, password string) (string, error) {
+	key := tokenKey(params, scope)
+	c.mutex.Lock()
+	cached, ok := c.tokens[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", errors.New("registry auth challenge without realm")
+	}
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token %s: %s", realm.Host, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	// tokens without expires_in are valid for 60 seconds, renewed a little
+	// early to not run out in flight
+	lifetime := time.Duration(body.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = tokenLifetime
+	}
+	c.mutex.Lock()
+	c.tokens[key] = cachedToken{token: body.Token, expires: time.Now().Add(lifetime - tokenLifetime/6)}
+	c.mutex.Unlock()
+	return body.Token, nil
+}
+
+// challenge parses a WWW-Authenticate header like
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+func challenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme = strings.ToLower(parts[0])
+	if len(parts) < 2 {
+		return
+	}
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return
+}
+
+// EnvAuth uses REGISTRY_USER and REGISTRY_PASSWORD for REGISTRY_HOST
+// (default docker hub), other registries are queried anonymously
+func EnvAuth(host string) (user, password string) {
+	if host != config.String("REGISTRY_HOST", "registry-1.docker.io") {
+		return "", ""
+	}
+	return config.String("REGISTRY_USER", ""), config.String("REGISTRY_PASSWORD", "")
+}
@@ -1,9 +1,42 @@
 package stream
 
 import (
+	"sync"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/h0rzn/monitoring_agent/selfmetrics"
 	"github.com/sirupsen/logrus"
 )
 
+// default sets buffered per receiver before new sets are skipped
+const defaultReceiverBuffer = 1
+
+// live receivers of all streamers, for the queue depth self-metrics
+var live = struct {
+	mutex     *sync.Mutex
+	receivers map[*Receiver]bool
+}{
+	mutex:     &sync.Mutex{},
+	receivers: make(map[*Receiver]bool),
+}
+
+func init() {
+	selfmetrics.Register("stream_receivers", func() float64 {
+		live.mutex.Lock()
+		defer live.mutex.Unlock()
+		return float64(len(live.receivers))
+	})
+	selfmetrics.Register("stream_receiver_queue", func() float64 {
+		live.mutex.Lock()
+		defer live.mutex.Unlock()
+		depth := 0
+		for r := range live.receivers {
+			depth += len(r.In)
+		}
+		return float64(depth)
+	})
+}
+
 type Receiver struct {
 	// interval streamer
 	Interv  bool
@@ -15,7 +48,7 @@ type Receiver struct {
 func NewReceiver(interv bool, leave chan *Receiver) *Receiver {
 	return &Receiver{
 		Interv:  interv,
-		In:      make(chan Set, 1),
+		In:      make(chan Set, config.Int("STREAM_BUFFER", defaultReceiverBuffer)),
 		Leave:   leave,
 		Closing: make(chan struct{}, 1),
 	}
@@ -29,6 +29,9 @@ func (st *Storage) Add(interv bool) *Receiver {
 	st.mutex.Lock()
 	st.Receivers[r] = true
 	st.mutex.Unlock()
+	live.mutex.Lock()
+	live.receivers[r] = true
+	live.mutex.Unlock()
 	return r
 }
 
@@ -40,6 +43,9 @@ func (st *Storage) Cls(rcv *Receiver) {
 		delete(st.Receivers, rcv)
 	}
 	st.mutex.Unlock()
+	live.mutex.Lock()
+	delete(live.receivers, rcv)
+	live.mutex.Unlock()
 }
 
 func NewStr(pipeline Pipeline) *Str {
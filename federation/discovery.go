@@ -0,0 +1,432 @@
+package federation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// DNS-SD service type agents advertise
+	serviceType = "_metawatch._tcp.local."
+	// meta query listing the service types of a network
+	servicesMeta = "_services._dns-sd._udp.local."
+	// ttl of the advertised records
+	recordTTL = 120
+	// default interval the network is browsed at
+	defaultBrowseInterval = time.Minute
+	// bits of the class field of mdns: unicast response wanted in
+	// questions, cache flush in answers
+	classMask = 0x7fff
+	classQU   = 0x8000
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Agent is an agent found on the network
+type Agent struct {
+	// instance name, the name the agent advertises itself under
+	Name      string   `json:"name"`
+	Host      string   `json:"host"`
+	Port      int      `json:"port"`
+	Addresses []string `json:"addresses"`
+	// BASE_PATH and api versions of the agent
+	Path string `json:"path"`
+	API  string `json:"api,omitempty"`
+	// root of the agent for PEERS, built from the first address
+	URL      string    `json:"url"`
+	LastSeen time.Time `json:"last_seen"`
+	expires  time.Time
+}
+
+// Discovery advertises the agent with mdns/DNS-SD as _metawatch._tcp and
+// browses the network for other agents. Only IPv4 is spoken
+type Discovery struct {
+	mutex    *sync.Mutex
+	conn     *net.UDPConn
+	pconn    *ipv4.PacketConn
+	iface    *net.Interface
+	instance string
+	host     string
+	port     int
+	txt      []string
+	interval time.Duration
+	agents   map[string]*Agent
+	found    func(Agent)
+	// records of other agents that arrived before their SRV
+	addrs map[string][]string
+	txts  map[string][]string
+}
+
+// NewDiscovery advertises the agent as MDNS_NAME (the host name by
+// default) on port, txt are the key=value pairs of its TXT record.
+// MDNS_INTERFACE restricts it to one interface
+func NewDiscovery(port int, txt []string) (*Discovery, error) {
+	hostname, _ := os.Hostname()
+	hostname = strings.SplitN(hostname, ".", 2)[0]
+	instance := config.String("MDNS_NAME", hostname)
+	if instance == "" || strings.ContainsAny(instance, ".") {
+		return nil, fmt.Errorf("mdns name %q has to be a single label", instance)
+	}
+	var iface *net.Interface
+	if name := config.String("MDNS_INTERFACE", ""); name != "" {
+		var err error
+		if iface, err = net.InterfaceByName(name); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", iface, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	pconn := ipv4.NewPacketConn(conn)
+	pconn.SetMulticastTTL(255)
+	pconn.SetMulticastLoopback(true)
+	if iface != nil {
+		pconn.SetMulticastInterface(iface)
+	} else {
+		// the default interface is joined already, the others are not
+		ifaces, _ := net.Interfaces()
+		for i := range ifaces {
+			if ifaces[i].Flags&net.FlagUp != 0 && ifaces[i].Flags&net.FlagMulticast != 0 {
+				pconn.JoinGroup(&ifaces[i], mdnsGroup)
+			}
+		}
+	}
+	return &Discovery{
+		mutex:    &sync.Mutex{},
+		conn:     conn,
+		pconn:    pconn,
+		iface:    iface,
+		instance: instance,
+		host:     instance + ".local.",
+		port:     port,
+		txt:      txt,
+		interval: config.Duration("MDNS_INTERVAL", defaultBrowseInterval),
+		agents:   make(map[string]*Agent),
+		addrs:    make(map[string][]string),
+		txts:     make(map[string][]string),
+	}, nil
+}
+
+// Run announces the agent, answers queries and browses the network
+func (d *Discovery) Run() {
+	logrus.Infof("- DISCOVERY - advertising %s.%s on port %d\n", d.instance, serviceType, d.port)
+	go d.read()
+	go func() {
+		// announced twice like RFC 6762 8.3 asks for
+		d.announce(recordTTL)
+		time.Sleep(time.Second)
+		d.announce(recordTTL)
+	}()
+	go func() {
+		for {
+			d.query()
+			time.Sleep(d.interval)
+		}
+	}()
+}
+
+// OnFound calls found for every agent that appears, set before Run
+func (d *Discovery) OnFound(found func(Agent)) {
+	d.found = found
+}
+
+// Stop says goodbye, other agents drop this one right away
+func (d *Discovery) Stop() {
+	d.announce(0)
+}
+
+// Agents returns the agents found, without this one
+func (d *Discovery) Agents() []Agent {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	agents := make([]Agent, 0, len(d.agents))
+	for name, a := range d.agents {
+		if time.Now().After(a.expires) {
+			delete(d.agents, name)
+			continue
+		}
+		agents = append(agents, *a)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+	return agents
+}
+
+func (d *Discovery) read() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			logrus.Errorf("- DISCOVERY - read failed, stopping: %s\n", err)
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		if msg.Header.Response {
+			d.learn(msg)
+		} else {
+			d.answer(msg, src)
+		}
+	}
+}
+
+// answer responds to the questions of msg about this agent
+func (d *Discovery) answer(msg dnsmessage.Message, src *net.UDPAddr) {
+	answers := make([]dnsmessage.Resource, 0)
+	unicast := src.Port != mdnsGroup.Port
+	for _, q := range msg.Questions {
+		name := strings.ToLower(q.Name.String())
+		unicast = unicast || uint16(q.Class)&classQU != 0
+		switch {
+		case name == servicesMeta && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+			answers = append(answers, ptrRecord(servicesMeta, serviceType, recordTTL))
+		case name == serviceType && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+			answers = append(answers, d.records(recordTTL)...)
+		case name == strings.ToLower(d.instance+"."+serviceType):
+			answers = append(answers, d.records(recordTTL)[1:]...)
+		case name == strings.ToLower(d.host) && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL):
+			answers = append(answers, d.addresses(recordTTL)...)
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	reply := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: answers,
+	}
+	dst := mdnsGroup
+	if unicast {
+		dst = src
+	}
+	if src.Port != mdnsGroup.Port {
+		// legacy unicast queries get their id and questions back
+		reply.Header.ID = msg.Header.ID
+		reply.Questions = msg.Questions
+	}
+	d.send(reply, dst)
+}
+
+// announce sends the records unasked, ttl 0 withdraws them
+func (d *Discovery) announce(ttl uint32) {
+	d.send(dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: d.records(ttl),
+	}, mdnsGroup)
+}
+
+// query asks the network for agents
+func (d *Discovery) query() {
+	name, _ := dnsmessage.NewName(serviceType)
+	d.send(dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}, mdnsGroup)
+}
+
+func (d *Discovery) send(msg dnsmessage.Message, dst *net.UDPAddr) {
+	data, err := msg.Pack()
+	if err != nil {
+		logrus.Errorf("- DISCOVERY - failed to pack message: %s\n", err)
+		return
+	}
+	if _, err = d.conn.WriteToUDP(data, dst); err != nil {
+		logrus.Debugf("- DISCOVERY - send to %s failed: %s\n", dst, err)
+	}
+}
+
+// records returns the PTR, SRV, TXT and A records of this agent
+func (d *Discovery) records(ttl uint32) []dnsmessage.Resource {
+	instance := d.instance + "." + serviceType
+	records := []dnsmessage.Resource{ptrRecord(serviceType, instance, ttl)}
+	name, _ := dnsmessage.NewName(instance)
+	target, _ := dnsmessage.NewName(d.host)
+	records = append(records, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.SRVResource{Target: target, Port: uint16(d.port)},
+	}, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.TXTResource{TXT: d.txt},
+	})
+	return append(records, d.addresses(ttl)...)
+}
+
+// addresses returns an A record per IPv4 address of the interfaces
+func (d *Discovery) addresses(ttl uint32) []dnsmessage.Resource {
+	var addrs []net.Addr
+	if d.iface != nil {
+		addrs, _ = d.iface.Addrs()
+	} else {
+		addrs, _ = net.InterfaceAddrs()
+	}
+	host, _ := dnsmessage.NewName(d.host)
+	records := make([]dnsmessage.Resource, 0)
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		var a [4]byte
+		copy(a[:], ipnet.IP.To4())
+		records = append(records, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: host, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: a},
+		})
+	}
+	return records
+}
+
+func ptrRecord(name, target string, ttl uint32) dnsmessage.Resource {
+	n, _ := dnsmessage.NewName(name)
+	t, _ := dnsmessage.NewName(target)
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: n, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: t},
+	}
+}
+
+// learn keeps the agents announced in a response
+func (d *Discovery) learn(msg dnsmessage.Message) {
+	records := append(msg.Answers, msg.Additionals...)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	// addresses and texts first, the SRV records refer to them. A response
+	// carries all addresses of a host, they replace the known ones
+	addrs := make(map[string][]string)
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs[name] = append(addrs[name], net.IP(body.A[:]).String())
+		case *dnsmessage.TXTResource:
+			d.txts[name] = body.TXT
+		}
+	}
+	for name, list := range addrs {
+		d.addrs[name] = list
+	}
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			instance := strings.ToLower(body.PTR.String())
+			if name == serviceType && r.Header.TTL == 0 {
+				delete(d.agents, instance)
+			}
+		case *dnsmessage.SRVResource:
+			if !strings.HasSuffix(name, "."+serviceType) || uint16(r.Header.Class)&classMask != uint16(dnsmessage.ClassINET) {
+				continue
+			}
+			if r.Header.TTL == 0 {
+				delete(d.agents, name)
+				continue
+			}
+			label := r.Header.Name.String()
+			label = label[:len(label)-len(serviceType)-1]
+			if strings.EqualFold(label, d.instance) {
+				continue
+			}
+			_, known := d.agents[name]
+			a := d.agent(label, strings.ToLower(body.Target.String()), int(body.Port), d.txts[name], r.Header.TTL)
+			d.agents[name] = a
+			if !known && d.found != nil {
+				logrus.Infof("- DISCOVERY - found %s at %s\n", a.Name, a.URL)
+				go d.found(*a)
+			}
+		}
+	}
+}
+
+// agent builds the agent of an SRV record, callers hold the lock
+func (d *Discovery) agent(label, target string, port int, txt []string, ttl uint32) *Agent {
+	a := &Agent{
+		Name:      label,
+		Host:      strings.TrimSuffix(target, "."),
+		Port:      port,
+		Addresses: d.addrs[target],
+		LastSeen:  time.Now(),
+		expires:   time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	if a.Addresses == nil {
+		a.Addresses = make([]string, 0)
+	}
+	for _, kv := range txt {
+		key, value, _ := strings.Cut(kv, "=")
+		switch key {
+		case "path":
+			a.Path = value
+		case "api":
+			a.API = value
+		}
+	}
+	host := a.Host
+	if len(a.Addresses) > 0 {
+		host = a.Addresses[0]
+	}
+	a.URL = a.root(host)
+	return a
+}
+
+// Listed returns the root of the agent at a target of list, false if none
+// is listed. Entries are host names, matched with and without the .local
+// domain, or addresses and CIDR ranges. The agent is dialed at the listed
+// host or address only, never at an address it merely advertised
+func (a Agent) Listed(list []string) (string, bool) {
+	host := strings.ToLower(a.Host)
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSuffix(entry, "."))
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			for _, addr := range a.Addresses {
+				if ip := net.ParseIP(addr); ip != nil && cidr.Contains(ip) {
+					return a.root(addr), true
+				}
+			}
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			for _, addr := range a.Addresses {
+				if ip.Equal(net.ParseIP(addr)) {
+					return a.root(addr), true
+				}
+			}
+			continue
+		}
+		if entry == host || entry+".local" == host {
+			return a.root(a.Host), true
+		}
+	}
+	return "", false
+}
+
+// root returns the url of the agent at host
+func (a Agent) root(host string) string {
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(a.Port)) + a.Path
+}
+
+// ListenPort returns the tcp port of a listen address like ":8080"
+func ListenPort(addr string) (int, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 {
+		return 0, errors.New("listen address " + addr + " has no port")
+	}
+	return p, nil
+}
@@ -0,0 +1,255 @@
+// Package federation lets an agent aggregate peer agents: it logs in to
+// the peers listed in PEERS, polls their container inventories and opens
+// their streams. Containers of peers are addressed by host-prefixed ids,
+// "<peer>/<container id>"
+package federation
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// default interval the inventories of the peers are polled at
+const defaultPollInterval = 10 * time.Second
+
+// default time pushing peers are kept after losing their link
+const defaultPushExpire = 10 * time.Minute
+
+var peerName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Container is a container of a peer as the peer serves it, with its id
+// prefixed and the peer as host
+type Container map[string]interface{}
+
+func (c Container) prefix(peer string) {
+	if id, ok := c["id"].(string); ok {
+		c["id"] = JoinID(peer, id)
+	}
+	c["host"] = peer
+}
+
+// JoinID returns the host-prefixed id of container id of peer
+func JoinID(peer, id string) string {
+	return peer + "/" + id
+}
+
+// SplitID splits a host-prefixed id, ok is false for ids of the local host
+func SplitID(id string) (peer string, cid string, ok bool) {
+	i := strings.IndexByte(id, '/')
+	if i <= 0 || i == len(id)-1 {
+		return "", id, false
+	}
+	return id[:i], id[i+1:], true
+}
+
+// Federation holds the peers of an aggregating agent
+type Federation struct {
+	mutex    *sync.RWMutex
+	peers    map[string]*Peer
+	interval time.Duration
+	// credentials of peers without their own
+	user     string
+	password string
+	// peers may push their links, see Accept
+	accept bool
+	// pushing peers whose link is lost longer are removed
+	expire time.Duration
+}
+
+// New reads the peers from PEERS, a list of name=url entries. Peers log in
+// with PEER_USER and PEER_PASSWORD unless their url carries credentials.
+// With PUSH_ACCEPT further peers can connect themselves
+func New() *Federation {
+	f := &Federation{
+		mutex:    &sync.RWMutex{},
+		peers:    make(map[string]*Peer),
+		interval: config.Duration("PEER_INTERVAL", defaultPollInterval),
+		accept:   config.Bool("PUSH_ACCEPT", false),
+		expire:   config.Duration("PUSH_PEER_EXPIRE", defaultPushExpire),
+		user:     config.String("PEER_USER", ""),
+		password: config.String("PEER_PASSWORD", ""),
+	}
+	for _, entry := range config.List("PEERS", nil) {
+		name, raw, found := strings.Cut(entry, "=")
+		if !found || !peerName.MatchString(name) {
+			logrus.Errorf("- FEDERATION - peer %q is not of the form name=url\n", entry)
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			logrus.Errorf("- FEDERATION - peer %s: %q is not a http(s) url\n", name, raw)
+			continue
+		}
+		if _, exists := f.peers[name]; exists {
+			logrus.Errorf("- FEDERATION - peer %s listed twice\n", name)
+			continue
+		}
+		f.peers[name] = newPeer(name, u, f.user, f.password)
+	}
+	return f
+}
+
+// Enabled reports whether the agent aggregates peers
+func (f *Federation) Enabled() bool {
+	return len(f.peers) > 0 || f.accept
+}
+
+// Accepting reports whether peers may push their links
+func (f *Federation) Accepting() bool {
+	return f.accept
+}
+
+// ErrPushOwner is returned for a link pushed under the name of a peer
+// another user registered
+var ErrPushOwner = errors.New("peer name is pushed by another user")
+
+// Accept serves the link owner pushed under name. The name is bound to the
+// user pushing it first, a peer reconnecting as the same user replaces its
+// previous link. Names of the peers of PEERS are refused. Peers whose link
+// stays lost for PUSH_PEER_EXPIRE are removed, freeing their name
+func (f *Federation) Accept(name, owner string, conn *websocket.Conn) error {
+	if !peerName.MatchString(name) {
+		return errors.New("peer names consist of letters, digits, _, . and -")
+	}
+	f.mutex.Lock()
+	p, exists := f.peers[name]
+	if !exists {
+		p = newPushedPeer(name, owner)
+		f.peers[name] = p
+		logrus.Infof("- FEDERATION - %s pushes its link as %s\n", name, owner)
+		go p.follow()
+	}
+	if !p.link.pushed {
+		f.mutex.Unlock()
+		return errors.New("peer " + name + " is dialed, it cannot push")
+	}
+	if p.owner != owner {
+		f.mutex.Unlock()
+		return ErrPushOwner
+	}
+	// not expired while the link is accepted
+	p.link.setDown(time.Time{})
+	f.mutex.Unlock()
+	go p.link.accept(conn)
+	return nil
+}
+
+// expirePushed removes the pushing peers whose link is lost longer than
+// PUSH_PEER_EXPIRE
+func (f *Federation) expirePushed() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		f.mutex.Lock()
+		for name, p := range f.peers {
+			down := p.link.downSince()
+			if !p.link.pushed || down.IsZero() || time.Since(down) < f.expire {
+				continue
+			}
+			delete(f.peers, name)
+			close(p.done)
+			logrus.Infof("- FEDERATION - %s expired, its link is lost since %s\n", name, down.Format(time.RFC3339))
+		}
+		f.mutex.Unlock()
+	}
+}
+
+// Add aggregates the agent at raw as peer name unless the name is taken,
+// e.g. for agents found on the network
+func (f *Federation) Add(name, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !peerName.MatchString(name) {
+		return errors.New("invalid peer " + name + "=" + raw)
+	}
+	f.mutex.Lock()
+	if _, exists := f.peers[name]; exists {
+		f.mutex.Unlock()
+		return nil
+	}
+	p := newPeer(name, u, f.user, f.password)
+	f.peers[name] = p
+	f.mutex.Unlock()
+	logrus.Infof("- FEDERATION - aggregating %s (%s)\n", p.Name, p.URL)
+	go f.loop(p)
+	return nil
+}
+
+// Run polls every peer of PEERS and expires pushing peers
+func (f *Federation) Run() {
+	for _, p := range f.Peers() {
+		logrus.Infof("- FEDERATION - aggregating %s (%s)\n", p.Name, p.URL)
+		go f.loop(p)
+	}
+	if f.accept {
+		go f.expirePushed()
+	}
+}
+
+func (f *Federation) loop(p *Peer) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	connected := false
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), f.interval+30*time.Second)
+		err := p.poll(ctx)
+		cancel()
+		switch {
+		case err != nil && connected:
+			logrus.Warnf("- FEDERATION - lost %s: %s\n", p.Name, err)
+		case err == nil && !connected:
+			logrus.Infof("- FEDERATION - connected to %s\n", p.Name)
+		}
+		connected = err == nil
+		<-ticker.C
+	}
+}
+
+// Peer returns the peer of name
+func (f *Federation) Peer(name string) (*Peer, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	p, exists := f.peers[name]
+	return p, exists
+}
+
+// Peers returns the peers by name
+func (f *Federation) Peers() []*Peer {
+	f.mutex.RLock()
+	peers := make([]*Peer, 0, len(f.peers))
+	for _, p := range f.peers {
+		peers = append(peers, p)
+	}
+	f.mutex.RUnlock()
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+	return peers
+}
+
+// Status returns the status of the peers by name
+func (f *Federation) Status() []PeerStatus {
+	status := make([]PeerStatus, 0)
+	for _, p := range f.Peers() {
+		status = append(status, p.Status())
+	}
+	return status
+}
+
+// Containers returns the inventories of all peers
+func (f *Federation) Containers() []Container {
+	containers := make([]Container, 0)
+	for _, p := range f.Peers() {
+		containers = append(containers, p.Containers()...)
+	}
+	return containers
+}
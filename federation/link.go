@@ -0,0 +1,370 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// first and maximum wait before a lost link is reopened
+	linkBackoff    = time.Second
+	linkMaxBackoff = 30 * time.Second
+	// time allowed to write a demand to the peer
+	linkWriteWait = 10 * time.Second
+	// frames queued per stream before new ones are dropped
+	linkStreamBuffer = 64
+)
+
+// linkClosing is returned for a link closed by a peer shutting down
+type linkClosing struct {
+	ReconnectAfter int `json:"reconnect_after"`
+}
+
+func (e *linkClosing) Error() string {
+	return "peer is shutting down"
+}
+
+// LinkStatus is the state of the link to a peer
+type LinkStatus struct {
+	Connected bool `json:"connected"`
+	// last host sequence number received and frames known to be lost
+	Seq     uint64 `json:"seq"`
+	Lost    uint64 `json:"lost"`
+	Streams int    `json:"streams"`
+}
+
+type linkKey struct {
+	cid string
+	typ string
+}
+
+// linkSession is the session frame a peer opens the link with
+type linkSession struct {
+	Token    string `json:"token"`
+	Restored []struct {
+		CID  string `json:"container_id"`
+		Type string `json:"type"`
+	} `json:"restored"`
+	Seq  uint64 `json:"seq"`
+	Lost uint64 `json:"lost"`
+}
+
+// Link is the connection to the link endpoint of a peer, shared by all
+// streams of the peer. It is opened with the first stream and reopened
+// with the session token and the last host sequence number received, so
+// the peer resends the frames missed in between. Links of pushing peers
+// are opened by the peer instead, see Accept
+type Link struct {
+	peer    *Peer
+	mutex   *sync.Mutex
+	streams map[linkKey]map[chan LinkFrame]bool
+	conn    *websocket.Conn
+	running bool
+	// the peer opens the link, it is not dialed
+	pushed bool
+	token  string
+	seq    uint64
+	lost   uint64
+	// when the pushed link was lost, zero while it is open or accepted
+	down time.Time
+}
+
+func newLink(p *Peer) *Link {
+	return &Link{
+		peer:    p,
+		mutex:   &sync.Mutex{},
+		streams: make(map[linkKey]map[chan LinkFrame]bool),
+	}
+}
+
+// Subscribe returns the frames of the resource typ of container cid of the
+// peer, including the errors answering the subscription
+func (l *Link) Subscribe(cid, typ string) chan LinkFrame {
+	ch := make(chan LinkFrame, linkStreamBuffer)
+	key := linkKey{cid: cid, typ: typ}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.streams[key] == nil {
+		l.streams[key] = make(map[chan LinkFrame]bool)
+		l.demand("subscribe", key)
+	}
+	l.streams[key][ch] = true
+	if !l.running && !l.pushed {
+		l.running = true
+		go l.run()
+	}
+	return ch
+}
+
+// Unsubscribe stops the frames sent to ch
+func (l *Link) Unsubscribe(cid, typ string, ch chan LinkFrame) {
+	key := linkKey{cid: cid, typ: typ}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.streams[key], ch)
+	if len(l.streams[key]) == 0 {
+		delete(l.streams, key)
+		l.demand("unsubscribe", key)
+	}
+}
+
+// demand sends a demand for key if the link is open, subscriptions made
+// while it is not are sent once it is. Callers hold the lock
+func (l *Link) demand(event string, key linkKey) {
+	if l.conn == nil {
+		return
+	}
+	l.conn.SetWriteDeadline(time.Now().Add(linkWriteWait))
+	err := l.conn.WriteMessage(websocket.BinaryMessage, EncodeDemand(LinkDemand{CID: key.cid, Event: event, Type: key.typ}))
+	if err != nil {
+		// the reader notices the broken connection and reopens it
+		logrus.Warnf("- FEDERATION - link %s: %s of %s/%s failed: %s\n", l.peer.Name, event, key.cid, key.typ, err)
+	}
+}
+
+// Status returns the state of the link
+func (l *Link) Status() LinkStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return LinkStatus{
+		Connected: l.conn != nil,
+		Seq:       l.seq,
+		Lost:      l.lost,
+		Streams:   len(l.streams),
+	}
+}
+
+// run keeps the link open
+func (l *Link) run() {
+	backoff := linkBackoff
+	for {
+		start := time.Now()
+		err := l.open()
+		if time.Since(start) > linkMaxBackoff {
+			backoff = linkBackoff
+		}
+		wait := backoff
+		var closing *linkClosing
+		if errors.As(err, &closing) && time.Duration(closing.ReconnectAfter)*time.Second > wait {
+			wait = time.Duration(closing.ReconnectAfter) * time.Second
+		}
+		logrus.Warnf("- FEDERATION - link %s lost, reopening in %s: %s\n", l.peer.Name, wait, err)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > linkMaxBackoff {
+			backoff = linkMaxBackoff
+		}
+	}
+}
+
+// open dials the peer and serves the link until the connection breaks
+func (l *Link) open() error {
+	conn, err := l.dial()
+	if err != nil {
+		return err
+	}
+	return l.serve(conn)
+}
+
+// accept serves the link a pushing peer opened, replacing the previous
+// connection of the peer. The peer is told the session to resume
+func (l *Link) accept(conn *websocket.Conn) {
+	l.mutex.Lock()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	resume := LinkDemand{Event: "resume", Session: l.token, Since: l.seq}
+	l.mutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(linkWriteWait))
+	err := conn.WriteMessage(websocket.BinaryMessage, EncodeDemand(resume))
+	if err == nil {
+		err = l.serve(conn)
+	}
+	l.mutex.Lock()
+	if l.conn == nil {
+		l.down = time.Now()
+	}
+	l.mutex.Unlock()
+	logrus.Warnf("- FEDERATION - push link of %s lost: %s\n", l.peer.Name, err)
+}
+
+// setDown sets when the pushed link was lost
+func (l *Link) setDown(at time.Time) {
+	l.mutex.Lock()
+	l.down = at
+	l.mutex.Unlock()
+}
+
+// downSince returns when the pushed link was lost, zero if it is open
+func (l *Link) downSince() time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.down
+}
+
+// serve resumes or starts the session on conn and reads frames until the
+// connection breaks
+func (l *Link) serve(conn *websocket.Conn) error {
+	defer func() {
+		l.mutex.Lock()
+		if l.conn == conn {
+			l.conn = nil
+		}
+		l.mutex.Unlock()
+		conn.Close()
+	}()
+
+	// the peer opens with the session frame
+	frame, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	if frame.Type != "session" {
+		return errors.New("link opened with " + frame.Type + " frame instead of session")
+	}
+	var session linkSession
+	if err = json.Unmarshal(frame.Message, &session); err != nil {
+		return err
+	}
+	l.attach(conn, session)
+
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return err
+		}
+		if frame.Type == "server_closing" {
+			closing := &linkClosing{}
+			json.Unmarshal(frame.Message, closing)
+			return closing
+		}
+		l.dispatch(frame)
+	}
+}
+
+// attach takes over the session of the opened link and demands what its
+// restored subscriptions lack
+func (l *Link) attach(conn *websocket.Conn, session linkSession) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.down = time.Time{}
+	if session.Token != l.token {
+		if l.token != "" {
+			logrus.Warnf("- FEDERATION - link %s: session expired, subscribing again\n", l.peer.Name)
+		}
+		l.token = session.Token
+		l.seq = session.Seq
+	}
+	l.lost += session.Lost
+	l.conn = conn
+	restored := make(map[linkKey]bool)
+	for _, sub := range session.Restored {
+		key := linkKey{cid: sub.CID, typ: sub.Type}
+		restored[key] = true
+		if l.streams[key] == nil {
+			l.demand("unsubscribe", key)
+		}
+	}
+	for key := range l.streams {
+		if !restored[key] {
+			l.demand("subscribe", key)
+		}
+	}
+	logrus.Infof("- FEDERATION - link %s open at seq %d, %d stream(s)\n", l.peer.Name, l.seq, len(l.streams))
+}
+
+// dispatch passes frame to the streams it belongs to, gaps in the host
+// sequence numbers are counted as lost
+func (l *Link) dispatch(frame LinkFrame) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if frame.HostSeq > 0 {
+		if frame.HostSeq <= l.seq {
+			// resent after a resume, already received
+			return
+		}
+		if gap := frame.HostSeq - l.seq - 1; gap > 0 && l.seq > 0 {
+			l.lost += gap
+			logrus.Warnf("- FEDERATION - link %s: %d frame(s) lost before seq %d\n", l.peer.Name, gap, frame.HostSeq)
+		}
+		l.seq = frame.HostSeq
+	}
+
+	key := linkKey{cid: frame.CID, typ: frame.Type}
+	switch frame.Type {
+	case "replay":
+		// resource replays after a resume, the frames themselves are forwarded
+		return
+	case "ack":
+		return
+	case "error":
+		// errors name the resource of the failed demand
+		var e struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(frame.Message, &e)
+		key.typ = e.Type
+	}
+	for ch := range l.streams[key] {
+		select {
+		case ch <- frame:
+		default:
+		}
+	}
+}
+
+// dial opens the link websocket of the peer, authenticated with the token
+// as subprotocol entry like the hub websocket
+func (l *Link) dial() (*websocket.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	token, err := l.peer.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u := l.peer.endpoint("link")
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	l.mutex.Lock()
+	if l.token != "" {
+		q := u.Query()
+		q.Set("session", l.token)
+		q.Set("since", strconv.FormatUint(l.seq, 10))
+		u.RawQuery = q.Encode()
+	}
+	l.mutex.Unlock()
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{LinkProtocol, "bearer." + token},
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), nil)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		// the token was revoked early, the next dial logs in again
+		l.peer.mutex.Lock()
+		l.peer.token = ""
+		l.peer.mutex.Unlock()
+	}
+	return conn, err
+}
+
+func readFrame(conn *websocket.Conn) (LinkFrame, error) {
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		return LinkFrame{}, err
+	}
+	if typ != websocket.BinaryMessage {
+		return LinkFrame{}, errors.New("link frames are binary")
+	}
+	return DecodeFrame(data)
+}
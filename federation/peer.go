@@ -0,0 +1,307 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokens are renewed this long before they expire
+const tokenSlack = time.Minute
+
+var ErrUnauthorized = errors.New("peer rejected the credentials")
+
+// PeerStatus is the connection state of a peer as seen by the aggregator
+type PeerStatus struct {
+	Name string `json:"name"`
+	// empty for pushing peers
+	URL    string `json:"url"`
+	Pushed bool   `json:"pushed,omitempty"`
+	// docker host name and version reported by the peer
+	Host       string     `json:"host,omitempty"`
+	Version    string     `json:"version,omitempty"`
+	Connected  bool       `json:"connected"`
+	LastSeen   time.Time  `json:"last_seen,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Containers int        `json:"containers"`
+	Link       LinkStatus `json:"link"`
+}
+
+// Peer is a remote agent the aggregator logs in to with user and
+// password, its inventory is polled. Pushing peers connect themselves,
+// they have no URL and their inventory is streamed over the link
+type Peer struct {
+	Name     string
+	URL      *url.URL
+	user     string
+	password string
+	http     *http.Client
+	mutex    *sync.Mutex
+	token    string
+	expire   time.Time
+	status   PeerStatus
+	// containers of the last poll with prefixed ids
+	containers []Container
+	link       *Link
+	// user that first pushed the link of a pushing peer, the only one
+	// allowed to replace it
+	owner string
+	// closed once a pushing peer expired
+	done chan struct{}
+}
+
+func newPeer(name string, u *url.URL, user, password string) *Peer {
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+		stripped := *u
+		stripped.User = nil
+		u = &stripped
+	}
+	p := &Peer{
+		Name:       name,
+		URL:        u,
+		user:       user,
+		password:   password,
+		http:       &http.Client{Timeout: 30 * time.Second},
+		mutex:      &sync.Mutex{},
+		status:     PeerStatus{Name: name, URL: u.String()},
+		containers: make([]Container, 0),
+	}
+	p.link = newLink(p)
+	return p
+}
+
+func newPushedPeer(name, owner string) *Peer {
+	p := &Peer{
+		Name:       name,
+		owner:      owner,
+		mutex:      &sync.Mutex{},
+		done:       make(chan struct{}),
+		status:     PeerStatus{Name: name, Pushed: true},
+		containers: make([]Container, 0),
+	}
+	p.link = newLink(p)
+	p.link.pushed = true
+	return p
+}
+
+// Owner returns the user pushing the link of a pushing peer
+func (p *Peer) Owner() string {
+	return p.owner
+}
+
+// endpoint returns the url of the v1 api path p of the peer
+func (p *Peer) endpoint(elem ...string) *url.URL {
+	u := *p.URL
+	u.Path = path.Join(append([]string{u.Path, "v1"}, elem...)...)
+	return &u
+}
+
+// Token returns a valid token of the peer, logging in if the cached one
+// is about to expire
+func (p *Peer) Token(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	token, expire := p.token, p.expire
+	p.mutex.Unlock()
+	if token != "" && time.Until(expire) > tokenSlack {
+		return token, nil
+	}
+	return p.login(ctx)
+}
+
+func (p *Peer) login(ctx context.Context) (string, error) {
+	body, _ := json.Marshal(map[string]string{"username": p.user, "password": p.password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint("login").String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login: %s", resp.Status)
+	}
+	var login struct {
+		Token  string    `json:"token"`
+		Expire time.Time `json:"expire"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		return "", err
+	}
+	p.mutex.Lock()
+	p.token, p.expire = login.Token, login.Expire
+	p.mutex.Unlock()
+	return login.Token, nil
+}
+
+// Do sends req with the token of the peer, a request rejected with 401 is
+// retried once with a new token. Requests need to be replayable, e.g. have
+// a GetBody
+func (p *Peer) Do(req *http.Request) (*http.Response, error) {
+	token, err := p.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.http.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	if req.Body != nil && req.GetBody == nil {
+		return nil, ErrUnauthorized
+	}
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	token, err = p.login(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return p.http.Do(req)
+}
+
+// get decodes the json answer of the api path elem into v
+func (p *Peer) get(ctx context.Context, v interface{}, elem ...string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint(elem...).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", strings.Join(elem, "/"), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// poll refreshes the inventory and the status of the peer
+func (p *Peer) poll(ctx context.Context) error {
+	var about struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	var containers []Container
+	err := p.get(ctx, &about, "about")
+	if err == nil {
+		err = p.get(ctx, &containers, "containers", "all")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.status.Connected = false
+		p.status.LastError = err.Error()
+		return err
+	}
+	for _, c := range containers {
+		c.prefix(p.Name)
+	}
+	p.containers = containers
+	p.status.Host = about.Name
+	p.status.Version = about.Version
+	p.status.Connected = true
+	p.status.LastSeen = time.Now()
+	p.status.LastError = ""
+	p.status.Containers = len(containers)
+	return nil
+}
+
+// containerChange is a frame of the containers resource of a peer
+type containerChange struct {
+	Action     string      `json:"action"`
+	ID         string      `json:"id"`
+	Container  Container   `json:"container"`
+	Containers []Container `json:"containers"`
+}
+
+// follow keeps the inventory of a pushing peer from the containers
+// resource streamed over its link until the peer expires
+func (p *Peer) follow() {
+	frames := p.link.Subscribe("", "containers")
+	defer p.link.Unsubscribe("", "containers", frames)
+	for {
+		var frame LinkFrame
+		select {
+		case <-p.done:
+			return
+		case frame = <-frames:
+		}
+		var change containerChange
+		if err := json.Unmarshal(frame.Message, &change); err != nil || frame.Type != "containers" {
+			continue
+		}
+		p.mutex.Lock()
+		switch change.Action {
+		case "snapshot":
+			p.containers = make([]Container, 0, len(change.Containers))
+			for _, c := range change.Containers {
+				c.prefix(p.Name)
+				p.containers = append(p.containers, c)
+			}
+		case "add", "update", "remove":
+			id := JoinID(p.Name, change.ID)
+			containers := make([]Container, 0, len(p.containers)+1)
+			for _, c := range p.containers {
+				if c["id"] != id {
+					containers = append(containers, c)
+				}
+			}
+			if change.Action != "remove" && change.Container != nil {
+				change.Container.prefix(p.Name)
+				containers = append(containers, change.Container)
+			}
+			p.containers = containers
+		}
+		p.status.Containers = len(p.containers)
+		p.status.LastSeen = time.Now()
+		p.mutex.Unlock()
+	}
+}
+
+// Status returns the state of the peer
+func (p *Peer) Status() PeerStatus {
+	p.mutex.Lock()
+	status := p.status
+	p.mutex.Unlock()
+	status.Link = p.link.Status()
+	if status.Pushed {
+		status.Connected = status.Link.Connected
+	}
+	return status
+}
+
+// Containers returns the inventory of the last poll
+func (p *Peer) Containers() []Container {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.containers
+}
+
+// Link returns the link to the peer the streams of its containers share
+func (p *Peer) Link() *Link {
+	return p.link
+}
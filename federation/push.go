@@ -0,0 +1,125 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ServeLink serves a link on conn, resuming session after the host
+// sequence number since. The returned channel is closed once it stopped
+type ServeLink func(conn *websocket.Conn, session string, since uint64) <-chan struct{}
+
+// Pusher keeps a link open to a central aggregator, for agents behind NAT
+// that cannot be dialed. The aggregator subscribes over it like over a
+// link it opened itself
+type Pusher struct {
+	central *Peer
+	name    string
+}
+
+// NewPusher reads the aggregator from PUSH_URL, nil if unset. It logs in
+// with PUSH_USER and PUSH_PASSWORD unless the url carries credentials and
+// registers as PUSH_NAME, the host name by default
+func NewPusher() *Pusher {
+	raw := config.String("PUSH_URL", "")
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		logrus.Errorf("- FEDERATION - PUSH_URL %q is not a http(s) url\n", raw)
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	name := config.String("PUSH_NAME", hostname)
+	if !peerName.MatchString(name) {
+		logrus.Errorf("- FEDERATION - PUSH_NAME %q is not a valid peer name\n", name)
+		return nil
+	}
+	user := config.String("PUSH_USER", "")
+	password := config.String("PUSH_PASSWORD", "")
+	return &Pusher{
+		central: newPeer("central", u, user, password),
+		name:    name,
+	}
+}
+
+// Run keeps the link to the aggregator open, serve serves it once the
+// aggregator told the session to resume
+func (p *Pusher) Run(serve ServeLink) {
+	logrus.Infof("- FEDERATION - pushing to %s as %s\n", p.central.URL, p.name)
+	backoff := linkBackoff
+	for {
+		start := time.Now()
+		err := p.push(serve)
+		if time.Since(start) > linkMaxBackoff {
+			backoff = linkBackoff
+		}
+		logrus.Warnf("- FEDERATION - push link lost, reopening in %s: %s\n", backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > linkMaxBackoff {
+			backoff = linkMaxBackoff
+		}
+	}
+}
+
+// push opens the link and serves it until it breaks
+func (p *Pusher) push(serve ServeLink) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	token, err := p.central.Token(ctx)
+	if err != nil {
+		return err
+	}
+	u := p.central.endpoint("push")
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.RawQuery = url.Values{"name": {p.name}}.Encode()
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{LinkProtocol, "bearer." + token},
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), nil)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		p.central.mutex.Lock()
+		p.central.token = ""
+		p.central.mutex.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	// the aggregator opens with the session to resume
+	conn.SetReadDeadline(time.Now().Add(linkWriteWait))
+	typ, data, err := conn.ReadMessage()
+	if err == nil && typ != websocket.BinaryMessage {
+		err = errors.New("link demands are binary")
+	}
+	var resume LinkDemand
+	if err == nil {
+		resume, err = DecodeDemand(data)
+	}
+	if err == nil && resume.Event != "resume" {
+		err = errors.New("link opened with " + resume.Event + " instead of resume")
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	logrus.Infof("- FEDERATION - push link to %s open\n", p.central.URL)
+	<-serve(conn, resume.Session, resume.Since)
+	return errors.New("link closed")
+}
@@ -0,0 +1,210 @@
+package federation
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// LinkProtocol is the websocket subprotocol of the link between agents,
+// its messages are the ones of proto/link.proto
+const LinkProtocol = "metawatch.link"
+
+// LinkDemand is a Demand of proto/link.proto
+type LinkDemand struct {
+	ID    string
+	CID   string
+	Event string
+	Type  string
+	// session token and last host sequence number of a resume
+	Session string
+	Since   uint64
+}
+
+// LinkFrame is a Frame of proto/link.proto, the payload in its json form
+type LinkFrame struct {
+	HostSeq  uint64
+	CID      string
+	Type     string
+	Seq      uint64
+	Message  json.RawMessage
+	Snapshot bool
+}
+
+// Demand fields
+const (
+	demandCID   protowire.Number = 1
+	demandEvent protowire.Number = 2
+	demandType  protowire.Number = 3
+	demandID    protowire.Number = 4
+	demandSess  protowire.Number = 5
+	demandSince protowire.Number = 6
+)
+
+// Frame fields
+const (
+	frameHostSeq  protowire.Number = 1
+	frameCID      protowire.Number = 2
+	frameType     protowire.Number = 3
+	frameSeq      protowire.Number = 4
+	frameMessage  protowire.Number = 5
+	frameSnapshot protowire.Number = 6
+)
+
+// EncodeDemand returns the wire form of dem
+func EncodeDemand(dem LinkDemand) []byte {
+	b := make([]byte, 0, 96)
+	b = appendString(b, demandCID, dem.CID)
+	b = appendString(b, demandEvent, dem.Event)
+	b = appendString(b, demandType, dem.Type)
+	b = appendString(b, demandID, dem.ID)
+	b = appendString(b, demandSess, dem.Session)
+	if dem.Since > 0 {
+		b = protowire.AppendTag(b, demandSince, protowire.VarintType)
+		b = protowire.AppendVarint(b, dem.Since)
+	}
+	return b
+}
+
+// DecodeDemand reads a demand, unknown fields are skipped
+func DecodeDemand(b []byte) (LinkDemand, error) {
+	var dem LinkDemand
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num == demandSince && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			dem.Since = v
+			return n
+		}
+		if typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b)
+		}
+		v, n := protowire.ConsumeString(b)
+		switch num {
+		case demandCID:
+			dem.CID = v
+		case demandEvent:
+			dem.Event = v
+		case demandType:
+			dem.Type = v
+		case demandID:
+			dem.ID = v
+		case demandSess:
+			dem.Session = v
+		}
+		return n
+	})
+	return dem, err
+}
+
+// EncodeFrame returns the wire form of f, the payload is converted into a
+// google.protobuf.Value
+func EncodeFrame(f LinkFrame) ([]byte, error) {
+	b := make([]byte, 0, 128)
+	if f.HostSeq > 0 {
+		b = protowire.AppendTag(b, frameHostSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, f.HostSeq)
+	}
+	b = appendString(b, frameCID, f.CID)
+	b = appendString(b, frameType, f.Type)
+	if f.Seq > 0 {
+		b = protowire.AppendTag(b, frameSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, f.Seq)
+	}
+	if len(f.Message) > 0 {
+		var generic interface{}
+		if err := json.Unmarshal(f.Message, &generic); err != nil {
+			return nil, err
+		}
+		value, err := structpb.NewValue(generic)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := proto.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, frameMessage, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	if f.Snapshot {
+		b = protowire.AppendTag(b, frameSnapshot, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b, nil
+}
+
+// DecodeFrame reads a frame, the payload is returned as json
+func DecodeFrame(b []byte) (LinkFrame, error) {
+	var f LinkFrame
+	var message []byte
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == frameHostSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.HostSeq = v
+			return n
+		case num == frameSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.Seq = v
+			return n
+		case num == frameSnapshot && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f.Snapshot = v != 0
+			return n
+		case num == frameCID && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			f.CID = v
+			return n
+		case num == frameType && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			f.Type = v
+			return n
+		case num == frameMessage && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			message = v
+			return n
+		}
+		return protowire.ConsumeFieldValue(num, typ, b)
+	})
+	if err != nil {
+		return f, err
+	}
+	if message == nil {
+		f.Message = json.RawMessage("null")
+		return f, nil
+	}
+	value := &structpb.Value{}
+	if err = proto.Unmarshal(message, value); err != nil {
+		return f, err
+	}
+	f.Message, err = json.Marshal(value.AsInterface())
+	return f, err
+}
+
+// consumeFields calls field for every field of the message in b, field
+// returns the length of the value consumed (negative on malformed input)
+func consumeFields(b []byte, field func(protowire.Number, protowire.Type, []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if n = field(num, typ, b); n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// appendString appends v unless it is empty, the proto3 default
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
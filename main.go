@@ -1,29 +1,82 @@
 package main
 
 import (
-	"github.com/h0rzn/monitoring_agent/api"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 )
 
+// set at build time: -ldflags "-X main.version=v0.2.0"
+var version = "dev"
+
+const usage = `usage: agent <command> [flags]
+
+commands:
+  serve         run the agent (default)
+  version       print the agent version
+  check-config  validate the configuration and exit
+
+run 'agent <command> -h' for the flags of a command
+`
+
 func main() {
 	logrus.SetLevel(logrus.DebugLevel)
-	err := godotenv.Load(".env")
-	if err != nil {
-		logrus.Errorf("- MAIN - failed to load .env")
-		return
+
+	cmd := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = serve(args)
+	case "version":
+		fmt.Println("metawatch-agent", version)
+	case "check-config":
+		err = checkConfig(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
-	logrus.Infoln("starting metawach-agent")
-	api, err := api.NewAPI()
+
 	if err != nil {
 		logrus.Errorln(err)
-		return
+		os.Exit(1)
 	}
-	err = api.RegRoutes()
+}
+
+// loadConfig loads the env file at path into the environment. A missing
+// default .env is not an error, the environment may be set otherwise
+func loadConfig(path string, explicit bool) error {
+	err := godotenv.Load(path)
 	if err != nil {
-		logrus.Errorf("- API - JWT init err: %s\n", err)
-		logrus.Errorln("This error is fatal. exit.")
-		return
+		if explicit || !os.IsNotExist(err) {
+			return fmt.Errorf("- MAIN - failed to load %s: %s", path, err)
+		}
+		logrus.Warnf("- MAIN - no %s found, using environment\n", path)
 	}
-	api.Run()
+	return nil
+}
+
+// configFlags registers the flags shared by config dependent commands
+func configFlags(fs *flag.FlagSet) *string {
+	return fs.String("config", ".env", "path of the env file holding the configuration")
+}
+
+// explicitFlag reports whether the flag name was set on the command line
+func explicitFlag(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
 }
@@ -0,0 +1,73 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Header carries the request id in requests and responses
+const Header = "X-Request-ID"
+
+type key struct{}
+
+// New returns a random request id
+func New() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// With returns a context carrying id
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// From returns the request id of ctx, empty if none
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Detach returns a context carrying the request id of ctx that is not
+// canceled with it, for work outliving the request
+func Detach(ctx context.Context) context.Context {
+	return With(context.Background(), From(ctx))
+}
+
+// Logger returns a log entry tagged with the request id of ctx
+func Logger(ctx context.Context) *logrus.Entry {
+	if id := From(ctx); id != "" {
+		return logrus.WithField("request_id", id)
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// Transport logs the requests carrying a request id, e.g. the docker api
+// calls resulting from an agent api call
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := From(req.Context())
+	if id == "" {
+		return t.Base.RoundTrip(req)
+	}
+	req.Header.Set(Header, id)
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	log := Logger(req.Context()).WithField("took", time.Since(start))
+	if err != nil {
+		log.Warnf("- DOCKER - %s %s failed: %s\n", req.Method, req.URL.Path, err)
+		return resp, err
+	}
+	log.Infof("- DOCKER - %s %s -> %d\n", req.Method, req.URL.Path, resp.StatusCode)
+	return resp, err
+}
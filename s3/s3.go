@@ -0,0 +1,163 @@
+// Package s3 uploads objects to s3 compatible storage (aws, minio, ...),
+// requests are signed with signature version 4
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client puts objects into a single bucket
+type Client struct {
+	endpoint *url.URL
+	Region   string
+	Bucket   string
+	// static credentials, SessionToken for temporary ones
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// address the bucket as path (endpoint/bucket/key) instead of as
+	// subdomain (bucket.endpoint/key), needed by most self-hosted stores
+	PathStyle bool
+	http      *http.Client
+}
+
+func NewClient(endpoint, region, bucket string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint %q needs to be a http(s) url", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket missing")
+	}
+	return &Client{
+		endpoint: u,
+		Region:   region,
+		Bucket:   bucket,
+		http:     &http.Client{},
+	}, nil
+}
+
+// Put uploads body as key
+func (c *Client) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	u := *c.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if c.PathStyle {
+		path += "/" + c.Bucket
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+	}
+	u.Path = path + "/" + strings.TrimPrefix(key, "/")
+	u.RawPath = escapePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	c.sign(req, body, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	amzDate    = "20060102T150405Z"
+	amzDay     = "20060102"
+	hostHeader = "host"
+)
+
+// sign adds the signature v4 authorization of req with payload body at t,
+// the host and the x-amz-* headers are signed
+func (c *Client) sign(req *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", t.Format(amzDate))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{hostHeader: req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonHeaders.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := strings.Join([]string{t.Format(amzDay), c.Region, "s3", "aws4_request"}, "/")
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{algorithm, t.Format(amzDate), scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), t.Format(amzDay))
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, c.AccessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath encodes every byte of path except the unreserved characters
+// and slashes, as signature v4 expects it
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
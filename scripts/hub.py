@@ -27,7 +27,7 @@ usub = {
 
 
 async def run():
-  async with websockets.connect("ws://localhost:8080/stream") as ws:
+  async with websockets.connect("ws://localhost:8080/ws") as ws:
     print("[SND]", json.dumps(sub))
     await ws.send(json.dumps(sub))
     
@@ -56,7 +56,7 @@ async def run():
       
 
 # async def run():
-#   async with websockets.connect("ws://localhost:8080/stream") as ws:
+#   async with websockets.connect("ws://localhost:8080/ws") as ws:
 #     print("[SND]", json.dumps(sub_comb))
 #     await ws.send(json.dumps(sub_comb))
     
@@ -19,7 +19,7 @@ sub_metrics = {
 
 
 async def run():
-    async with websockets.connect("ws://localhost:8080/stream") as ws:
+    async with websockets.connect("ws://localhost:8080/ws") as ws:
         print("[SND]", json.dumps(sub_events))
         await ws.send(json.dumps(sub_events))
         print("[SND]", json.dumps(sub_metrics))
@@ -23,7 +23,7 @@ async def run():
     sub = json.dumps(subscribe)
     usub = json.dumps(unsubscribe)
 
-    async with websockets.connect("ws://localhost:8081/stream") as ws:
+    async with websockets.connect("ws://localhost:8081/ws") as ws:
         # subscribe
         await ws.send(sub)
         print("[OUT]", sub)
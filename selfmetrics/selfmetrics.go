@@ -0,0 +1,41 @@
+package selfmetrics
+
+import (
+	"sync"
+)
+
+// Gauge reports a current value of the agent, e.g. the depth of a channel
+type Gauge func() float64
+
+var registry = struct {
+	mutex  *sync.RWMutex
+	gauges map[string]Gauge
+}{
+	mutex:  &sync.RWMutex{},
+	gauges: make(map[string]Gauge),
+}
+
+// Register adds or replaces the gauge of name
+func Register(name string, g Gauge) {
+	registry.mutex.Lock()
+	registry.gauges[name] = g
+	registry.mutex.Unlock()
+}
+
+// Unregister removes the gauge of name
+func Unregister(name string) {
+	registry.mutex.Lock()
+	delete(registry.gauges, name)
+	registry.mutex.Unlock()
+}
+
+// Snapshot reads all gauges
+func Snapshot() map[string]float64 {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	values := make(map[string]float64, len(registry.gauges))
+	for name, g := range registry.gauges {
+		values[name] = g()
+	}
+	return values
+}
@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/h0rzn/monitoring_agent/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// spans buffered for export, further spans are dropped
+	queueSize     = 2048
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+// exporter sends finished spans in batches as otlp/http json,
+// nil if tracing is disabled
+var exp *exporter
+
+type exporter struct {
+	endpoint string
+	service  string
+	version  string
+	spans    chan *Span
+	http     *http.Client
+}
+
+// Init enables tracing if OTEL_EXPORTER_OTLP_ENDPOINT is set (e.g.
+// http://localhost:4318), spans are reported as OTEL_SERVICE_NAME
+func Init(version string) {
+	endpoint := config.String("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if endpoint == "" {
+		return
+	}
+	exp = &exporter{
+		endpoint: strings.TrimRight(endpoint, "/") + "/v1/traces",
+		service:  config.String("OTEL_SERVICE_NAME", "metawatch-agent"),
+		version:  version,
+		spans:    make(chan *Span, queueSize),
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+	go exp.run()
+	logrus.Infof("- TRACING - exporting spans to %s\n", exp.endpoint)
+}
+
+func (e *exporter) queue(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		logrus.Debugln("- TRACING - export queue full, span dropped")
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(flushInterval)
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		err := e.export(batch)
+		if err != nil {
+			logrus.Warnf("- TRACING - export of %d span(s) failed: %s\n", len(batch), err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+}
+
+// otlp json payload, see opentelemetry-proto trace/v1
+type otlpValue map[string]interface{}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func attr(key string, value interface{}) otlpAttr {
+	switch v := value.(type) {
+	case bool:
+		return otlpAttr{key, otlpValue{"boolValue": v}}
+	case int:
+		return otlpAttr{key, otlpValue{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttr{key, otlpValue{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpAttr{key, otlpValue{"doubleValue": v}}
+	case string:
+		return otlpAttr{key, otlpValue{"stringValue": v}}
+	}
+	return otlpAttr{key, otlpValue{"stringValue": ""}}
+}
+
+func (e *exporter) export(batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mutex.Lock()
+		span := otlpSpan{
+			TraceID:    hex.EncodeToString(s.traceID[:]),
+			SpanID:     hex.EncodeToString(s.spanID[:]),
+			Name:       s.name,
+			Kind:       s.kind,
+			Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes: make([]otlpAttr, 0, len(s.attrs)),
+		}
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for key, value := range s.attrs {
+			span.Attributes = append(span.Attributes, attr(key, value))
+		}
+		if s.err != "" {
+			// STATUS_CODE_ERROR
+			span.Status.Code = 2
+			span.Status.Message = s.err
+		}
+		s.mutex.Unlock()
+		spans = append(spans, span)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{
+						attr("service.name", e.service),
+						attr("service.version", e.version),
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/h0rzn/monitoring_agent/tracing"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := e.http.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// span kinds of the otlp data model
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+type key struct{}
+
+// Span is a timed operation of a trace, a nil span (tracing disabled)
+// ignores all calls
+type Span struct {
+	mutex   sync.Mutex
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   map[string]interface{}
+	err     string
+}
+
+// Start begins a span as child of the span in ctx (or of a remote parent
+// set by Extract), the returned context carries the new span
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if exp == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: make(map[string]interface{}),
+	}
+	rand.Read(s.spanID[:])
+	if parent, ok := ctx.Value(key{}).(*Span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parent = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return context.WithValue(ctx, key{}, s), s
+}
+
+// SetAttr records an attribute, values are strings, ints, floats or bools
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.attrs[key] = value
+	s.mutex.Unlock()
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.err = err.Error()
+	s.mutex.Unlock()
+}
+
+// End finishes the span and queues it for export
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.end = time.Now()
+	s.mutex.Unlock()
+	exp.queue(s)
+}
+
+// Traceparent returns the w3c trace context header of the span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// Extract returns ctx with the remote parent of a w3c traceparent header
+// ("00-<trace id>-<span id>-<flags>"), ctx if the header is malformed
+func Extract(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(traceparent, "-")
+	if exp == nil || len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	remote := &Span{}
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, key{}, remote)
+}
+
+// Transport creates client spans for requests whose context carries a
+// span, e.g. docker api calls made for an agent api request
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if parent, _ := req.Context().Value(key{}).(*Span); parent == nil {
+		return t.Base.RoundTrip(req)
+	}
+	ctx, span := Start(req.Context(), req.Method+" "+req.URL.Path, KindClient)
+	defer span.End()
+	// a RoundTripper must not modify the request of its caller
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.Traceparent())
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.URL.Path)
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+	span.SetAttr("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetError(fmt.Errorf("status %d", resp.StatusCode))
+	}
+	return resp, err
+}